@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/SawitProRecruitment/UserService/handler"
@@ -15,34 +22,376 @@ import (
 func main() {
 	e := echo.New()
 
+	// the client ip counts the failed logins, X-Forwarded-For is only trusted from proxies on private networks
+	e.IPExtractor = echo.ExtractIPFromXFFHeader()
+
 	e.Use(middleware.Logger())
 
-	var server generated.ServerInterface = newServer()
+	server := newServer()
+
+	// the admin endpoints require the permissions declared on their operation in api.yml
+	swagger, err := generated.GetSwagger()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	permissions, err := server.PermissionsMiddleware(swagger)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	e.Use(permissions)
 
 	generated.RegisterHandlers(e, server)
+
+	go cleanUpPeriodically(server, durationFromEnv("ACCOUNT_ERASURE_INTERVAL"))
+	go processDataExportsPeriodically(server, durationFromEnv("DATA_EXPORT_INTERVAL"))
+
 	e.Logger.Fatal(e.Start(":1323"))
 }
 
+// cleanUpPeriodically erases the personal data of the deleted users once their grace period is over, and deletes
+// the revoked access tokens which expired, checking every interval (1 hour by default) from the start
+func cleanUpPeriodically(server *handler.Server, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		erased, err := server.EraseDeletedUsers(context.Background())
+		if erased > 0 {
+			log.Printf("erased %d deleted users", erased)
+		}
+		if err != nil {
+			log.Printf("failed to erase the deleted users: %v", err)
+		}
+
+		pruned, err := server.Repository.DeleteExpiredRevokedAccessTokens(context.Background())
+		if pruned > 0 {
+			log.Printf("deleted %d expired revoked access tokens", pruned)
+		}
+		if err != nil {
+			log.Printf("failed to delete the expired revoked access tokens: %v", err)
+		}
+
+		<-ticker.C
+	}
+}
+
 func newServer() *handler.Server {
 	dbDsn := os.Getenv("DATABASE_URL")
 	var repo repository.RepositoryInterface = repository.NewRepository(repository.NewRepositoryOptions{
 		Dsn: dbDsn,
 	})
 
-	// create jwt handler
-	privKey, err := os.ReadFile(os.Getenv("RSA_PRIVATE_KEY_PATH"))
+	signingKey, verificationKeys, err := loadJWTKeys()
 	if err != nil {
 		log.Fatalln(err)
 	}
-	pubKey, err := os.ReadFile(os.Getenv("RSA_PUBLIC_KEY_PATH"))
+	jwtHandler, err := handler.NewJWT(handler.NewJWTOptions{
+		SigningKey:       signingKey,
+		VerificationKeys: verificationKeys,
+		Issuer:           os.Getenv("JWT_ISSUER"),
+		Audience:         os.Getenv("JWT_AUDIENCE"),
+		Leeway:           durationFromEnv("JWT_CLOCK_SKEW"),
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	go reloadJWTKeysOnSIGHUP(jwtHandler)
+
+	secretBox, err := loadSecretBox()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	smsSender, err := newSMSSender()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	passwordHasher, err := newPasswordHasher()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	breachedPasswords, err := newBreachedPasswordChecker()
 	if err != nil {
 		log.Fatalln(err)
 	}
-	jwtHandler := handler.NewJWT(pubKey, privKey)
 
 	opts := handler.NewServerOptions{
-		Repository: repo,
-		JWT:        jwtHandler,
+		Repository:              repo,
+		JWT:                     jwtHandler,
+		AccessTokenTTL:          durationFromEnv("ACCESS_TOKEN_TTL"),
+		RefreshTokenTTL:         durationFromEnv("REFRESH_TOKEN_TTL"),
+		SecretBox:               secretBox,
+		TOTPIssuer:              os.Getenv("TOTP_ISSUER"),
+		WebAuthnRPID:            os.Getenv("WEBAUTHN_RP_ID"),
+		WebAuthnRPName:          os.Getenv("WEBAUTHN_RP_NAME"),
+		WebAuthnOrigins:         listFromEnv("WEBAUTHN_ORIGINS"),
+		SMSSender:               smsSender,
+		PasswordHistorySize:     intFromEnv("PASSWORD_HISTORY_SIZE"),
+		LoginAttemptWindow:      durationFromEnv("LOGIN_ATTEMPT_WINDOW"),
+		LoginLockoutThreshold:   intFromEnv("LOGIN_LOCKOUT_THRESHOLD"),
+		LoginLockoutDuration:    durationFromEnv("LOGIN_LOCKOUT_DURATION"),
+		LoginIPThreshold:        intFromEnv("LOGIN_IP_THRESHOLD"),
+		RegistrationPrivacyMode: os.Getenv("REGISTRATION_PRIVACY_MODE") == "true",
+		PasswordHasher:          passwordHasher,
+		BreachedPasswords:       breachedPasswords,
+
+		AccountDeletionGracePeriod: durationFromEnv("ACCOUNT_DELETION_GRACE_PERIOD"),
+		DataExportTTL:              durationFromEnv("DATA_EXPORT_TTL"),
 	}
 	return handler.NewServer(opts)
 }
+
+// durationFromEnv parses an optional duration (e.g. "15m") from the environment.
+// unset values return 0, letting the handler fall back to its defaults.
+func durationFromEnv(key string) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+
+	return duration
+}
+
+// intFromEnv parses an optional integer from the environment.
+// unset values return 0, letting the handler fall back to its defaults.
+func intFromEnv(key string) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+
+	return n
+}
+
+// listFromEnv parses an optional comma separated list from the environment
+func listFromEnv(key string) []string {
+	values := []string{}
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// loadSecretBox reads the 32 bytes key encrypting the totp secrets.
+// two-factor authentication stays disabled when no key is configured.
+func loadSecretBox() (*handler.SecretBox, error) {
+	path := os.Getenv("MFA_ENCRYPTION_KEY_PATH")
+	if path == "" {
+		return nil, nil
+	}
+
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.NewSecretBox(key)
+}
+
+// newPasswordHasher hashes the passwords with argon2id, peppered when a pepper is configured in the environment.
+// the pepper is read from PASSWORD_PEPPER_PATH, or from PASSWORD_PEPPER itself.
+func newPasswordHasher() (handler.PasswordHasher, error) {
+	hasher := handler.NewArgon2idHasher(handler.Argon2idParams{
+		Memory:      uint32(intFromEnv("ARGON2_MEMORY_KIB")),
+		Iterations:  uint32(intFromEnv("ARGON2_ITERATIONS")),
+		Parallelism: uint8(intFromEnv("ARGON2_PARALLELISM")),
+	})
+
+	key := []byte(os.Getenv("PASSWORD_PEPPER"))
+	if path := os.Getenv("PASSWORD_PEPPER_PATH"); path != "" {
+		var err error
+		key, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(key) == 0 {
+		return hasher, nil
+	}
+
+	previousPeppers, err := previousPeppersFromEnv("PASSWORD_PREVIOUS_PEPPERS")
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.NewPepperedHasher(handler.NewPepperedHasherOptions{
+		Hasher: hasher,
+		Pepper: handler.PasswordPepper{
+			ID:  os.Getenv("PASSWORD_PEPPER_ID"),
+			Key: key,
+		},
+		PreviousPeppers: previousPeppers,
+	})
+}
+
+// previousPeppersFromEnv loads the peppers kept to verify the hashes made before a pepper rotation.
+// the value is a comma separated list of id=path pairs.
+func previousPeppersFromEnv(key string) ([]handler.PasswordPepper, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	peppers := []handler.PasswordPepper{}
+	for _, pair := range strings.Split(value, ",") {
+		id, path, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("invalid %s entry %q: expected id=path", key, pair)
+		}
+
+		pepper, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		peppers = append(peppers, handler.PasswordPepper{
+			ID:  id,
+			Key: pepper,
+		})
+	}
+
+	return peppers, nil
+}
+
+// newBreachedPasswordChecker loads the Pwned Passwords corpus from BREACHED_PASSWORDS_PATH, either a directory of
+// range files or a single file ordered by hash. the new passwords are not checked when it is not set.
+func newBreachedPasswordChecker() (handler.BreachedPasswordChecker, error) {
+	path := os.Getenv("BREACHED_PASSWORDS_PATH")
+	if path == "" {
+		return nil, nil
+	}
+
+	return handler.NewPwnedPasswordsChecker(path)
+}
+
+// newSMSSender logs the text messages to the file configured in the environment, or to stdout.
+// no SMS gateway is integrated yet, so the messages are never actually delivered.
+func newSMSSender() (handler.SMSSender, error) {
+	path := os.Getenv("SMS_LOG_PATH")
+	if path == "" {
+		return handler.NewLogSMSSender(os.Stdout), nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.NewLogSMSSender(file), nil
+}
+
+// loadJWTKeys reads the signing key & the verification keys from the files configured in the environment.
+// despite their names, the key paths accept RSA, ECDSA P-256 & Ed25519 keys.
+func loadJWTKeys() (handler.JWTKey, []handler.JWTKey, error) {
+	privKey, err := os.ReadFile(os.Getenv("RSA_PRIVATE_KEY_PATH"))
+	if err != nil {
+		return handler.JWTKey{}, nil, err
+	}
+	pubKey, err := os.ReadFile(os.Getenv("RSA_PUBLIC_KEY_PATH"))
+	if err != nil {
+		return handler.JWTKey{}, nil, err
+	}
+
+	verificationKeys, err := verificationKeysFromEnv("JWT_VERIFICATION_KEYS")
+	if err != nil {
+		return handler.JWTKey{}, nil, err
+	}
+
+	return handler.JWTKey{
+		ID:         os.Getenv("JWT_KEY_ID"),
+		PublicKey:  pubKey,
+		PrivateKey: privKey,
+		Algorithm:  os.Getenv("JWT_ALGORITHM"),
+	}, verificationKeys, nil
+}
+
+// reloadJWTKeysOnSIGHUP re-reads the key files on every SIGHUP, so keys can be rotated without a restart.
+// the environment itself cannot change, so only the content of the configured files is picked up.
+func reloadJWTKeysOnSIGHUP(jwtHandler *handler.JWT) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		signingKey, verificationKeys, err := loadJWTKeys()
+		if err == nil {
+			err = jwtHandler.Reload(signingKey, verificationKeys)
+		}
+		if err != nil {
+			log.Printf("failed to reload jwt keys, keeping the current keys: %v", err)
+			continue
+		}
+
+		log.Println("reloaded jwt keys")
+	}
+}
+
+// verificationKeysFromEnv loads the previously active public keys, kept to verify tokens
+// signed before a key rotation. the value is a comma separated list of kid=path pairs.
+func verificationKeysFromEnv(key string) ([]handler.JWTKey, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	keys := []handler.JWTKey{}
+	for _, pair := range strings.Split(value, ",") {
+		kid, path, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid %s entry %q: expected kid=path", key, pair)
+		}
+
+		pubKey, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, handler.JWTKey{
+			ID:        kid,
+			PublicKey: pubKey,
+		})
+	}
+
+	return keys, nil
+}
+
+// processDataExportsPeriodically builds the archives of the data exports requested by the users, checking every
+// interval (30 seconds by default) from the start
+func processDataExportsPeriodically(server *handler.Server, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		built, err := server.ProcessDataExports(context.Background())
+		if built > 0 {
+			log.Printf("built %d data exports", built)
+		}
+		if err != nil {
+			log.Printf("failed to build the data exports: %v", err)
+		}
+
+		<-ticker.C
+	}
+}
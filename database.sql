@@ -7,3 +7,219 @@ CREATE TABLE IF NOT EXISTS users (
   created_at TIMESTAMP(0) DEFAULT NOW(),
   updated_at TIMESTAMP(0) DEFAULT NOW()
 );
+
+-- the columns & indexes added to the users since are in the migrations directory, applied after this file
+
+-- clients allowed to call the oauth endpoints. public clients (e.g. mobile apps) have no secret,
+-- and must use PKCE. redirect_uris, scopes & grant_types are space separated lists.
+CREATE TABLE IF NOT EXISTS oauth_clients (
+  id VARCHAR(64) PRIMARY KEY,
+  name VARCHAR(100) NOT NULL,
+  hashed_secret VARCHAR(256) NOT NULL DEFAULT '',
+  redirect_uris TEXT NOT NULL DEFAULT '',
+  scopes TEXT NOT NULL DEFAULT '',
+  grant_types TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users (id),
+  family_id UUID NOT NULL,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  -- client_id & scope are only set for refresh tokens issued through the oauth token endpoint
+  client_id VARCHAR(64) REFERENCES oauth_clients (id),
+  scope TEXT NOT NULL DEFAULT '',
+  expires_at TIMESTAMP(0) NOT NULL,
+  rotated_at TIMESTAMP(0),
+  revoked_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+CREATE TABLE IF NOT EXISTS revoked_access_tokens (
+  jti UUID PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users (id),
+  expires_at TIMESTAMP(0) NOT NULL,
+  revoked_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+-- revoked tokens are useless once expired, this index allows periodically pruning them
+CREATE INDEX IF NOT EXISTS revoked_access_tokens_expires_at_idx ON revoked_access_tokens (expires_at);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- authorization codes are single use: used_at is set when exchanged for tokens
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+  code_hash VARCHAR(64) PRIMARY KEY,
+  client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients (id),
+  user_id UUID NOT NULL REFERENCES users (id),
+  redirect_uri TEXT NOT NULL,
+  scope TEXT NOT NULL DEFAULT '',
+  code_challenge VARCHAR(128) NOT NULL,
+  code_challenge_method VARCHAR(10) NOT NULL,
+  nonce TEXT NOT NULL DEFAULT '',
+  auth_time TIMESTAMP(0) NOT NULL,
+  expires_at TIMESTAMP(0) NOT NULL,
+  used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS user_totp_secrets (
+  user_id UUID PRIMARY KEY REFERENCES users (id),
+  encrypted_secret TEXT NOT NULL,
+  confirmed_at TIMESTAMP(0),
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+  user_id UUID NOT NULL REFERENCES users (id),
+  code_hash VARCHAR(64) NOT NULL,
+  used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW(),
+  PRIMARY KEY (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+  token_hash VARCHAR(64) PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users (id),
+  attempts INT NOT NULL DEFAULT 0,
+  expires_at TIMESTAMP(0) NOT NULL,
+  used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+-- passkeys. public_key is the COSE encoded key of the credential, transports a space separated list.
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+  id TEXT PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users (id),
+  public_key BYTEA NOT NULL,
+  sign_count BIGINT NOT NULL DEFAULT 0,
+  transports TEXT NOT NULL DEFAULT '',
+  last_used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+-- challenges of the passkey ceremonies. user_id is only set for registration, logins are usernameless.
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+  challenge_hash VARCHAR(64) PRIMARY KEY,
+  ceremony VARCHAR(16) NOT NULL,
+  user_id UUID REFERENCES users (id),
+  expires_at TIMESTAMP(0) NOT NULL,
+  used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+-- sms one-time codes, one per user & purpose. code_hash is a bcrypt hash, as the codes only have 6 digits.
+CREATE TABLE IF NOT EXISTS phone_otp_codes (
+  user_id UUID NOT NULL REFERENCES users (id),
+  purpose VARCHAR(16) NOT NULL,
+  phone_number VARCHAR(16) NOT NULL,
+  code_hash VARCHAR(256) NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  expires_at TIMESTAMP(0) NOT NULL,
+  used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW(),
+  PRIMARY KEY (user_id, purpose)
+);
+
+-- the previous passwords of the users, so recently used passwords cannot be set again
+CREATE TABLE IF NOT EXISTS password_history (
+  id BIGSERIAL PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users (id),
+  hashed_password VARCHAR(256) NOT NULL,
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS password_history_user_id_idx ON password_history (user_id, created_at);
+
+-- every login of a known user, successful or not. login_count & last_login_at of the users are updated along with
+-- the successful ones. session_id is the refresh token family started by a successful login.
+CREATE TABLE IF NOT EXISTS login_events (
+  id BIGSERIAL PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users (id),
+  session_id UUID,
+  method VARCHAR(16) NOT NULL,
+  ip_address VARCHAR(45) NOT NULL,
+  user_agent TEXT NOT NULL DEFAULT '',
+  succeeded BOOLEAN NOT NULL,
+  failure_reason VARCHAR(32) NOT NULL DEFAULT '',
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_events_user_id_idx ON login_events (user_id, id);
+
+CREATE INDEX IF NOT EXISTS login_events_session_id_idx ON login_events (session_id);
+
+-- the roles grant permissions, which the admin endpoints require. the users are granted roles, which their access
+-- tokens carry in the roles claim.
+CREATE TABLE IF NOT EXISTS roles (
+  name VARCHAR(32) PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role VARCHAR(32) NOT NULL REFERENCES roles (name),
+  permission VARCHAR(64) NOT NULL,
+  PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+  user_id UUID NOT NULL REFERENCES users (id),
+  role VARCHAR(32) NOT NULL REFERENCES roles (name),
+  created_at TIMESTAMP(0) DEFAULT NOW(),
+  PRIMARY KEY (user_id, role)
+);
+
+INSERT INTO roles (name, description) VALUES
+  ('admin', 'Manages the users & their roles'),
+  ('support', 'Looks the users up & lifts their login locks')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('admin', 'users:read'),
+  ('admin', 'users:write'),
+  ('admin', 'users:suspend'),
+  ('admin', 'users:unlock'),
+  ('admin', 'users:erase'),
+  ('support', 'users:read'),
+  ('support', 'users:unlock')
+ON CONFLICT DO NOTHING;
+
+-- the actions of the admins on the users, kept for the audit. the actor is an admin user, or a back-office oauth
+-- client acting with the client_credentials grant. detail completes the action, e.g. the roles set.
+CREATE TABLE IF NOT EXISTS admin_audit_events (
+  id BIGSERIAL PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users (id),
+  action VARCHAR(32) NOT NULL,
+  detail TEXT NOT NULL DEFAULT '',
+  actor_user_id UUID REFERENCES users (id),
+  actor_client_id VARCHAR(64) REFERENCES oauth_clients (id),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_events_user_id_idx ON admin_audit_events (user_id, id);
+
+-- the personal data exports requested by the users. archive is built by a background job, which sets started_at when
+-- it picks the export up, so another job takes over an export left unfinished. once completed, the archive can be
+-- downloaded until expires_at with the token of its link, only its sha256 is stored.
+CREATE TABLE IF NOT EXISTS data_exports (
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users (id),
+  format VARCHAR(8) NOT NULL,
+  archive BYTEA,
+  download_token_hash VARCHAR(64) UNIQUE,
+  started_at TIMESTAMP(0),
+  completed_at TIMESTAMP(0),
+  expires_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON data_exports (user_id, created_at);
+
+CREATE INDEX IF NOT EXISTS data_exports_pending_idx ON data_exports (created_at) WHERE completed_at IS NULL;
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// erasureBatchSize is the number of deleted users erased by a single query, so an erasure run never holds the rows
+// of many users locked at once
+const erasureBatchSize = 100
+
+// Deletes the account of the logged in user, logging every session out. The account is gone at once, and its
+// personal data is erased after a grace period
+// (DELETE /users/me)
+func (s *Server) DeleteLoggedInUser(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	var payload DeleteAccountValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	if payload.Password == "" {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "password is required",
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// a stolen access token alone is not enough to delete the account
+	valid, err := s.PasswordHasher.Verify(user.HashedPassword, payload.Password)
+	if err == errPasswordPepperRetired {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: errPasswordResetRequired.Error(),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if !valid {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "password not valid",
+		})
+	}
+
+	err = s.Repository.SoftDeleteUser(ctx, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusAccepted, generated.AccountDeletionResponse{
+		EraseAfter: time.Now().Add(s.AccountDeletionGracePeriod).UTC(),
+	})
+}
+
+// EraseDeletedUsers erases the personal data of the users whose deletion grace period is over, and returns the
+// number of users erased. it is meant to be run periodically, any number of instances can run it at once.
+func (s *Server) EraseDeletedUsers(ctx context.Context) (int64, error) {
+	deletedBefore := time.Now().Add(-s.AccountDeletionGracePeriod).UTC()
+
+	var total int64
+	for {
+		erased, err := s.Repository.EraseDeletedUsers(ctx, repository.EraseDeletedUsersInput{
+			DeletedBefore: deletedBefore,
+			Limit:         erasureBatchSize,
+		})
+		total += erased
+		if err != nil || erased < erasureBatchSize {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_DeleteLoggedInUser(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("CurrentPassword1!"), bcrypt.MinCost)
+	user := repository.UserOutput{
+		ID:             dummyJWTClaims.ID,
+		FullName:       "test",
+		PhoneNumber:    "+62812345678",
+		HashedPassword: string(hashedPassword),
+	}
+	payload := map[string]interface{}{
+		"password": "CurrentPassword1!",
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		token      string
+		payload    map[string]interface{}
+		wantStatus int
+	}{
+		{
+			name: "successfully deletes the account",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					gomock.InOrder(
+						mockRepo.EXPECT().
+							GetUserByID(gomock.Any(), user.ID).
+							Return(user, nil),
+						mockRepo.EXPECT().
+							SoftDeleteUser(gomock.Any(), user.ID).
+							Return(nil),
+					)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    payload,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+			},
+			payload:    payload,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "password is missing",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    map[string]interface{}{},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "password not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+			},
+			token: dummyJWT,
+			payload: map[string]interface{}{
+				"password": "WrongPassword1!",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed when getting the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(repository.UserOutput{}, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    payload,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "failed when deleting the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						SoftDeleteUser(gomock.Any(), user.ID).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    payload,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository:                 tt.fields.Repository,
+				JWT:                        newFixtureJWT(),
+				AccountDeletionGracePeriod: 7 * 24 * time.Hour,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Delete("/users/me").
+				WithJWSAuth(tt.token).
+				WithJsonBody(tt.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusAccepted {
+				return
+			}
+
+			var deletion generated.AccountDeletionResponse
+			err := response.UnmarshalBodyToObject(&deletion)
+			assert.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), deletion.EraseAfter, time.Minute)
+		})
+	}
+}
+
+type eraseDeletedUsersInputMatcher struct {
+	deletedBefore time.Time
+}
+
+func (m eraseDeletedUsersInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.EraseDeletedUsersInput)
+	if !ok {
+		return false
+	}
+
+	diff := actualInput.DeletedBefore.Sub(m.deletedBefore)
+	return actualInput.Limit == 100 && diff > -time.Minute && diff < time.Minute
+}
+
+func (m eraseDeletedUsersInputMatcher) String() string {
+	return "{EraseDeletedUsersInput - DeletedBefore around " + m.deletedBefore.Format(time.RFC3339) + ", Limit:100}"
+}
+
+func TestServer_EraseDeletedUsers(t *testing.T) {
+	input := eraseDeletedUsersInputMatcher{deletedBefore: time.Now().Add(-7 * 24 * time.Hour)}
+
+	tests := []struct {
+		name       string
+		repository func(ctrl *gomock.Controller) repository.RepositoryInterface
+		wantErased int64
+		wantErr    bool
+	}{
+		{
+			name: "erases the users in batches until none is left",
+			repository: func(ctrl *gomock.Controller) repository.RepositoryInterface {
+				mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+				gomock.InOrder(
+					mockRepo.EXPECT().
+						EraseDeletedUsers(gomock.Any(), input).
+						Return(int64(100), nil),
+					mockRepo.EXPECT().
+						EraseDeletedUsers(gomock.Any(), input).
+						Return(int64(3), nil),
+				)
+
+				return mockRepo
+			},
+			wantErased: 103,
+		},
+		{
+			name: "no user to erase",
+			repository: func(ctrl *gomock.Controller) repository.RepositoryInterface {
+				mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+				mockRepo.EXPECT().
+					EraseDeletedUsers(gomock.Any(), input).
+					Return(int64(0), nil)
+
+				return mockRepo
+			},
+			wantErased: 0,
+		},
+		{
+			name: "stops at the first failed batch",
+			repository: func(ctrl *gomock.Controller) repository.RepositoryInterface {
+				mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+				gomock.InOrder(
+					mockRepo.EXPECT().
+						EraseDeletedUsers(gomock.Any(), input).
+						Return(int64(100), nil),
+					mockRepo.EXPECT().
+						EraseDeletedUsers(gomock.Any(), input).
+						Return(int64(0), assert.AnError),
+				)
+
+				return mockRepo
+			},
+			wantErased: 100,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository:                 tt.repository(gomock.NewController(t)),
+				AccountDeletionGracePeriod: 7 * 24 * time.Hour,
+			})
+
+			erased, err := s.EraseDeletedUsers(context.Background())
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantErased, erased)
+		})
+	}
+}
@@ -0,0 +1,596 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// adminScope is granted to the back-office oauth clients, through the client_credentials grant only
+const adminScope = "admin"
+
+// the page size of the user listing
+const (
+	defaultUserListLimit = 20
+	maxUserListLimit     = 100
+)
+
+// the length of a user search, a single character has too few trigrams to be similar to anything
+const (
+	minUserSearchLength = 2
+	maxUserSearchLength = 50
+)
+
+// the actions of the admins on the users, recorded for the audit
+const (
+	adminActionSuspend       = "suspend"
+	adminActionUnsuspend     = "unsuspend"
+	adminActionUnlock        = "unlock"
+	adminActionSetRoles      = "set_roles"
+	adminActionUpdateProfile = "update_profile"
+)
+
+// Lists the users matching the filters, a page at a time
+// (GET /admin/users)
+func (s *Server) ListUsers(c echo.Context, params generated.ListUsersParams) error {
+	if _, err := authorizedClaims(c); err != nil {
+		return permissionErrorResponse(c, err)
+	}
+
+	input, err := listUsersInput(params)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	limit := input.Limit
+
+	// one more user than the page tells whether there is a next page
+	input.Limit++
+	users, err := s.Repository.ListUsers(c.Request().Context(), input)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	response := generated.AdminUsersResponse{
+		Users: []generated.AdminUser{},
+	}
+	if len(users) > limit {
+		users = users[:limit]
+		nextCursor := encodeUserCursor(users[limit-1])
+		response.NextCursor = &nextCursor
+	}
+
+	for _, user := range users {
+		response.Users = append(response.Users, adminUserResponse(user))
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// Searches the users by full name, the best matches first
+// (GET /admin/users/search)
+func (s *Server) SearchUsers(c echo.Context, params generated.SearchUsersParams) error {
+	if _, err := authorizedClaims(c); err != nil {
+		return permissionErrorResponse(c, err)
+	}
+
+	query := strings.TrimSpace(params.Q)
+	if length := utf8.RuneCountInString(query); length < minUserSearchLength || length > maxUserSearchLength {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "q must be between 2 and 50 characters",
+		})
+	}
+
+	limit := defaultUserListLimit
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if limit < 1 || limit > maxUserListLimit {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "limit must be between 1 and 100",
+		})
+	}
+
+	results, err := s.Repository.SearchUsers(c.Request().Context(), repository.SearchUsersInput{
+		Query: query,
+		Limit: limit,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	response := generated.AdminUserSearchResponse{
+		Results: []generated.AdminUserSearchResult{},
+	}
+	for _, result := range results {
+		response.Results = append(response.Results, generated.AdminUserSearchResult{
+			User: adminUserResponse(result.UserOutput),
+			Rank: result.Rank,
+		})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// Gets a user
+// (GET /admin/users/{id})
+func (s *Server) GetUser(c echo.Context, id string) error {
+	if _, err := authorizedClaims(c); err != nil {
+		return permissionErrorResponse(c, err)
+	}
+
+	// users are identified by a uuid, anything else cannot be one of them
+	if _, err := uuid.Parse(id); err != nil {
+		return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+			Message: "user not found",
+		})
+	}
+
+	user, err := s.Repository.GetUserByID(c.Request().Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "user not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, adminUserResponse(user))
+}
+
+// Updates the full name, the phone number or the roles of a user
+// (PATCH /admin/users/{id})
+func (s *Server) AdminUpdateUser(c echo.Context, id string) error {
+	claims, err := authorizedClaims(c)
+	if err != nil {
+		return permissionErrorResponse(c, err)
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+			Message: "user not found",
+		})
+	}
+
+	var payload AdminUpdateUserValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	fieldErrors := payload.Validate()
+	if len(fieldErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message:          "field validation failed",
+			ValidationErrors: (*[]generated.FieldError)(&fieldErrors),
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "user not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	if payload.Roles != nil {
+		fieldErrors, err = s.roleFieldErrors(c, *payload.Roles)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+		if len(fieldErrors) > 0 {
+			return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+				Message:          "field validation failed",
+				ValidationErrors: (*[]generated.FieldError)(&fieldErrors),
+			})
+		}
+	}
+
+	if payload.FullName != nil || payload.PhoneNumber != nil {
+		_, err = s.updateUserProfile(ctx, user.ID, user, payload.FullName, payload.PhoneNumber)
+		if err == nil {
+			err = s.recordAdminAction(c, claims, user.ID, adminActionUpdateProfile, updatedProfileFields(payload))
+		}
+		if err != nil {
+			if err == errPhoneNumberRegistered {
+				return c.JSON(http.StatusConflict, generated.ErrorResponse{
+					Message: err.Error(),
+				})
+			}
+
+			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+	}
+
+	// the sessions of the user are logged out along with a change of their roles, so the roles are only set
+	// when they change
+	if payload.Roles != nil && !sameRoles(user.Roles, *payload.Roles) {
+		err = s.Repository.SetUserRoles(ctx, repository.SetUserRolesInput{
+			UserID: user.ID,
+			Roles:  *payload.Roles,
+		})
+		if err == nil {
+			err = s.recordAdminAction(c, claims, user.ID, adminActionSetRoles, strings.Join(*payload.Roles, " "))
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+	}
+
+	user, err = s.Repository.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, adminUserResponse(user))
+}
+
+// Suspends a user, logging every session of the user out
+// (POST /admin/users/{id}/suspend)
+func (s *Server) SuspendUser(c echo.Context, id string) error {
+	claims, err := authorizedClaims(c)
+	if err != nil {
+		return permissionErrorResponse(c, err)
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+			Message: "user not found",
+		})
+	}
+
+	// an admin suspending themselves would be locked out, along with the means to undo it
+	if claims.ID == id {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "cannot suspend yourself",
+		})
+	}
+
+	err = s.Repository.SuspendUser(c.Request().Context(), id)
+	if err == nil {
+		err = s.recordAdminAction(c, claims, id, adminActionSuspend, "")
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "user not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Erases the personal data of a user at once, whether they deleted their account or not. An anonymized tombstone
+// of the user is kept, and every session of the user is logged out
+// (DELETE /admin/users/{id})
+func (s *Server) EraseUser(c echo.Context, id string) error {
+	claims, err := authorizedClaims(c)
+	if err != nil {
+		return permissionErrorResponse(c, err)
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+			Message: "user not found",
+		})
+	}
+
+	if claims.ID == id {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "cannot erase yourself",
+		})
+	}
+
+	err = s.Repository.EraseUser(c.Request().Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "user not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Lets a suspended user log in again
+// (POST /admin/users/{id}/unsuspend)
+func (s *Server) UnsuspendUser(c echo.Context, id string) error {
+	claims, err := authorizedClaims(c)
+	if err != nil {
+		return permissionErrorResponse(c, err)
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+			Message: "user not found",
+		})
+	}
+
+	err = s.Repository.UnsuspendUser(c.Request().Context(), id)
+	if err == nil {
+		err = s.recordAdminAction(c, claims, id, adminActionUnsuspend, "")
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "user not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Lifts the login lock of a user & clears their failed logins
+// (POST /admin/users/{id}/unlock)
+func (s *Server) UnlockUser(c echo.Context, id string) error {
+	claims, err := authorizedClaims(c)
+	if err != nil {
+		return permissionErrorResponse(c, err)
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+			Message: "user not found",
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if err == nil {
+		err = s.Repository.UnlockUser(ctx, user.ID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "user not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// the delays of the failed logins so far would still slow the user down otherwise
+	err = s.LoginAttempts.Reset(ctx, accountLoginKey(user.PhoneNumber))
+	if err == nil {
+		err = s.recordAdminAction(c, claims, user.ID, adminActionUnlock, "")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// recordAdminAction records the action of the admin on the user for the audit. the admin is the user of the
+// token, or the back-office client of a client_credentials token.
+func (s *Server) recordAdminAction(c echo.Context, claims *JWTCustomClaims, userID, action, detail string) error {
+	input := repository.CreateAdminAuditEventInput{
+		UserID: userID,
+		Action: action,
+		Detail: detail,
+	}
+	if claims.ID != "" {
+		input.ActorUserID = &claims.ID
+	} else {
+		input.ActorClientID = &claims.ClientID
+	}
+
+	return s.Repository.CreateAdminAuditEvent(c.Request().Context(), input)
+}
+
+// updatedProfileFields lists the fields of the profile an admin updated, as recorded in the audit
+func updatedProfileFields(payload AdminUpdateUserValidator) string {
+	var fields []string
+	if payload.FullName != nil {
+		fields = append(fields, "full_name")
+	}
+	if payload.PhoneNumber != nil {
+		fields = append(fields, "phone_number")
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// roleFieldErrors refuses the roles which do not exist
+func (s *Server) roleFieldErrors(c echo.Context, roles []string) (FieldErrors, error) {
+	knownRoles, err := s.Repository.ListRoles(c.Request().Context())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		if !hasRole(knownRoles, role) {
+			return FieldErrors{{
+				Field:      "Roles",
+				Validation: "role " + role + " does not exist",
+			}}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// sameRoles reports whether both lists hold the same roles, whatever their order
+func sameRoles(current, roles []string) bool {
+	for _, role := range roles {
+		if !hasRole(current, role) {
+			return false
+		}
+	}
+	for _, role := range current {
+		if !hasRole(roles, role) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listUsersInput turns the query parameters of the user listing into the repository input
+func listUsersInput(params generated.ListUsersParams) (repository.ListUsersInput, error) {
+	input := repository.ListUsersInput{
+		Limit: defaultUserListLimit,
+		Filter: repository.ListUsersFilter{
+			FullNamePrefix:    stringValue(params.FullName),
+			PhoneNumberPrefix: stringValue(params.PhoneNumber),
+			CreatedFrom:       params.CreatedFrom,
+			CreatedBefore:     params.CreatedBefore,
+		},
+	}
+
+	if params.Limit != nil {
+		input.Limit = *params.Limit
+	}
+	if input.Limit < 1 || input.Limit > maxUserListLimit {
+		return repository.ListUsersInput{}, errors.New("limit must be between 1 and 100")
+	}
+
+	if params.Status != nil {
+		switch *params.Status {
+		case generated.Active, generated.Locked, generated.Suspended:
+			input.Filter.Status = repository.UserStatus(*params.Status)
+		default:
+			return repository.ListUsersInput{}, errors.New("status must be active, locked or suspended")
+		}
+	}
+
+	if params.Sort != nil {
+		switch *params.Sort {
+		case generated.CreatedAt:
+			input.OldestFirst = true
+		case generated.MinusCreatedAt:
+		default:
+			return repository.ListUsersInput{}, errors.New("sort must be created_at or -created_at")
+		}
+	}
+
+	if params.Cursor != nil {
+		cursor, err := decodeUserCursor(*params.Cursor)
+		if err != nil {
+			return repository.ListUsersInput{}, errors.New("cursor not valid")
+		}
+		input.After = &cursor
+	}
+
+	return input, nil
+}
+
+// encodeUserCursor returns the cursor of the page following the user. it is opaque to the clients, but is only
+// the creation & the id of the user.
+func encodeUserCursor(user repository.UserOutput) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(user.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + user.ID))
+}
+
+func decodeUserCursor(cursor string) (repository.UserCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return repository.UserCursor{}, err
+	}
+
+	createdAt, id, found := strings.Cut(string(decoded), ",")
+	if !found {
+		return repository.UserCursor{}, errors.New("cursor has no id")
+	}
+	// the id is compared with a uuid column, anything else fails the query
+	if _, err := uuid.Parse(id); err != nil {
+		return repository.UserCursor{}, err
+	}
+
+	userCursor := repository.UserCursor{ID: id}
+	userCursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return repository.UserCursor{}, err
+	}
+
+	return userCursor, nil
+}
+
+func adminUserResponse(user repository.UserOutput) generated.AdminUser {
+	response := generated.AdminUser{
+		Id:            user.ID,
+		FullName:      user.FullName,
+		PhoneNumber:   user.PhoneNumber,
+		PhoneVerified: user.PhoneVerifiedAt != nil,
+		LoginCount:    int64(user.LoginCount),
+		LastLoginAt:   user.LastLoginAt,
+		SuspendedAt:   user.SuspendedAt,
+		Status:        generated.Active,
+		Roles:         append([]string{}, user.Roles...),
+		CreatedAt:     user.CreatedAt,
+	}
+	// an expired lock is only cleared by the next successful login
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		response.LockedUntil = user.LockedUntil
+		response.Status = generated.Locked
+	}
+	if user.SuspendedAt != nil {
+		response.Status = generated.Suspended
+	}
+
+	return response
+}
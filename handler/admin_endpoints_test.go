@@ -0,0 +1,1503 @@
+package handler_test
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// adminRevocationCheck is the revocation lookup made for every request authenticated with dummyAdminJWT
+var adminRevocationCheck = repository.IsAccessTokenRevokedInput{
+	JTI: dummyAdminJWTClaims.RegisteredClaims.ID,
+}
+
+// the permissions of the roles seeded by database.sql
+var (
+	adminPermissions   = []string{"users:erase", "users:read", "users:suspend", "users:unlock", "users:write"}
+	supportPermissions = []string{"users:read", "users:unlock"}
+)
+
+// newAdminEcho registers the handlers of the server behind the permissions middleware, as done by main
+func newAdminEcho(t *testing.T, s *handler.Server) *echo.Echo {
+	swagger, err := generated.GetSwagger()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	permissions, err := s.PermissionsMiddleware(swagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(permissions)
+	generated.RegisterHandlers(e, s)
+
+	return e
+}
+
+// expectAdminToken expects the lookups made to authorize dummyAdminJWT
+func expectAdminToken(mockRepo *repository.MockRepositoryInterface) {
+	mockRepo.EXPECT().
+		IsAccessTokenRevoked(gomock.Any(), adminRevocationCheck).
+		Return(false, nil)
+
+	mockRepo.EXPECT().
+		ListRolePermissions(gomock.Any(), []string{"admin"}).
+		Return(adminPermissions, nil)
+}
+
+// adminAuditEvent is the audit of an action made with dummyAdminJWT, by the back-office client
+func adminAuditEvent(userID, action, detail string) repository.CreateAdminAuditEventInput {
+	clientID := dummyAdminJWTClaims.ClientID
+	return repository.CreateAdminAuditEventInput{
+		UserID:        userID,
+		Action:        action,
+		Detail:        detail,
+		ActorClientID: &clientID,
+	}
+}
+
+func TestServer_PermissionsMiddleware(t *testing.T) {
+	t.Run("handlers refuse the requests when the middleware is not installed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		e := echo.New()
+		s := handler.NewServer(handler.NewServerOptions{
+			Repository: repository.NewMockRepositoryInterface(ctrl),
+			JWT:        newFixtureJWT(),
+		})
+		generated.RegisterHandlers(e, s)
+
+		response := testutil.NewRequest().Get("/admin/users").
+			WithJWSAuth(dummyAdminJWT).
+			GoWithHTTPHandler(t, e)
+
+		assert.Equal(t, http.StatusInternalServerError, response.Code())
+	})
+
+	t.Run("operations without permissions are left to their handler", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+			Repository: repository.NewMockRepositoryInterface(ctrl),
+			JWT:        newFixtureJWT(),
+		}))
+
+		response := testutil.NewRequest().Get("/me").
+			GoWithHTTPHandler(t, e)
+
+		assert.Equal(t, http.StatusForbidden, response.Code())
+	})
+
+	t.Run("fails when the permissions of the roles cannot be listed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+		mockRepo.EXPECT().
+			IsAccessTokenRevoked(gomock.Any(), adminRevocationCheck).
+			Return(false, nil)
+
+		mockRepo.EXPECT().
+			ListRolePermissions(gomock.Any(), []string{"admin"}).
+			Return(nil, assert.AnError)
+
+		e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+			Repository: mockRepo,
+			JWT:        newFixtureJWT(),
+		}))
+
+		response := testutil.NewRequest().Get("/admin/users").
+			WithJWSAuth(dummyAdminJWT).
+			GoWithHTTPHandler(t, e)
+
+		assert.Equal(t, http.StatusInternalServerError, response.Code())
+	})
+}
+
+func TestServer_ListUsers(t *testing.T) {
+	lockedUntil := time.Now().Add(time.Hour)
+	expiredLock := time.Now().Add(-time.Hour)
+	suspendedAt := time.Now().Add(-time.Hour)
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := []repository.UserOutput{
+		{ID: "8d1f2a3b-4c5d-4e6f-8a7b-9c0d1e2f3a4b", FullName: "test", PhoneNumber: "+62812345678", Roles: []string{"support"}, LockedUntil: &lockedUntil, CreatedAt: createdAt},
+		{ID: "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d", FullName: "other", PhoneNumber: "+62887654321", LockedUntil: &expiredLock, CreatedAt: createdAt.Add(-time.Hour)},
+		{ID: "f0e1d2c3-b4a5-4968-8776-655443322110", FullName: "suspended", PhoneNumber: "+62811111111", LockedUntil: &lockedUntil, SuspendedAt: &suspendedAt, CreatedAt: createdAt.Add(-2 * time.Hour)},
+	}
+	// the cursor of the page following the first user
+	cursor := base64.RawURLEncoding.EncodeToString([]byte("2024-01-01T00:00:00Z," + users[0].ID))
+	createdBefore := createdAt.Add(24 * time.Hour)
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name           string
+		fields         fields
+		query          string
+		token          string
+		wantStatus     int
+		wantUsers      []generated.AdminUser
+		wantNextCursor *string
+	}{
+		{
+			name: "successfully lists the users",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						ListUsers(gomock.Any(), repository.ListUsersInput{Limit: 21}).
+						Return(users, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusOK,
+			wantUsers: []generated.AdminUser{
+				{Id: users[0].ID, FullName: "test", PhoneNumber: "+62812345678", Status: generated.Locked, Roles: []string{"support"}, LockedUntil: &lockedUntil},
+				{Id: users[1].ID, FullName: "other", PhoneNumber: "+62887654321", Status: generated.Active, Roles: []string{}},
+				{Id: users[2].ID, FullName: "suspended", PhoneNumber: "+62811111111", Status: generated.Suspended, Roles: []string{}, LockedUntil: &lockedUntil},
+			},
+		},
+		{
+			name: "returns a cursor when there are more users",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						ListUsers(gomock.Any(), repository.ListUsersInput{Limit: 2}).
+						Return(users[:2], nil)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?limit=1",
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusOK,
+			wantUsers: []generated.AdminUser{
+				{Id: users[0].ID, FullName: "test", PhoneNumber: "+62812345678", Status: generated.Locked, Roles: []string{"support"}, LockedUntil: &lockedUntil},
+			},
+			wantNextCursor: &cursor,
+		},
+		{
+			name: "successfully lists the next page of the filtered users with the support role",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummySupportJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListRolePermissions(gomock.Any(), []string{"support"}).
+						Return(supportPermissions, nil)
+
+					mockRepo.EXPECT().
+						ListUsers(gomock.Any(), repository.ListUsersInput{
+							Filter: repository.ListUsersFilter{
+								FullNamePrefix:    "Te",
+								PhoneNumberPrefix: "+6281",
+								CreatedFrom:       &createdAt,
+								CreatedBefore:     &createdBefore,
+								Status:            repository.UserStatusSuspended,
+							},
+							OldestFirst: true,
+							After:       &repository.UserCursor{CreatedAt: createdAt, ID: users[0].ID},
+							Limit:       11,
+						}).
+						Return(nil, nil)
+
+					return mockRepo
+				}(),
+			},
+			query: "?limit=10&full_name=Te&phone_number=%2B6281&created_from=2024-01-01T00:00:00Z&created_before=2024-01-02T00:00:00Z" +
+				"&status=suspended&sort=created_at&cursor=" + cursor,
+			token:      dummySupportJWT,
+			wantStatus: http.StatusOK,
+			wantUsers:  []generated.AdminUser{},
+		},
+		{
+			name: "limit is too large",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?limit=101",
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid cursor",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?cursor=" + base64.RawURLEncoding.EncodeToString([]byte("2024-01-01T00:00:00Z,abc")),
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown status",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?status=deleted",
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown sort",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?sort=full_name",
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid creation date",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?created_from=yesterday",
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed when listing the users",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						ListUsers(gomock.Any(), gomock.Any()).
+						Return(nil, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "token without roles is refused",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "missing token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			}))
+
+			request := testutil.NewRequest().Get("/admin/users" + tt.query)
+			if tt.token != "" {
+				request = request.WithJWSAuth(tt.token)
+			}
+			response := request.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body generated.AdminUsersResponse
+			assert.NoError(t, response.UnmarshalBodyToObject(&body))
+			assert.Equal(t, tt.wantNextCursor, body.NextCursor)
+			assert.Len(t, body.Users, len(tt.wantUsers))
+			for i, want := range tt.wantUsers {
+				got := body.Users[i]
+				assert.Equal(t, want.Id, got.Id)
+				assert.Equal(t, want.FullName, got.FullName)
+				assert.Equal(t, want.PhoneNumber, got.PhoneNumber)
+				assert.Equal(t, want.Status, got.Status)
+				assert.Equal(t, want.Roles, got.Roles)
+				assert.Equal(t, want.LockedUntil != nil, got.LockedUntil != nil)
+			}
+		})
+	}
+}
+
+func TestServer_SearchUsers(t *testing.T) {
+	results := []repository.UserSearchResultOutput{
+		{UserOutput: repository.UserOutput{ID: "5b0d8c6e-3f1a-4c2b-9e7d-1a2b3c4d5e6f", FullName: "José Ramírez", PhoneNumber: "+62812345678"}, Rank: 1},
+		{UserOutput: repository.UserOutput{ID: "9e8d7c6b-5a4f-4e3d-8c2b-1a0f9e8d7c6b", FullName: "Jose Ramos", PhoneNumber: "+62887654321", Roles: []string{"support"}}, Rank: 0.6666667},
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name        string
+		fields      fields
+		query       string
+		token       string
+		wantStatus  int
+		wantResults []generated.AdminUserSearchResult
+	}{
+		{
+			name: "successfully searches the users",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						SearchUsers(gomock.Any(), repository.SearchUsersInput{Query: "jose rami", Limit: 20}).
+						Return(results, nil)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?q=%20jose%20rami%20",
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusOK,
+			wantResults: []generated.AdminUserSearchResult{
+				{User: generated.AdminUser{Id: "5b0d8c6e-3f1a-4c2b-9e7d-1a2b3c4d5e6f", FullName: "José Ramírez", Roles: []string{}}, Rank: 1},
+				{User: generated.AdminUser{Id: "9e8d7c6b-5a4f-4e3d-8c2b-1a0f9e8d7c6b", FullName: "Jose Ramos", Roles: []string{"support"}}, Rank: 0.6666667},
+			},
+		},
+		{
+			name: "successfully searches the users with the support role",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummySupportJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListRolePermissions(gomock.Any(), []string{"support"}).
+						Return(supportPermissions, nil)
+
+					mockRepo.EXPECT().
+						SearchUsers(gomock.Any(), repository.SearchUsersInput{Query: "zz", Limit: 5}).
+						Return(nil, nil)
+
+					return mockRepo
+				}(),
+			},
+			query:       "?q=zz&limit=5",
+			token:       dummySupportJWT,
+			wantStatus:  http.StatusOK,
+			wantResults: []generated.AdminUserSearchResult{},
+		},
+		{
+			name: "query is too short",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?q=%C3%A9%20",
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "query is missing",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "limit is too large",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?q=jose&limit=101",
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed when searching the users",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						SearchUsers(gomock.Any(), gomock.Any()).
+						Return(nil, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?q=jose",
+			token:      dummyAdminJWT,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "token without roles is refused",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?q=jose",
+			token:      dummyJWT,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			}))
+
+			response := testutil.NewRequest().Get("/admin/users/search"+tt.query).
+				WithJWSAuth(tt.token).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body generated.AdminUserSearchResponse
+			assert.NoError(t, response.UnmarshalBodyToObject(&body))
+			assert.Len(t, body.Results, len(tt.wantResults))
+			for i, want := range tt.wantResults {
+				got := body.Results[i]
+				assert.Equal(t, want.User.Id, got.User.Id)
+				assert.Equal(t, want.User.FullName, got.User.FullName)
+				assert.Equal(t, want.User.Roles, got.User.Roles)
+				assert.Equal(t, want.Rank, got.Rank)
+			}
+		})
+	}
+}
+
+func TestServer_GetUser(t *testing.T) {
+	suspendedAt := time.Now().Add(-time.Hour)
+	user := repository.UserOutput{
+		ID:          "5b0d8c6e-3f1a-4c2b-9e7d-1a2b3c4d5e6f",
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+		SuspendedAt: &suspendedAt,
+		Roles:       []string{"admin"},
+		CreatedAt:   time.Now(),
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		wantStatus int
+	}{
+		{
+			name: "successfully gets the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "user not found",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "failed when getting the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(repository.UserOutput{}, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			}))
+
+			response := testutil.NewRequest().Get("/admin/users/"+user.ID).
+				WithJWSAuth(dummyAdminJWT).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body generated.AdminUser
+			assert.NoError(t, response.UnmarshalBodyToObject(&body))
+			assert.Equal(t, user.ID, body.Id)
+			assert.Equal(t, []string{"admin"}, body.Roles)
+			assert.NotNil(t, body.SuspendedAt)
+			assert.Equal(t, generated.Suspended, body.Status)
+			assert.Nil(t, body.LockedUntil)
+		})
+	}
+}
+
+func TestServer_AdminUpdateUser(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          "5b0d8c6e-3f1a-4c2b-9e7d-1a2b3c4d5e6f",
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+		Roles:       []string{"support"},
+	}
+	updatedUser := user
+	updatedUser.FullName = "updated name"
+	updatedUser.Roles = []string{"admin"}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		payload map[string]interface{}
+		// token is dummyAdminJWT unless set
+		token      string
+		wantStatus int
+	}{
+		{
+			name: "successfully updates the full name & the roles",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					gomock.InOrder(
+						mockRepo.EXPECT().
+							GetUserByID(gomock.Any(), user.ID).
+							Return(user, nil),
+						mockRepo.EXPECT().
+							GetUserByID(gomock.Any(), user.ID).
+							Return(updatedUser, nil),
+					)
+
+					mockRepo.EXPECT().
+						ListRoles(gomock.Any()).
+						Return([]string{"admin", "support"}, nil)
+
+					mockRepo.EXPECT().
+						UpdateUser(gomock.Any(), user.ID, repository.UpdateUserInput{
+							FullName:    "updated name",
+							PhoneNumber: user.PhoneNumber,
+						}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						SetUserRoles(gomock.Any(), repository.SetUserRolesInput{
+							UserID: user.ID,
+							Roles:  []string{"admin"},
+						}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateAdminAuditEvent(gomock.Any(), adminAuditEvent(user.ID, "update_profile", "full_name")).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateAdminAuditEvent(gomock.Any(), adminAuditEvent(user.ID, "set_roles", "admin")).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    map[string]interface{}{"full_name": "updated name", "roles": []string{"admin"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "unchanged roles are not set again",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil).
+						Times(2)
+
+					mockRepo.EXPECT().
+						ListRoles(gomock.Any()).
+						Return([]string{"admin", "support"}, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    map[string]interface{}{"roles": []string{"support"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "role does not exist",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						ListRoles(gomock.Any()).
+						Return([]string{"admin", "support"}, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    map[string]interface{}{"roles": []string{"superuser"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid payload",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					return mockRepo
+				}(),
+			},
+			payload:    map[string]interface{}{"phone_number": "0812345678"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "phone number already registered",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), "+62887654321").
+						Return(repository.UserOutput{ID: "9e8d7c6b-5a4f-4e3d-8c2b-1a0f9e8d7c6b"}, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    map[string]interface{}{"phone_number": "+62887654321"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "user not found",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			payload:    map[string]interface{}{"full_name": "updated name"},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "failed when setting the roles",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						ListRoles(gomock.Any()).
+						Return([]string{"admin", "support"}, nil)
+
+					mockRepo.EXPECT().
+						SetUserRoles(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			payload:    map[string]interface{}{"roles": []string{}},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "failed when recording the audit",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						ListRoles(gomock.Any()).
+						Return([]string{"admin", "support"}, nil)
+
+					mockRepo.EXPECT().
+						SetUserRoles(gomock.Any(), gomock.Any()).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateAdminAuditEvent(gomock.Any(), adminAuditEvent(user.ID, "set_roles", "")).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			payload:    map[string]interface{}{"roles": []string{}},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "support role cannot update users",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummySupportJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListRolePermissions(gomock.Any(), []string{"support"}).
+						Return(supportPermissions, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    map[string]interface{}{"roles": []string{"admin"}},
+			token:      dummySupportJWT,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			}))
+
+			token := tt.token
+			if token == "" {
+				token = dummyAdminJWT
+			}
+
+			response := testutil.NewRequest().Patch("/admin/users/"+user.ID).
+				WithJWSAuth(token).
+				WithJsonBody(tt.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+		})
+	}
+}
+
+func TestServer_SuspendUser(t *testing.T) {
+	userID := "5b0d8c6e-3f1a-4c2b-9e7d-1a2b3c4d5e6f"
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		id         string
+		wantStatus int
+	}{
+		{
+			name: "successfully suspends the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						SuspendUser(gomock.Any(), userID).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateAdminAuditEvent(gomock.Any(), adminAuditEvent(userID, "suspend", "")).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			id:         userID,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "user not found",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						SuspendUser(gomock.Any(), userID).
+						Return(sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			id:         userID,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "failed when suspending the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						SuspendUser(gomock.Any(), userID).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			id:         userID,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			}))
+
+			response := testutil.NewRequest().Post("/admin/users/"+tt.id+"/suspend").
+				WithJWSAuth(dummyAdminJWT).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+		})
+	}
+
+	t.Run("cannot suspend yourself", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+		mockRepo.EXPECT().
+			IsAccessTokenRevoked(gomock.Any(), dummySupportJWTRevocationCheck).
+			Return(false, nil)
+
+		mockRepo.EXPECT().
+			ListRolePermissions(gomock.Any(), []string{"support"}).
+			Return(append(supportPermissions, "users:suspend"), nil)
+
+		e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+			Repository: mockRepo,
+			JWT:        newFixtureJWT(),
+		}))
+
+		response := testutil.NewRequest().Post("/admin/users/"+dummySupportJWTClaims.ID+"/suspend").
+			WithJWSAuth(dummySupportJWT).
+			GoWithHTTPHandler(t, e)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+}
+
+func TestServer_UnsuspendUser(t *testing.T) {
+	userID := "5b0d8c6e-3f1a-4c2b-9e7d-1a2b3c4d5e6f"
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		wantStatus int
+	}{
+		{
+			name: "successfully unsuspends the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						UnsuspendUser(gomock.Any(), userID).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateAdminAuditEvent(gomock.Any(), adminAuditEvent(userID, "unsuspend", "")).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "user not found",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						UnsuspendUser(gomock.Any(), userID).
+						Return(sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			}))
+
+			response := testutil.NewRequest().Post("/admin/users/"+userID+"/unsuspend").
+				WithJWSAuth(dummyAdminJWT).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+		})
+	}
+}
+
+func TestServer_EraseUser(t *testing.T) {
+	userID := "5b0d8c6e-3f1a-4c2b-9e7d-1a2b3c4d5e6f"
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		token      string
+		id         string
+		wantStatus int
+	}{
+		{
+			name: "successfully erases the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						EraseUser(gomock.Any(), userID).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyAdminJWT,
+			id:         userID,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "support users cannot erase users",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummySupportJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListRolePermissions(gomock.Any(), []string{"support"}).
+						Return(supportPermissions, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummySupportJWT,
+			id:         userID,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "user not found",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						EraseUser(gomock.Any(), userID).
+						Return(sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyAdminJWT,
+			id:         userID,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "failed when erasing the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					expectAdminToken(mockRepo)
+
+					mockRepo.EXPECT().
+						EraseUser(gomock.Any(), userID).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyAdminJWT,
+			id:         userID,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			}))
+
+			response := testutil.NewRequest().Delete("/admin/users/"+tt.id).
+				WithJWSAuth(tt.token).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+		})
+	}
+
+	t.Run("cannot erase yourself", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+		mockRepo.EXPECT().
+			IsAccessTokenRevoked(gomock.Any(), dummySupportJWTRevocationCheck).
+			Return(false, nil)
+
+		mockRepo.EXPECT().
+			ListRolePermissions(gomock.Any(), []string{"support"}).
+			Return(append(supportPermissions, "users:erase"), nil)
+
+		e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+			Repository: mockRepo,
+			JWT:        newFixtureJWT(),
+		}))
+
+		response := testutil.NewRequest().Delete("/admin/users/"+dummySupportJWTClaims.ID).
+			WithJWSAuth(dummySupportJWT).
+			GoWithHTTPHandler(t, e)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code())
+	})
+}
+
+func TestServer_UnlockUser(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          "5b0d8c6e-3f1a-4c2b-9e7d-1a2b3c4d5e6f",
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+	}
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		token      string
+		wantStatus int
+		// wantFailures are the failed logins of the user left after the request
+		wantFailures int
+	}{
+		{
+			name: "successfully unlocks the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), adminRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListRolePermissions(gomock.Any(), []string{"admin"}).
+						Return(adminPermissions, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UnlockUser(gomock.Any(), user.ID).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateAdminAuditEvent(gomock.Any(), adminAuditEvent(user.ID, "unlock", "")).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			token:        dummyAdminJWT,
+			wantStatus:   http.StatusNoContent,
+			wantFailures: 0,
+		},
+		{
+			name: "user not found",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), adminRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListRolePermissions(gomock.Any(), []string{"admin"}).
+						Return(adminPermissions, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			token:        dummyAdminJWT,
+			wantStatus:   http.StatusNotFound,
+			wantFailures: 3,
+		},
+		{
+			name: "failed when unlocking the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), adminRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListRolePermissions(gomock.Any(), []string{"admin"}).
+						Return(adminPermissions, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UnlockUser(gomock.Any(), user.ID).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			token:        dummyAdminJWT,
+			wantStatus:   http.StatusInternalServerError,
+			wantFailures: 3,
+		},
+		{
+			name: "successfully unlocks the user with the support role",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummySupportJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListRolePermissions(gomock.Any(), []string{"support"}).
+						Return(supportPermissions, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UnlockUser(gomock.Any(), user.ID).
+						Return(nil)
+
+					// the support user is the actor, rather than a back-office client
+					mockRepo.EXPECT().
+						CreateAdminAuditEvent(gomock.Any(), repository.CreateAdminAuditEventInput{
+							UserID:      user.ID,
+							Action:      "unlock",
+							ActorUserID: &dummySupportJWTClaims.ID,
+						}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			token:        dummySupportJWT,
+			wantStatus:   http.StatusNoContent,
+			wantFailures: 0,
+		},
+		{
+			name: "token without roles is refused",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:        dummyJWT,
+			wantStatus:   http.StatusForbidden,
+			wantFailures: 3,
+		},
+		{
+			name: "missing token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			wantStatus:   http.StatusUnauthorized,
+			wantFailures: 3,
+		},
+		{
+			name: "revoked token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), adminRevocationCheck).
+						Return(true, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:        dummyAdminJWT,
+			wantStatus:   http.StatusUnauthorized,
+			wantFailures: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loginAttempts := newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+				"phone:" + user.PhoneNumber: {Failures: 3, LastFailureAt: time.Now()},
+			})
+
+			e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+				Repository:    tt.fields.Repository,
+				JWT:           newFixtureJWT(),
+				LoginAttempts: loginAttempts,
+			}))
+
+			request := testutil.NewRequest().Post("/admin/users/" + user.ID + "/unlock")
+			if tt.token != "" {
+				request = request.WithJWSAuth(tt.token)
+			}
+			response := request.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			assert.Equal(t, tt.wantFailures, loginAttempts.attempts["phone:"+user.PhoneNumber].Failures)
+		})
+	}
+}
+
+func TestServer_AdminUserIDNotUUID(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{name: "get user", method: http.MethodGet, path: "/admin/users/abc123-def456"},
+		{name: "update user", method: http.MethodPatch, path: "/admin/users/abc123-def456"},
+		{name: "erase user", method: http.MethodDelete, path: "/admin/users/abc123-def456"},
+		{name: "suspend user", method: http.MethodPost, path: "/admin/users/abc123-def456/suspend"},
+		{name: "unsuspend user", method: http.MethodPost, path: "/admin/users/abc123-def456/unsuspend"},
+		{name: "unlock user", method: http.MethodPost, path: "/admin/users/abc123-def456/unlock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockRepo := repository.NewMockRepositoryInterface(ctrl)
+			expectAdminToken(mockRepo)
+
+			e := newAdminEcho(t, handler.NewServer(handler.NewServerOptions{
+				Repository: mockRepo,
+				JWT:        newFixtureJWT(),
+			}))
+
+			response := testutil.NewRequest().WithMethod(tt.method, tt.path).
+				WithJWSAuth(dummyAdminJWT).
+				WithJsonBody(map[string]interface{}{"fullName": "test"}).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, http.StatusNotFound, response.Code())
+		})
+	}
+}
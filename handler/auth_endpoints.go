@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// Exchanges a refresh token for a new access token. The refresh token is rotated, and reusing an already rotated refresh token revokes its whole token family
+// (POST /auth/refresh)
+func (s *Server) RefreshToken(c echo.Context) error {
+	var payload RefreshTokenValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	// refresh tokens issued to oauth clients can only be used on the oauth token endpoint
+	existingToken, err := s.rotateRefreshToken(ctx, payload.RefreshToken, nil)
+	if err != nil {
+		if err == errRefreshTokenNotValid {
+			return c.JSON(http.StatusUnauthorized, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, existingToken.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	token, err := s.GenerateJWT(user, existingToken.FamilyID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	refreshToken, err := s.createRefreshToken(ctx, user.ID, tokenGrant{SessionID: existingToken.FamilyID})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, generated.AuthenticateUserResponse{
+		Id:           user.ID,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.AccessTokenTTL.Seconds()),
+	})
+}
+
+// Logs the current session out, revoking its access token & refresh tokens
+// (POST /auth/logout)
+func (s *Server) LogoutUser(c echo.Context) error {
+	claims, err := s.validateLoggedInClaims(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	err = s.Repository.RevokeAccessToken(ctx, repository.RevokeAccessTokenInput{
+		JTI:       claims.RegisteredClaims.ID,
+		UserID:    claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time.UTC(),
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	if claims.SessionID != "" {
+		err = s.Repository.RevokeRefreshTokenFamily(ctx, claims.SessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Logs every session of the logged in user out, revoking all of their access tokens & refresh tokens
+// (POST /auth/logout-all)
+func (s *Server) LogoutAllSessions(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	err = s.Repository.RevokeAllUserSessions(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+var errRefreshTokenNotValid = errors.New("refresh token not valid")
+
+// rotateRefreshToken marks the refresh token as used, so it can be exchanged for new tokens once. the token must
+// have been issued to the given oauth client, or to no client when nil. errRefreshTokenNotValid is returned when
+// the token cannot be exchanged.
+func (s *Server) rotateRefreshToken(ctx context.Context, token string, clientID *string) (repository.RefreshTokenOutput, error) {
+	existingToken, err := s.Repository.GetRefreshTokenByHash(ctx, hashOpaqueToken(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return repository.RefreshTokenOutput{}, errRefreshTokenNotValid
+		}
+
+		return repository.RefreshTokenOutput{}, err
+	}
+
+	if !sameClientID(existingToken.ClientID, clientID) {
+		return repository.RefreshTokenOutput{}, errRefreshTokenNotValid
+	}
+
+	if existingToken.RevokedAt != nil || !existingToken.ExpiresAt.After(time.Now()) {
+		return repository.RefreshTokenOutput{}, errRefreshTokenNotValid
+	}
+
+	// a rotated refresh token must never be presented again. if it is, the token
+	// has most likely leaked, so the whole family descending from the login is revoked.
+	if existingToken.RotatedAt != nil {
+		return repository.RefreshTokenOutput{}, s.revokeReusedRefreshToken(ctx, existingToken.FamilyID)
+	}
+
+	err = s.Repository.RotateRefreshToken(ctx, existingToken.ID)
+	if err != nil {
+		// another request rotated the same token in the meantime
+		if err == repository.ErrRefreshTokenNotActive {
+			return repository.RefreshTokenOutput{}, s.revokeReusedRefreshToken(ctx, existingToken.FamilyID)
+		}
+
+		return repository.RefreshTokenOutput{}, err
+	}
+
+	return existingToken, nil
+}
+
+func (s *Server) revokeReusedRefreshToken(ctx context.Context, familyID string) error {
+	err := s.Repository.RevokeRefreshTokenFamily(ctx, familyID)
+	if err != nil {
+		return err
+	}
+
+	return errRefreshTokenNotValid
+}
+
+func sameClientID(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return *a == *b
+}
+
+// createRefreshToken generates a new opaque refresh token in the family of the grant session.
+// only the token hash is stored, the raw token is returned to the client once.
+func (s *Server) createRefreshToken(ctx context.Context, userID string, grant tokenGrant) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	input := repository.CreateRefreshTokenInput{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		FamilyID:  grant.SessionID,
+		TokenHash: hashOpaqueToken(token),
+		Scope:     grant.Scope,
+		ExpiresAt: time.Now().Add(s.RefreshTokenTTL).UTC(),
+	}
+	if grant.ClientID != "" {
+		input.ClientID = &grant.ClientID
+	}
+
+	err = s.Repository.CreateRefreshToken(ctx, input)
+	if err != nil {
+		return "", errors.Wrap(err, "error storing refresh token")
+	}
+
+	return token, nil
+}
+
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "error generating random token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// opaque tokens carry 256 bits of entropy, so an unsalted sha256 is enough to
+// keep the stored value useless if the database leaks.
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,628 @@
+package handler_test
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_RefreshToken(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          "c118a1a9-28f1-4137-9093-87487d24e5d9",
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+		LoginCount:  1,
+	}
+
+	sum := sha256.Sum256([]byte("refresh-token"))
+	tokenHash := hex.EncodeToString(sum[:])
+	activeToken := repository.RefreshTokenOutput{
+		ID:        "token-id",
+		UserID:    user.ID,
+		FamilyID:  "family-id",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	past := time.Now().Add(-time.Hour)
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+		JWT        *handler.JWT
+	}
+	type args struct {
+		payload map[string]interface{}
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		args       args
+		wantStatus int
+	}{
+		{
+			name: "successfully rotates the refresh token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(activeToken, nil)
+
+					mockRepo.EXPECT().
+						RotateRefreshToken(gomock.Any(), activeToken.ID).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID, familyID: activeToken.FamilyID}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "failed to parse payload",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": 123,
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "refresh token not found",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(repository.RefreshTokenOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "refresh token issued to an oauth client",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					clientID := "mobile-app"
+					clientToken := activeToken
+					clientToken.ClientID = &clientID
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(clientToken, nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "error fetching refresh token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(repository.RefreshTokenOutput{}, assert.AnError)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "refresh token is expired",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					expiredToken := activeToken
+					expiredToken.ExpiresAt = past
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(expiredToken, nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "refresh token is revoked",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					revokedToken := activeToken
+					revokedToken.RevokedAt = &past
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(revokedToken, nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "reused refresh token revokes the family",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					rotatedToken := activeToken
+					rotatedToken.RotatedAt = &past
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(rotatedToken, nil)
+
+					mockRepo.EXPECT().
+						RevokeRefreshTokenFamily(gomock.Any(), activeToken.FamilyID).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "concurrently rotated refresh token revokes the family",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(activeToken, nil)
+
+					mockRepo.EXPECT().
+						RotateRefreshToken(gomock.Any(), activeToken.ID).
+						Return(repository.ErrRefreshTokenNotActive)
+
+					mockRepo.EXPECT().
+						RevokeRefreshTokenFamily(gomock.Any(), activeToken.FamilyID).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "failed revoking the family of a reused refresh token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					rotatedToken := activeToken
+					rotatedToken.RotatedAt = &past
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(rotatedToken, nil)
+
+					mockRepo.EXPECT().
+						RevokeRefreshTokenFamily(gomock.Any(), activeToken.FamilyID).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "failed when generating jwt",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), tokenHash).
+						Return(activeToken, nil)
+
+					mockRepo.EXPECT().
+						RotateRefreshToken(gomock.Any(), activeToken.ID).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+				// a JWT without any key loaded fails to sign tokens
+				JWT: &handler.JWT{},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"refresh_token": "refresh-token",
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        tt.fields.JWT,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/auth/refresh").
+				WithAcceptJson().
+				WithJsonBody(tt.args.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+		})
+	}
+}
+
+func TestServer_LogoutUser(t *testing.T) {
+	type fields struct {
+		Repository repository.RepositoryInterface
+		JWT        *handler.JWT
+	}
+	type args struct {
+		header map[string]string
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		args       args
+		wantStatus int
+	}{
+		{
+			name: "successfully logs the current session out",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						RevokeAccessToken(gomock.Any(), repository.RevokeAccessTokenInput{
+							JTI:       dummyJWTClaims.RegisteredClaims.ID,
+							UserID:    dummyJWTClaims.ID,
+							ExpiresAt: dummyJWTClaims.ExpiresAt.Time.UTC(),
+						}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						RevokeRefreshTokenFamily(gomock.Any(), dummyJWTClaims.SessionID).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": fmt.Sprintf("Bearer %s", dummyJWT),
+				},
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args:       args{},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "token has already been revoked",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(true, nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": fmt.Sprintf("Bearer %s", dummyJWT),
+				},
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "failed revoking the access token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						RevokeAccessToken(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": fmt.Sprintf("Bearer %s", dummyJWT),
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "failed revoking the session refresh tokens",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						RevokeAccessToken(gomock.Any(), gomock.Any()).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						RevokeRefreshTokenFamily(gomock.Any(), dummyJWTClaims.SessionID).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": fmt.Sprintf("Bearer %s", dummyJWT),
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        tt.fields.JWT,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			req := testutil.NewRequest().Post("/auth/logout").
+				WithAcceptJson()
+
+			for key, value := range tt.args.header {
+				req = req.WithHeader(key, value)
+			}
+
+			response := req.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+		})
+	}
+}
+
+func TestServer_LogoutAllSessions(t *testing.T) {
+	type fields struct {
+		Repository repository.RepositoryInterface
+		JWT        *handler.JWT
+	}
+	type args struct {
+		header map[string]string
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		args       args
+		wantStatus int
+	}{
+		{
+			name: "successfully logs every session out",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						RevokeAllUserSessions(gomock.Any(), dummyJWTClaims.ID).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": fmt.Sprintf("Bearer %s", dummyJWT),
+				},
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args:       args{},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "failed revoking every session",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						RevokeAllUserSessions(gomock.Any(), dummyJWTClaims.ID).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": fmt.Sprintf("Bearer %s", dummyJWT),
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        tt.fields.JWT,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			req := testutil.NewRequest().Post("/auth/logout-all").
+				WithAcceptJson()
+
+			for key, value := range tt.args.header {
+				req = req.WithHeader(key, value)
+			}
+
+			response := req.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BreachedPasswordChecker tells whether a password is known from a data breach
+type BreachedPasswordChecker interface {
+	IsBreached(ctx context.Context, password string) (bool, error)
+}
+
+// pwnedPasswordsPrefixLength is the length of the sha-1 prefix naming the range files, as served by the
+// k-anonymity api of Have I Been Pwned
+const pwnedPasswordsPrefixLength = 5
+
+// pwnedPasswordsMaxLineLength bounds the lines of the sorted file, a 40 characters hash, a count & a CRLF
+const pwnedPasswordsMaxLineLength = 64
+
+var errPwnedPasswordsLineNotValid = errors.New("pwned passwords line not valid")
+
+// NewPwnedPasswordsChecker checks the passwords against an offline copy of the Pwned Passwords corpus, in either of
+// the formats of the official downloader:
+//   - a directory of range files, <PREFIX>.txt holding the SUFFIX:COUNT lines of the hashes with this 5 characters prefix
+//   - a single file of HASH:COUNT lines, ordered by hash. it is binary searched on disk, never loaded in memory.
+func NewPwnedPasswordsChecker(path string) (BreachedPasswordChecker, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening pwned passwords")
+	}
+
+	if info.IsDir() {
+		return &PwnedPasswordsRanges{dir: path}, nil
+	}
+
+	return &PwnedPasswordsFile{path: path, size: info.Size()}, nil
+}
+
+// PwnedPasswordsRanges looks the passwords up in a directory of range files
+type PwnedPasswordsRanges struct {
+	dir string
+}
+
+func (r *PwnedPasswordsRanges) IsBreached(ctx context.Context, password string) (bool, error) {
+	hash := pwnedPasswordHash(password)
+	prefix, suffix := hash[:pwnedPasswordsPrefixLength], hash[pwnedPasswordsPrefixLength:]
+
+	f, err := os.Open(filepath.Join(r.dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "error opening pwned passwords range")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineSuffix, count, err := parsePwnedPasswordsLine(scanner.Text())
+		if err != nil {
+			return false, err
+		}
+		// the padding lines of the api have a count of 0
+		if strings.EqualFold(lineSuffix, suffix) {
+			return count > 0, nil
+		}
+	}
+
+	return false, errors.Wrap(scanner.Err(), "error reading pwned passwords range")
+}
+
+// PwnedPasswordsFile binary searches a file of full hashes ordered by hash, reading a single line per step
+type PwnedPasswordsFile struct {
+	path string
+	size int64
+}
+
+func (p *PwnedPasswordsFile) IsBreached(ctx context.Context, password string) (bool, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return false, errors.Wrap(err, "error opening pwned passwords")
+	}
+	defer f.Close()
+
+	hash := pwnedPasswordHash(password)
+
+	// the lines left to search are the ones starting within [lo, hi)
+	lo, hi := int64(0), p.size
+	for lo < hi {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+
+		mid := lo + (hi-lo)/2
+		start, line, err := readLineFrom(f, mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		lineHash, count, err := parsePwnedPasswordsLine(strings.TrimRight(string(line), "\r\n"))
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.Compare(strings.ToUpper(lineHash), hash) {
+		case 0:
+			return count > 0, nil
+		case -1:
+			lo = start + int64(len(line))
+		default:
+			hi = mid
+		}
+	}
+
+	return false, nil
+}
+
+// readLineFrom reads the first line starting at or after the offset, with its line break. the start is past the end
+// of the file when there is no such line.
+func readLineFrom(r io.ReaderAt, offset int64) (int64, []byte, error) {
+	// reading from the byte before tells whether the offset is the start of a line
+	readFrom := offset
+	if offset > 0 {
+		readFrom--
+	}
+
+	buf := make([]byte, 2*pwnedPasswordsMaxLineLength)
+	n, err := r.ReadAt(buf, readFrom)
+	if err != nil && err != io.EOF {
+		return 0, nil, errors.Wrap(err, "error reading pwned passwords")
+	}
+	buf = buf[:n]
+
+	start := offset
+	if offset > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 && err == io.EOF {
+			return readFrom + int64(n) + 1, nil, nil
+		}
+		if i < 0 {
+			return 0, nil, errPwnedPasswordsLineNotValid
+		}
+		buf = buf[i+1:]
+		start = readFrom + int64(i) + 1
+	}
+
+	if end := bytes.IndexByte(buf, '\n'); end >= 0 {
+		return start, buf[:end+1], nil
+	}
+	if err == io.EOF {
+		// the last line may not end with a line break
+		return start, buf, nil
+	}
+
+	return 0, nil, errPwnedPasswordsLineNotValid
+}
+
+// parsePwnedPasswordsLine parses a HASH:COUNT line, the hash being either a full hash or the suffix of a range file
+func parsePwnedPasswordsLine(line string) (string, int64, error) {
+	hash, countValue, ok := strings.Cut(line, ":")
+	if !ok {
+		return "", 0, errPwnedPasswordsLineNotValid
+	}
+
+	count, err := strconv.ParseInt(strings.TrimSpace(countValue), 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %q", errPwnedPasswordsLineNotValid, line)
+	}
+
+	return hash, count, nil
+}
+
+// pwnedPasswordHash is the uppercase hex sha-1 of the password, as used by the Pwned Passwords corpus
+func pwnedPasswordHash(password string) string {
+	sum := sha1.Sum([]byte(password))
+
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,108 @@
+package handler_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/stretchr/testify/assert"
+)
+
+func pwnedPasswordHash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// newPwnedPasswordsRanges writes the range files of the breached passwords, as served by the api
+func newPwnedPasswordsRanges(t *testing.T, passwords ...string) string {
+	dir := t.TempDir()
+
+	ranges := map[string][]string{}
+	for i, password := range passwords {
+		hash := pwnedPasswordHash(password)
+		ranges[hash[:5]] = append(ranges[hash[:5]], fmt.Sprintf("%s:%d", hash[5:], i+1))
+	}
+	for prefix, lines := range ranges {
+		// a padding line, which is not a breached password
+		lines = append(lines, "0000000000000000000000000000000000A:0")
+
+		err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\r\n")), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// newPwnedPasswordsFile writes the full hashes of the breached passwords ordered by hash, like the default output
+// of the downloader
+func newPwnedPasswordsFile(t *testing.T, passwords ...string) string {
+	lines := []string{}
+	for i, password := range passwords {
+		// counts of various lengths, so the lines are not of the same length
+		lines = append(lines, fmt.Sprintf("%s:%d", pwnedPasswordHash(password), (i+1)*(i+1)*997))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwnedpasswords.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestPwnedPasswordsChecker(t *testing.T) {
+	breached := []string{}
+	for i := 0; i < 200; i++ {
+		breached = append(breached, fmt.Sprintf("Password%d!", i))
+	}
+
+	for name, path := range map[string]string{
+		"range files": newPwnedPasswordsRanges(t, breached...),
+		"sorted file": newPwnedPasswordsFile(t, breached...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			checker, err := handler.NewPwnedPasswordsChecker(path)
+			assert.NoError(t, err)
+
+			for _, password := range breached {
+				isBreached, err := checker.IsBreached(context.Background(), password)
+				assert.NoError(t, err)
+				assert.True(t, isBreached, password)
+			}
+
+			for _, password := range []string{"Enter123!", "Password200!", "password0!", ""} {
+				isBreached, err := checker.IsBreached(context.Background(), password)
+				assert.NoError(t, err)
+				assert.False(t, isBreached, password)
+			}
+		})
+	}
+
+	t.Run("missing corpus", func(t *testing.T) {
+		_, err := handler.NewPwnedPasswordsChecker(filepath.Join(t.TempDir(), "missing"))
+		assert.Error(t, err)
+	})
+
+	t.Run("malformed corpus", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "pwnedpasswords.txt")
+		if err := os.WriteFile(path, []byte("not a corpus\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		checker, err := handler.NewPwnedPasswordsChecker(path)
+		assert.NoError(t, err)
+
+		_, err = checker.IsBreached(context.Background(), "Enter123!")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,373 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dataExportLoginPageSize is the number of logins read at once while building an archive
+	dataExportLoginPageSize = 500
+	// dataExportLease is how long a job has to build an archive before another job takes the export over
+	dataExportLease = 10 * time.Minute
+)
+
+// Requests an export of the personal data of the logged in user. The archive is built in the background, its
+// download link is returned by GET /users/me/export once ready
+// (POST /users/me/export)
+func (s *Server) RequestDataExport(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	var payload RequestDataExportValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	format := generated.DataExportFormat(payload.Format)
+	if format == "" {
+		format = generated.Json
+	}
+	if format != generated.Json && format != generated.Zip {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "format must be json or zip",
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	// a single archive is built at a time for a user, requesting another one returns the pending export
+	latest, err := s.Repository.GetLatestDataExport(ctx, id)
+	if err == nil && latest.CompletedAt == nil {
+		return c.JSON(http.StatusAccepted, dataExportResponse(latest))
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	input := repository.CreateDataExportInput{
+		ID:     uuid.NewString(),
+		UserID: id,
+		Format: string(format),
+	}
+	err = s.Repository.CreateDataExport(ctx, input)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusAccepted, dataExportResponse(repository.DataExportOutput{
+		ID:        input.ID,
+		UserID:    input.UserID,
+		Format:    input.Format,
+		CreatedAt: time.Now().UTC(),
+	}))
+}
+
+// Returns the latest export of the personal data of the logged in user. Once ready, it carries a new download link,
+// the previous links stop working
+// (GET /users/me/export)
+func (s *Server) GetDataExport(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	export, err := s.Repository.GetLatestDataExport(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "data export not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	response := dataExportResponse(export)
+	if export.CompletedAt == nil {
+		return c.JSON(http.StatusOK, response)
+	}
+
+	// only the hash of the token is stored, so the link is replaced every time it is shown
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	err = s.Repository.SetDataExportDownloadToken(ctx, repository.SetDataExportDownloadTokenInput{
+		ID:        export.ID,
+		TokenHash: hashOpaqueToken(token),
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "data export not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	downloadURL := strings.TrimSuffix(s.JWT.Issuer(), "/") + "/exports/" + token
+	response.DownloadUrl = &downloadURL
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// Downloads the archive of a personal data export. The token of the link is the only credential required
+// (GET /exports/{token})
+func (s *Server) DownloadDataExport(c echo.Context, token string) error {
+	archive, err := s.Repository.GetDataExportArchive(c.Request().Context(), hashOpaqueToken(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "data export not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	contentType := echo.MIMEApplicationJSON
+	if archive.Format == string(generated.Zip) {
+		contentType = "application/zip"
+	}
+
+	filename := fmt.Sprintf("user-data-%s.%s", archive.CompletedAt.Format("2006-01-02"), archive.Format)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	// the link is a credential, the archive must not be kept by a cache along the way
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	return c.Blob(http.StatusOK, contentType, archive.Archive)
+}
+
+// ProcessDataExports builds the archives of the pending data exports until none is left, then deletes the expired
+// ones. it returns the number of archives built, and is meant to be run periodically by any number of instances.
+func (s *Server) ProcessDataExports(ctx context.Context) (int64, error) {
+	var built int64
+	for {
+		export, err := s.Repository.ClaimDataExport(ctx, repository.ClaimDataExportInput{
+			StartedBefore: time.Now().Add(-dataExportLease).UTC(),
+		})
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return built, err
+		}
+
+		archive, err := s.buildDataExportArchive(ctx, export)
+		if err != nil {
+			return built, errors.Wrapf(err, "error building data export %s", export.ID)
+		}
+
+		// the export is already completed when another job took it over, its archive is kept
+		err = s.Repository.CompleteDataExport(ctx, repository.CompleteDataExportInput{
+			ID:        export.ID,
+			Archive:   archive,
+			ExpiresAt: time.Now().Add(s.DataExportTTL).UTC(),
+		})
+		if err != nil && err != sql.ErrNoRows {
+			return built, err
+		}
+
+		built++
+	}
+
+	_, err := s.Repository.DeleteExpiredDataExports(ctx)
+
+	return built, err
+}
+
+// buildDataExportArchive gathers everything stored about the user of the export, in the format of the export
+func (s *Server) buildDataExportArchive(ctx context.Context, export repository.DataExportOutput) ([]byte, error) {
+	user, err := s.Repository.GetUserByID(ctx, export.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactorEnabled, err := s.isMFAEnrolled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := generated.DataExportArchive{
+		ExportedAt: time.Now().UTC(),
+		User: generated.DataExportUser{
+			Id:               user.ID,
+			FullName:         user.FullName,
+			PhoneNumber:      user.PhoneNumber,
+			PhoneVerifiedAt:  user.PhoneVerifiedAt,
+			LoginCount:       int64(user.LoginCount),
+			LastLoginAt:      user.LastLoginAt,
+			LockedUntil:      user.LockedUntil,
+			SuspendedAt:      user.SuspendedAt,
+			Roles:            append([]string{}, user.Roles...),
+			TwoFactorEnabled: twoFactorEnabled,
+			CreatedAt:        user.CreatedAt,
+		},
+		Logins:       []generated.LoginEvent{},
+		Sessions:     []generated.Session{},
+		Passkeys:     []generated.DataExportPasskey{},
+		Consents:     []generated.DataExportConsent{},
+		AdminActions: []generated.DataExportAdminAction{},
+	}
+
+	// the login history is read by pages, as it grows with every login
+	var beforeID int64
+	for {
+		events, err := s.Repository.ListLoginEvents(ctx, repository.ListLoginEventsInput{
+			UserID:   user.ID,
+			BeforeID: beforeID,
+			Limit:    dataExportLoginPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range events {
+			archive.Logins = append(archive.Logins, loginEventResponse(event))
+		}
+		if len(events) < dataExportLoginPageSize {
+			break
+		}
+		beforeID = events[len(events)-1].ID
+	}
+
+	sessions, err := s.Repository.ListUserSessions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		archive.Sessions = append(archive.Sessions, sessionResponse(session, ""))
+	}
+
+	credentials, err := s.Repository.ListWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, credential := range credentials {
+		archive.Passkeys = append(archive.Passkeys, generated.DataExportPasskey{
+			Id:         credential.ID,
+			Transports: strings.Fields(credential.Transports),
+			CreatedAt:  credential.CreatedAt,
+		})
+	}
+
+	consents, err := s.Repository.ListUserConsents(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, consent := range consents {
+		archive.Consents = append(archive.Consents, generated.DataExportConsent{
+			ClientId:   consent.ClientID,
+			ClientName: consent.ClientName,
+		})
+	}
+
+	// the admins who acted are kept in the audit, but are not part of the data of the user
+	events, err := s.Repository.ListAdminAuditEvents(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		archive.AdminActions = append(archive.AdminActions, generated.DataExportAdminAction{
+			Action:    event.Action,
+			Detail:    event.Detail,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+
+	if export.Format == string(generated.Zip) {
+		return zipDataExportArchive(archive)
+	}
+
+	return json.Marshal(archive)
+}
+
+// zipDataExportArchive bundles every section of the archive as a JSON file of its own
+func zipDataExportArchive(archive generated.DataExportArchive) ([]byte, error) {
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"user.json", archive.User},
+		{"logins.json", archive.Logins},
+		{"sessions.json", archive.Sessions},
+		{"passkeys.json", archive.Passkeys},
+		{"consents.json", archive.Consents},
+		{"admin_actions.json", archive.AdminActions},
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, file := range files {
+		f, err := w.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: archive.ExportedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.NewEncoder(f).Encode(file.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// dataExportResponse returns the export, without its download link
+func dataExportResponse(export repository.DataExportOutput) generated.DataExport {
+	response := generated.DataExport{
+		Id:          export.ID,
+		Format:      generated.DataExportFormat(export.Format),
+		Status:      generated.Pending,
+		CreatedAt:   export.CreatedAt,
+		CompletedAt: export.CompletedAt,
+		ExpiresAt:   export.ExpiresAt,
+	}
+	if export.CompletedAt != nil {
+		response.Status = generated.Ready
+	}
+
+	return response
+}
@@ -0,0 +1,818 @@
+package handler_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type createDataExportInputMatcher struct {
+	userID string
+	format string
+}
+
+func (m createDataExportInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.CreateDataExportInput)
+	if !ok {
+		return false
+	}
+
+	return actualInput.ID != "" && actualInput.UserID == m.userID && actualInput.Format == m.format
+}
+
+func (m createDataExportInputMatcher) String() string {
+	return "{CreateDataExportInput - UserID:" + m.userID + ", Format:" + m.format + "}"
+}
+
+func TestServer_RequestDataExport(t *testing.T) {
+	completedAt := time.Now().Add(-time.Hour)
+	expiresAt := time.Now().Add(time.Hour)
+	pendingExport := repository.DataExportOutput{
+		ID:        "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+		UserID:    dummyJWTClaims.ID,
+		Format:    "json",
+		CreatedAt: time.Now().Add(-time.Minute),
+	}
+	readyExport := pendingExport
+	readyExport.CompletedAt = &completedAt
+	readyExport.ExpiresAt = &expiresAt
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		token      string
+		payload    map[string]interface{}
+		wantStatus int
+		wantExport generated.DataExport
+	}{
+		{
+			name: "successfully requests a json export by default",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetLatestDataExport(gomock.Any(), dummyJWTClaims.ID).
+						Return(repository.DataExportOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateDataExport(gomock.Any(), createDataExportInputMatcher{userID: dummyJWTClaims.ID, format: "json"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    map[string]interface{}{},
+			wantStatus: http.StatusAccepted,
+			wantExport: generated.DataExport{Format: generated.Json, Status: generated.Pending},
+		},
+		{
+			name: "successfully requests a zip export once the previous one is ready",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetLatestDataExport(gomock.Any(), dummyJWTClaims.ID).
+						Return(readyExport, nil)
+
+					mockRepo.EXPECT().
+						CreateDataExport(gomock.Any(), createDataExportInputMatcher{userID: dummyJWTClaims.ID, format: "zip"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    map[string]interface{}{"format": "zip"},
+			wantStatus: http.StatusAccepted,
+			wantExport: generated.DataExport{Format: generated.Zip, Status: generated.Pending},
+		},
+		{
+			name: "returns the export already pending",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetLatestDataExport(gomock.Any(), dummyJWTClaims.ID).
+						Return(pendingExport, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    map[string]interface{}{"format": "zip"},
+			wantStatus: http.StatusAccepted,
+			wantExport: generated.DataExport{Id: pendingExport.ID, Format: generated.Json, Status: generated.Pending},
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+			},
+			payload:    map[string]interface{}{},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "format not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    map[string]interface{}{"format": "csv"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed when creating the export",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetLatestDataExport(gomock.Any(), dummyJWTClaims.ID).
+						Return(repository.DataExportOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateDataExport(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    map[string]interface{}{},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/users/me/export").
+				WithJWSAuth(tt.token).
+				WithJsonBody(tt.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusAccepted {
+				return
+			}
+
+			var export generated.DataExport
+			err := response.UnmarshalBodyToObject(&export)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, export.Id)
+			if tt.wantExport.Id != "" {
+				assert.Equal(t, tt.wantExport.Id, export.Id)
+			}
+			assert.Equal(t, tt.wantExport.Format, export.Format)
+			assert.Equal(t, tt.wantExport.Status, export.Status)
+			assert.Nil(t, export.DownloadUrl)
+		})
+	}
+}
+
+type setDataExportDownloadTokenInputMatcher struct {
+	id string
+}
+
+func (m setDataExportDownloadTokenInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.SetDataExportDownloadTokenInput)
+	if !ok {
+		return false
+	}
+
+	return actualInput.ID == m.id && len(actualInput.TokenHash) == 64
+}
+
+func (m setDataExportDownloadTokenInputMatcher) String() string {
+	return "{SetDataExportDownloadTokenInput - ID:" + m.id + ", sha256 of a token}"
+}
+
+func TestServer_GetDataExport(t *testing.T) {
+	completedAt := time.Now().Add(-time.Hour)
+	expiresAt := time.Now().Add(time.Hour)
+	pendingExport := repository.DataExportOutput{
+		ID:        "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+		UserID:    dummyJWTClaims.ID,
+		Format:    "zip",
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+	}
+	readyExport := pendingExport
+	readyExport.CompletedAt = &completedAt
+	readyExport.ExpiresAt = &expiresAt
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		token      string
+		wantStatus int
+		// wantExportStatus is the status of the export returned, which carries a download link once ready
+		wantExportStatus generated.DataExportStatus
+	}{
+		{
+			name: "pending export has no download link",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetLatestDataExport(gomock.Any(), dummyJWTClaims.ID).
+						Return(pendingExport, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:            dummyJWT,
+			wantStatus:       http.StatusOK,
+			wantExportStatus: generated.Pending,
+		},
+		{
+			name: "ready export carries a new download link",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetLatestDataExport(gomock.Any(), dummyJWTClaims.ID).
+						Return(readyExport, nil)
+
+					mockRepo.EXPECT().
+						SetDataExportDownloadToken(gomock.Any(), setDataExportDownloadTokenInputMatcher{id: readyExport.ID}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			token:            dummyJWT,
+			wantStatus:       http.StatusOK,
+			wantExportStatus: generated.Ready,
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "user has no export",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetLatestDataExport(gomock.Any(), dummyJWTClaims.ID).
+						Return(repository.DataExportOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "export expired while setting its download link",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetLatestDataExport(gomock.Any(), dummyJWTClaims.ID).
+						Return(readyExport, nil)
+
+					mockRepo.EXPECT().
+						SetDataExportDownloadToken(gomock.Any(), gomock.Any()).
+						Return(sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "failed when getting the export",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetLatestDataExport(gomock.Any(), dummyJWTClaims.ID).
+						Return(repository.DataExportOutput{}, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Get("/users/me/export").
+				WithJWSAuth(tt.token).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var export generated.DataExport
+			err := response.UnmarshalBodyToObject(&export)
+			assert.NoError(t, err)
+			assert.Equal(t, pendingExport.ID, export.Id)
+			assert.Equal(t, generated.Zip, export.Format)
+			assert.Equal(t, tt.wantExportStatus, export.Status)
+			if tt.wantExportStatus == generated.Pending {
+				assert.Nil(t, export.DownloadUrl)
+				return
+			}
+
+			if assert.NotNil(t, export.DownloadUrl) {
+				assert.True(t, strings.HasPrefix(*export.DownloadUrl, fixtureIssuer+"/exports/"))
+			}
+			assert.NotNil(t, export.ExpiresAt)
+		})
+	}
+}
+
+func TestServer_DownloadDataExport(t *testing.T) {
+	// the sha256 of the token in the link
+	tokenHash := "9c4f6cfdfff40c629a0200b416fa5e754ce416cd11ecce01bde9baded075de37"
+	completedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name            string
+		fields          fields
+		wantStatus      int
+		wantContentType string
+		wantFilename    string
+	}{
+		{
+			name: "successfully downloads a json archive",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetDataExportArchive(gomock.Any(), tokenHash).
+						Return(repository.DataExportArchiveOutput{
+							Format:      "json",
+							Archive:     []byte(`{"user":{}}`),
+							CompletedAt: completedAt,
+						}, nil)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/json",
+			wantFilename:    `attachment; filename="user-data-2024-01-02.json"`,
+		},
+		{
+			name: "successfully downloads a zip archive",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetDataExportArchive(gomock.Any(), tokenHash).
+						Return(repository.DataExportArchiveOutput{
+							Format:      "zip",
+							Archive:     []byte("PK"),
+							CompletedAt: completedAt,
+						}, nil)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/zip",
+			wantFilename:    `attachment; filename="user-data-2024-01-02.zip"`,
+		},
+		{
+			name: "link unknown, replaced or expired",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetDataExportArchive(gomock.Any(), tokenHash).
+						Return(repository.DataExportArchiveOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Get("/exports/download-token").
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			header := response.Recorder.Header()
+			assert.Equal(t, tt.wantContentType, header.Get("Content-Type"))
+			assert.Equal(t, tt.wantFilename, header.Get("Content-Disposition"))
+			assert.Equal(t, "no-store", header.Get("Cache-Control"))
+		})
+	}
+}
+
+func TestServer_ProcessDataExports(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          dummyJWTClaims.ID,
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+		LoginCount:  3,
+		Roles:       []string{"support"},
+		CreatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	jsonExport := repository.DataExportOutput{
+		ID:     "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+		UserID: user.ID,
+		Format: "json",
+	}
+	zipExport := repository.DataExportOutput{
+		ID:     "5f0c9d62-8a1b-4f4e-9a3e-1d2c3b4a5e6f",
+		UserID: user.ID,
+		Format: "zip",
+	}
+
+	// a first page full of logins, so the next page is read too
+	logins := make([]repository.LoginEventOutput, 500)
+	for i := range logins {
+		logins[i] = repository.LoginEventOutput{
+			ID:        int64(1000 - i),
+			Method:    "password",
+			IPAddress: "203.0.113.7",
+			Succeeded: true,
+			CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	lastLogin := repository.LoginEventOutput{
+		ID:            1,
+		Method:        "password",
+		IPAddress:     "203.0.113.7",
+		FailureReason: "invalid_credentials",
+		CreatedAt:     time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	sessions := []repository.SessionOutput{
+		{
+			ID:        "e4d3c2b1-0a9f-4e8d-7c6b-5a4f3e2d1c0b",
+			IPAddress: "203.0.113.7",
+			ExpiresAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	credentials := []repository.WebAuthnCredentialOutput{
+		{
+			ID:         "credential-id",
+			UserID:     user.ID,
+			PublicKey:  []byte("public-key"),
+			Transports: "internal hybrid",
+		},
+	}
+	consents := []repository.ConsentOutput{
+		{
+			ClientID:   "mobile-app",
+			ClientName: "Mobile App",
+		},
+	}
+	adminActorID := "9c8b7a6f-5e4d-4c3b-a291-8f7e6d5c4b3a"
+	adminEvents := []repository.AdminAuditEventOutput{
+		{
+			ID:          2,
+			Action:      "set_roles",
+			Detail:      "support",
+			ActorUserID: &adminActorID,
+			CreatedAt:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			ID:        1,
+			Action:    "unlock",
+			CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	// expectUserData expects the lookups of everything stored about the user
+	expectUserData := func(mockRepo *repository.MockRepositoryInterface) {
+		mockRepo.EXPECT().
+			GetUserByID(gomock.Any(), user.ID).
+			Return(user, nil)
+
+		mockRepo.EXPECT().
+			GetTOTPSecret(gomock.Any(), user.ID).
+			Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+		gomock.InOrder(
+			mockRepo.EXPECT().
+				ListLoginEvents(gomock.Any(), repository.ListLoginEventsInput{UserID: user.ID, Limit: 500}).
+				Return(logins, nil),
+			mockRepo.EXPECT().
+				ListLoginEvents(gomock.Any(), repository.ListLoginEventsInput{UserID: user.ID, BeforeID: 501, Limit: 500}).
+				Return([]repository.LoginEventOutput{lastLogin}, nil),
+		)
+
+		mockRepo.EXPECT().
+			ListUserSessions(gomock.Any(), user.ID).
+			Return(sessions, nil)
+
+		mockRepo.EXPECT().
+			ListWebAuthnCredentials(gomock.Any(), user.ID).
+			Return(credentials, nil)
+
+		mockRepo.EXPECT().
+			ListUserConsents(gomock.Any(), user.ID).
+			Return(consents, nil)
+
+		mockRepo.EXPECT().
+			ListAdminAuditEvents(gomock.Any(), user.ID).
+			Return(adminEvents, nil)
+	}
+
+	// checkArchive checks the sections of an archive against the data of the user
+	checkArchive := func(t *testing.T, archive generated.DataExportArchive) {
+		assert.Equal(t, user.ID, archive.User.Id)
+		assert.Equal(t, user.PhoneNumber, archive.User.PhoneNumber)
+		assert.Equal(t, int64(3), archive.User.LoginCount)
+		assert.Equal(t, []string{"support"}, archive.User.Roles)
+		assert.False(t, archive.User.TwoFactorEnabled)
+		if assert.Len(t, archive.Logins, 501) {
+			assert.Equal(t, "invalid_credentials", *archive.Logins[500].FailureReason)
+		}
+		if assert.Len(t, archive.Sessions, 1) {
+			assert.False(t, archive.Sessions[0].Current)
+		}
+		if assert.Len(t, archive.Passkeys, 1) {
+			assert.Equal(t, []string{"internal", "hybrid"}, archive.Passkeys[0].Transports)
+		}
+		assert.Equal(t, []generated.DataExportConsent{{ClientId: "mobile-app", ClientName: "Mobile App"}}, archive.Consents)
+		// the admins who acted are not exported
+		assert.Equal(t, []generated.DataExportAdminAction{
+			{Action: "set_roles", Detail: "support", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+			{Action: "unlock", Detail: "", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		}, archive.AdminActions)
+	}
+
+	t.Run("builds a json archive", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+		var archive []byte
+		gomock.InOrder(
+			mockRepo.EXPECT().
+				ClaimDataExport(gomock.Any(), gomock.Any()).
+				Return(jsonExport, nil),
+			mockRepo.EXPECT().
+				CompleteDataExport(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, input repository.CompleteDataExportInput) error {
+					assert.Equal(t, jsonExport.ID, input.ID)
+					assert.WithinDuration(t, time.Now().Add(2*time.Hour), input.ExpiresAt, time.Minute)
+					archive = input.Archive
+					return nil
+				}),
+			mockRepo.EXPECT().
+				ClaimDataExport(gomock.Any(), gomock.Any()).
+				Return(repository.DataExportOutput{}, sql.ErrNoRows),
+			mockRepo.EXPECT().
+				DeleteExpiredDataExports(gomock.Any()).
+				Return(int64(0), nil),
+		)
+		expectUserData(mockRepo)
+
+		s := handler.NewServer(handler.NewServerOptions{
+			Repository:    mockRepo,
+			DataExportTTL: 2 * time.Hour,
+		})
+
+		built, err := s.ProcessDataExports(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), built)
+
+		var document generated.DataExportArchive
+		err = json.Unmarshal(archive, &document)
+		assert.NoError(t, err)
+		checkArchive(t, document)
+	})
+
+	t.Run("builds a zip archive with a file per section", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+		var archive []byte
+		gomock.InOrder(
+			mockRepo.EXPECT().
+				ClaimDataExport(gomock.Any(), gomock.Any()).
+				Return(zipExport, nil),
+			mockRepo.EXPECT().
+				CompleteDataExport(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, input repository.CompleteDataExportInput) error {
+					archive = input.Archive
+					return nil
+				}),
+			mockRepo.EXPECT().
+				ClaimDataExport(gomock.Any(), gomock.Any()).
+				Return(repository.DataExportOutput{}, sql.ErrNoRows),
+			mockRepo.EXPECT().
+				DeleteExpiredDataExports(gomock.Any()).
+				Return(int64(0), nil),
+		)
+		expectUserData(mockRepo)
+
+		s := handler.NewServer(handler.NewServerOptions{
+			Repository: mockRepo,
+		})
+
+		built, err := s.ProcessDataExports(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), built)
+
+		r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		sections := map[string]interface{}{}
+		var document generated.DataExportArchive
+		sections["user.json"] = &document.User
+		sections["logins.json"] = &document.Logins
+		sections["sessions.json"] = &document.Sessions
+		sections["passkeys.json"] = &document.Passkeys
+		sections["consents.json"] = &document.Consents
+		sections["admin_actions.json"] = &document.AdminActions
+
+		assert.Len(t, r.File, len(sections))
+		for _, file := range r.File {
+			f, err := file.Open()
+			if !assert.NoError(t, err) {
+				return
+			}
+			content, err := io.ReadAll(f)
+			f.Close()
+			assert.NoError(t, err)
+
+			section, ok := sections[file.Name]
+			if assert.True(t, ok, file.Name) {
+				assert.NoError(t, json.Unmarshal(content, section))
+			}
+		}
+		checkArchive(t, document)
+	})
+
+	t.Run("nothing to export still deletes the expired exports", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+		mockRepo.EXPECT().
+			ClaimDataExport(gomock.Any(), gomock.Any()).
+			Return(repository.DataExportOutput{}, sql.ErrNoRows)
+
+		mockRepo.EXPECT().
+			DeleteExpiredDataExports(gomock.Any()).
+			Return(int64(2), nil)
+
+		s := handler.NewServer(handler.NewServerOptions{
+			Repository: mockRepo,
+		})
+
+		built, err := s.ProcessDataExports(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), built)
+	})
+
+	t.Run("stops when an archive cannot be built", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+		mockRepo.EXPECT().
+			ClaimDataExport(gomock.Any(), gomock.Any()).
+			Return(jsonExport, nil)
+
+		mockRepo.EXPECT().
+			GetUserByID(gomock.Any(), user.ID).
+			Return(repository.UserOutput{}, assert.AnError)
+
+		s := handler.NewServer(handler.NewServerOptions{
+			Repository: mockRepo,
+		})
+
+		built, err := s.ProcessDataExports(context.Background())
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Equal(t, int64(0), built)
+	})
+}
@@ -0,0 +1,245 @@
+package handler_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+)
+
+// softwareAuthenticator plays the role of a passkey authenticator & of the browser, as seen by the relying party
+// of newFixtureJWT()
+type softwareAuthenticator struct {
+	credentialID []byte
+	key          crypto.Signer
+	signCount    uint32
+	// noCounter authenticators always report a sign count of 0, like most synced passkeys
+	noCounter bool
+	rpID      string
+	origin    string
+	flags     byte
+}
+
+func newSoftwareAuthenticator(t *testing.T, key crypto.Signer) *softwareAuthenticator {
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softwareAuthenticator{
+		credentialID: credentialID,
+		key:          key,
+		rpID:         "users.example.com",
+		origin:       fixtureIssuer,
+		// user present & user verified
+		flags: 0x05,
+	}
+}
+
+func newES256Authenticator(t *testing.T) *softwareAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSoftwareAuthenticator(t, key)
+}
+
+func newEdDSAAuthenticator(t *testing.T) *softwareAuthenticator {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSoftwareAuthenticator(t, key)
+}
+
+func newRS256Authenticator(t *testing.T) *softwareAuthenticator {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSoftwareAuthenticator(t, key)
+}
+
+func (a *softwareAuthenticator) credentialIDString() string {
+	return base64.RawURLEncoding.EncodeToString(a.credentialID)
+}
+
+// coseKey encodes the public key of the credential, as stored by the relying party
+func (a *softwareAuthenticator) coseKey() []byte {
+	switch key := a.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		return cborEncode(map[interface{}]interface{}{
+			1:  2,
+			3:  -7,
+			-1: 1,
+			-2: key.X.FillBytes(make([]byte, 32)),
+			-3: key.Y.FillBytes(make([]byte, 32)),
+		})
+	case ed25519.PublicKey:
+		return cborEncode(map[interface{}]interface{}{
+			1:  1,
+			3:  -8,
+			-1: 6,
+			-2: []byte(key),
+		})
+	case *rsa.PublicKey:
+		return cborEncode(map[interface{}]interface{}{
+			1:  3,
+			3:  -257,
+			-1: key.N.Bytes(),
+			-2: big.NewInt(int64(key.E)).Bytes(),
+		})
+	}
+
+	panic("unsupported key")
+}
+
+func (a *softwareAuthenticator) clientData(ceremonyType, challenge string) []byte {
+	clientData, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremonyType,
+		"challenge":   challenge,
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	return clientData
+}
+
+func (a *softwareAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, flags)
+	return binary.BigEndian.AppendUint32(authData, a.signCount)
+}
+
+// create returns the result of navigator.credentials.create(), with a "none" attestation
+func (a *softwareAuthenticator) create(challenge string) generated.RegisterPasskeyRequest {
+	// attested credential data: aaguid, credential id length, credential id, public key
+	authData := a.authenticatorData(a.flags | 0x40)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, a.coseKey()...)
+
+	attestationObject := cborEncode(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": authData,
+	})
+
+	transports := []string{"internal", "hybrid"}
+	return generated.RegisterPasskeyRequest{
+		Id:    a.credentialIDString(),
+		RawId: a.credentialIDString(),
+		Type:  "public-key",
+		Response: generated.PasskeyAttestationResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(a.clientData("webauthn.create", challenge)),
+			AttestationObject: base64.RawURLEncoding.EncodeToString(attestationObject),
+			Transports:        &transports,
+		},
+	}
+}
+
+// get returns the result of navigator.credentials.get(), signed by the credential
+func (a *softwareAuthenticator) get(challenge string, userHandle string) generated.PasskeyLoginRequest {
+	if !a.noCounter {
+		a.signCount++
+	}
+
+	authData := a.authenticatorData(a.flags)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+
+	var signature []byte
+	var err error
+	if _, ok := a.key.(ed25519.PrivateKey); ok {
+		signature, err = a.key.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(signed)
+		signature, err = a.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	request := generated.PasskeyLoginRequest{
+		Id:    a.credentialIDString(),
+		RawId: a.credentialIDString(),
+		Type:  "public-key",
+		Response: generated.PasskeyAssertionResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientData),
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			Signature:         base64.RawURLEncoding.EncodeToString(signature),
+		},
+	}
+	if userHandle != "" {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(userHandle))
+		request.Response.UserHandle = &encoded
+	}
+
+	return request
+}
+
+// cborEncode encodes the few CBOR types used by authenticators
+func cborEncode(value interface{}) []byte {
+	switch value := value.(type) {
+	case int:
+		if value < 0 {
+			return cborHead(1, uint64(-1-value))
+		}
+		return cborHead(0, uint64(value))
+	case []byte:
+		return append(cborHead(2, uint64(len(value))), value...)
+	case string:
+		return append(cborHead(3, uint64(len(value))), value...)
+	case map[interface{}]interface{}:
+		// keys are sorted as in the CTAP2 canonical encoding, so a key always encodes to the same bytes
+		entries := make([][2][]byte, 0, len(value))
+		for k, v := range value {
+			entries = append(entries, [2][]byte{cborEncode(k), cborEncode(v)})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			a, b := entries[i][0], entries[j][0]
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return bytes.Compare(a, b) < 0
+		})
+
+		encoded := cborHead(5, uint64(len(value)))
+		for _, entry := range entries {
+			encoded = append(encoded, entry[0]...)
+			encoded = append(encoded, entry[1]...)
+		}
+		return encoded
+	}
+
+	panic("unsupported cbor value")
+}
+
+func cborHead(majorType byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{majorType<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{majorType<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{majorType<<5 | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{majorType<<5 | 26}, uint32(argument))
+	}
+	return binary.BigEndian.AppendUint64([]byte{majorType<<5 | 27}, argument)
+}
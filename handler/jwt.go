@@ -1,38 +1,192 @@
 package handler
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"sort"
+	"sync/atomic"
+	"time"
 
+	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/pkg/errors"
 )
 
+// JWTKey is a PEM encoded RSA, ECDSA P-256 or Ed25519 key pair, identified by the kid written in the token header.
+// keys only used for verification do not need the private key.
+type JWTKey struct {
+	ID         string
+	PublicKey  []byte
+	PrivateKey []byte
+	// Algorithm is the only algorithm the key signs & verifies tokens with, one of RS256, ES256 or EdDSA.
+	// when empty, it is derived from the key type.
+	Algorithm string
+}
+
+// signingMethods are the supported algorithms, each bound to the only key type it can be used with
+var signingMethods = map[string]jwt.SigningMethod{
+	jwt.SigningMethodRS256.Alg(): jwt.SigningMethodRS256,
+	jwt.SigningMethodES256.Alg(): jwt.SigningMethodES256,
+	jwt.SigningMethodEdDSA.Alg(): jwt.SigningMethodEdDSA,
+}
+
+// errors returned when validating a token, letting callers tell why the token is rejected
+var (
+	ErrTokenMissing       = errors.New("token missing")
+	ErrTokenMalformed     = errors.New("token malformed")
+	ErrTokenUnverifiable  = errors.New("token signature invalid")
+	ErrTokenExpired       = errors.New("token expired")
+	ErrTokenNotValidYet   = errors.New("token not valid yet")
+	ErrTokenInvalidClaims = errors.New("token claims invalid")
+	ErrTokenRevoked       = errors.New("token revoked")
+)
+
+// JWT signs & validates tokens with keys parsed once, when created or reloaded.
+// it is safe for concurrent use, including while its keys are being reloaded.
 type JWT struct {
-	publicKey  []byte
-	privateKey []byte
+	keySet   atomic.Pointer[jwtKeySet]
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+// jwtKeySet is an immutable set of parsed keys, swapped as a whole on reload
+type jwtKeySet struct {
+	signingKey *parsedJWTKey
+	// keys contains every key accepted when validating tokens, including the signing key
+	keys map[string]*parsedJWTKey
+	jwks []generated.JSONWebKey
+}
+
+type parsedJWTKey struct {
+	id         string
+	method     jwt.SigningMethod
+	publicKey  crypto.PublicKey
+	privateKey crypto.Signer
 }
 
 type JWTCustomClaims struct {
 	ID          string `json:"id"`
 	FullName    string `json:"full_name"`
 	PhoneNumber string `json:"phone_number"`
+	// SessionID is the refresh token family the token was issued for
+	SessionID string `json:"sid,omitempty"`
+	// TokenVersion must match the user token version, which is bumped when all of the user sessions are revoked
+	TokenVersion int `json:"ver"`
+	// Roles are the roles of the user, granting the permissions of the admin endpoints. only first party tokens carry them.
+	Roles []string `json:"roles,omitempty"`
+	// ClientID & Scope are only set for tokens issued to oauth clients.
+	// for tokens issued with the client_credentials grant, there is no user and sub is the client id.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func NewJWT(publicKey, privateKey []byte) JWT {
-	return JWT{
-		publicKey:  publicKey,
-		privateKey: privateKey,
+// IDTokenClaims are the OpenID Connect id token claims. the audience is the client the token is issued to,
+// so id tokens are never accepted as access tokens.
+type IDTokenClaims struct {
+	Name                string `json:"name,omitempty"`
+	PhoneNumber         string `json:"phone_number,omitempty"`
+	PhoneNumberVerified *bool  `json:"phone_number_verified,omitempty"`
+	// Nonce is copied from the authorization request, letting the client bind the token to its session
+	Nonce    string           `json:"nonce,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type NewJWTOptions struct {
+	// SigningKey is the active key, used to sign every new token
+	SigningKey JWTKey
+	// VerificationKeys are previously active keys, still accepted until the tokens they signed expire
+	VerificationKeys []JWTKey
+	// Issuer & Audience are written to every token, and required on every validated token
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew tolerated when validating exp, nbf and iat
+	Leeway time.Duration
+}
+
+// NewJWT parses & checks every key up front, so a misconfigured key fails at startup instead of on the first request.
+// the issuer & audience are required too, as the validation skips the iss & aud claims when they are empty.
+func NewJWT(opts NewJWTOptions) (*JWT, error) {
+	if opts.Issuer == "" {
+		return nil, errors.New("jwt issuer is required")
 	}
+	if opts.Audience == "" {
+		return nil, errors.New("jwt audience is required")
+	}
+
+	j := &JWT{
+		issuer:   opts.Issuer,
+		audience: opts.Audience,
+		leeway:   opts.Leeway,
+	}
+
+	if err := j.Reload(opts.SigningKey, opts.VerificationKeys); err != nil {
+		return nil, err
+	}
+
+	return j, nil
 }
 
-func (j JWT) CreateToken(payload JWTCustomClaims) (string, error) {
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(j.privateKey)
+// Reload atomically replaces the signing & verification keys. when any of the new keys is invalid,
+// an error is returned and the current keys are kept.
+func (j *JWT) Reload(signingKey JWTKey, verificationKeys []JWTKey) error {
+	keySet, err := parseJWTKeySet(signingKey, verificationKeys)
 	if err != nil {
-		return "", errors.Wrap(err, "error parsing private key")
+		return err
+	}
+
+	j.keySet.Store(keySet)
+
+	return nil
+}
+
+func (j *JWT) Issuer() string {
+	return j.issuer
+}
+
+func (j *JWT) Audience() string {
+	return j.audience
+}
+
+// SigningAlgorithm returns the algorithm new tokens are signed with
+func (j *JWT) SigningAlgorithm() string {
+	keySet := j.keySet.Load()
+	if keySet == nil {
+		return ""
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, payload).SignedString(key)
+	return keySet.signingKey.method.Alg()
+}
+
+func (j *JWT) CreateToken(payload JWTCustomClaims) (string, error) {
+	return j.sign(payload)
+}
+
+func (j *JWT) CreateIDToken(payload IDTokenClaims) (string, error) {
+	return j.sign(payload)
+}
+
+func (j *JWT) sign(payload jwt.Claims) (string, error) {
+	keySet := j.keySet.Load()
+	if keySet == nil {
+		return "", errors.New("no signing key loaded")
+	}
+	key := keySet.signingKey
+
+	jwtToken := jwt.NewWithClaims(key.method, payload)
+	jwtToken.Header["kid"] = key.id
+
+	token, err := jwtToken.SignedString(key.privateKey)
 	if err != nil {
 		return "", errors.Wrap(err, "error returning signed string")
 	}
@@ -40,27 +194,280 @@ func (j JWT) CreateToken(payload JWTCustomClaims) (string, error) {
 	return token, nil
 }
 
-func (j JWT) ValidateToken(token string) (string, error) {
-	key, err := jwt.ParseRSAPublicKeyFromPEM(j.publicKey)
+// ValidateToken verifies the token signature, and strictly validates its claims: exp, nbf, iat,
+// iss, aud, sub & jti must all be present and valid. errors are one of the ErrToken* values.
+func (j *JWT) ValidateToken(token string) (*JWTCustomClaims, error) {
+	if token == "" {
+		return nil, ErrTokenMissing
+	}
+
+	keySet := j.keySet.Load()
+	if keySet == nil {
+		return nil, fmt.Errorf("%w: no verification key loaded", ErrTokenUnverifiable)
+	}
+
+	claims := &JWTCustomClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(jwtToken *jwt.Token) (interface{}, error) {
+		kid, _ := jwtToken.Header["kid"].(string)
+		key, ok := keySet.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id: %s", kid)
+		}
+
+		// the token header must never decide how the key is used, otherwise a token could
+		// for instance be signed with HS256 using the public key as the secret.
+		if jwtToken.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("key %s only accepts %s tokens, got %s", kid, key.method.Alg(), jwtToken.Method.Alg())
+		}
+
+		return key.publicKey, nil
+	},
+		jwt.WithValidMethods(validAlgorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(j.issuer),
+		jwt.WithAudience(j.audience),
+		jwt.WithLeeway(j.leeway),
+	)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to parse key")
+		return nil, tokenValidationError(err)
+	}
+
+	// the parser only validates nbf & iat when present, and never looks at sub & jti
+	switch {
+	case claims.NotBefore == nil:
+		return nil, fmt.Errorf("%w: nbf is required", ErrTokenInvalidClaims)
+	case claims.IssuedAt == nil:
+		return nil, fmt.Errorf("%w: iat is required", ErrTokenInvalidClaims)
+	case claims.Subject == "" || (claims.ID != "" && claims.Subject != claims.ID):
+		return nil, fmt.Errorf("%w: sub is invalid", ErrTokenInvalidClaims)
+	case claims.ID == "" && claims.Subject != claims.ClientID:
+		return nil, fmt.Errorf("%w: sub is invalid", ErrTokenInvalidClaims)
+	case claims.RegisteredClaims.ID == "":
+		return nil, fmt.Errorf("%w: jti is required", ErrTokenInvalidClaims)
+	}
+
+	return claims, nil
+}
+
+// tokenValidationError maps the jwt library errors to our own typed errors, keeping the cause
+func tokenValidationError(err error) error {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return fmt.Errorf("%w: %v", ErrTokenMalformed, err)
+	case errors.Is(err, jwt.ErrTokenUnverifiable), errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return fmt.Errorf("%w: %v", ErrTokenUnverifiable, err)
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return fmt.Errorf("%w: %v", ErrTokenExpired, err)
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return fmt.Errorf("%w: %v", ErrTokenNotValidYet, err)
+	default:
+		return fmt.Errorf("%w: %v", ErrTokenInvalidClaims, err)
+	}
+}
+
+// JSONWebKeys returns the public part of every key accepted for verification,
+// so other services can validate the tokens on their own.
+func (j *JWT) JSONWebKeys() []generated.JSONWebKey {
+	keySet := j.keySet.Load()
+	if keySet == nil {
+		return []generated.JSONWebKey{}
+	}
+
+	return keySet.jwks
+}
+
+func parseJWTKeySet(signingKey JWTKey, verificationKeys []JWTKey) (*jwtKeySet, error) {
+	if signingKey.ID == "" {
+		return nil, errors.New("signing key id is required")
 	}
 
-	tok, err := jwt.Parse(token, func(jwtToken *jwt.Token) (interface{}, error) {
-		if _, ok := jwtToken.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected method: %s", jwtToken.Header["alg"])
+	privateKey, err := parsePrivateKey(signingKey.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse private key %s", signingKey.ID)
+	}
+
+	// the public key is optional for the signing key, but must belong to the private key when given
+	if len(signingKey.PublicKey) > 0 {
+		publicKey, err := parsePublicKey(signingKey.PublicKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse public key %s", signingKey.ID)
+		}
+
+		if !publicKeysEqual(privateKey.Public(), publicKey) {
+			return nil, fmt.Errorf("key %s: public key does not match the private key", signingKey.ID)
+		}
+	}
+
+	method, err := keySigningMethod(signingKey, privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := &jwtKeySet{
+		signingKey: &parsedJWTKey{
+			id:         signingKey.ID,
+			method:     method,
+			publicKey:  privateKey.Public(),
+			privateKey: privateKey,
+		},
+	}
+	keySet.keys = map[string]*parsedJWTKey{
+		signingKey.ID: keySet.signingKey,
+	}
+
+	for _, key := range verificationKeys {
+		if _, ok := keySet.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id: %s", key.ID)
+		}
+
+		publicKey, err := parsePublicKey(key.PublicKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse public key %s", key.ID)
+		}
+
+		method, err := keySigningMethod(key, publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		keySet.keys[key.ID] = &parsedJWTKey{
+			id:        key.ID,
+			method:    method,
+			publicKey: publicKey,
 		}
+	}
+
+	kids := make([]string, 0, len(keySet.keys))
+	for kid := range keySet.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keySet.jwks = make([]generated.JSONWebKey, 0, len(kids))
+	for _, kid := range kids {
+		key := keySet.keys[kid]
+		keySet.jwks = append(keySet.jwks, publicJSONWebKey(kid, key.method.Alg(), key.publicKey))
+	}
+
+	return keySet, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+func publicJSONWebKey(kid, alg string, key crypto.PublicKey) generated.JSONWebKey {
+	jwk := generated.JSONWebKey{
+		Kid: kid,
+		Use: "sig",
+		Alg: alg,
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+
+		jwk.Kty = "RSA"
+		jwk.N = &n
+		jwk.E = &e
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the curve size, as required by RFC 7518
+		size := (key.Curve.Params().BitSize + 7) / 8
+		crv := key.Curve.Params().Name
+		x := base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+		y := base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+
+		jwk.Kty = "EC"
+		jwk.Crv = &crv
+		jwk.X = &x
+		jwk.Y = &y
+	case ed25519.PublicKey:
+		crv := "Ed25519"
+		x := base64.RawURLEncoding.EncodeToString(key)
+
+		jwk.Kty = "OKP"
+		jwk.Crv = &crv
+		jwk.X = &x
+	}
+
+	return jwk
+}
+
+// keySigningMethod returns the algorithm bound to the key. the configured algorithm must match
+// the key type, so a key can never be used with an algorithm it was not generated for.
+func keySigningMethod(jwtKey JWTKey, key crypto.PublicKey) (jwt.SigningMethod, error) {
+	var alg string
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		alg = jwt.SigningMethodRS256.Alg()
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: unsupported curve %s", jwtKey.ID, key.Curve.Params().Name)
+		}
+		alg = jwt.SigningMethodES256.Alg()
+	case ed25519.PublicKey:
+		alg = jwt.SigningMethodEdDSA.Alg()
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", jwtKey.ID, key)
+	}
+
+	if jwtKey.Algorithm != "" && jwtKey.Algorithm != alg {
+		return nil, fmt.Errorf("key %s: algorithm %s cannot be used with a %s key", jwtKey.ID, jwtKey.Algorithm, alg)
+	}
+
+	return signingMethods[alg], nil
+}
+
+func validAlgorithms() []string {
+	algs := make([]string, 0, len(signingMethods))
+	for alg := range signingMethods {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+
+	return algs
+}
+
+// parsePublicKey parses a PEM encoded PKIX public key, or a PKCS #1 RSA public key
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #8 private key, or a PKCS #1 RSA or SEC 1 EC private key
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
 
-		return key, nil
-	})
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
-		return "", errors.Wrap(err, "error parsing token")
+		return nil, err
 	}
 
-	claims, ok := tok.Claims.(jwt.MapClaims)
-	if !ok || !tok.Valid {
-		return "", errors.New("invalid claims")
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
 	}
 
-	return claims["id"].(string), nil
+	return signer, nil
 }
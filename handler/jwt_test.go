@@ -0,0 +1,523 @@
+package handler_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// generateRSAKey returns a freshly generated PEM encoded key pair for tests needing a second key
+func generateRSAKey(t *testing.T, kid string) handler.JWTKey {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKey, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return handler.JWTKey{
+		ID:         kid,
+		PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privKey)}),
+		PublicKey:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKey}),
+	}
+}
+
+// generateECKey returns a freshly generated PEM encoded ECDSA key pair on the given curve
+func generateECKey(t *testing.T, kid string, curve elliptic.Curve) handler.JWTKey {
+	privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encodeKeyPair(t, kid, privKey, &privKey.PublicKey)
+}
+
+// generateEd25519Key returns a freshly generated PEM encoded Ed25519 key pair
+func generateEd25519Key(t *testing.T, kid string) handler.JWTKey {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encodeKeyPair(t, kid, privKey, pubKey)
+}
+
+func encodeKeyPair(t *testing.T, kid string, privKey, pubKey interface{}) handler.JWTKey {
+	privDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return handler.JWTKey{
+		ID:         kid,
+		PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}),
+		PublicKey:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}),
+	}
+}
+
+func TestNewJWT(t *testing.T) {
+	claims := handler.JWTCustomClaims{
+		ID: "c118a1a9-28f1-4137-9093-87487d24e5d9",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    fixtureIssuer,
+			Audience:  jwt.ClaimStrings{fixtureAudience},
+			ID:        "5f0c9d62-8a1b-4f4e-9a3e-1d2c3b4a5e6f",
+			Subject:   "c118a1a9-28f1-4137-9093-87487d24e5d9",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+
+	withAlgorithm := func(key handler.JWTKey, alg string) handler.JWTKey {
+		key.Algorithm = alg
+		return key
+	}
+
+	tests := []struct {
+		name             string
+		key              handler.JWTKey
+		verificationKeys []handler.JWTKey
+		wantAlg          string
+		wantErr          bool
+	}{
+		{
+			name:    "RSA key signs with RS256",
+			key:     generateRSAKey(t, "rsa"),
+			wantAlg: "RS256",
+		},
+		{
+			name:    "ECDSA P-256 key signs with ES256",
+			key:     generateECKey(t, "ec", elliptic.P256()),
+			wantAlg: "ES256",
+		},
+		{
+			name:    "Ed25519 key signs with EdDSA",
+			key:     generateEd25519Key(t, "ed25519"),
+			wantAlg: "EdDSA",
+		},
+		{
+			name:    "key with its matching algorithm configured",
+			key:     withAlgorithm(generateEd25519Key(t, "ed25519"), "EdDSA"),
+			wantAlg: "EdDSA",
+		},
+		{
+			name:    "key with an algorithm not matching its type",
+			key:     withAlgorithm(generateECKey(t, "ec", elliptic.P256()), "RS256"),
+			wantErr: true,
+		},
+		{
+			name:    "ECDSA key on an unsupported curve",
+			key:     generateECKey(t, "ec", elliptic.P384()),
+			wantErr: true,
+		},
+		{
+			name: "key is not PEM encoded",
+			key: handler.JWTKey{
+				ID:         "random",
+				PrivateKey: []byte("random_private_key"),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "key without id",
+			key:     generateRSAKey(t, ""),
+			wantErr: true,
+		},
+		{
+			name: "public key not matching the private key",
+			key: handler.JWTKey{
+				ID:         "rsa",
+				PrivateKey: []byte(RsaPrivateKey),
+				PublicKey:  generateRSAKey(t, "another").PublicKey,
+			},
+			wantErr: true,
+		},
+		{
+			name:             "verification key reusing the signing key id",
+			key:              generateRSAKey(t, "rsa"),
+			verificationKeys: []handler.JWTKey{generateRSAKey(t, "rsa")},
+			wantErr:          true,
+		},
+		{
+			name: "verification key is not PEM encoded",
+			key:  generateRSAKey(t, "rsa"),
+			verificationKeys: []handler.JWTKey{
+				{
+					ID:        "random",
+					PublicKey: []byte("random_public_key"),
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := handler.NewJWT(handler.NewJWTOptions{
+				SigningKey:       tt.key,
+				VerificationKeys: tt.verificationKeys,
+				Issuer:           fixtureIssuer,
+				Audience:         fixtureAudience,
+			})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			token, err := j.CreateToken(claims)
+			assert.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &handler.JWTCustomClaims{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantAlg, parsed.Header["alg"])
+			assert.Equal(t, tt.key.ID, parsed.Header["kid"])
+
+			_, err = j.ValidateToken(token)
+			assert.NoError(t, err)
+		})
+	}
+
+	// an empty issuer or audience would turn the checks of the iss & aud claims off
+	t.Run("issuer & audience are required", func(t *testing.T) {
+		_, err := handler.NewJWT(handler.NewJWTOptions{
+			SigningKey: generateRSAKey(t, "rsa"),
+			Audience:   fixtureAudience,
+		})
+		assert.Error(t, err)
+
+		_, err = handler.NewJWT(handler.NewJWTOptions{
+			SigningKey: generateRSAKey(t, "rsa"),
+			Issuer:     fixtureIssuer,
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestJWT_ValidateToken(t *testing.T) {
+	previousKey := generateRSAKey(t, "previous-key")
+	unknownKey := generateRSAKey(t, "unknown-key")
+	ecKey := generateECKey(t, "ec-key", elliptic.P256())
+
+	validClaims := func() handler.JWTCustomClaims {
+		return handler.JWTCustomClaims{
+			ID: "c118a1a9-28f1-4137-9093-87487d24e5d9",
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        "5f0c9d62-8a1b-4f4e-9a3e-1d2c3b4a5e6f",
+				Subject:   "c118a1a9-28f1-4137-9093-87487d24e5d9",
+				Issuer:    fixtureIssuer,
+				Audience:  jwt.ClaimStrings{fixtureAudience},
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				NotBefore: jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+	}
+
+	sign := func(key handler.JWTKey, modify func(*handler.JWTCustomClaims)) string {
+		claims := validClaims()
+		if modify != nil {
+			modify(&claims)
+		}
+
+		token, err := mustNewJWT(handler.NewJWTOptions{
+			SigningKey: key,
+			Issuer:     fixtureIssuer,
+			Audience:   fixtureAudience,
+		}).CreateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	fixtureKey := handler.JWTKey{
+		ID:         "fixture-key",
+		PublicKey:  []byte(RsaPublicKey),
+		PrivateKey: []byte(RsaPrivateKey),
+	}
+
+	validator := mustNewJWT(handler.NewJWTOptions{
+		SigningKey: fixtureKey,
+		VerificationKeys: []handler.JWTKey{
+			{
+				ID:        previousKey.ID,
+				PublicKey: previousKey.PublicKey,
+			},
+			{
+				ID:        ecKey.ID,
+				PublicKey: ecKey.PublicKey,
+			},
+		},
+		Issuer:   fixtureIssuer,
+		Audience: fixtureAudience,
+		Leeway:   time.Minute,
+	})
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{
+			name:    "token signed with the signing key",
+			token:   dummyJWT,
+			wantErr: nil,
+		},
+		{
+			name:    "token signed with a previous key kept for verification",
+			token:   sign(previousKey, nil),
+			wantErr: nil,
+		},
+		{
+			name:    "token signed with an unknown key",
+			token:   sign(unknownKey, nil),
+			wantErr: handler.ErrTokenUnverifiable,
+		},
+		{
+			name: "token signed with a known key under another kid",
+			token: sign(handler.JWTKey{
+				ID:         previousKey.ID,
+				PublicKey:  unknownKey.PublicKey,
+				PrivateKey: unknownKey.PrivateKey,
+			}, nil),
+			wantErr: handler.ErrTokenUnverifiable,
+		},
+		{
+			name:    "token signed with an ECDSA verification key",
+			token:   sign(ecKey, nil),
+			wantErr: nil,
+		},
+		{
+			name: "token signed with RS256 under the kid of an ES256 key",
+			token: sign(handler.JWTKey{
+				ID:         ecKey.ID,
+				PublicKey:  []byte(RsaPublicKey),
+				PrivateKey: []byte(RsaPrivateKey),
+			}, nil),
+			wantErr: handler.ErrTokenUnverifiable,
+		},
+		{
+			name:    "token signed with HS256 using the public key as the secret",
+			token:   signHMAC(t, "fixture-key", []byte(RsaPublicKey), validClaims()),
+			wantErr: handler.ErrTokenUnverifiable,
+		},
+		{
+			name:    "token with the none algorithm",
+			token:   signNone(t, "fixture-key", validClaims()),
+			wantErr: handler.ErrTokenUnverifiable,
+		},
+		{
+			name:    "token is missing",
+			token:   "",
+			wantErr: handler.ErrTokenMissing,
+		},
+		{
+			name:    "token is malformed",
+			token:   "not-a-jwt",
+			wantErr: handler.ErrTokenMalformed,
+		},
+		{
+			name: "token is expired",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			}),
+			wantErr: handler.ErrTokenExpired,
+		},
+		{
+			name: "token expired within the clock skew leeway",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-30 * time.Second))
+			}),
+			wantErr: nil,
+		},
+		{
+			name: "token is not valid yet",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			}),
+			wantErr: handler.ErrTokenNotValidYet,
+		},
+		{
+			name: "token is issued in the future",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			}),
+			wantErr: handler.ErrTokenInvalidClaims,
+		},
+		{
+			name: "token without expiry",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.ExpiresAt = nil
+			}),
+			wantErr: handler.ErrTokenInvalidClaims,
+		},
+		{
+			name: "token without not before",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.NotBefore = nil
+			}),
+			wantErr: handler.ErrTokenInvalidClaims,
+		},
+		{
+			name: "token without issued at",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.IssuedAt = nil
+			}),
+			wantErr: handler.ErrTokenInvalidClaims,
+		},
+		{
+			name: "token from another issuer",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.Issuer = "https://evil.example.com"
+			}),
+			wantErr: handler.ErrTokenInvalidClaims,
+		},
+		{
+			name: "token for another audience",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.Audience = jwt.ClaimStrings{"another-service"}
+			}),
+			wantErr: handler.ErrTokenInvalidClaims,
+		},
+		{
+			name: "token subject does not match the user id",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.Subject = "another-user"
+			}),
+			wantErr: handler.ErrTokenInvalidClaims,
+		},
+		{
+			name: "client credentials token, with the client as the subject",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.ID = ""
+				claims.ClientID = "billing-service"
+				claims.Subject = "billing-service"
+			}),
+			wantErr: nil,
+		},
+		{
+			name: "token without a user id, with a subject other than the client",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.ID = ""
+				claims.ClientID = "billing-service"
+			}),
+			wantErr: handler.ErrTokenInvalidClaims,
+		},
+		{
+			name: "token without jti",
+			token: sign(fixtureKey, func(claims *handler.JWTCustomClaims) {
+				claims.RegisteredClaims.ID = ""
+			}),
+			wantErr: handler.ErrTokenInvalidClaims,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validator.ValidateToken(tt.token)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				if got.ClientID != "" {
+					assert.Equal(t, got.ClientID, got.Subject)
+				} else {
+					assert.Equal(t, "c118a1a9-28f1-4137-9093-87487d24e5d9", got.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestJWT_Reload(t *testing.T) {
+	currentKey := generateRSAKey(t, "current-key")
+	nextKey := generateEd25519Key(t, "next-key")
+
+	claims := handler.JWTCustomClaims{
+		ID: "c118a1a9-28f1-4137-9093-87487d24e5d9",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    fixtureIssuer,
+			Audience:  jwt.ClaimStrings{fixtureAudience},
+			ID:        "5f0c9d62-8a1b-4f4e-9a3e-1d2c3b4a5e6f",
+			Subject:   "c118a1a9-28f1-4137-9093-87487d24e5d9",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+
+	j := mustNewJWT(handler.NewJWTOptions{
+		SigningKey: currentKey,
+		Issuer:     fixtureIssuer,
+		Audience:   fixtureAudience,
+	})
+	currentToken, err := j.CreateToken(claims)
+	assert.NoError(t, err)
+
+	// an invalid key set is rejected, and the current keys are kept
+	err = j.Reload(handler.JWTKey{ID: "broken", PrivateKey: []byte("random_private_key")}, nil)
+	assert.Error(t, err)
+	_, err = j.ValidateToken(currentToken)
+	assert.NoError(t, err)
+
+	// rotating keeps the previous key for verification only
+	err = j.Reload(nextKey, []handler.JWTKey{{ID: currentKey.ID, PublicKey: currentKey.PublicKey}})
+	assert.NoError(t, err)
+
+	nextToken, err := j.CreateToken(claims)
+	assert.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(nextToken, &handler.JWTCustomClaims{})
+	assert.NoError(t, err)
+	assert.Equal(t, "next-key", parsed.Header["kid"])
+	assert.Equal(t, "EdDSA", parsed.Header["alg"])
+
+	_, err = j.ValidateToken(currentToken)
+	assert.NoError(t, err)
+	_, err = j.ValidateToken(nextToken)
+	assert.NoError(t, err)
+
+	// dropping the previous key stops accepting the tokens it signed
+	err = j.Reload(nextKey, nil)
+	assert.NoError(t, err)
+	_, err = j.ValidateToken(currentToken)
+	assert.ErrorIs(t, err, handler.ErrTokenUnverifiable)
+}
+
+func signHMAC(t *testing.T, kid string, secret []byte, claims handler.JWTCustomClaims) string {
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	jwtToken.Header["kid"] = kid
+
+	token, err := jwtToken.SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func signNone(t *testing.T, kid string, claims handler.JWTCustomClaims) string {
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	jwtToken.Header["kid"] = kid
+
+	token, err := jwtToken.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
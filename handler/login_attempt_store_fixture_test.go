@@ -0,0 +1,49 @@
+package handler_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler"
+)
+
+// fakeLoginAttemptStore starts with the given attempts, letting the tests set failures made in the past
+type fakeLoginAttemptStore struct {
+	attempts map[string]handler.LoginAttempts
+	err      error
+}
+
+func newFakeLoginAttemptStore(attempts map[string]handler.LoginAttempts) *fakeLoginAttemptStore {
+	if attempts == nil {
+		attempts = map[string]handler.LoginAttempts{}
+	}
+
+	return &fakeLoginAttemptStore{attempts: attempts}
+}
+
+func (s *fakeLoginAttemptStore) Get(ctx context.Context, key string) (handler.LoginAttempts, error) {
+	return s.attempts[key], s.err
+}
+
+func (s *fakeLoginAttemptStore) RecordFailure(ctx context.Context, key string) (handler.LoginAttempts, error) {
+	if s.err != nil {
+		return handler.LoginAttempts{}, s.err
+	}
+
+	attempts := s.attempts[key]
+	attempts.Failures++
+	attempts.LastFailureAt = time.Now()
+	s.attempts[key] = attempts
+
+	return attempts, nil
+}
+
+func (s *fakeLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	delete(s.attempts, key)
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LoginAttempts counts the failed logins of a key (an account or a client ip) within the counting window
+type LoginAttempts struct {
+	Failures      int
+	LastFailureAt time.Time
+}
+
+// LoginAttemptStore keeps the failed login counters. The in-memory store only works for a single instance,
+// a shared store (e.g. redis) is needed once the service runs on several.
+type LoginAttemptStore interface {
+	// Get returns the failed logins of the key, or zero attempts once they are forgotten. a counter must be kept as
+	// long as the lock of an unknown phone number lasts, even past the counting window.
+	Get(ctx context.Context, key string) (LoginAttempts, error)
+	// RecordFailure counts a failed login of the key & returns the updated attempts
+	RecordFailure(ctx context.Context, key string) (LoginAttempts, error)
+	// Reset clears the failed logins of the key
+	Reset(ctx context.Context, key string) error
+}
+
+// MemoryLoginAttemptStore keeps the counters in memory. The failures are counted within the window, a counter is
+// forgotten once its last failure is older than the retention.
+type MemoryLoginAttemptStore struct {
+	window    time.Duration
+	retention time.Duration
+
+	mu        sync.Mutex
+	attempts  map[string]LoginAttempts
+	lastSweep time.Time
+}
+
+type NewMemoryLoginAttemptStoreOptions struct {
+	// Window is how long a failed login is counted
+	Window time.Duration
+	// Retention is how long a counter is kept after its last failure, so the lock of an unknown phone number can
+	// outlast the window. defaults to the window
+	Retention time.Duration
+}
+
+// NewMemoryLoginAttemptStore creates an empty store counting the failures within the window
+func NewMemoryLoginAttemptStore(opts NewMemoryLoginAttemptStoreOptions) *MemoryLoginAttemptStore {
+	retention := opts.Retention
+	if retention < opts.Window {
+		retention = opts.Window
+	}
+
+	return &MemoryLoginAttemptStore{
+		window:    opts.Window,
+		retention: retention,
+		attempts:  make(map[string]LoginAttempts),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryLoginAttemptStore) Get(ctx context.Context, key string) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.current(key, time.Now()), nil
+}
+
+func (s *MemoryLoginAttemptStore) RecordFailure(ctx context.Context, key string) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	attempts := s.current(key, now)
+	// the count starts over once the last failure left the window
+	if now.Sub(attempts.LastFailureAt) > s.window {
+		attempts = LoginAttempts{}
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = now
+	s.attempts[key] = attempts
+
+	return attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
+
+// current returns the attempts of the key, unless they are older than the retention
+func (s *MemoryLoginAttemptStore) current(key string, now time.Time) LoginAttempts {
+	attempts, ok := s.attempts[key]
+	if !ok || now.Sub(attempts.LastFailureAt) > s.retention {
+		return LoginAttempts{}
+	}
+
+	return attempts
+}
+
+// sweep drops the expired counters once per retention, so keys which never come back do not pile up
+func (s *MemoryLoginAttemptStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.retention {
+		return
+	}
+
+	for key, attempts := range s.attempts {
+		if now.Sub(attempts.LastFailureAt) > s.retention {
+			delete(s.attempts, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,91 @@
+package handler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLoginAttemptStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("counts the failures of every key on its own", func(t *testing.T) {
+		store := handler.NewMemoryLoginAttemptStore(handler.NewMemoryLoginAttemptStoreOptions{Window: time.Minute})
+
+		attempts, err := store.RecordFailure(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempts.Failures)
+
+		attempts, err = store.RecordFailure(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts.Failures)
+		assert.WithinDuration(t, time.Now(), attempts.LastFailureAt, time.Second)
+
+		attempts, err = store.Get(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts.Failures)
+
+		attempts, err = store.Get(ctx, "ip:192.0.2.1")
+		assert.NoError(t, err)
+		assert.Equal(t, handler.LoginAttempts{}, attempts)
+	})
+
+	t.Run("reset clears the failures", func(t *testing.T) {
+		store := handler.NewMemoryLoginAttemptStore(handler.NewMemoryLoginAttemptStoreOptions{Window: time.Minute})
+
+		_, err := store.RecordFailure(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+
+		err = store.Reset(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+
+		attempts, err := store.Get(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, attempts.Failures)
+	})
+
+	t.Run("forgets the failures older than the window", func(t *testing.T) {
+		store := handler.NewMemoryLoginAttemptStore(handler.NewMemoryLoginAttemptStoreOptions{Window: 20 * time.Millisecond})
+
+		_, err := store.RecordFailure(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+
+		time.Sleep(30 * time.Millisecond)
+
+		attempts, err := store.Get(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, attempts.Failures)
+
+		// the count starts over rather than adding to the expired failures
+		attempts, err = store.RecordFailure(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempts.Failures)
+	})
+
+	t.Run("keeps the counters past the window until the retention", func(t *testing.T) {
+		store := handler.NewMemoryLoginAttemptStore(handler.NewMemoryLoginAttemptStoreOptions{
+			Window:    20 * time.Millisecond,
+			Retention: time.Minute,
+		})
+
+		_, err := store.RecordFailure(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+		_, err = store.RecordFailure(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+
+		time.Sleep(30 * time.Millisecond)
+
+		// a lock lasting longer than the window is still known
+		attempts, err := store.Get(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts.Failures)
+
+		// but only the failures within the window are counted
+		attempts, err = store.RecordFailure(ctx, "phone:+62812345678")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempts.Failures)
+	})
+}
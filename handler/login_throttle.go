@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// maxLoginDelay caps the progressive delay between the failed logins of an account
+const maxLoginDelay = 30 * time.Second
+
+// loginAttempt identifies who is logging in, the failures are counted for both the account & the client ip
+type loginAttempt struct {
+	IP          string
+	PhoneNumber string
+}
+
+func (a loginAttempt) ipKey() string {
+	return "ip:" + a.IP
+}
+
+func (a loginAttempt) accountKey() string {
+	return accountLoginKey(a.PhoneNumber)
+}
+
+func accountLoginKey(phoneNumber string) string {
+	return "phone:" + phoneNumber
+}
+
+// loginBlock tells why a login is refused before the credentials are checked
+type loginBlock struct {
+	Status     int
+	Message    string
+	RetryAfter time.Duration
+}
+
+// loginDelay is the time to wait after the last failed login of an account, doubling from the second failure
+func loginDelay(failures int) time.Duration {
+	if failures < 2 {
+		return 0
+	}
+
+	shift := failures - 2
+	if shift >= 5 {
+		return maxLoginDelay
+	}
+
+	delay := time.Second << shift
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+
+	return delay
+}
+
+// checkLoginThrottle refuses a login while the client ip made too many failed logins, or while the
+// progressive delay of the account has not passed yet
+func (s *Server) checkLoginThrottle(ctx context.Context, attempt loginAttempt) (*loginBlock, error) {
+	ipAttempts, err := s.LoginAttempts.Get(ctx, attempt.ipKey())
+	if err != nil {
+		return nil, err
+	}
+	// the counters may be kept past the window, the block of a client ip ends with it
+	if ipAttempts.Failures >= s.LoginIPThreshold {
+		if wait := time.Until(ipAttempts.LastFailureAt.Add(s.LoginAttemptWindow)); wait > 0 {
+			return &loginBlock{
+				Status:     http.StatusTooManyRequests,
+				Message:    "too many failed logins",
+				RetryAfter: wait,
+			}, nil
+		}
+	}
+
+	accountAttempts, err := s.LoginAttempts.Get(ctx, attempt.accountKey())
+	if err != nil {
+		return nil, err
+	}
+	// the counter of a user is reset once they are locked, so this is the lock of an unknown phone number
+	if accountAttempts.Failures >= s.LoginLockoutThreshold {
+		if wait := time.Until(accountAttempts.LastFailureAt.Add(s.LoginLockoutDuration)); wait > 0 {
+			return accountLockedBlock(wait), nil
+		}
+	}
+	if wait := time.Until(accountAttempts.LastFailureAt.Add(loginDelay(accountAttempts.Failures))); wait > 0 {
+		return &loginBlock{
+			Status:     http.StatusTooManyRequests,
+			Message:    "too many failed logins",
+			RetryAfter: wait,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// accountLockBlock refuses the login of a locked user
+func accountLockBlock(user repository.UserOutput) *loginBlock {
+	if user.LockedUntil == nil || !user.LockedUntil.After(time.Now()) {
+		return nil
+	}
+
+	return accountLockedBlock(time.Until(*user.LockedUntil))
+}
+
+func accountLockedBlock(wait time.Duration) *loginBlock {
+	return &loginBlock{
+		Status:     http.StatusLocked,
+		Message:    "account locked",
+		RetryAfter: wait,
+	}
+}
+
+// recordLoginFailure counts a failed login, and locks the account once its failures reach the lockout threshold.
+// unknown phone numbers are locked too, by keeping their counter, so the lockout does not tell who is registered.
+func (s *Server) recordLoginFailure(ctx context.Context, attempt loginAttempt, user *repository.UserOutput) (*loginBlock, error) {
+	if _, err := s.LoginAttempts.RecordFailure(ctx, attempt.ipKey()); err != nil {
+		return nil, err
+	}
+
+	accountAttempts, err := s.LoginAttempts.RecordFailure(ctx, attempt.accountKey())
+	if err != nil {
+		return nil, err
+	}
+	if accountAttempts.Failures < s.LoginLockoutThreshold {
+		return nil, nil
+	}
+	if user == nil {
+		return accountLockedBlock(s.LoginLockoutDuration), nil
+	}
+
+	err = s.Repository.LockUser(ctx, repository.LockUserInput{
+		UserID:      user.ID,
+		LockedUntil: time.Now().Add(s.LoginLockoutDuration).UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the lock takes over from the counter, the count starts over once the lock expires
+	if err := s.LoginAttempts.Reset(ctx, attempt.accountKey()); err != nil {
+		return nil, err
+	}
+
+	return accountLockedBlock(s.LoginLockoutDuration), nil
+}
+
+// recordLoginSuccess clears the failed logins of the account. the failures of the client ip are kept, a
+// valid login of one account must not hide the guesses made against others.
+func (s *Server) recordLoginSuccess(ctx context.Context, attempt loginAttempt) error {
+	return s.LoginAttempts.Reset(ctx, attempt.accountKey())
+}
+
+// loginBlockedResponse responds to a refused login, with the seconds to wait before trying again
+func loginBlockedResponse(c echo.Context, block *loginBlock) error {
+	setRetryAfter(c, block.RetryAfter)
+
+	return c.JSON(block.Status, generated.ErrorResponse{
+		Message: block.Message,
+	})
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up & at least 1
+func setRetryAfter(c echo.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+}
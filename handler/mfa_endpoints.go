@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// mfaChallengeTTL is the time left to the user to enter their second factor after their password
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaChallengeMaxAttempts keeps a challenge from being used to guess the 6 digits code
+	mfaChallengeMaxAttempts = 5
+	recoveryCodeCount       = 10
+)
+
+var errMFANotConfigured = errors.New("two-factor authentication is not configured")
+
+// Starts the TOTP enrollment of the logged in user, returning a new secret. The enrollment is only active once confirmed with a first code
+// (POST /users/me/mfa/totp)
+func (s *Server) EnrollTOTP(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	if s.SecretBox == nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: errMFANotConfigured.Error(),
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// the secret is bound to its user, so it cannot be moved to another account in the database
+	encryptedSecret, err := s.SecretBox.Seal(secret, []byte(user.ID))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	err = s.Repository.UpsertTOTPSecret(ctx, repository.UpsertTOTPSecretInput{
+		UserID:          user.ID,
+		EncryptedSecret: encryptedSecret,
+	})
+	if err != nil {
+		if err == repository.ErrTOTPAlreadyConfirmed {
+			return c.JSON(http.StatusConflict, generated.ErrorResponse{
+				Message: "two-factor authentication already enabled",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, generated.TOTPEnrollmentResponse{
+		Secret:     totpSecretEncoding.EncodeToString(secret),
+		OtpauthUri: totpURI(s.TOTPIssuer, user.PhoneNumber, secret),
+	})
+}
+
+// Confirms the pending TOTP enrollment of the logged in user with a first code, and returns one-time recovery codes
+// (POST /users/me/mfa/totp/confirm)
+func (s *Server) ConfirmTOTP(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	var payload ConfirmTOTPValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	existingSecret, err := s.Repository.GetTOTPSecret(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "no pending two-factor authentication enrollment",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if existingSecret.ConfirmedAt != nil {
+		return c.JSON(http.StatusConflict, generated.ErrorResponse{
+			Message: "two-factor authentication already enabled",
+		})
+	}
+
+	secret, err := s.openTOTPSecret(existingSecret)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	step, ok := verifyTOTP(secret, payload.Code, time.Now())
+	if !ok {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "code not valid",
+		})
+	}
+
+	recoveryCodes, recoveryCodeHashes, err := generateRecoveryCodes()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	err = s.Repository.ConfirmTOTPSecret(ctx, repository.ConfirmTOTPSecretInput{
+		UserID:             id,
+		Step:               step,
+		RecoveryCodeHashes: recoveryCodeHashes,
+	})
+	if err != nil {
+		if err == repository.ErrTOTPAlreadyConfirmed {
+			return c.JSON(http.StatusConflict, generated.ErrorResponse{
+				Message: "two-factor authentication already enabled",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, generated.RecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// Completes the login of a user enrolled in two-factor authentication, with a TOTP code or a recovery code
+// (POST /auth/mfa)
+func (s *Server) VerifyMFA(c echo.Context) error {
+	var payload VerifyMFAValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	if payload.MFAToken == "" || (payload.Code == "") == (payload.RecoveryCode == "") {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "mfa_token and either code or recovery_code are required",
+		})
+	}
+
+	user, err := s.completeMFAChallenge(c, payload.MFAToken, payload.Code, payload.RecoveryCode, loginMethodMFA)
+	if err != nil {
+		if err == errMFATokenNotValid || err == errMFACodeNotValid {
+			return c.JSON(http.StatusUnauthorized, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return s.loginResponse(c, user, loginMethodMFA)
+}
+
+var (
+	errMFATokenNotValid = errors.New("mfa token not valid")
+	errMFACodeNotValid  = errors.New("code not valid")
+)
+
+// completeMFAChallenge checks the TOTP code or the recovery code against the challenge, and consumes the challenge
+// once the code is valid. errMFATokenNotValid is returned for a wrong, expired or used challenge, errMFACodeNotValid
+// for a wrong code, which is added to the login history of the user with the method.
+func (s *Server) completeMFAChallenge(c echo.Context, token, code, recoveryCode, method string) (repository.UserOutput, error) {
+	ctx := c.Request().Context()
+	tokenHash := hashOpaqueToken(token)
+
+	// every attempt is counted before checking the code, so the code cannot be brute forced
+	challenge, err := s.Repository.AttemptMFAChallenge(ctx, repository.AttemptMFAChallengeInput{
+		TokenHash:   tokenHash,
+		MaxAttempts: mfaChallengeMaxAttempts,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return repository.UserOutput{}, errMFATokenNotValid
+		}
+
+		return repository.UserOutput{}, err
+	}
+	if !challenge.ExpiresAt.After(time.Now()) {
+		return repository.UserOutput{}, errMFATokenNotValid
+	}
+
+	if code != "" {
+		err = s.useTOTPCode(ctx, challenge.UserID, code)
+	} else {
+		err = s.Repository.UseRecoveryCode(ctx, repository.UseRecoveryCodeInput{
+			UserID:   challenge.UserID,
+			CodeHash: hashRecoveryCode(recoveryCode),
+		})
+	}
+	if err != nil {
+		if err == errTOTPCodeNotValid || err == repository.ErrTOTPStepAlreadyUsed || err == repository.ErrRecoveryCodeNotValid {
+			err = s.recordFailedLogin(c, challenge.UserID, method, loginFailureInvalidMFACode)
+			if err != nil {
+				return repository.UserOutput{}, err
+			}
+
+			return repository.UserOutput{}, errMFACodeNotValid
+		}
+
+		return repository.UserOutput{}, err
+	}
+
+	err = s.Repository.ConsumeMFAChallenge(ctx, tokenHash)
+	if err != nil {
+		if err == repository.ErrMFAChallengeNotActive {
+			return repository.UserOutput{}, errMFATokenNotValid
+		}
+
+		return repository.UserOutput{}, err
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, challenge.UserID)
+	if err != nil {
+		// the account was deleted since the password was checked
+		if err == sql.ErrNoRows {
+			return repository.UserOutput{}, errMFATokenNotValid
+		}
+
+		return repository.UserOutput{}, err
+	}
+
+	return user, nil
+}
+
+var errTOTPCodeNotValid = errors.New("totp code not valid")
+
+// useTOTPCode checks the code against the confirmed secret of the user, and records its time step as used
+func (s *Server) useTOTPCode(ctx context.Context, userID, code string) error {
+	existingSecret, err := s.Repository.GetTOTPSecret(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errTOTPCodeNotValid
+		}
+
+		return err
+	}
+	if existingSecret.ConfirmedAt == nil {
+		return errTOTPCodeNotValid
+	}
+
+	secret, err := s.openTOTPSecret(existingSecret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := verifyTOTP(secret, code, time.Now())
+	if !ok {
+		return errTOTPCodeNotValid
+	}
+
+	return s.Repository.UseTOTPStep(ctx, repository.UseTOTPStepInput{
+		UserID: userID,
+		Step:   step,
+	})
+}
+
+func (s *Server) openTOTPSecret(existingSecret repository.TOTPSecretOutput) ([]byte, error) {
+	if s.SecretBox == nil {
+		return nil, errMFANotConfigured
+	}
+
+	return s.SecretBox.Open(existingSecret.EncryptedSecret, []byte(existingSecret.UserID))
+}
+
+// isMFAEnrolled reports whether the user has a confirmed second factor
+func (s *Server) isMFAEnrolled(ctx context.Context, userID string) (bool, error) {
+	existingSecret, err := s.Repository.GetTOTPSecret(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return existingSecret.ConfirmedAt != nil, nil
+}
+
+// mfaChallengeResponse answers a valid password of an enrolled user with a short-lived challenge, instead of the tokens
+func (s *Server) mfaChallengeResponse(c echo.Context, user repository.UserOutput) error {
+	token, err := s.createMFAChallenge(c.Request().Context(), user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusAccepted, generated.MFAChallengeResponse{
+		MfaToken:  token,
+		ExpiresIn: int64(mfaChallengeTTL.Seconds()),
+		Methods:   []string{"totp", "recovery_code"},
+	})
+}
+
+// createMFAChallenge starts the second step of the login of the user, and returns the token of the challenge
+func (s *Server) createMFAChallenge(ctx context.Context, userID string) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.Repository.CreateMFAChallenge(ctx, repository.CreateMFAChallengeInput{
+		TokenHash: hashOpaqueToken(token),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(mfaChallengeTTL).UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// generateRecoveryCodes returns recovery codes formatted as XXXX-XXXX-XXXX-XXXX, along with their hashes.
+// each code carries 80 bits of entropy, so an unsalted hash is enough, like for opaque tokens.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, errors.Wrap(err, "error generating recovery code")
+		}
+
+		code := base32.StdEncoding.EncodeToString(b)
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:16])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores the case & separators of the code, as users may type it by hand
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	return hashOpaqueToken(normalized)
+}
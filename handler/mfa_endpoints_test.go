@@ -0,0 +1,763 @@
+package handler_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// the secret of the RFC 6238 test vectors
+var fixtureTOTPSecret = []byte("12345678901234567890")
+
+func newFixtureSecretBox() *handler.SecretBox {
+	box, err := handler.NewSecretBox(bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		panic(err)
+	}
+	return box
+}
+
+// totpCodeAt computes the 6 digits TOTP code of the time, as an authenticator app would
+func totpCodeAt(secret []byte, at time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(at.Unix()/30))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[19] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func sealFixtureTOTPSecret(t *testing.T, userID string) string {
+	sealed, err := newFixtureSecretBox().Seal(fixtureTOTPSecret, []byte(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+type sealedTOTPSecretMatcher struct {
+	userID string
+}
+
+func (m sealedTOTPSecretMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.UpsertTOTPSecretInput)
+	if !ok {
+		return false
+	}
+
+	secret, err := newFixtureSecretBox().Open(actualInput.EncryptedSecret, []byte(m.userID))
+
+	return err == nil && len(secret) == 20 && actualInput.UserID == m.userID
+}
+
+func (m sealedTOTPSecretMatcher) String() string {
+	return fmt.Sprintf("{UpsertTOTPSecretInput - UserID:%s, secret sealed for the user}", m.userID)
+}
+
+type confirmTOTPSecretInputMatcher struct {
+	userID string
+}
+
+func (m confirmTOTPSecretInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.ConfirmTOTPSecretInput)
+	if !ok {
+		return false
+	}
+
+	currentStep := time.Now().Unix() / 30
+
+	return actualInput.UserID == m.userID &&
+		actualInput.Step >= currentStep-1 && actualInput.Step <= currentStep+1 &&
+		len(actualInput.RecoveryCodeHashes) == 10
+}
+
+func (m confirmTOTPSecretInputMatcher) String() string {
+	return fmt.Sprintf("{ConfirmTOTPSecretInput - UserID:%s, 10 recovery codes}", m.userID)
+}
+
+func TestSecretBox(t *testing.T) {
+	box := newFixtureSecretBox()
+
+	sealed, err := box.Seal([]byte("secret"), []byte("user-id"))
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "secret")
+
+	opened, err := box.Open(sealed, []byte("user-id"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), opened)
+
+	_, err = box.Open(sealed, []byte("another-user-id"))
+	assert.Error(t, err)
+
+	_, err = handler.NewSecretBox([]byte("too-short"))
+	assert.Error(t, err)
+}
+
+func TestServer_EnrollTOTP(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          dummyJWTClaims.ID,
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+		SecretBox  *handler.SecretBox
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		wantStatus int
+	}{
+		{
+			name: "successfully starts the enrollment",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertTOTPSecret(gomock.Any(), sealedTOTPSecretMatcher{userID: user.ID}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				SecretBox: newFixtureSecretBox(),
+			},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name: "user is already enrolled",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertTOTPSecret(gomock.Any(), gomock.Any()).
+						Return(repository.ErrTOTPAlreadyConfirmed)
+
+					return mockRepo
+				}(),
+				SecretBox: newFixtureSecretBox(),
+			},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "two-factor authentication is not configured",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+				SecretBox:  tt.fields.SecretBox,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/users/me/mfa/totp").
+				WithJWSAuth(dummyJWT).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			var enrollment generated.TOTPEnrollmentResponse
+			err := response.UnmarshalBodyToObject(&enrollment)
+			assert.NoError(t, err)
+
+			secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+			assert.NoError(t, err)
+			assert.Len(t, secret, 20)
+
+			uri, err := url.Parse(enrollment.OtpauthUri)
+			assert.NoError(t, err)
+			assert.Equal(t, "otpauth", uri.Scheme)
+			assert.Equal(t, "totp", uri.Host)
+			assert.Equal(t, "/User Service:"+user.PhoneNumber, uri.Path)
+			assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+			assert.Equal(t, "User Service", uri.Query().Get("issuer"))
+		})
+	}
+}
+
+func TestServer_ConfirmTOTP(t *testing.T) {
+	pendingSecret := repository.TOTPSecretOutput{
+		UserID:          dummyJWTClaims.ID,
+		EncryptedSecret: sealFixtureTOTPSecret(t, dummyJWTClaims.ID),
+	}
+	confirmedAt := time.Now()
+	confirmedSecret := pendingSecret
+	confirmedSecret.ConfirmedAt = &confirmedAt
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		code       string
+		wantStatus int
+	}{
+		{
+			name: "successfully confirms the enrollment",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), dummyJWTClaims.ID).
+						Return(pendingSecret, nil)
+
+					mockRepo.EXPECT().
+						ConfirmTOTPSecret(gomock.Any(), confirmTOTPSecretInputMatcher{userID: dummyJWTClaims.ID}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			code:       totpCodeAt(fixtureTOTPSecret, time.Now()),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "code not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), dummyJWTClaims.ID).
+						Return(pendingSecret, nil)
+
+					return mockRepo
+				}(),
+			},
+			code:       totpCodeAt(fixtureTOTPSecret, time.Now().Add(-5*time.Minute)),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "no pending enrollment",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), dummyJWTClaims.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			code:       totpCodeAt(fixtureTOTPSecret, time.Now()),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "enrollment already confirmed",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), dummyJWTClaims.ID).
+						Return(confirmedSecret, nil)
+
+					return mockRepo
+				}(),
+			},
+			code:       totpCodeAt(fixtureTOTPSecret, time.Now()),
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "failed confirming the enrollment",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), dummyJWTClaims.ID).
+						Return(pendingSecret, nil)
+
+					mockRepo.EXPECT().
+						ConfirmTOTPSecret(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			code:       totpCodeAt(fixtureTOTPSecret, time.Now()),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+				SecretBox:  newFixtureSecretBox(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/users/me/mfa/totp/confirm").
+				WithJWSAuth(dummyJWT).
+				WithJsonBody(map[string]interface{}{"code": tt.code}).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var recoveryCodes generated.RecoveryCodesResponse
+			err := response.UnmarshalBodyToObject(&recoveryCodes)
+			assert.NoError(t, err)
+			assert.Len(t, recoveryCodes.RecoveryCodes, 10)
+			for _, code := range recoveryCodes.RecoveryCodes {
+				assert.Regexp(t, `^[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}$`, code)
+			}
+		})
+	}
+}
+
+func TestServer_VerifyMFA(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          "c118a1a9-28f1-4137-9093-87487d24e5d9",
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+	}
+
+	sum := sha256.Sum256([]byte("mfa-token"))
+	mfaTokenHash := hex.EncodeToString(sum[:])
+	challenge := repository.MFAChallengeOutput{
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	attempt := repository.AttemptMFAChallengeInput{
+		TokenHash:   mfaTokenHash,
+		MaxAttempts: 5,
+	}
+
+	confirmedAt := time.Now()
+	confirmedSecret := repository.TOTPSecretOutput{
+		UserID:          user.ID,
+		EncryptedSecret: sealFixtureTOTPSecret(t, user.ID),
+		ConfirmedAt:     &confirmedAt,
+	}
+	currentStep := time.Now().Unix() / 30
+
+	sum = sha256.Sum256([]byte("ABCDEFGHIJKLMNOP"))
+	recoveryCodeHash := hex.EncodeToString(sum[:])
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		payload    map[string]interface{}
+		wantStatus int
+	}{
+		{
+			name: "successfully logs in with a totp code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(challenge, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(confirmedSecret, nil)
+
+					mockRepo.EXPECT().
+						UseTOTPStep(gomock.Any(), repository.UseTOTPStepInput{UserID: user.ID, Step: currentStep}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						ConsumeMFAChallenge(gomock.Any(), mfaTokenHash).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "mfa"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token": "mfa-token",
+				"code":      totpCodeAt(fixtureTOTPSecret, time.Unix(currentStep*30, 0)),
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "successfully logs in with a recovery code typed by hand",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(challenge, nil)
+
+					mockRepo.EXPECT().
+						UseRecoveryCode(gomock.Any(), repository.UseRecoveryCodeInput{UserID: user.ID, CodeHash: recoveryCodeHash}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						ConsumeMFAChallenge(gomock.Any(), mfaTokenHash).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "mfa"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token":     "mfa-token",
+				"recovery_code": "abcd-efgh ijkl-mnop",
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "user deleted since the password was checked",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(challenge, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(confirmedSecret, nil)
+
+					mockRepo.EXPECT().
+						UseTOTPStep(gomock.Any(), repository.UseTOTPStepInput{UserID: user.ID, Step: currentStep}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						ConsumeMFAChallenge(gomock.Any(), mfaTokenHash).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token": "mfa-token",
+				"code":      totpCodeAt(fixtureTOTPSecret, time.Unix(currentStep*30, 0)),
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "totp code not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(challenge, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(confirmedSecret, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "mfa", failureReason: "invalid_mfa_code"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token": "mfa-token",
+				"code":      totpCodeAt(fixtureTOTPSecret, time.Now().Add(-5*time.Minute)),
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "replayed totp code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(challenge, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(confirmedSecret, nil)
+
+					mockRepo.EXPECT().
+						UseTOTPStep(gomock.Any(), gomock.Any()).
+						Return(repository.ErrTOTPStepAlreadyUsed)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "mfa", failureReason: "invalid_mfa_code"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token": "mfa-token",
+				"code":      totpCodeAt(fixtureTOTPSecret, time.Now()),
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "recovery code not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(challenge, nil)
+
+					mockRepo.EXPECT().
+						UseRecoveryCode(gomock.Any(), gomock.Any()).
+						Return(repository.ErrRecoveryCodeNotValid)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "mfa", failureReason: "invalid_mfa_code"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token":     "mfa-token",
+				"recovery_code": "ABCD-EFGH-IJKL-MNOP",
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "mfa token unknown, used or out of attempts",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(repository.MFAChallengeOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token": "mfa-token",
+				"code":      "123456",
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "mfa token expired",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					expiredChallenge := challenge
+					expiredChallenge.ExpiresAt = time.Now().Add(-time.Minute)
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(expiredChallenge, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token": "mfa-token",
+				"code":      "123456",
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "mfa token used concurrently",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(challenge, nil)
+
+					mockRepo.EXPECT().
+						UseRecoveryCode(gomock.Any(), gomock.Any()).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						ConsumeMFAChallenge(gomock.Any(), mfaTokenHash).
+						Return(repository.ErrMFAChallengeNotActive)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token":     "mfa-token",
+				"recovery_code": "ABCD-EFGH-IJKL-MNOP",
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "both a code & a recovery code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			payload: map[string]interface{}{
+				"mfa_token":     "mfa-token",
+				"code":          "123456",
+				"recovery_code": "ABCD-EFGH-IJKL-MNOP",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+				SecretBox:  newFixtureSecretBox(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/auth/mfa").
+				WithJsonBody(tt.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus == http.StatusOK {
+				var login generated.AuthenticateUserResponse
+				err := response.UnmarshalBodyToObject(&login)
+				assert.NoError(t, err)
+				assert.Equal(t, user.ID, login.Id)
+				assert.False(t, strings.Contains(response.Recorder.Body.String(), "mfa_token"))
+			}
+		})
+	}
+}
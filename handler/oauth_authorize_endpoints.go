@@ -0,0 +1,411 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// authorizationCodeTTL is kept short, the client exchanges the code right after being redirected
+const authorizationCodeTTL = 5 * time.Minute
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var (
+	authorizeTemplate      = template.Must(template.ParseFS(templateFiles, "templates/authorize.html"))
+	authorizeErrorTemplate = template.Must(template.ParseFS(templateFiles, "templates/authorize_error.html"))
+)
+
+// scopeDescriptions are shown on the consent page, unknown scopes are shown as is
+var scopeDescriptions = map[string]string{
+	"openid":  "Sign you in with your account",
+	"profile": "See your full name",
+	"phone":   "See your phone number",
+}
+
+// a PKCE S256 code challenge is the base64url encoded sha256 of the code verifier
+var codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// authorizationError is an error of an authorization request. when redirect is false, the client or its
+// redirect uri cannot be trusted, so the error is shown to the user instead of being sent to the client.
+type authorizationError struct {
+	code        string
+	description string
+	redirect    bool
+}
+
+func (e *authorizationError) Error() string {
+	return e.code + ": " + e.description
+}
+
+type authorizePage struct {
+	ClientName string
+	Scopes     []string
+	Request    AuthorizationRequestValidator
+	// MFAToken is set on the second step of the login of a user enrolled in two-factor authentication
+	MFAToken string
+	Error    string
+}
+
+// Starts the OAuth 2.0 authorization code flow, rendering the login & consent page
+// (GET /oauth/authorize)
+func (s *Server) Authorize(c echo.Context, params generated.AuthorizeParams) error {
+	request := AuthorizationRequestValidator{
+		ResponseType:        stringValue(params.ResponseType),
+		ClientID:            stringValue(params.ClientId),
+		RedirectURI:         stringValue(params.RedirectUri),
+		Scope:               stringValue(params.Scope),
+		State:               stringValue(params.State),
+		CodeChallenge:       stringValue(params.CodeChallenge),
+		CodeChallengeMethod: stringValue(params.CodeChallengeMethod),
+		Nonce:               stringValue(params.Nonce),
+	}
+
+	client, err := s.validateAuthorizationRequest(c.Request().Context(), &request)
+	if err != nil {
+		return s.authorizationErrorResponse(c, request, err)
+	}
+
+	return renderAuthorizePage(c, http.StatusOK, client, request, "")
+}
+
+// Submits the login & consent page. On approval, the user is redirected back to the client with an authorization code
+// (POST /oauth/authorize)
+func (s *Server) SubmitAuthorization(c echo.Context) error {
+	var payload SubmitAuthorizationValidator
+	if err := c.Bind(&payload); err != nil {
+		return renderAuthorizeError(c, http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+	request := payload.AuthorizationRequestValidator
+
+	client, err := s.validateAuthorizationRequest(ctx, &request)
+	if err != nil {
+		return s.authorizationErrorResponse(c, request, err)
+	}
+
+	switch payload.Decision {
+	case "approve":
+	case "deny":
+		return s.authorizationErrorResponse(c, request, &authorizationError{"access_denied", "the user denied the request", true})
+	default:
+		return s.authorizationErrorResponse(c, request, &authorizationError{"invalid_request", "decision must be approve or deny", true})
+	}
+
+	// a user enrolled in two-factor authentication comes back with the challenge started by their valid password
+	if payload.MFAToken != "" {
+		return s.submitAuthorizationMFA(c, client, request, payload)
+	}
+
+	// the page is another way to guess passwords, failed logins are counted like on POST /auth
+	attempt := loginAttempt{IP: c.RealIP(), PhoneNumber: payload.PhoneNumber}
+	block, err := s.checkLoginThrottle(ctx, attempt)
+	if err != nil {
+		return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+	}
+	if block != nil {
+		return renderLoginBlockedPage(c, client, request, block)
+	}
+
+	user, err := s.Repository.GetUserByPhoneNumber(ctx, payload.PhoneNumber)
+	if err != nil && err != sql.ErrNoRows {
+		return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	var existingUser *repository.UserOutput
+	valid := false
+	failureReason := loginFailureInvalidCredentials
+	if err == sql.ErrNoRows {
+		s.checkDummyPassword(payload.Password)
+	} else {
+		if block := accountLockBlock(user); block != nil {
+			err = s.recordFailedLogin(c, user.ID, loginMethodAuthorize, loginFailureAccountLocked)
+			if err != nil {
+				return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+			}
+
+			return renderLoginBlockedPage(c, client, request, block)
+		}
+
+		existingUser = &user
+		valid, err = s.verifyPassword(ctx, user, payload.Password)
+		// the hash cannot be checked anymore, the login is refused like a wrong password until the password is reset
+		if err == errPasswordPepperRetired {
+			err = nil
+			failureReason = loginFailurePasswordResetRequired
+		}
+		if err != nil {
+			return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+		}
+	}
+	if !valid {
+		if existingUser != nil {
+			err = s.recordFailedLogin(c, existingUser.ID, loginMethodAuthorize, failureReason)
+			if err != nil {
+				return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+			}
+		}
+
+		block, err := s.recordLoginFailure(ctx, attempt, existingUser)
+		if err != nil {
+			return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+		}
+		if block != nil {
+			return renderLoginBlockedPage(c, client, request, block)
+		}
+
+		return renderAuthorizePage(c, http.StatusUnauthorized, client, request, "Invalid phone number or password")
+	}
+
+	err = s.recordLoginSuccess(ctx, attempt)
+	if err != nil {
+		return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if user.SuspendedAt != nil {
+		err = s.recordFailedLogin(c, user.ID, loginMethodAuthorize, loginFailureAccountSuspended)
+		if err != nil {
+			return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+		}
+
+		return renderAuthorizePage(c, http.StatusForbidden, client, request, "This account is suspended")
+	}
+
+	// the password alone is not enough for a user enrolled in two-factor authentication, the code is only issued
+	// once their second factor is checked
+	enrolled, err := s.isMFAEnrolled(ctx, user.ID)
+	if err != nil {
+		return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+	}
+	if enrolled {
+		mfaToken, err := s.createMFAChallenge(ctx, user.ID)
+		if err != nil {
+			return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+		}
+
+		return renderAuthorizeMFAPage(c, http.StatusOK, client, request, mfaToken, "")
+	}
+
+	return s.authorizationCodeResponse(c, client, request, user)
+}
+
+// submitAuthorizationMFA completes the login of a user enrolled in two-factor authentication with their TOTP code or
+// a recovery code, checked the same way as on POST /auth/mfa
+func (s *Server) submitAuthorizationMFA(c echo.Context, client repository.OAuthClientOutput, request AuthorizationRequestValidator, payload SubmitAuthorizationValidator) error {
+	if (payload.Code == "") == (payload.RecoveryCode == "") {
+		return renderAuthorizeMFAPage(c, http.StatusBadRequest, client, request, payload.MFAToken, "Enter the code of your authenticator app or a recovery code")
+	}
+
+	user, err := s.completeMFAChallenge(c, payload.MFAToken, payload.Code, payload.RecoveryCode, loginMethodAuthorize)
+	if err != nil {
+		switch err {
+		case errMFACodeNotValid:
+			return renderAuthorizeMFAPage(c, http.StatusUnauthorized, client, request, payload.MFAToken, "Invalid code")
+		case errMFATokenNotValid:
+			// the challenge expired or ran out of attempts, the password is asked again
+			return renderAuthorizePage(c, http.StatusUnauthorized, client, request, "Your sign in expired, please sign in again")
+		}
+
+		return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return s.authorizationCodeResponse(c, client, request, user)
+}
+
+// authorizationCodeResponse records the login of the user, and redirects them back to the client with a new
+// authorization code
+func (s *Server) authorizationCodeResponse(c echo.Context, client repository.OAuthClientOutput, request AuthorizationRequestValidator, user repository.UserOutput) error {
+	ctx := c.Request().Context()
+
+	// the session is only started once the client exchanges the code, so the login has none
+	err := s.Repository.CreateLoginEvent(ctx, repository.CreateLoginEventInput{
+		UserID:    user.ID,
+		Method:    loginMethodAuthorize,
+		IPAddress: c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Succeeded: true,
+	})
+	if err != nil {
+		return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	code, err := generateOpaqueToken()
+	if err != nil {
+		return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	// the user has just authenticated, this is the auth_time of the id token
+	now := time.Now()
+
+	err = s.Repository.CreateAuthorizationCode(ctx, repository.CreateAuthorizationCodeInput{
+		CodeHash:            hashOpaqueToken(code),
+		ClientID:            client.ID,
+		UserID:              user.ID,
+		RedirectURI:         request.RedirectURI,
+		Scope:               request.Scope,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		Nonce:               request.Nonce,
+		AuthTime:            now.UTC(),
+		ExpiresAt:           now.Add(authorizationCodeTTL).UTC(),
+	})
+	if err != nil {
+		return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return redirectToClient(c, request, url.Values{"code": {code}})
+}
+
+// validateAuthorizationRequest checks the request against the registered client, and fills in the default scope.
+// invalid requests return an *authorizationError, anything else is a server error.
+func (s *Server) validateAuthorizationRequest(ctx context.Context, request *AuthorizationRequestValidator) (repository.OAuthClientOutput, error) {
+	if request.ClientID == "" {
+		return repository.OAuthClientOutput{}, &authorizationError{"invalid_request", "client_id is required", false}
+	}
+
+	client, err := s.Repository.GetOAuthClientByID(ctx, request.ClientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return repository.OAuthClientOutput{}, &authorizationError{"invalid_client", "unknown client", false}
+		}
+
+		return repository.OAuthClientOutput{}, err
+	}
+
+	// redirect uris are compared exactly, so codes can never be sent anywhere else
+	if !hasField(client.RedirectURIs, request.RedirectURI) {
+		return repository.OAuthClientOutput{}, &authorizationError{"invalid_request", "redirect_uri is not registered for the client", false}
+	}
+
+	switch {
+	case request.ResponseType != "code":
+		return client, &authorizationError{"unsupported_response_type", "response_type must be code", true}
+	case !hasField(client.GrantTypes, "authorization_code"):
+		return client, &authorizationError{"unauthorized_client", "the client cannot use the authorization code grant", true}
+	case request.CodeChallengeMethod != "S256":
+		return client, &authorizationError{"invalid_request", "code_challenge_method must be S256", true}
+	case !codeChallengePattern.MatchString(request.CodeChallenge):
+		return client, &authorizationError{"invalid_request", "code_challenge is invalid", true}
+	}
+
+	scope, ok := resolveScope(client, request.Scope)
+	if !ok {
+		return client, &authorizationError{"invalid_scope", "the requested scope is not allowed for the client", true}
+	}
+	request.Scope = scope
+
+	return client, nil
+}
+
+func (s *Server) authorizationErrorResponse(c echo.Context, request AuthorizationRequestValidator, err error) error {
+	authErr, ok := err.(*authorizationError)
+	if !ok {
+		return renderAuthorizeError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if !authErr.redirect {
+		return renderAuthorizeError(c, http.StatusBadRequest, authErr.description)
+	}
+
+	return redirectToClient(c, request, url.Values{
+		"error":             {authErr.code},
+		"error_description": {authErr.description},
+	})
+}
+
+// redirectToClient sends the user back to the validated redirect uri, along with the request state
+func redirectToClient(c echo.Context, request AuthorizationRequestValidator, params url.Values) error {
+	redirectURI, err := url.Parse(request.RedirectURI)
+	if err != nil {
+		return renderAuthorizeError(c, http.StatusBadRequest, "redirect_uri is invalid")
+	}
+
+	query := redirectURI.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	redirectURI.RawQuery = query.Encode()
+
+	return c.Redirect(http.StatusFound, redirectURI.String())
+}
+
+func renderAuthorizePage(c echo.Context, status int, client repository.OAuthClientOutput, request AuthorizationRequestValidator, errMessage string) error {
+	return renderHTML(c, status, authorizeTemplate, newAuthorizePage(client, request, errMessage))
+}
+
+// renderAuthorizeMFAPage renders the page asking for the TOTP code or a recovery code of the user, instead of their
+// phone number & password
+func renderAuthorizeMFAPage(c echo.Context, status int, client repository.OAuthClientOutput, request AuthorizationRequestValidator, mfaToken, errMessage string) error {
+	page := newAuthorizePage(client, request, errMessage)
+	page.MFAToken = mfaToken
+
+	return renderHTML(c, status, authorizeTemplate, page)
+}
+
+func newAuthorizePage(client repository.OAuthClientOutput, request AuthorizationRequestValidator, errMessage string) authorizePage {
+	page := authorizePage{
+		ClientName: client.Name,
+		Request:    request,
+		Error:      errMessage,
+	}
+	for _, scope := range strings.Fields(request.Scope) {
+		description, ok := scopeDescriptions[scope]
+		if !ok {
+			description = scope
+		}
+		page.Scopes = append(page.Scopes, description)
+	}
+
+	return page
+}
+
+// renderLoginBlockedPage renders the login page again, telling the user when they can try again
+func renderLoginBlockedPage(c echo.Context, client repository.OAuthClientOutput, request AuthorizationRequestValidator, block *loginBlock) error {
+	setRetryAfter(c, block.RetryAfter)
+
+	message := "Too many failed logins, please try again later"
+	if block.Status == http.StatusLocked {
+		message = "This account is temporarily locked after too many failed logins, please try again later"
+	}
+
+	return renderAuthorizePage(c, block.Status, client, request, message)
+}
+
+func renderAuthorizeError(c echo.Context, status int, description string) error {
+	return renderHTML(c, status, authorizeErrorTemplate, struct{ Description string }{description})
+}
+
+func renderHTML(c echo.Context, status int, tmpl *template.Template, data interface{}) error {
+	// the page asks for credentials, it must never be cached nor framed by another site
+	header := c.Response().Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Frame-Options", "DENY")
+	header.Set("Content-Security-Policy", "default-src 'none'; form-action 'self'; frame-ancestors 'none'")
+	header.Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+
+	return tmpl.Execute(c.Response(), data)
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+
+	return *value
+}
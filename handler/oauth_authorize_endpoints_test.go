@@ -0,0 +1,680 @@
+package handler_test
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// the code verifier & challenge example of RFC 7636
+const (
+	pkceCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	pkceCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+// mobileAppClient is a public client, using the authorization code grant with PKCE
+var mobileAppClient = repository.OAuthClientOutput{
+	ID:           "mobile-app",
+	Name:         "Mobile App",
+	RedirectURIs: "com.example.app:/callback https://app.example.com/callback",
+	Scopes:       "profile phone",
+	GrantTypes:   "authorization_code refresh_token",
+}
+
+type authorizationCodeInputMatcher struct {
+	clientID string
+	userID   string
+	scope    string
+}
+
+func (m authorizationCodeInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.CreateAuthorizationCodeInput)
+	if !ok {
+		return false
+	}
+
+	return len(actualInput.CodeHash) == 64 &&
+		m.clientID == actualInput.ClientID &&
+		m.userID == actualInput.UserID &&
+		m.scope == actualInput.Scope &&
+		actualInput.CodeChallenge == pkceCodeChallenge &&
+		actualInput.CodeChallengeMethod == "S256" &&
+		actualInput.ExpiresAt.After(time.Now())
+}
+
+func (m authorizationCodeInputMatcher) String() string {
+	return fmt.Sprintf("{CreateAuthorizationCodeInput - ClientID:%s UserID:%s Scope:%s}", m.clientID, m.userID, m.scope)
+}
+
+func authorizationRequestValues(modify func(url.Values)) url.Values {
+	values := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {mobileAppClient.ID},
+		"redirect_uri":          {"https://app.example.com/callback"},
+		"scope":                 {"profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {pkceCodeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	if modify != nil {
+		modify(values)
+	}
+
+	return values
+}
+
+func TestServer_Authorize(t *testing.T) {
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name          string
+		fields        fields
+		query         url.Values
+		wantStatus    int
+		wantBody      []string
+		wantRedirect  string
+		wantErrorCode string
+	}{
+		{
+			name: "successfully renders the login & consent page",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			query:      authorizationRequestValues(nil),
+			wantStatus: http.StatusOK,
+			wantBody: []string{
+				"Mobile App",
+				"See your full name",
+				`name="code_challenge" value="` + pkceCodeChallenge + `"`,
+			},
+		},
+		{
+			name: "unknown client is not redirected",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), "unknown-app").
+						Return(repository.OAuthClientOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			query: authorizationRequestValues(func(v url.Values) {
+				v.Set("client_id", "unknown-app")
+			}),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   []string{"unknown client"},
+		},
+		{
+			name: "unregistered redirect uri is not redirected",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			query: authorizationRequestValues(func(v url.Values) {
+				v.Set("redirect_uri", "https://evil.example.com/callback")
+			}),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   []string{"redirect_uri is not registered"},
+		},
+		{
+			name: "missing code challenge is redirected to the client",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			query: authorizationRequestValues(func(v url.Values) {
+				v.Del("code_challenge")
+			}),
+			wantStatus:    http.StatusFound,
+			wantRedirect:  "https://app.example.com/callback",
+			wantErrorCode: "invalid_request",
+		},
+		{
+			name: "plain code challenge method is rejected",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			query: authorizationRequestValues(func(v url.Values) {
+				v.Set("code_challenge_method", "plain")
+			}),
+			wantStatus:    http.StatusFound,
+			wantRedirect:  "https://app.example.com/callback",
+			wantErrorCode: "invalid_request",
+		},
+		{
+			name: "scope not allowed for the client",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			query: authorizationRequestValues(func(v url.Values) {
+				v.Set("scope", "profile admin")
+			}),
+			wantStatus:    http.StatusFound,
+			wantRedirect:  "https://app.example.com/callback",
+			wantErrorCode: "invalid_scope",
+		},
+		{
+			name: "unsupported response type",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			query: authorizationRequestValues(func(v url.Values) {
+				v.Set("response_type", "token")
+			}),
+			wantStatus:    http.StatusFound,
+			wantRedirect:  "https://app.example.com/callback",
+			wantErrorCode: "unsupported_response_type",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Get("/oauth/authorize?"+tt.query.Encode()).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			for _, want := range tt.wantBody {
+				assert.Contains(t, response.Recorder.Body.String(), want)
+			}
+
+			if tt.wantRedirect != "" {
+				location, err := url.Parse(response.Recorder.Header().Get("Location"))
+				assert.NoError(t, err)
+				assert.True(t, strings.HasPrefix(location.String(), tt.wantRedirect+"?"))
+				assert.Equal(t, tt.wantErrorCode, location.Query().Get("error"))
+				assert.Equal(t, "xyz", location.Query().Get("state"))
+			}
+		})
+	}
+}
+
+func TestServer_SubmitAuthorization(t *testing.T) {
+	hashedPassword, err := handler.NewArgon2idHasher(handler.DefaultArgon2idParams).Hash("Enter123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := repository.UserOutput{
+		ID:             "c118a1a9-28f1-4137-9093-87487d24e5d9",
+		FullName:       "test",
+		PhoneNumber:    "+62812345678",
+		HashedPassword: hashedPassword,
+	}
+
+	confirmedAt := time.Now()
+	confirmedSecret := repository.TOTPSecretOutput{
+		UserID:      user.ID,
+		ConfirmedAt: &confirmedAt,
+	}
+
+	sum := sha256.Sum256([]byte("mfa-token"))
+	attempt := repository.AttemptMFAChallengeInput{
+		TokenHash:   hex.EncodeToString(sum[:]),
+		MaxAttempts: 5,
+	}
+	challenge := repository.MFAChallengeOutput{
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+
+	sum = sha256.Sum256([]byte("ABCDEFGHIJKLMNOP"))
+	recoveryCodeHash := hex.EncodeToString(sum[:])
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name          string
+		fields        fields
+		form          url.Values
+		wantStatus    int
+		wantMFAStep   bool
+		wantCode      bool
+		wantErrorCode string
+	}{
+		{
+			name: "successfully approves & redirects with an authorization code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateAuthorizationCode(gomock.Any(), authorizationCodeInputMatcher{mobileAppClient.ID, user.ID, "profile"}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "authorize"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("phone_number", user.PhoneNumber)
+				v.Set("password", "Enter123!")
+				v.Set("decision", "approve")
+			}),
+			wantStatus: http.StatusFound,
+			wantCode:   true,
+		},
+		{
+			name: "enrolled user is asked for their second factor instead of getting a code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(confirmedSecret, nil)
+
+					mockRepo.EXPECT().
+						CreateMFAChallenge(gomock.Any(), gomock.Any()).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("phone_number", user.PhoneNumber)
+				v.Set("password", "Enter123!")
+				v.Set("decision", "approve")
+			}),
+			wantStatus:  http.StatusOK,
+			wantMFAStep: true,
+		},
+		{
+			name: "enrolled user gets a code once their recovery code is valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					gomock.InOrder(
+						mockRepo.EXPECT().
+							AttemptMFAChallenge(gomock.Any(), attempt).
+							Return(challenge, nil),
+						mockRepo.EXPECT().
+							UseRecoveryCode(gomock.Any(), repository.UseRecoveryCodeInput{UserID: user.ID, CodeHash: recoveryCodeHash}).
+							Return(nil),
+						mockRepo.EXPECT().
+							ConsumeMFAChallenge(gomock.Any(), attempt.TokenHash).
+							Return(nil),
+						mockRepo.EXPECT().
+							GetUserByID(gomock.Any(), user.ID).
+							Return(user, nil),
+						mockRepo.EXPECT().
+							CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "authorize"}).
+							Return(nil),
+						mockRepo.EXPECT().
+							CreateAuthorizationCode(gomock.Any(), authorizationCodeInputMatcher{mobileAppClient.ID, user.ID, "profile"}).
+							Return(nil),
+					)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("mfa_token", "mfa-token")
+				v.Set("recovery_code", "abcd-efgh-ijkl-mnop")
+				v.Set("decision", "approve")
+			}),
+			wantStatus: http.StatusFound,
+			wantCode:   true,
+		},
+		{
+			name: "wrong second factor renders the second step again",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(challenge, nil)
+
+					mockRepo.EXPECT().
+						UseRecoveryCode(gomock.Any(), gomock.Any()).
+						Return(repository.ErrRecoveryCodeNotValid)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "authorize", failureReason: "invalid_mfa_code"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("mfa_token", "mfa-token")
+				v.Set("recovery_code", "wrong-code")
+				v.Set("decision", "approve")
+			}),
+			wantStatus:  http.StatusUnauthorized,
+			wantMFAStep: true,
+		},
+		{
+			name: "missing second factor renders the second step again",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("mfa_token", "mfa-token")
+				v.Set("decision", "approve")
+			}),
+			wantStatus:  http.StatusBadRequest,
+			wantMFAStep: true,
+		},
+		{
+			name: "expired second step asks for the password again",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						AttemptMFAChallenge(gomock.Any(), attempt).
+						Return(repository.MFAChallengeOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("mfa_token", "mfa-token")
+				v.Set("code", "123456")
+				v.Set("decision", "approve")
+			}),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong password renders the page again",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "authorize", failureReason: "invalid_credentials"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("phone_number", user.PhoneNumber)
+				v.Set("password", "wrong-password")
+				v.Set("decision", "approve")
+			}),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "locked account renders the page again",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					lockedUntil := time.Now().Add(10 * time.Minute)
+					lockedUser := user
+					lockedUser.LockedUntil = &lockedUntil
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(lockedUser, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "authorize", failureReason: "account_locked"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("phone_number", user.PhoneNumber)
+				v.Set("password", "Enter123!")
+				v.Set("decision", "approve")
+			}),
+			wantStatus: http.StatusLocked,
+		},
+		{
+			name: "unknown user renders the page again",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("phone_number", user.PhoneNumber)
+				v.Set("password", "Enter123!")
+				v.Set("decision", "approve")
+			}),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "denied by the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("decision", "deny")
+			}),
+			wantStatus:    http.StatusFound,
+			wantErrorCode: "access_denied",
+		},
+		{
+			name: "failed storing the authorization code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateAuthorizationCode(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "authorize"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			form: authorizationRequestValues(func(v url.Values) {
+				v.Set("phone_number", user.PhoneNumber)
+				v.Set("password", "Enter123!")
+				v.Set("decision", "approve")
+			}),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/oauth/authorize").
+				WithContentType(echo.MIMEApplicationForm).
+				WithBody([]byte(tt.form.Encode())).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusFound {
+				assert.Equal(t, tt.wantMFAStep, strings.Contains(response.Recorder.Body.String(), `name="mfa_token"`))
+				return
+			}
+
+			location, err := url.Parse(response.Recorder.Header().Get("Location"))
+			assert.NoError(t, err)
+			assert.Equal(t, "app.example.com", location.Host)
+			assert.Equal(t, "xyz", location.Query().Get("state"))
+			assert.Equal(t, tt.wantErrorCode, location.Query().Get("error"))
+			assert.Equal(t, tt.wantCode, location.Query().Get("code") != "")
+		})
+	}
+}
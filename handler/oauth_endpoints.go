@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+var errInvalidClient = errors.New("client authentication failed")
+
+// Returns whether a token is active, along with its claims & the current state of its user (RFC 7662)
+// (POST /oauth/introspect)
+func (s *Server) IntrospectToken(c echo.Context) error {
+	var payload IntrospectTokenValidator
+	if err := c.Bind(&payload); err != nil {
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
+	ctx := c.Request().Context()
+
+	client, err := s.authenticateOAuthClient(ctx, c.Request(), payload.ClientID, payload.ClientSecret)
+	if err == nil && isPublicClient(client) {
+		// public clients cannot keep a secret, so they cannot be trusted with other users tokens
+		err = errInvalidClient
+	}
+	if err != nil {
+		return invalidClientResponse(c, err)
+	}
+
+	if err := validate.Struct(payload); err != nil {
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
+	// the hint only decides which kind of token is looked up first, as allowed by RFC 7662
+	introspectors := []func(context.Context, string) (generated.IntrospectTokenResponse, error){
+		s.introspectAccessToken,
+		s.introspectRefreshToken,
+	}
+	if payload.TokenTypeHint == "refresh_token" {
+		introspectors[0], introspectors[1] = introspectors[1], introspectors[0]
+	}
+
+	// the introspection result must never be reused once the token is revoked
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	for _, introspect := range introspectors {
+		response, err := introspect(ctx, payload.Token)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+
+		if response.Active {
+			return c.JSON(http.StatusOK, response)
+		}
+	}
+
+	return c.JSON(http.StatusOK, generated.IntrospectTokenResponse{
+		Active: false,
+	})
+}
+
+// introspectAccessToken returns an active response for a valid & unrevoked access token of an existing user.
+// any invalid token returns an inactive response, only unexpected failures are returned as errors.
+func (s *Server) introspectAccessToken(ctx context.Context, token string) (generated.IntrospectTokenResponse, error) {
+	inactive := generated.IntrospectTokenResponse{Active: false}
+
+	claims, err := s.JWT.ValidateToken(token)
+	if err != nil {
+		return inactive, nil
+	}
+
+	// tokens issued with the client_credentials grant have no user, they are active as long as their client exists
+	if claims.ID == "" {
+		_, err := s.Repository.GetOAuthClientByID(ctx, claims.ClientID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return inactive, nil
+			}
+
+			return inactive, err
+		}
+
+		return claimsIntrospection(claims, claims.ClientID), nil
+	}
+
+	revoked, err := s.Repository.IsAccessTokenRevoked(ctx, repository.IsAccessTokenRevokedInput{
+		JTI:          claims.RegisteredClaims.ID,
+		UserID:       claims.ID,
+		TokenVersion: claims.TokenVersion,
+		SessionID:    claims.SessionID,
+	})
+	if err != nil {
+		return inactive, errors.Wrap(err, "error checking token revocation")
+	}
+	if revoked {
+		return inactive, nil
+	}
+
+	// the token claims may be outdated, the user is returned as it currently is
+	user, err := s.Repository.GetUserByID(ctx, claims.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return inactive, nil
+		}
+
+		return inactive, err
+	}
+
+	response := claimsIntrospection(claims, user.ID)
+	response.FullName = &user.FullName
+	response.PhoneNumber = &user.PhoneNumber
+
+	return response, nil
+}
+
+func claimsIntrospection(claims *JWTCustomClaims, sub string) generated.IntrospectTokenResponse {
+	tokenType := "access_token"
+	exp := claims.ExpiresAt.Unix()
+	iat := claims.IssuedAt.Unix()
+	response := generated.IntrospectTokenResponse{
+		Active:    true,
+		TokenType: &tokenType,
+		Sub:       &sub,
+		Iss:       &claims.Issuer,
+		Jti:       &claims.RegisteredClaims.ID,
+		Exp:       &exp,
+		Iat:       &iat,
+	}
+	if claims.ClientID != "" {
+		response.ClientId = &claims.ClientID
+	}
+	if claims.Scope != "" {
+		response.Scope = &claims.Scope
+	}
+
+	return response
+}
+
+// introspectRefreshToken returns an active response for a refresh token which can still be exchanged.
+func (s *Server) introspectRefreshToken(ctx context.Context, token string) (generated.IntrospectTokenResponse, error) {
+	inactive := generated.IntrospectTokenResponse{Active: false}
+
+	refreshToken, err := s.Repository.GetRefreshTokenByHash(ctx, hashOpaqueToken(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return inactive, nil
+		}
+
+		return inactive, err
+	}
+
+	if refreshToken.RevokedAt != nil || refreshToken.RotatedAt != nil || !refreshToken.ExpiresAt.After(time.Now()) {
+		return inactive, nil
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, refreshToken.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return inactive, nil
+		}
+
+		return inactive, err
+	}
+
+	tokenType := "refresh_token"
+	exp := refreshToken.ExpiresAt.Unix()
+	response := generated.IntrospectTokenResponse{
+		Active:      true,
+		TokenType:   &tokenType,
+		Sub:         &user.ID,
+		Exp:         &exp,
+		ClientId:    refreshToken.ClientID,
+		FullName:    &user.FullName,
+		PhoneNumber: &user.PhoneNumber,
+	}
+	if refreshToken.Scope != "" {
+		response.Scope = &refreshToken.Scope
+	}
+
+	return response, nil
+}
+
+// authenticateOAuthClient checks the client credentials, sent with HTTP Basic authentication (client_secret_basic),
+// or in the form body (client_secret_post). public clients only send their client id.
+// errInvalidClient is returned when the credentials are missing or invalid.
+func (s *Server) authenticateOAuthClient(ctx context.Context, r *http.Request, formClientID, formClientSecret string) (repository.OAuthClientOutput, error) {
+	clientID, clientSecret := formClientID, formClientSecret
+	if basicID, basicSecret, ok := r.BasicAuth(); ok {
+		// RFC 6749 requires the basic credentials to be form encoded before being base64 encoded
+		var err error
+		if clientID, err = url.QueryUnescape(basicID); err != nil {
+			return repository.OAuthClientOutput{}, errInvalidClient
+		}
+		if clientSecret, err = url.QueryUnescape(basicSecret); err != nil {
+			return repository.OAuthClientOutput{}, errInvalidClient
+		}
+	}
+
+	if clientID == "" {
+		return repository.OAuthClientOutput{}, errInvalidClient
+	}
+
+	client, err := s.Repository.GetOAuthClientByID(ctx, clientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return repository.OAuthClientOutput{}, errInvalidClient
+		}
+
+		return repository.OAuthClientOutput{}, err
+	}
+
+	// public clients are only identified, they must not send a secret they cannot keep
+	if isPublicClient(client) {
+		if clientSecret != "" {
+			return repository.OAuthClientOutput{}, errInvalidClient
+		}
+
+		return client, nil
+	}
+
+	if clientSecret == "" {
+		return repository.OAuthClientOutput{}, errInvalidClient
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(client.HashedSecret), []byte(clientSecret))
+	if err != nil {
+		return repository.OAuthClientOutput{}, errInvalidClient
+	}
+
+	return client, nil
+}
+
+func isPublicClient(client repository.OAuthClientOutput) bool {
+	return client.HashedSecret == ""
+}
+
+func invalidClientResponse(c echo.Context, err error) error {
+	if err == errInvalidClient {
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		return oauthErrorResponse(c, http.StatusUnauthorized, "invalid_client", err.Error())
+	}
+
+	return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+		Message: err.Error(),
+	})
+}
+
+func oauthErrorResponse(c echo.Context, status int, code, description string) error {
+	return c.JSON(status, generated.OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: &description,
+	})
+}
@@ -0,0 +1,429 @@
+package handler_test
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func basicAuth(clientID, clientSecret string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(clientID)+":"+url.QueryEscape(clientSecret)))
+}
+
+func TestServer_IntrospectToken(t *testing.T) {
+	hashedSecret, err := bcrypt.GenerateFromPassword([]byte("client-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := repository.OAuthClientOutput{
+		ID:           "billing-service",
+		Name:         "Billing Service",
+		HashedSecret: string(hashedSecret),
+	}
+
+	user := repository.UserOutput{
+		ID:          dummyJWTClaims.ID,
+		FullName:    "Updated Name",
+		PhoneNumber: "+62812345678",
+	}
+
+	sum := sha256.Sum256([]byte("refresh-token"))
+	refreshTokenHash := hex.EncodeToString(sum[:])
+	activeRefreshToken := repository.RefreshTokenOutput{
+		ID:        "token-id",
+		UserID:    user.ID,
+		FamilyID:  "family-id",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	past := time.Now().Add(-time.Hour)
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	type args struct {
+		header map[string]string
+		form   url.Values
+	}
+	tests := []struct {
+		name          string
+		fields        fields
+		args          args
+		wantStatus    int
+		wantActive    bool
+		wantTokenType string
+	}{
+		{
+			name: "active access token, authenticated with basic auth",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), client.ID).
+						Return(client, nil)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": basicAuth(client.ID, "client-secret"),
+				},
+				form: url.Values{"token": {dummyJWT}},
+			},
+			wantStatus:    http.StatusOK,
+			wantActive:    true,
+			wantTokenType: "access_token",
+		},
+		{
+			name: "active access token, authenticated with form credentials",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), client.ID).
+						Return(client, nil)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: url.Values{
+					"token":         {dummyJWT},
+					"client_id":     {client.ID},
+					"client_secret": {"client-secret"},
+				},
+			},
+			wantStatus:    http.StatusOK,
+			wantActive:    true,
+			wantTokenType: "access_token",
+		},
+		{
+			name: "revoked access token is inactive",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), client.ID).
+						Return(client, nil)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(true, nil)
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), gomock.Any()).
+						Return(repository.RefreshTokenOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": basicAuth(client.ID, "client-secret"),
+				},
+				form: url.Values{"token": {dummyJWT}},
+			},
+			wantStatus: http.StatusOK,
+			wantActive: false,
+		},
+		{
+			name: "access token of a user who no longer exists is inactive",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), client.ID).
+						Return(client, nil)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), gomock.Any()).
+						Return(repository.RefreshTokenOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": basicAuth(client.ID, "client-secret"),
+				},
+				form: url.Values{"token": {dummyJWT}},
+			},
+			wantStatus: http.StatusOK,
+			wantActive: false,
+		},
+		{
+			name: "active refresh token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), client.ID).
+						Return(client, nil)
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), refreshTokenHash).
+						Return(activeRefreshToken, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": basicAuth(client.ID, "client-secret"),
+				},
+				form: url.Values{
+					"token":           {"refresh-token"},
+					"token_type_hint": {"refresh_token"},
+				},
+			},
+			wantStatus:    http.StatusOK,
+			wantActive:    true,
+			wantTokenType: "refresh_token",
+		},
+		{
+			name: "rotated refresh token is inactive",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), client.ID).
+						Return(client, nil)
+
+					rotatedToken := activeRefreshToken
+					rotatedToken.RotatedAt = &past
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), refreshTokenHash).
+						Return(rotatedToken, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": basicAuth(client.ID, "client-secret"),
+				},
+				form: url.Values{"token": {"refresh-token"}},
+			},
+			wantStatus: http.StatusOK,
+			wantActive: false,
+		},
+		{
+			name: "missing client credentials",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			args: args{
+				form: url.Values{"token": {dummyJWT}},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown client",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), "unknown-service").
+						Return(repository.OAuthClientOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": basicAuth("unknown-service", "client-secret"),
+				},
+				form: url.Values{"token": {dummyJWT}},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong client secret",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), client.ID).
+						Return(client, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": basicAuth(client.ID, "wrong-secret"),
+				},
+				form: url.Values{"token": {dummyJWT}},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "public clients cannot introspect tokens",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: url.Values{"token": {dummyJWT}, "client_id": {mobileAppClient.ID}},
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "missing token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), client.ID).
+						Return(client, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": basicAuth(client.ID, "client-secret"),
+				},
+				form: url.Values{},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed checking token revocation",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), client.ID).
+						Return(client, nil)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": basicAuth(client.ID, "client-secret"),
+				},
+				form: url.Values{"token": {dummyJWT}},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			req := testutil.NewRequest().Post("/oauth/introspect").
+				WithAcceptJson().
+				WithContentType(echo.MIMEApplicationForm).
+				WithBody([]byte(tt.args.form.Encode()))
+			for key, value := range tt.args.header {
+				req = req.WithHeader(key, value)
+			}
+			response := req.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body generated.IntrospectTokenResponse
+			assert.NoError(t, response.UnmarshalBodyToObject(&body))
+			assert.Equal(t, tt.wantActive, body.Active)
+			if !tt.wantActive {
+				assert.Nil(t, body.Sub)
+				return
+			}
+
+			assert.Equal(t, tt.wantTokenType, *body.TokenType)
+			assert.Equal(t, user.ID, *body.Sub)
+			assert.Equal(t, user.FullName, *body.FullName)
+			assert.Equal(t, user.PhoneNumber, *body.PhoneNumber)
+			assert.NotNil(t, body.Exp)
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// code verifiers are 43 to 128 unreserved characters, as defined by RFC 7636
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// Issues tokens for the authorization_code (with PKCE), refresh_token & client_credentials grants
+// (POST /oauth/token)
+func (s *Server) CreateOAuthToken(c echo.Context) error {
+	var payload OAuthTokenValidator
+	if err := c.Bind(&payload); err != nil {
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
+	switch payload.GrantType {
+	case "authorization_code", "refresh_token", "client_credentials":
+	case "":
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_request", "grant_type is required")
+	default:
+		return oauthErrorResponse(c, http.StatusBadRequest, "unsupported_grant_type", "grant_type is not supported")
+	}
+
+	ctx := c.Request().Context()
+
+	client, err := s.authenticateOAuthClient(ctx, c.Request(), payload.ClientID, payload.ClientSecret)
+	if err != nil {
+		return invalidClientResponse(c, err)
+	}
+
+	if !hasField(client.GrantTypes, payload.GrantType) {
+		return oauthErrorResponse(c, http.StatusBadRequest, "unauthorized_client", "the client cannot use this grant type")
+	}
+
+	// tokens must never be cached, as required by RFC 6749
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+
+	switch payload.GrantType {
+	case "authorization_code":
+		return s.exchangeAuthorizationCode(c, client, payload)
+	case "refresh_token":
+		return s.exchangeOAuthRefreshToken(c, client, payload)
+	default:
+		return s.issueClientCredentialsToken(c, client, payload)
+	}
+}
+
+func (s *Server) exchangeAuthorizationCode(c echo.Context, client repository.OAuthClientOutput, payload OAuthTokenValidator) error {
+	if payload.Code == "" || payload.CodeVerifier == "" {
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_request", "code & code_verifier are required")
+	}
+
+	ctx := c.Request().Context()
+
+	// the code is consumed before being checked, so a code which leaked can never be tried twice
+	code, err := s.Repository.ConsumeAuthorizationCode(ctx, hashOpaqueToken(payload.Code))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return oauthErrorResponse(c, http.StatusBadRequest, "invalid_grant", "authorization code not valid")
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	switch {
+	case code.ClientID != client.ID, code.RedirectURI != payload.RedirectURI, !code.ExpiresAt.After(time.Now()):
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_grant", "authorization code not valid")
+	case !verifyCodeChallenge(payload.CodeVerifier, code.CodeChallenge):
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code challenge")
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, code.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return oauthErrorResponse(c, http.StatusBadRequest, "invalid_grant", "authorization code not valid")
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	// the user may have been suspended since the code was issued
+	if user.SuspendedAt != nil {
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_grant", "user suspended")
+	}
+
+	// the id token is only issued along with the authorization, it is not renewed by refresh tokens
+	idToken := ""
+	if hasField(code.Scope, "openid") {
+		idToken, err = s.generateIDToken(user, code)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+	}
+
+	// every authorization starts a new session, identified by its refresh token family
+	return s.oauthTokenResponse(c, client, user, tokenGrant{
+		SessionID: uuid.NewString(),
+		ClientID:  client.ID,
+		Scope:     code.Scope,
+	}, idToken)
+}
+
+func (s *Server) exchangeOAuthRefreshToken(c echo.Context, client repository.OAuthClientOutput, payload OAuthTokenValidator) error {
+	if payload.RefreshToken == "" {
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+	}
+
+	ctx := c.Request().Context()
+
+	existingToken, err := s.rotateRefreshToken(ctx, payload.RefreshToken, &client.ID)
+	if err != nil {
+		if err == errRefreshTokenNotValid {
+			return oauthErrorResponse(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, existingToken.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return oauthErrorResponse(c, http.StatusBadRequest, "invalid_grant", errRefreshTokenNotValid.Error())
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// the scope is carried over as granted by the user, a requested scope is ignored as allowed by RFC 6749
+	return s.oauthTokenResponse(c, client, user, tokenGrant{
+		SessionID: existingToken.FamilyID,
+		ClientID:  client.ID,
+		Scope:     existingToken.Scope,
+	}, "")
+}
+
+func (s *Server) issueClientCredentialsToken(c echo.Context, client repository.OAuthClientOutput, payload OAuthTokenValidator) error {
+	if isPublicClient(client) {
+		return oauthErrorResponse(c, http.StatusBadRequest, "unauthorized_client", "public clients cannot use the client credentials grant")
+	}
+
+	scope, ok := resolveScope(client, payload.Scope)
+	if !ok {
+		return oauthErrorResponse(c, http.StatusBadRequest, "invalid_scope", "the requested scope is not allowed for the client")
+	}
+
+	// the client acts on its own behalf: there is no user, so the client is the subject
+	token, err := s.JWT.CreateToken(JWTCustomClaims{
+		ClientID:         client.ID,
+		Scope:            scope,
+		RegisteredClaims: s.registeredClaims(client.ID),
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, generated.OAuthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.AccessTokenTTL.Seconds()),
+		Scope:       optionalString(scope),
+	})
+}
+
+// oauthTokenResponse issues an access token for the user, along with a refresh token when the client may use them
+func (s *Server) oauthTokenResponse(c echo.Context, client repository.OAuthClientOutput, user repository.UserOutput, grant tokenGrant, idToken string) error {
+	token, err := s.generateAccessToken(user, grant)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	response := generated.OAuthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.AccessTokenTTL.Seconds()),
+		Scope:       optionalString(grant.Scope),
+		IdToken:     optionalString(idToken),
+	}
+
+	if hasField(client.GrantTypes, "refresh_token") {
+		refreshToken, err := s.createRefreshToken(c.Request().Context(), user.ID, grant)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+		response.RefreshToken = &refreshToken
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// verifyCodeChallenge checks the PKCE code verifier against the S256 code challenge of the authorization request
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// resolveScope checks that every requested scope is allowed for the client.
+// when no scope is requested, every scope allowed for the client is granted.
+func resolveScope(client repository.OAuthClientOutput, requested string) (string, bool) {
+	allowed := strings.Fields(client.Scopes)
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " "), true
+	}
+
+	scopes := []string{}
+	for _, scope := range strings.Fields(requested) {
+		if !hasField(client.Scopes, scope) {
+			return "", false
+		}
+		if !hasField(strings.Join(scopes, " "), scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return strings.Join(scopes, " "), true
+}
+
+// hasField reports whether the space separated list (e.g. a scope) contains the given value
+func hasField(list, value string) bool {
+	if value == "" {
+		return false
+	}
+
+	for _, field := range strings.Fields(list) {
+		if field == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+
+	return &value
+}
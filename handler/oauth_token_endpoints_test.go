@@ -0,0 +1,552 @@
+package handler_test
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_CreateOAuthToken(t *testing.T) {
+	hashedSecret, err := bcrypt.GenerateFromPassword([]byte("client-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceClient := repository.OAuthClientOutput{
+		ID:           "billing-service",
+		Name:         "Billing Service",
+		HashedSecret: string(hashedSecret),
+		Scopes:       "profile",
+		GrantTypes:   "client_credentials",
+	}
+
+	user := repository.UserOutput{
+		ID:          "c118a1a9-28f1-4137-9093-87487d24e5d9",
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+	}
+
+	sum := sha256.Sum256([]byte("authorization-code"))
+	codeHash := hex.EncodeToString(sum[:])
+	authorizationCode := repository.AuthorizationCodeOutput{
+		ClientID:            mobileAppClient.ID,
+		UserID:              user.ID,
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               "profile",
+		CodeChallenge:       pkceCodeChallenge,
+		CodeChallengeMethod: "S256",
+		ExpiresAt:           time.Now().Add(time.Minute),
+	}
+	codeExchangeForm := func(modify func(url.Values)) url.Values {
+		values := url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {mobileAppClient.ID},
+			"code":          {"authorization-code"},
+			"redirect_uri":  {"https://app.example.com/callback"},
+			"code_verifier": {pkceCodeVerifier},
+		}
+		if modify != nil {
+			modify(values)
+		}
+
+		return values
+	}
+
+	sum = sha256.Sum256([]byte("refresh-token"))
+	refreshTokenHash := hex.EncodeToString(sum[:])
+	clientRefreshToken := repository.RefreshTokenOutput{
+		ID:        "token-id",
+		UserID:    user.ID,
+		FamilyID:  "family-id",
+		ClientID:  &mobileAppClient.ID,
+		Scope:     "profile phone",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	type args struct {
+		header map[string]string
+		form   url.Values
+	}
+	tests := []struct {
+		name             string
+		fields           fields
+		args             args
+		wantStatus       int
+		wantError        string
+		wantScope        string
+		wantRefreshToken bool
+		wantClaims       func(t *testing.T, claims *handler.JWTCustomClaims)
+		wantIDToken      func(t *testing.T, claims *handler.IDTokenClaims)
+	}{
+		{
+			name: "successfully exchanges an authorization code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						ConsumeAuthorizationCode(gomock.Any(), codeHash).
+						Return(authorizationCode, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), gomock.Any()).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: codeExchangeForm(nil),
+			},
+			wantStatus:       http.StatusOK,
+			wantScope:        "profile",
+			wantRefreshToken: true,
+			wantClaims: func(t *testing.T, claims *handler.JWTCustomClaims) {
+				assert.Equal(t, user.ID, claims.ID)
+				assert.Equal(t, mobileAppClient.ID, claims.ClientID)
+				assert.Equal(t, "profile", claims.Scope)
+				assert.Equal(t, user.FullName, claims.FullName)
+				assert.Empty(t, claims.PhoneNumber)
+				assert.NotEmpty(t, claims.SessionID)
+			},
+		},
+		{
+			name: "successfully exchanges an authorization code granted with the openid scope",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					openIDCode := authorizationCode
+					openIDCode.Scope = "openid profile"
+					openIDCode.Nonce = "n-0S6_WzA2Mj"
+					openIDCode.AuthTime = time.Unix(1700000000, 0)
+					mockRepo.EXPECT().
+						ConsumeAuthorizationCode(gomock.Any(), codeHash).
+						Return(openIDCode, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), gomock.Any()).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: codeExchangeForm(nil),
+			},
+			wantStatus:       http.StatusOK,
+			wantScope:        "openid profile",
+			wantRefreshToken: true,
+			wantClaims: func(t *testing.T, claims *handler.JWTCustomClaims) {
+				assert.Equal(t, "openid profile", claims.Scope)
+			},
+			wantIDToken: func(t *testing.T, claims *handler.IDTokenClaims) {
+				assert.Equal(t, user.ID, claims.Subject)
+				assert.Equal(t, fixtureIssuer, claims.Issuer)
+				assert.Equal(t, jwt.ClaimStrings{mobileAppClient.ID}, claims.Audience)
+				assert.Equal(t, "n-0S6_WzA2Mj", claims.Nonce)
+				assert.Equal(t, int64(1700000000), claims.AuthTime.Unix())
+				assert.Equal(t, user.FullName, claims.Name)
+				assert.Empty(t, claims.PhoneNumber)
+				assert.Nil(t, claims.PhoneNumberVerified)
+			},
+		},
+		{
+			name: "code verifier does not match the challenge",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						ConsumeAuthorizationCode(gomock.Any(), codeHash).
+						Return(authorizationCode, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: codeExchangeForm(func(v url.Values) {
+					v.Set("code_verifier", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+				}),
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_grant",
+		},
+		{
+			name: "redirect uri does not match the authorization request",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						ConsumeAuthorizationCode(gomock.Any(), codeHash).
+						Return(authorizationCode, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: codeExchangeForm(func(v url.Values) {
+					v.Set("redirect_uri", "com.example.app:/callback")
+				}),
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_grant",
+		},
+		{
+			name: "code issued to another client",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					otherClientCode := authorizationCode
+					otherClientCode.ClientID = "other-app"
+					mockRepo.EXPECT().
+						ConsumeAuthorizationCode(gomock.Any(), codeHash).
+						Return(otherClientCode, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: codeExchangeForm(nil),
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_grant",
+		},
+		{
+			name: "code already used",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						ConsumeAuthorizationCode(gomock.Any(), codeHash).
+						Return(repository.AuthorizationCodeOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: codeExchangeForm(nil),
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_grant",
+		},
+		{
+			name: "code expired",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					expiredCode := authorizationCode
+					expiredCode.ExpiresAt = time.Now().Add(-time.Minute)
+					mockRepo.EXPECT().
+						ConsumeAuthorizationCode(gomock.Any(), codeHash).
+						Return(expiredCode, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: codeExchangeForm(nil),
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_grant",
+		},
+		{
+			name: "successfully exchanges a refresh token, keeping its scope",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), refreshTokenHash).
+						Return(clientRefreshToken, nil)
+
+					mockRepo.EXPECT().
+						RotateRefreshToken(gomock.Any(), clientRefreshToken.ID).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID, familyID: clientRefreshToken.FamilyID}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: url.Values{
+					"grant_type":    {"refresh_token"},
+					"client_id":     {mobileAppClient.ID},
+					"refresh_token": {"refresh-token"},
+					"scope":         {"profile"},
+				},
+			},
+			wantStatus:       http.StatusOK,
+			wantScope:        "profile phone",
+			wantRefreshToken: true,
+			wantClaims: func(t *testing.T, claims *handler.JWTCustomClaims) {
+				assert.Equal(t, clientRefreshToken.FamilyID, claims.SessionID)
+				assert.Equal(t, user.PhoneNumber, claims.PhoneNumber)
+			},
+		},
+		{
+			name: "refresh token issued to the first party login",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					firstPartyToken := clientRefreshToken
+					firstPartyToken.ClientID = nil
+					mockRepo.EXPECT().
+						GetRefreshTokenByHash(gomock.Any(), refreshTokenHash).
+						Return(firstPartyToken, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: url.Values{
+					"grant_type":    {"refresh_token"},
+					"client_id":     {mobileAppClient.ID},
+					"refresh_token": {"refresh-token"},
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_grant",
+		},
+		{
+			name: "successfully issues a client credentials token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), serviceClient.ID).
+						Return(serviceClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{"Authorization": basicAuth(serviceClient.ID, "client-secret")},
+				form:   url.Values{"grant_type": {"client_credentials"}},
+			},
+			wantStatus: http.StatusOK,
+			wantScope:  "profile",
+			wantClaims: func(t *testing.T, claims *handler.JWTCustomClaims) {
+				assert.Empty(t, claims.ID)
+				assert.Equal(t, serviceClient.ID, claims.Subject)
+				assert.Equal(t, serviceClient.ID, claims.ClientID)
+			},
+		},
+		{
+			name: "client credentials with a scope not allowed for the client",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), serviceClient.ID).
+						Return(serviceClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{"Authorization": basicAuth(serviceClient.ID, "client-secret")},
+				form:   url.Values{"grant_type": {"client_credentials"}, "scope": {"phone"}},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_scope",
+		},
+		{
+			name: "grant type not allowed for the client",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), mobileAppClient.ID).
+						Return(mobileAppClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				form: url.Values{"grant_type": {"client_credentials"}, "client_id": {mobileAppClient.ID}},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "unauthorized_client",
+		},
+		{
+			name: "wrong client secret",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetOAuthClientByID(gomock.Any(), serviceClient.ID).
+						Return(serviceClient, nil)
+
+					return mockRepo
+				}(),
+			},
+			args: args{
+				header: map[string]string{"Authorization": basicAuth(serviceClient.ID, "wrong-secret")},
+				form:   url.Values{"grant_type": {"client_credentials"}},
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name: "unsupported grant type",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			args: args{
+				form: url.Values{"grant_type": {"password"}, "client_id": {mobileAppClient.ID}},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "unsupported_grant_type",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			jwtHandler := newFixtureJWT()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        jwtHandler,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			request := testutil.NewRequest().Post("/oauth/token").
+				WithContentType(echo.MIMEApplicationForm).
+				WithBody([]byte(tt.args.form.Encode()))
+			for key, value := range tt.args.header {
+				request = request.WithHeader(key, value)
+			}
+			response := request.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantError != "" {
+				var errorResponse generated.OAuthErrorResponse
+				err := response.UnmarshalBodyToObject(&errorResponse)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantError, errorResponse.Error)
+				return
+			}
+
+			assert.Equal(t, "no-store", response.Recorder.Header().Get("Cache-Control"))
+
+			var tokenResponse generated.OAuthTokenResponse
+			err := response.UnmarshalBodyToObject(&tokenResponse)
+			assert.NoError(t, err)
+			assert.Equal(t, "Bearer", tokenResponse.TokenType)
+			assert.Equal(t, tt.wantScope, *tokenResponse.Scope)
+			assert.Equal(t, tt.wantRefreshToken, tokenResponse.RefreshToken != nil)
+
+			claims, err := jwtHandler.ValidateToken(tokenResponse.AccessToken)
+			assert.NoError(t, err)
+			tt.wantClaims(t, claims)
+
+			assert.Equal(t, tt.wantIDToken != nil, tokenResponse.IdToken != nil)
+			if tt.wantIDToken != nil {
+				// id tokens are issued for the client, they are never accepted as access tokens
+				_, err = jwtHandler.ValidateToken(*tokenResponse.IdToken)
+				assert.ErrorIs(t, err, handler.ErrTokenInvalidClaims)
+
+				var idTokenClaims handler.IDTokenClaims
+				_, err = jwt.ParseWithClaims(*tokenResponse.IdToken, &idTokenClaims, func(*jwt.Token) (interface{}, error) {
+					return jwt.ParseRSAPublicKeyFromPEM([]byte(RsaPublicKey))
+				}, jwt.WithValidMethods([]string{"RS256"}))
+				assert.NoError(t, err)
+				tt.wantIDToken(t, &idTokenClaims)
+			}
+		})
+	}
+}
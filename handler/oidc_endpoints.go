@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// supportedScopes are the scopes understood by the service, each client is only allowed a subset of them
+var supportedScopes = []string{"openid", "profile", "phone"}
+
+// Returns the OpenID Connect discovery document of the service
+// (GET /.well-known/openid-configuration)
+func (s *Server) GetOpenIDConfiguration(c echo.Context) error {
+	// the issuer is the base url of the service, every endpoint is published relative to it
+	issuer := strings.TrimSuffix(s.JWT.Issuer(), "/")
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+
+	return c.JSON(http.StatusOK, generated.OpenIDConfiguration{
+		Issuer:                            s.JWT.Issuer(),
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		JwksUri:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{s.JWT.SigningAlgorithm()},
+		ScopesSupported:                   supportedScopes,
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "phone_number", "phone_number_verified"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	})
+}
+
+// Returns the claims about the user of the access token, limited to the scope granted to the client
+// (GET /userinfo)
+func (s *Server) GetUserInfo(c echo.Context) error {
+	claims, err := s.validateBearerToken(c)
+	if err != nil {
+		for _, tokenErr := range tokenErrors {
+			if errors.Is(err, tokenErr) {
+				return invalidTokenResponse(c, http.StatusUnauthorized, "invalid_token", tokenErr.Error())
+			}
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// client credentials tokens have no user to describe
+	if claims.ID == "" {
+		return invalidTokenResponse(c, http.StatusUnauthorized, "invalid_token", "token not issued for a user")
+	}
+
+	// first party tokens see the whole profile, like on GET /me
+	scope := claims.Scope
+	if claims.ClientID == "" {
+		scope = strings.Join(supportedScopes, " ")
+	}
+	if !hasField(scope, "openid") {
+		return invalidTokenResponse(c, http.StatusForbidden, "insufficient_scope", "token not granted the openid scope")
+	}
+
+	user, err := s.Repository.GetUserByID(c.Request().Context(), claims.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return invalidTokenResponse(c, http.StatusUnauthorized, "invalid_token", "user no longer exists")
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	return c.JSON(http.StatusOK, userInfo(user, scope))
+}
+
+// generateIDToken issues the id token of an authorization code granted with the openid scope
+func (s *Server) generateIDToken(user repository.UserOutput, code repository.AuthorizationCodeOutput) (string, error) {
+	now := time.Now()
+	info := userInfo(user, code.Scope)
+
+	return s.JWT.CreateIDToken(IDTokenClaims{
+		Name:                stringValue(info.Name),
+		PhoneNumber:         stringValue(info.PhoneNumber),
+		PhoneNumberVerified: info.PhoneNumberVerified,
+		Nonce:               code.Nonce,
+		AuthTime:            jwt.NewNumericDate(code.AuthTime),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID,
+			Issuer:    s.JWT.Issuer(),
+			Audience:  jwt.ClaimStrings{code.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.AccessTokenTTL)),
+		},
+	})
+}
+
+// userInfo returns the standard claims about the user which the scope gives access to
+func userInfo(user repository.UserOutput, scope string) generated.UserInfoResponse {
+	info := generated.UserInfoResponse{
+		Sub: user.ID,
+	}
+
+	if hasField(scope, "profile") {
+		info.Name = &user.FullName
+	}
+	if hasField(scope, "phone") {
+		verified := user.PhoneVerifiedAt != nil
+		info.PhoneNumber = &user.PhoneNumber
+		info.PhoneNumberVerified = &verified
+	}
+
+	return info
+}
+
+// invalidTokenResponse renders a bearer token error, as defined by RFC 6750
+func invalidTokenResponse(c echo.Context, status int, code, description string) error {
+	c.Response().Header().Set("WWW-Authenticate", `Bearer error="`+code+`", error_description="`+description+`"`)
+
+	return oauthErrorResponse(c, status, code, description)
+}
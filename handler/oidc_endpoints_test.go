@@ -0,0 +1,296 @@
+package handler_test
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_GetOpenIDConfiguration(t *testing.T) {
+	e := echo.New()
+	s := handler.NewServer(handler.NewServerOptions{
+		Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+		JWT: mustNewJWT(handler.NewJWTOptions{
+			SigningKey: generateEd25519Key(t, "ed25519-key"),
+			Issuer:     fixtureIssuer + "/",
+			Audience:   fixtureAudience,
+		}),
+	})
+
+	generated.RegisterHandlers(e, s)
+
+	response := testutil.NewRequest().Get("/.well-known/openid-configuration").GoWithHTTPHandler(t, e)
+	assert.Equal(t, http.StatusOK, response.Code())
+
+	var configuration generated.OpenIDConfiguration
+	err := response.UnmarshalBodyToObject(&configuration)
+	assert.NoError(t, err)
+	assert.Equal(t, fixtureIssuer+"/", configuration.Issuer)
+	assert.Equal(t, fixtureIssuer+"/oauth/authorize", configuration.AuthorizationEndpoint)
+	assert.Equal(t, fixtureIssuer+"/oauth/token", configuration.TokenEndpoint)
+	assert.Equal(t, fixtureIssuer+"/userinfo", configuration.UserinfoEndpoint)
+	assert.Equal(t, fixtureIssuer+"/.well-known/jwks.json", configuration.JwksUri)
+	assert.Equal(t, []string{"EdDSA"}, configuration.IdTokenSigningAlgValuesSupported)
+	assert.Equal(t, []string{"S256"}, configuration.CodeChallengeMethodsSupported)
+	assert.Contains(t, configuration.ScopesSupported, "openid")
+}
+
+func TestServer_GetUserInfo(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          dummyJWTClaims.ID,
+		FullName:    "Updated Name",
+		PhoneNumber: "+62812345678",
+	}
+	verifiedAt := time.Now()
+	verifiedUser := user
+	verifiedUser.PhoneVerifiedAt = &verifiedAt
+
+	clientToken := func(claims handler.JWTCustomClaims) string {
+		now := time.Now()
+		claims.RegisteredClaims = jwt.RegisteredClaims{
+			ID:        "9b1d3c4e-7f2a-4c5b-8d6e-0a1b2c3d4e5f",
+			Subject:   claims.ID,
+			Issuer:    fixtureIssuer,
+			Audience:  jwt.ClaimStrings{fixtureAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+		if claims.ID == "" {
+			claims.Subject = claims.ClientID
+		}
+
+		token, err := newFixtureJWT().CreateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		token      string
+		wantStatus int
+		wantError  string
+		want       generated.UserInfoResponse
+	}{
+		{
+			name: "successfully returns the claims granted to the client",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), gomock.Any()).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+			},
+			token: clientToken(handler.JWTCustomClaims{
+				ID:       user.ID,
+				ClientID: "mobile-app",
+				Scope:    "openid profile",
+			}),
+			wantStatus: http.StatusOK,
+			want: generated.UserInfoResponse{
+				Sub:  user.ID,
+				Name: &user.FullName,
+			},
+		},
+		{
+			name: "successfully returns every claim for a first party token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(verifiedUser, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusOK,
+			want: func() generated.UserInfoResponse {
+				verified := true
+				return generated.UserInfoResponse{
+					Sub:                 user.ID,
+					Name:                &user.FullName,
+					PhoneNumber:         &user.PhoneNumber,
+					PhoneNumberVerified: &verified,
+				}
+			}(),
+		},
+		{
+			name: "token not granted the openid scope",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), gomock.Any()).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			token: clientToken(handler.JWTCustomClaims{
+				ID:       user.ID,
+				ClientID: "mobile-app",
+				Scope:    "profile",
+			}),
+			wantStatus: http.StatusForbidden,
+			wantError:  "insufficient_scope",
+		},
+		{
+			name: "client credentials token has no user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), gomock.Any()).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			token: clientToken(handler.JWTCustomClaims{
+				ClientID: "billing-service",
+				Scope:    "openid",
+			}),
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+		},
+		{
+			name: "revoked token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(true, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+		},
+		{
+			name: "missing token",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+		},
+		{
+			name: "user no longer exists",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+		},
+		{
+			name: "failed checking token revocation",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			request := testutil.NewRequest().Get("/userinfo")
+			if tt.token != "" {
+				request = request.WithJWSAuth(tt.token)
+			}
+			response := request.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantError != "" {
+				var errorResponse generated.OAuthErrorResponse
+				err := response.UnmarshalBodyToObject(&errorResponse)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantError, errorResponse.Error)
+				assert.Contains(t, response.Recorder.Header().Get("WWW-Authenticate"), `error="`+tt.wantError+`"`)
+				return
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				var got generated.UserInfoResponse
+				err := response.UnmarshalBodyToObject(&got)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// passkeyChallengeTTL is the time left to the user to complete a passkey ceremony with their authenticator
+	passkeyChallengeTTL = 5 * time.Minute
+
+	passkeyCeremonyRegistration   = "registration"
+	passkeyCeremonyAuthentication = "authentication"
+)
+
+var errPasskeyChallengeNotValid = errors.New("challenge not valid")
+
+// Starts the registration of a passkey for the logged in user, returning the options to pass to navigator.credentials.create()
+// (POST /auth/passkeys/registration/options)
+func (s *Server) CreatePasskeyRegistrationOptions(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// the authenticator refuses to create a second passkey for the same account
+	credentials, err := s.Repository.ListWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	excludeCredentials := make([]generated.PasskeyCredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		transports := strings.Fields(credential.Transports)
+		excludeCredentials = append(excludeCredentials, generated.PasskeyCredentialDescriptor{
+			Type:       "public-key",
+			Id:         credential.ID,
+			Transports: &transports,
+		})
+	}
+
+	challenge, err := s.createPasskeyChallenge(c, passkeyCeremonyRegistration, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	pubKeyCredParams := make([]generated.PasskeyCredentialParameter, 0, len(supportedCOSEAlgorithms))
+	for _, algorithm := range supportedCOSEAlgorithms {
+		pubKeyCredParams = append(pubKeyCredParams, generated.PasskeyCredentialParameter{
+			Type: "public-key",
+			Alg:  algorithm,
+		})
+	}
+
+	return c.JSON(http.StatusOK, generated.PasskeyRegistrationOptionsResponse{
+		Challenge: challenge,
+		Rp: generated.PasskeyRelyingParty{
+			Id:   s.webAuthnRPID(),
+			Name: s.WebAuthnRPName,
+		},
+		User: generated.PasskeyUser{
+			Id:          base64.RawURLEncoding.EncodeToString([]byte(user.ID)),
+			Name:        user.PhoneNumber,
+			DisplayName: user.FullName,
+		},
+		PubKeyCredParams:   pubKeyCredParams,
+		Timeout:            passkeyChallengeTTL.Milliseconds(),
+		ExcludeCredentials: excludeCredentials,
+		// passkeys are discoverable, so the login does not need the phone number
+		AuthenticatorSelection: generated.PasskeyAuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	})
+}
+
+// Completes the registration of a passkey for the logged in user, with the credential created by the authenticator
+// (POST /auth/passkeys/registration)
+func (s *Server) RegisterPasskey(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	var payload RegisterPasskeyValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	rawID, errID := decodeWebAuthnBase64(payload.RawID)
+	clientData, errClientData := decodeWebAuthnBase64(payload.Response.ClientDataJSON)
+	attestationObject, errAttestation := decodeWebAuthnBase64(payload.Response.AttestationObject)
+	if payload.Type != "public-key" || len(rawID) == 0 || errID != nil || errClientData != nil || errAttestation != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "credential not valid",
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	challenge, err := parseWebAuthnClientData(clientData, "webauthn.create", s.webAuthnOrigins())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	err = s.consumePasskeyChallenge(c, passkeyCeremonyRegistration, challenge, id)
+	if err != nil {
+		if err == errPasskeyChallengeNotValid {
+			return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	credential, err := s.verifyPasskeyAttestation(attestationObject, rawID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	transports := payload.Response.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(rawID)
+	err = s.Repository.CreateWebAuthnCredential(ctx, repository.CreateWebAuthnCredentialInput{
+		ID:         credentialID,
+		UserID:     id,
+		PublicKey:  credential.CredentialPublicKey,
+		SignCount:  int64(credential.SignCount),
+		Transports: strings.Join(transports, " "),
+	})
+	if err != nil {
+		if err == repository.ErrWebAuthnCredentialExists {
+			return c.JSON(http.StatusConflict, generated.ErrorResponse{
+				Message: "passkey already registered",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, generated.PasskeyResponse{
+		Id:         credentialID,
+		Transports: transports,
+	})
+}
+
+// Starts a passwordless login, returning the options to pass to navigator.credentials.get()
+// (POST /auth/passkeys/login/options)
+func (s *Server) CreatePasskeyLoginOptions(c echo.Context) error {
+	challenge, err := s.createPasskeyChallenge(c, passkeyCeremonyAuthentication, "")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, generated.PasskeyLoginOptionsResponse{
+		Challenge:        challenge,
+		RpId:             s.webAuthnRPID(),
+		Timeout:          passkeyChallengeTTL.Milliseconds(),
+		UserVerification: "required",
+	})
+}
+
+// Logs a user in with a passkey & return the logged in user ID & generated jwt token
+// (POST /auth/passkeys/login)
+func (s *Server) LoginWithPasskey(c echo.Context) error {
+	var payload PasskeyLoginValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	rawID, errID := decodeWebAuthnBase64(payload.RawID)
+	clientData, errClientData := decodeWebAuthnBase64(payload.Response.ClientDataJSON)
+	authData, errAuthData := decodeWebAuthnBase64(payload.Response.AuthenticatorData)
+	signature, errSignature := decodeWebAuthnBase64(payload.Response.Signature)
+	userHandle, errUserHandle := decodeWebAuthnBase64(payload.Response.UserHandle)
+	if payload.Type != "public-key" || len(rawID) == 0 || errID != nil || errClientData != nil || errAuthData != nil || errSignature != nil || errUserHandle != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "credential not valid",
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	challenge, err := parseWebAuthnClientData(clientData, "webauthn.get", s.webAuthnOrigins())
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, generated.ErrorResponse{
+			Message: "passkey not valid",
+		})
+	}
+
+	err = s.consumePasskeyChallenge(c, passkeyCeremonyAuthentication, challenge, "")
+	if err != nil {
+		if err == errPasskeyChallengeNotValid {
+			return c.JSON(http.StatusUnauthorized, generated.ErrorResponse{
+				Message: "passkey not valid",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	credential, err := s.Repository.GetWebAuthnCredential(ctx, base64.RawURLEncoding.EncodeToString(rawID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusUnauthorized, generated.ErrorResponse{
+				Message: "passkey not valid",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	signCount, err := s.verifyPasskeyAssertion(credential, userHandle, authData, clientData, signature)
+	if err != nil {
+		return s.failedPasskeyLoginResponse(c, credential)
+	}
+
+	// a sign count going backwards means the private key was copied to another authenticator
+	err = s.Repository.UseWebAuthnCredential(ctx, repository.UseWebAuthnCredentialInput{
+		ID:        credential.ID,
+		SignCount: int64(signCount),
+	})
+	if err != nil {
+		if err == repository.ErrWebAuthnSignCountNotValid {
+			return s.failedPasskeyLoginResponse(c, credential)
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, credential.UserID)
+	if err != nil {
+		// the passkeys of a deleted account are kept until its erasure, they cannot log in anymore
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusUnauthorized, generated.ErrorResponse{
+				Message: "passkey not valid",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// a passkey verifies the user on the authenticator, so it stands for both factors
+	return s.loginResponse(c, user, loginMethodPasskey)
+}
+
+// failedPasskeyLoginResponse refuses an assertion of a known passkey, adding the failure to the login history of its user
+func (s *Server) failedPasskeyLoginResponse(c echo.Context, credential repository.WebAuthnCredentialOutput) error {
+	err := s.recordFailedLogin(c, credential.UserID, loginMethodPasskey, loginFailureInvalidPasskey)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusUnauthorized, generated.ErrorResponse{
+		Message: "passkey not valid",
+	})
+}
+
+// createPasskeyChallenge stores a new single use challenge for the ceremony, bound to the user for registrations
+func (s *Server) createPasskeyChallenge(c echo.Context, ceremony, userID string) (string, error) {
+	challenge, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.Repository.CreateWebAuthnChallenge(c.Request().Context(), repository.CreateWebAuthnChallengeInput{
+		ChallengeHash: hashOpaqueToken(challenge),
+		Ceremony:      ceremony,
+		UserID:        userID,
+		ExpiresAt:     time.Now().Add(passkeyChallengeTTL).UTC(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error storing passkey challenge")
+	}
+
+	return challenge, nil
+}
+
+// consumePasskeyChallenge checks the challenge signed by the authenticator was issued for the ceremony & the user,
+// and marks it as used. errPasskeyChallengeNotValid is returned for unknown, replayed & expired challenges.
+func (s *Server) consumePasskeyChallenge(c echo.Context, ceremony, challenge, userID string) error {
+	existingChallenge, err := s.Repository.ConsumeWebAuthnChallenge(c.Request().Context(), repository.ConsumeWebAuthnChallengeInput{
+		ChallengeHash: hashOpaqueToken(challenge),
+		Ceremony:      ceremony,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errPasskeyChallengeNotValid
+		}
+
+		return err
+	}
+
+	if !existingChallenge.ExpiresAt.After(time.Now()) || existingChallenge.UserID != userID {
+		return errPasskeyChallengeNotValid
+	}
+
+	return nil
+}
+
+// verifyPasskeyAttestation returns the credential created by the authenticator, once checked against the relying party
+func (s *Server) verifyPasskeyAttestation(attestationObject, rawID []byte) (authenticatorData, error) {
+	data, err := parseAttestationObject(attestationObject)
+	if err != nil {
+		return authenticatorData{}, err
+	}
+
+	authData, err := parseAuthenticatorData(data)
+	if err != nil {
+		return authenticatorData{}, err
+	}
+
+	if err := authData.verify(s.webAuthnRPID()); err != nil {
+		return authenticatorData{}, err
+	}
+
+	if authData.CredentialID == nil || string(authData.CredentialID) != string(rawID) {
+		return authenticatorData{}, errors.New("credential id not valid")
+	}
+
+	// only keys of a supported algorithm are stored, so every stored key can verify assertions
+	if _, err := parseCOSEKey(authData.CredentialPublicKey); err != nil {
+		return authenticatorData{}, err
+	}
+
+	return authData, nil
+}
+
+// verifyPasskeyAssertion checks the assertion was signed by the stored credential, and returns its new sign count
+func (s *Server) verifyPasskeyAssertion(credential repository.WebAuthnCredentialOutput, userHandle, data, clientData, signature []byte) (uint32, error) {
+	// the user handle is optional, but must designate the owner of the credential when sent
+	if len(userHandle) > 0 && string(userHandle) != credential.UserID {
+		return 0, errors.New("user handle not valid")
+	}
+
+	authData, err := parseAuthenticatorData(data)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := authData.verify(s.webAuthnRPID()); err != nil {
+		return 0, err
+	}
+
+	key, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := key.verify(data, clientData, signature); err != nil {
+		return 0, err
+	}
+
+	return authData.SignCount, nil
+}
+
+// webAuthnRPID returns the relying party id passkeys are bound to, the host of the jwt issuer by default
+func (s *Server) webAuthnRPID() string {
+	if s.WebAuthnRPID != "" {
+		return s.WebAuthnRPID
+	}
+
+	issuer, err := url.Parse(s.JWT.Issuer())
+	if err != nil {
+		return ""
+	}
+
+	return issuer.Hostname()
+}
+
+// webAuthnOrigins returns the origins allowed to run the passkey ceremonies, the origin of the jwt issuer by default
+func (s *Server) webAuthnOrigins() []string {
+	if len(s.WebAuthnOrigins) > 0 {
+		return s.WebAuthnOrigins
+	}
+
+	issuer, err := url.Parse(s.JWT.Issuer())
+	if err != nil {
+		return nil
+	}
+
+	return []string{issuer.Scheme + "://" + issuer.Host}
+}
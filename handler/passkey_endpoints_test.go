@@ -0,0 +1,827 @@
+package handler_test
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const passkeyChallenge = "cGFzc2tleS1jaGFsbGVuZ2U"
+
+func passkeyChallengeHash() string {
+	sum := sha256.Sum256([]byte(passkeyChallenge))
+	return hex.EncodeToString(sum[:])
+}
+
+type webAuthnChallengeInputMatcher struct {
+	ceremony string
+	userID   string
+}
+
+func (m webAuthnChallengeInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.CreateWebAuthnChallengeInput)
+	if !ok {
+		return false
+	}
+
+	return actualInput.Ceremony == m.ceremony &&
+		actualInput.UserID == m.userID &&
+		len(actualInput.ChallengeHash) == 64 &&
+		actualInput.ExpiresAt.After(time.Now())
+}
+
+func (m webAuthnChallengeInputMatcher) String() string {
+	return fmt.Sprintf("{CreateWebAuthnChallengeInput - Ceremony:%s, UserID:%s}", m.ceremony, m.userID)
+}
+
+func TestServer_CreatePasskeyRegistrationOptions(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          dummyJWTClaims.ID,
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		wantStatus int
+	}{
+		{
+			name: "successfully returns the creation options",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						ListWebAuthnCredentials(gomock.Any(), user.ID).
+						Return([]repository.WebAuthnCredentialOutput{
+							{ID: "existing-credential", UserID: user.ID, Transports: "usb nfc"},
+						}, nil)
+
+					mockRepo.EXPECT().
+						CreateWebAuthnChallenge(gomock.Any(), webAuthnChallengeInputMatcher{ceremony: "registration", userID: user.ID}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "failed storing the challenge",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						ListWebAuthnCredentials(gomock.Any(), user.ID).
+						Return(nil, nil)
+
+					mockRepo.EXPECT().
+						CreateWebAuthnChallenge(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/auth/passkeys/registration/options").
+				WithJWSAuth(dummyJWT).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var options generated.PasskeyRegistrationOptionsResponse
+			err := response.UnmarshalBodyToObject(&options)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, options.Challenge)
+			assert.Equal(t, generated.PasskeyRelyingParty{Id: "users.example.com", Name: "User Service"}, options.Rp)
+			assert.Equal(t, generated.PasskeyUser{
+				Id:          base64.RawURLEncoding.EncodeToString([]byte(user.ID)),
+				Name:        user.PhoneNumber,
+				DisplayName: user.FullName,
+			}, options.User)
+			assert.Equal(t, int64(-7), options.PubKeyCredParams[0].Alg)
+			assert.Len(t, options.ExcludeCredentials, 1)
+			assert.Equal(t, "existing-credential", options.ExcludeCredentials[0].Id)
+			assert.Equal(t, []string{"usb", "nfc"}, *options.ExcludeCredentials[0].Transports)
+			assert.Equal(t, "required", options.AuthenticatorSelection.ResidentKey)
+			assert.Equal(t, "required", options.AuthenticatorSelection.UserVerification)
+		})
+	}
+}
+
+func TestServer_RegisterPasskey(t *testing.T) {
+	userID := dummyJWTClaims.ID
+	registrationChallenge := repository.WebAuthnChallengeOutput{
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	consumeChallenge := repository.ConsumeWebAuthnChallengeInput{
+		ChallengeHash: passkeyChallengeHash(),
+		Ceremony:      "registration",
+	}
+
+	es256Authenticator := newES256Authenticator(t)
+	eddsaAuthenticator := newEdDSAAuthenticator(t)
+	rs256Authenticator := newRS256Authenticator(t)
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		payload    interface{}
+		wantStatus int
+	}{
+		{
+			name: "successfully registers an ES256 passkey",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(registrationChallenge, nil)
+
+					mockRepo.EXPECT().
+						CreateWebAuthnCredential(gomock.Any(), repository.CreateWebAuthnCredentialInput{
+							ID:         es256Authenticator.credentialIDString(),
+							UserID:     userID,
+							PublicKey:  es256Authenticator.coseKey(),
+							SignCount:  0,
+							Transports: "internal hybrid",
+						}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.create(passkeyChallenge),
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name: "successfully registers an EdDSA passkey",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(registrationChallenge, nil)
+
+					mockRepo.EXPECT().
+						CreateWebAuthnCredential(gomock.Any(), gomock.Any()).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    eddsaAuthenticator.create(passkeyChallenge),
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name: "successfully registers an RS256 passkey",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(registrationChallenge, nil)
+
+					mockRepo.EXPECT().
+						CreateWebAuthnCredential(gomock.Any(), gomock.Any()).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    rs256Authenticator.create(passkeyChallenge),
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name: "passkey already registered",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(registrationChallenge, nil)
+
+					mockRepo.EXPECT().
+						CreateWebAuthnCredential(gomock.Any(), gomock.Any()).
+						Return(repository.ErrWebAuthnCredentialExists)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.create(passkeyChallenge),
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "origin not allowed",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: func() generated.RegisterPasskeyRequest {
+				phishing := *es256Authenticator
+				phishing.origin = "https://users.example.co"
+				return phishing.create(passkeyChallenge)
+			}(),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "credential created for another relying party",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(registrationChallenge, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: func() generated.RegisterPasskeyRequest {
+				otherRP := *es256Authenticator
+				otherRP.rpID = "example.org"
+				return otherRP.create(passkeyChallenge)
+			}(),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "user not verified by the authenticator",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(registrationChallenge, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: func() generated.RegisterPasskeyRequest {
+				presenceOnly := *es256Authenticator
+				presenceOnly.flags = 0x01
+				return presenceOnly.create(passkeyChallenge)
+			}(),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "challenge issued to another user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(repository.WebAuthnChallengeOutput{
+							UserID:    "6a0f4c5e-8d3b-4f1a-9c2e-7b5d1e3f9a0c",
+							ExpiresAt: time.Now().Add(time.Minute),
+						}, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.create(passkeyChallenge),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "challenge expired",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(repository.WebAuthnChallengeOutput{
+							UserID:    userID,
+							ExpiresAt: time.Now().Add(-time.Minute),
+						}, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.create(passkeyChallenge),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "challenge unknown or already used",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(repository.WebAuthnChallengeOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.create(passkeyChallenge),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "malformed credential",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"id":    "not base64!",
+				"rawId": "not base64!",
+				"type":  "public-key",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/auth/passkeys/registration").
+				WithJWSAuth(dummyJWT).
+				WithJsonBody(tt.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus == http.StatusCreated {
+				var passkey generated.PasskeyResponse
+				err := response.UnmarshalBodyToObject(&passkey)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.payload.(generated.RegisterPasskeyRequest).RawId, passkey.Id)
+				assert.Equal(t, []string{"internal", "hybrid"}, passkey.Transports)
+			}
+		})
+	}
+}
+
+func TestServer_CreatePasskeyLoginOptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRepo := repository.NewMockRepositoryInterface(ctrl)
+	mockRepo.EXPECT().
+		CreateWebAuthnChallenge(gomock.Any(), webAuthnChallengeInputMatcher{ceremony: "authentication"}).
+		Return(nil)
+
+	e := echo.New()
+	s := handler.NewServer(handler.NewServerOptions{
+		Repository: mockRepo,
+		JWT:        newFixtureJWT(),
+	})
+
+	generated.RegisterHandlers(e, s)
+
+	response := testutil.NewRequest().Post("/auth/passkeys/login/options").GoWithHTTPHandler(t, e)
+	assert.Equal(t, http.StatusOK, response.Code())
+
+	var options generated.PasskeyLoginOptionsResponse
+	err := response.UnmarshalBodyToObject(&options)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, options.Challenge)
+	assert.Equal(t, "users.example.com", options.RpId)
+	assert.Equal(t, "required", options.UserVerification)
+}
+
+func TestServer_LoginWithPasskey(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          "c118a1a9-28f1-4137-9093-87487d24e5d9",
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+	}
+	loginChallenge := repository.WebAuthnChallengeOutput{
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	consumeChallenge := repository.ConsumeWebAuthnChallengeInput{
+		ChallengeHash: passkeyChallengeHash(),
+		Ceremony:      "authentication",
+	}
+
+	es256Authenticator := newES256Authenticator(t)
+	es256Authenticator.signCount = 4
+	es256Credential := repository.WebAuthnCredentialOutput{
+		ID:        es256Authenticator.credentialIDString(),
+		UserID:    user.ID,
+		PublicKey: es256Authenticator.coseKey(),
+		SignCount: 4,
+	}
+
+	eddsaAuthenticator := newEdDSAAuthenticator(t)
+	eddsaAuthenticator.noCounter = true
+	eddsaCredential := repository.WebAuthnCredentialOutput{
+		ID:        eddsaAuthenticator.credentialIDString(),
+		UserID:    user.ID,
+		PublicKey: eddsaAuthenticator.coseKey(),
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		payload    interface{}
+		wantStatus int
+	}{
+		{
+			name: "successfully logs in with a passkey",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(loginChallenge, nil)
+
+					mockRepo.EXPECT().
+						GetWebAuthnCredential(gomock.Any(), es256Credential.ID).
+						Return(es256Credential, nil)
+
+					mockRepo.EXPECT().
+						UseWebAuthnCredential(gomock.Any(), repository.UseWebAuthnCredentialInput{ID: es256Credential.ID, SignCount: 5}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "passkey"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.get(passkeyChallenge, user.ID),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "successfully logs in with a passkey without sign count nor user handle",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(loginChallenge, nil)
+
+					mockRepo.EXPECT().
+						GetWebAuthnCredential(gomock.Any(), eddsaCredential.ID).
+						Return(eddsaCredential, nil)
+
+					mockRepo.EXPECT().
+						UseWebAuthnCredential(gomock.Any(), repository.UseWebAuthnCredentialInput{ID: eddsaCredential.ID, SignCount: 0}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "passkey"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    eddsaAuthenticator.get(passkeyChallenge, ""),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "signature of another key",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(loginChallenge, nil)
+
+					mockRepo.EXPECT().
+						GetWebAuthnCredential(gomock.Any(), es256Credential.ID).
+						Return(es256Credential, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "passkey", failureReason: "invalid_passkey"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: func() generated.PasskeyLoginRequest {
+				impostor := newES256Authenticator(t)
+				impostor.credentialID = es256Authenticator.credentialID
+				return impostor.get(passkeyChallenge, user.ID)
+			}(),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "sign count went backwards",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(loginChallenge, nil)
+
+					mockRepo.EXPECT().
+						GetWebAuthnCredential(gomock.Any(), es256Credential.ID).
+						Return(es256Credential, nil)
+
+					mockRepo.EXPECT().
+						UseWebAuthnCredential(gomock.Any(), gomock.Any()).
+						Return(repository.ErrWebAuthnSignCountNotValid)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "passkey", failureReason: "invalid_passkey"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.get(passkeyChallenge, user.ID),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "user handle of another user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(loginChallenge, nil)
+
+					mockRepo.EXPECT().
+						GetWebAuthnCredential(gomock.Any(), es256Credential.ID).
+						Return(es256Credential, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "passkey", failureReason: "invalid_passkey"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.get(passkeyChallenge, "6a0f4c5e-8d3b-4f1a-9c2e-7b5d1e3f9a0c"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "passkey of a deleted user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(loginChallenge, nil)
+
+					mockRepo.EXPECT().
+						GetWebAuthnCredential(gomock.Any(), es256Credential.ID).
+						Return(es256Credential, nil)
+
+					mockRepo.EXPECT().
+						UseWebAuthnCredential(gomock.Any(), gomock.Any()).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.get(passkeyChallenge, user.ID),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown credential",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(loginChallenge, nil)
+
+					mockRepo.EXPECT().
+						GetWebAuthnCredential(gomock.Any(), es256Credential.ID).
+						Return(repository.WebAuthnCredentialOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.get(passkeyChallenge, user.ID),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "challenge unknown or already used",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						ConsumeWebAuthnChallenge(gomock.Any(), consumeChallenge).
+						Return(repository.WebAuthnChallengeOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			payload:    es256Authenticator.get(passkeyChallenge, user.ID),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "origin not allowed",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			payload: func() generated.PasskeyLoginRequest {
+				phishing := *es256Authenticator
+				phishing.origin = "https://users.example.co"
+				return phishing.get(passkeyChallenge, user.ID)
+			}(),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "malformed assertion",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			payload: map[string]interface{}{
+				"id":    "not base64!",
+				"rawId": "not base64!",
+				"type":  "public-key",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/auth/passkeys/login").
+				WithJsonBody(tt.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus == http.StatusOK {
+				var login generated.AuthenticateUserResponse
+				err := response.UnmarshalBodyToObject(&login)
+				assert.NoError(t, err)
+				assert.Equal(t, user.ID, login.Id)
+				assert.NotEmpty(t, login.Token)
+			}
+		})
+	}
+}
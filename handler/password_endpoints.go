@@ -0,0 +1,370 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const phoneOTPPurposePasswordReset = "password_reset"
+
+var errPasswordReused = errors.New("password was used recently")
+
+// Sends a password reset code by SMS, if the phone number belongs to a user
+// (POST /auth/password-reset)
+func (s *Server) RequestPasswordReset(c echo.Context) error {
+	var payload RequestPasswordResetValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	if payload.PhoneNumber == "" {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "phone_number is required",
+		})
+	}
+
+	if s.SMSSender == nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: errSMSNotConfigured.Error(),
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := s.Repository.GetUserByPhoneNumber(ctx, payload.PhoneNumber)
+	if err != nil && err != sql.ErrNoRows {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// unknown phone numbers get the same response after the same hashing work, so this endpoint does not tell who is
+	// registered. a failed send must not change the response either, it is only logged
+	if err == nil {
+		err = s.sendPhoneOTP(ctx, user, phoneOTPPurposePasswordReset)
+		if err != nil && err != repository.ErrPhoneOTPResendTooSoon {
+			c.Logger().Errorf("failed to send a password reset code: %v", err)
+		}
+	} else {
+		hashDummyPhoneOTP()
+	}
+
+	return c.JSON(http.StatusAccepted, generated.PhoneOTPResponse{
+		ExpiresIn: int64(phoneOTPTTL.Seconds()),
+	})
+}
+
+// Sets a new password with the code sent by SMS, and logs every session of the user out
+// (POST /auth/password-reset/confirm)
+func (s *Server) ConfirmPasswordReset(c echo.Context) error {
+	var payload ConfirmPasswordResetValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	fieldErrors := payload.Validate()
+	if len(fieldErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message:          "field validation failed",
+			ValidationErrors: (*[]generated.FieldError)(&fieldErrors),
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	// checked before the code, a refused password does not use up one of its attempts
+	fieldErrors, err := s.breachedPasswordFieldErrors(ctx, "Password", payload.Password)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if len(fieldErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message:          "field validation failed",
+			ValidationErrors: (*[]generated.FieldError)(&fieldErrors),
+		})
+	}
+
+	// an unknown phone number is answered like a wrong code, after the same hashing work
+	var codeHash string
+	user, err := s.Repository.GetUserByPhoneNumber(ctx, payload.PhoneNumber)
+	if err == nil {
+		codeHash, err = s.checkPhoneOTP(ctx, user, phoneOTPPurposePasswordReset, payload.Code)
+	} else if err == sql.ErrNoRows {
+		compareDummyPhoneOTP(payload.Code)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows || err == errPhoneOTPNotValid {
+			return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+				Message: "code not valid",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// the code is checked first, so the name, the phone number & the password history of a user cannot be
+	// probed without it. it is only used once the password is accepted, so a refused password does not waste it
+	fieldErrors = passwordPersonalInfoFieldErrors("Password", payload.Password, user.FullName, user.PhoneNumber)
+	if len(fieldErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message:          "field validation failed",
+			ValidationErrors: (*[]generated.FieldError)(&fieldErrors),
+		})
+	}
+
+	reused, err := s.isPasswordReused(ctx, user, payload.Password)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if reused {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: errPasswordReused.Error(),
+		})
+	}
+
+	hashedPassword, err := s.PasswordHasher.Hash(payload.Password)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	err = s.consumePhoneOTP(ctx, user.ID, phoneOTPPurposePasswordReset, codeHash)
+	if err != nil {
+		if err == errPhoneOTPNotValid {
+			return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+				Message: "code not valid",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// whoever knew the previous password may still be logged in, so every session is revoked along with it
+	err = s.Repository.UpdatePassword(ctx, repository.UpdatePasswordInput{
+		UserID:         user.ID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Changes the password of the logged in user. Every other session is logged out, and a new session is returned
+// (PUT /users/me/password)
+func (s *Server) ChangePassword(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	var payload ChangePasswordValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	fieldErrors := payload.Validate()
+	if len(fieldErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message:          "field validation failed",
+			ValidationErrors: (*[]generated.FieldError)(&fieldErrors),
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	fieldErrors, err = s.breachedPasswordFieldErrors(ctx, "NewPassword", payload.NewPassword)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if len(fieldErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message:          "field validation failed",
+			ValidationErrors: (*[]generated.FieldError)(&fieldErrors),
+		})
+	}
+
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// a stolen access token alone is not enough to take the account over
+	valid, err := s.PasswordHasher.Verify(user.HashedPassword, payload.CurrentPassword)
+	if err == errPasswordPepperRetired {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: errPasswordResetRequired.Error(),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if !valid {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "current password not valid",
+		})
+	}
+
+	fieldErrors = passwordPersonalInfoFieldErrors("NewPassword", payload.NewPassword, user.FullName, user.PhoneNumber)
+	if len(fieldErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message:          "field validation failed",
+			ValidationErrors: (*[]generated.FieldError)(&fieldErrors),
+		})
+	}
+
+	reused, err := s.isPasswordReused(ctx, user, payload.NewPassword)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if reused {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: errPasswordReused.Error(),
+		})
+	}
+
+	hashedPassword, err := s.PasswordHasher.Hash(payload.NewPassword)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	err = s.Repository.UpdatePassword(ctx, repository.UpdatePasswordInput{
+		UserID:         user.ID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// every session was revoked with the update, the current one included. the new session is issued
+	// with the bumped token version, so it is the only one left.
+	user, err = s.Repository.GetUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// the new session is recorded in the login history like any other
+	return s.loginResponse(c, user, loginMethodPasswordChange)
+}
+
+// breachedPasswordFieldErrors refuses a new password known from a data breach, it is among the first guesses
+// of any attacker whatever its strength
+func (s *Server) breachedPasswordFieldErrors(ctx context.Context, field, password string) (FieldErrors, error) {
+	if s.BreachedPasswords == nil {
+		return nil, nil
+	}
+
+	breached, err := s.BreachedPasswords.IsBreached(ctx, password)
+	if err != nil || !breached {
+		return nil, err
+	}
+
+	return FieldErrors{{
+		Field:      field,
+		Validation: "has appeared in a data breach, choose another password",
+	}}, nil
+}
+
+// isPasswordReused reports whether the password is the current password of the user, or one of their
+// previous passwords within the history size
+func (s *Server) isPasswordReused(ctx context.Context, user repository.UserOutput, password string) (bool, error) {
+	// a hash made with a retired pepper cannot be checked anymore, the password has to be reset then
+	reused, err := s.PasswordHasher.Verify(user.HashedPassword, password)
+	if err != nil && err != errPasswordPepperRetired || reused {
+		return reused, err
+	}
+	if s.PasswordHistorySize <= 1 {
+		return false, nil
+	}
+
+	previousHashes, err := s.Repository.ListPasswordHistory(ctx, repository.ListPasswordHistoryInput{
+		UserID: user.ID,
+		Limit:  s.PasswordHistorySize - 1,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, hash := range previousHashes {
+		// the previous passwords are never rehashed, they are skipped once their pepper is retired
+		reused, err := s.PasswordHasher.Verify(hash, password)
+		if err != nil && err != errPasswordPepperRetired || reused {
+			return reused, err
+		}
+	}
+
+	return false, nil
+}
+
+// verifyPassword checks the password of the user. their hash is upgraded when it was made with an outdated
+// algorithm or outdated parameters, as this is only possible while the password is known.
+// errPasswordPepperRetired is returned when the hash cannot be checked anymore, after the time of a password check.
+func (s *Server) verifyPassword(ctx context.Context, user repository.UserOutput, password string) (bool, error) {
+	valid, err := s.PasswordHasher.Verify(user.HashedPassword, password)
+	if err == errPasswordPepperRetired {
+		// the refusal takes as long as a wrong password, so it does not tell the retired hashes apart
+		s.checkDummyPassword(password)
+		return false, err
+	}
+	if err != nil || !valid {
+		return false, err
+	}
+	if !s.PasswordHasher.NeedsRehash(user.HashedPassword) {
+		return true, nil
+	}
+
+	hashedPassword, err := s.PasswordHasher.Hash(password)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.Repository.UpdatePasswordHash(ctx, repository.UpdatePasswordHashInput{
+		UserID:         user.ID,
+		PreviousHash:   user.HashedPassword,
+		HashedPassword: hashedPassword,
+	})
+	// a password changed in the meantime comes with its own hash, which is up to date already
+	if err != nil && err != repository.ErrPasswordHashChanged {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,815 @@
+package handler_test
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type updatePasswordInputMatcher struct {
+	userID   string
+	password string
+}
+
+func (m updatePasswordInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.UpdatePasswordInput)
+	if !ok {
+		return false
+	}
+
+	valid, err := handler.NewArgon2idHasher(handler.DefaultArgon2idParams).Verify(actualInput.HashedPassword, m.password)
+	return actualInput.UserID == m.userID &&
+		strings.HasPrefix(actualInput.HashedPassword, "$argon2id$") &&
+		err == nil && valid
+}
+
+func (m updatePasswordInputMatcher) String() string {
+	return fmt.Sprintf("{UpdatePasswordInput - UserID:%s, password hash of %s}", m.userID, m.password)
+}
+
+func TestServer_RequestPasswordReset(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          "abc123-def456",
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+	}
+
+	upsertMatcher := upsertPhoneOTPInputMatcher{
+		userID:      user.ID,
+		purpose:     "password_reset",
+		phoneNumber: user.PhoneNumber,
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name        string
+		fields      fields
+		phoneNumber string
+		sendErr     error
+		wantStatus  int
+		wantSent    bool
+		wantLogged  bool
+	}{
+		{
+			name: "successfully sends a password reset code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusAccepted,
+			wantSent:    true,
+		},
+		{
+			name: "unknown phone number gets the same response",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusAccepted,
+		},
+		{
+			name: "code sent too recently gets the same response",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(repository.ErrPhoneOTPResendTooSoon)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusAccepted,
+		},
+		{
+			name: "phone number is missing",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed send gets the same response",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			sendErr:     assert.AnError,
+			wantStatus:  http.StatusAccepted,
+			wantSent:    true,
+			wantLogged:  true,
+		},
+		{
+			name: "failed code storage gets the same response",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusAccepted,
+			wantLogged:  true,
+		},
+		{
+			name: "failed when fetching the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(repository.UserOutput{}, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			var logs bytes.Buffer
+			e.Logger.SetOutput(&logs)
+			sender := &recordingSMSSender{err: tt.sendErr}
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+				SMSSender:  sender,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/auth/password-reset").
+				WithJsonBody(map[string]interface{}{"phone_number": tt.phoneNumber}).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			assert.Equal(t, tt.wantLogged, strings.Contains(logs.String(), "failed to send a password reset code"))
+			if tt.wantSent {
+				assert.Equal(t, []string{user.PhoneNumber}, sender.phoneNumbers)
+				assert.Contains(t, sender.messages[0], "password reset code")
+				assert.Len(t, sender.lastCode(), 6)
+			} else {
+				assert.Empty(t, sender.messages)
+			}
+		})
+	}
+}
+
+func TestServer_ConfirmPasswordReset(t *testing.T) {
+	currentHash, _ := handler.NewArgon2idHasher(handler.DefaultArgon2idParams).Hash("ForgottenPassword1!")
+	user := repository.UserOutput{
+		ID:             "abc123-def456",
+		FullName:       "test",
+		PhoneNumber:    "+62812345678",
+		HashedPassword: currentHash,
+	}
+
+	codeHash, _ := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.MinCost)
+	attempt := repository.AttemptPhoneOTPInput{
+		UserID:      user.ID,
+		Purpose:     "password_reset",
+		MaxAttempts: 5,
+	}
+	activeCode := repository.PhoneOTPOutput{
+		PhoneNumber: user.PhoneNumber,
+		CodeHash:    string(codeHash),
+		ExpiresAt:   time.Now().Add(time.Minute),
+	}
+	consume := repository.ConsumePhoneOTPInput{
+		UserID:   user.ID,
+		Purpose:  "password_reset",
+		CodeHash: string(codeHash),
+	}
+	payload := map[string]interface{}{
+		"phone_number": user.PhoneNumber,
+		"code":         "123456",
+		"password":     "NewPassword1!",
+	}
+	history := repository.ListPasswordHistoryInput{
+		UserID: user.ID,
+		Limit:  4,
+	}
+	previousHash, _ := bcrypt.GenerateFromPassword([]byte("NewPassword1!"), bcrypt.MinCost)
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		payload    map[string]interface{}
+		wantStatus int
+	}{
+		{
+			name: "successfully resets the password",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(activeCode, nil)
+
+					mockRepo.EXPECT().
+						ListPasswordHistory(gomock.Any(), history).
+						Return([]string{}, nil)
+
+					mockRepo.EXPECT().
+						ConsumePhoneOTP(gomock.Any(), consume).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						UpdatePassword(gomock.Any(), updatePasswordInputMatcher{userID: user.ID, password: "NewPassword1!"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    payload,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "new password does not follow the rules",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+			},
+			payload: map[string]interface{}{
+				"phone_number": user.PhoneNumber,
+				"code":         "123456",
+				"password":     "newpassword",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "code is missing",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+			},
+			payload: map[string]interface{}{
+				"phone_number": user.PhoneNumber,
+				"password":     "NewPassword1!",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown phone number",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			payload:    payload,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "code not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(repository.PhoneOTPOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			payload:    payload,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "new password was used recently, the code is kept",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(activeCode, nil)
+
+					mockRepo.EXPECT().
+						ListPasswordHistory(gomock.Any(), history).
+						Return([]string{string(previousHash)}, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload:    payload,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "new password contains the name of the user, the code is kept",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(activeCode, nil)
+
+					return mockRepo
+				}(),
+			},
+			payload: map[string]interface{}{
+				"phone_number": user.PhoneNumber,
+				"code":         "123456",
+				"password":     "MyTest1Password!",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "code used in the meantime",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(activeCode, nil)
+
+					mockRepo.EXPECT().
+						ListPasswordHistory(gomock.Any(), history).
+						Return([]string{}, nil)
+
+					mockRepo.EXPECT().
+						ConsumePhoneOTP(gomock.Any(), consume).
+						Return(repository.ErrPhoneOTPNotActive)
+
+					return mockRepo
+				}(),
+			},
+			payload:    payload,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed when updating the password",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(activeCode, nil)
+
+					mockRepo.EXPECT().
+						ListPasswordHistory(gomock.Any(), history).
+						Return([]string{}, nil)
+
+					mockRepo.EXPECT().
+						ConsumePhoneOTP(gomock.Any(), consume).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						UpdatePassword(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			payload:    payload,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+				SMSSender:  &recordingSMSSender{},
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/auth/password-reset/confirm").
+				WithJsonBody(tt.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+		})
+	}
+}
+
+func TestServer_ChangePassword(t *testing.T) {
+	currentHash, _ := bcrypt.GenerateFromPassword([]byte("CurrentPassword1!"), bcrypt.MinCost)
+	previousHash, _ := bcrypt.GenerateFromPassword([]byte("PreviousPassword1!"), bcrypt.MinCost)
+	user := repository.UserOutput{
+		ID:             dummyJWTClaims.ID,
+		FullName:       "test",
+		PhoneNumber:    "+62812345678",
+		HashedPassword: string(currentHash),
+	}
+	// the password update bumps the token version, revoking every token issued so far
+	updatedUser := user
+	updatedUser.TokenVersion = user.TokenVersion + 1
+
+	history := repository.ListPasswordHistoryInput{
+		UserID: user.ID,
+		Limit:  4,
+	}
+	payload := map[string]interface{}{
+		"current_password": "CurrentPassword1!",
+		"new_password":     "NewPassword1!",
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		token      string
+		payload    map[string]interface{}
+		wantStatus int
+	}{
+		{
+			name: "successfully changes the password",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					gomock.InOrder(
+						mockRepo.EXPECT().
+							GetUserByID(gomock.Any(), user.ID).
+							Return(user, nil),
+						mockRepo.EXPECT().
+							ListPasswordHistory(gomock.Any(), history).
+							Return([]string{string(previousHash)}, nil),
+						mockRepo.EXPECT().
+							UpdatePassword(gomock.Any(), updatePasswordInputMatcher{userID: user.ID, password: "NewPassword1!"}).
+							Return(nil),
+						mockRepo.EXPECT().
+							GetUserByID(gomock.Any(), user.ID).
+							Return(updatedUser, nil),
+						mockRepo.EXPECT().
+							CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+							Return(nil),
+						mockRepo.EXPECT().
+							CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password_change"}).
+							Return(nil),
+					)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    payload,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+			},
+			payload:    payload,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "new password does not follow the rules",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			token: dummyJWT,
+			payload: map[string]interface{}{
+				"current_password": "CurrentPassword1!",
+				"new_password":     "newpassword",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "current password not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+			},
+			token: dummyJWT,
+			payload: map[string]interface{}{
+				"current_password": "WrongPassword1!",
+				"new_password":     "NewPassword1!",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "new password is the current password",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+			},
+			token: dummyJWT,
+			payload: map[string]interface{}{
+				"current_password": "CurrentPassword1!",
+				"new_password":     "CurrentPassword1!",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "new password is a previous password",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						ListPasswordHistory(gomock.Any(), history).
+						Return([]string{string(previousHash)}, nil)
+
+					return mockRepo
+				}(),
+			},
+			token: dummyJWT,
+			payload: map[string]interface{}{
+				"current_password": "CurrentPassword1!",
+				"new_password":     "PreviousPassword1!",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed when updating the password",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						ListPasswordHistory(gomock.Any(), history).
+						Return([]string{}, nil)
+
+					mockRepo.EXPECT().
+						UpdatePassword(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			payload:    payload,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "new password contains the phone number of the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					return mockRepo
+				}(),
+			},
+			token: dummyJWT,
+			payload: map[string]interface{}{
+				"current_password": "CurrentPassword1!",
+				"new_password":     "Zq812345678!xv",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			jwtHandler := newFixtureJWT()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        jwtHandler,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Put("/users/me/password").
+				WithJWSAuth(tt.token).
+				WithJsonBody(tt.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var session generated.AuthenticateUserResponse
+			err := response.UnmarshalBodyToObject(&session)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, session.RefreshToken)
+
+			// the new access token carries the bumped token version
+			claims, err := jwtHandler.ValidateToken(session.Token)
+			assert.NoError(t, err)
+			assert.Equal(t, updatedUser.TokenVersion, claims.TokenVersion)
+		})
+	}
+}
+
+func TestServer_ChangePassword_PasswordPepperRetired(t *testing.T) {
+	// the hash was made with a pepper which is not configured anymore
+	retiredHash, _ := newPepperedHasher(t, "1").Hash("CurrentPassword1!")
+	user := repository.UserOutput{
+		ID:             dummyJWTClaims.ID,
+		FullName:       "test",
+		PhoneNumber:    "+62812345678",
+		HashedPassword: retiredHash,
+	}
+
+	ctrl := gomock.NewController(t)
+	mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+	mockRepo.EXPECT().
+		IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+		Return(false, nil)
+
+	mockRepo.EXPECT().
+		GetUserByID(gomock.Any(), user.ID).
+		Return(user, nil)
+
+	e := echo.New()
+	s := handler.NewServer(handler.NewServerOptions{
+		Repository:     mockRepo,
+		JWT:            newFixtureJWT(),
+		PasswordHasher: newPepperedHasher(t, "2"),
+	})
+
+	generated.RegisterHandlers(e, s)
+
+	response := testutil.NewRequest().Put("/users/me/password").
+		WithJWSAuth(dummyJWT).
+		WithJsonBody(map[string]interface{}{
+			"current_password": "CurrentPassword1!",
+			"new_password":     "NewPassword1!",
+		}).
+		GoWithHTTPHandler(t, e)
+
+	assert.Equal(t, http.StatusBadRequest, response.Code())
+	assert.Contains(t, response.Recorder.Body.String(), "reset it with a code sent by sms")
+}
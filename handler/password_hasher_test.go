@@ -0,0 +1,89 @@
+package handler_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := handler.NewArgon2idHasher(handler.DefaultArgon2idParams)
+
+	t.Run("hashes with argon2id & the configured parameters", func(t *testing.T) {
+		hash, err := hasher.Hash("Enter123!")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$"))
+		assert.False(t, hasher.NeedsRehash(hash))
+
+		otherHash, err := hasher.Hash("Enter123!")
+		assert.NoError(t, err)
+		assert.NotEqual(t, hash, otherHash, "every hash has its own salt")
+
+		valid, err := hasher.Verify(hash, "Enter123!")
+		assert.NoError(t, err)
+		assert.True(t, valid)
+
+		valid, err = hasher.Verify(hash, "Enter123?")
+		assert.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("verifies passwords longer than 72 bytes in full", func(t *testing.T) {
+		password := strings.Repeat("é", 64)
+
+		hash, err := hasher.Hash(password)
+		assert.NoError(t, err)
+
+		valid, err := hasher.Verify(hash, password[:len(password)-2]+"e")
+		assert.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("verifies bcrypt hashes, which always need a rehash", func(t *testing.T) {
+		hash, err := bcrypt.GenerateFromPassword([]byte("Enter123!"), bcrypt.MinCost)
+		assert.NoError(t, err)
+
+		valid, err := hasher.Verify(string(hash), "Enter123!")
+		assert.NoError(t, err)
+		assert.True(t, valid)
+
+		valid, err = hasher.Verify(string(hash), "Enter123?")
+		assert.NoError(t, err)
+		assert.False(t, valid)
+
+		assert.True(t, hasher.NeedsRehash(string(hash)))
+	})
+
+	t.Run("hashes made with other parameters need a rehash", func(t *testing.T) {
+		weakHasher := handler.NewArgon2idHasher(handler.Argon2idParams{Memory: 8 * 1024, Iterations: 1})
+
+		hash, err := weakHasher.Hash("Enter123!")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$"))
+
+		valid, err := hasher.Verify(hash, "Enter123!")
+		assert.NoError(t, err)
+		assert.True(t, valid)
+		assert.True(t, hasher.NeedsRehash(hash))
+	})
+
+	t.Run("malformed hashes are not valid", func(t *testing.T) {
+		for _, hash := range []string{
+			"",
+			"plain-text",
+			"$argon2i$v=19$m=19456,t=2,p=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5",
+			"$argon2id$v=16$m=19456,t=2,p=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5",
+			"$argon2id$v=19$m=0,t=2,p=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5",
+			"$argon2id$v=19$m=19456,t=2,p=1$not base64!$a2V5a2V5a2V5",
+			"$2a$10$short",
+		} {
+			valid, err := hasher.Verify(hash, "Enter123!")
+			assert.Error(t, err, hash)
+			assert.False(t, valid, hash)
+			assert.True(t, hasher.NeedsRehash(hash), hash)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// minPasswordPepperSize is the minimum length of a pepper key, in bytes
+const minPasswordPepperSize = 32
+
+// pepperedHashPrefix starts the hashes of peppered passwords, followed by the pepper id & the inner hash,
+// e.g. $hmac-sha256$k=2024-01$argon2id$v=19$...
+const pepperedHashPrefix = "$hmac-sha256$k="
+
+var errPasswordPepperRetired = errors.New("password pepper of the hash is no longer configured")
+
+// errPasswordResetRequired answers the logged in users whose password cannot be checked anymore
+var errPasswordResetRequired = errors.New("password can no longer be checked, reset it with a code sent by sms")
+
+// PasswordPepper is a secret key kept outside of the database, mixed into every password before it is hashed.
+// a database dump alone is then not enough to crack the hashes.
+type PasswordPepper struct {
+	// ID is stored with every hash, so the pepper can be rotated
+	ID  string
+	Key []byte
+}
+
+// PepperedHasher applies an HMAC of the current pepper to the passwords before hashing them with the inner hasher.
+// hashes of the previous peppers & unpeppered hashes are still verified, and need a rehash.
+type PepperedHasher struct {
+	hasher  PasswordHasher
+	current PasswordPepper
+	peppers map[string]PasswordPepper
+}
+
+type NewPepperedHasherOptions struct {
+	// Hasher hashes the peppered passwords
+	Hasher PasswordHasher
+	// Pepper is used for the new hashes
+	Pepper PasswordPepper
+	// PreviousPeppers are kept to verify the hashes made before a rotation, until they are all rehashed
+	PreviousPeppers []PasswordPepper
+}
+
+func NewPepperedHasher(opts NewPepperedHasherOptions) (*PepperedHasher, error) {
+	if opts.Hasher == nil {
+		return nil, errors.New("password hasher is required")
+	}
+
+	h := &PepperedHasher{
+		hasher:  opts.Hasher,
+		current: opts.Pepper,
+		peppers: make(map[string]PasswordPepper),
+	}
+	for _, pepper := range append([]PasswordPepper{opts.Pepper}, opts.PreviousPeppers...) {
+		if pepper.ID == "" || strings.Contains(pepper.ID, "$") {
+			return nil, fmt.Errorf("invalid password pepper id %q", pepper.ID)
+		}
+		if len(pepper.Key) < minPasswordPepperSize {
+			return nil, fmt.Errorf("password pepper %q must be at least %d bytes", pepper.ID, minPasswordPepperSize)
+		}
+		if _, ok := h.peppers[pepper.ID]; ok {
+			return nil, fmt.Errorf("duplicate password pepper id %q", pepper.ID)
+		}
+
+		h.peppers[pepper.ID] = pepper
+	}
+
+	return h, nil
+}
+
+func (h *PepperedHasher) Hash(password string) (string, error) {
+	hash, err := h.hasher.Hash(applyPepper(h.current, password))
+	if err != nil {
+		return "", err
+	}
+
+	return pepperedHashPrefix + h.current.ID + hash, nil
+}
+
+func (h *PepperedHasher) Verify(hash, password string) (bool, error) {
+	pepperID, innerHash, ok := splitPepperedHash(hash)
+	if !ok {
+		// hashed before the pepper was configured
+		return h.hasher.Verify(hash, password)
+	}
+
+	pepper, ok := h.peppers[pepperID]
+	if !ok {
+		return false, errPasswordPepperRetired
+	}
+
+	return h.hasher.Verify(innerHash, applyPepper(pepper, password))
+}
+
+func (h *PepperedHasher) NeedsRehash(hash string) bool {
+	pepperID, innerHash, ok := splitPepperedHash(hash)
+
+	return !ok || pepperID != h.current.ID || h.hasher.NeedsRehash(innerHash)
+}
+
+// applyPepper returns the HMAC of the password with the pepper, base64 encoded to keep it printable
+func applyPepper(pepper PasswordPepper, password string) string {
+	mac := hmac.New(sha256.New, pepper.Key)
+	mac.Write([]byte(password))
+
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// splitPepperedHash returns the pepper id & the inner hash of a peppered hash
+func splitPepperedHash(hash string) (string, string, bool) {
+	if !strings.HasPrefix(hash, pepperedHashPrefix) {
+		return "", "", false
+	}
+
+	rest := strings.TrimPrefix(hash, pepperedHashPrefix)
+	i := strings.Index(rest, "$")
+	if i <= 0 {
+		return "", "", false
+	}
+
+	return rest[:i], rest[i:], true
+}
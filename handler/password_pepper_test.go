@@ -0,0 +1,97 @@
+package handler_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPepperedHasher(t *testing.T) {
+	argon2idHasher := handler.NewArgon2idHasher(handler.DefaultArgon2idParams)
+	firstPepper := handler.PasswordPepper{ID: "1", Key: bytes.Repeat([]byte{1}, 32)}
+	secondPepper := handler.PasswordPepper{ID: "2", Key: bytes.Repeat([]byte{2}, 32)}
+
+	newHasher := func(pepper handler.PasswordPepper, previousPeppers ...handler.PasswordPepper) *handler.PepperedHasher {
+		hasher, err := handler.NewPepperedHasher(handler.NewPepperedHasherOptions{
+			Hasher:          argon2idHasher,
+			Pepper:          pepper,
+			PreviousPeppers: previousPeppers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hasher
+	}
+
+	t.Run("hashes the peppered password & stores the pepper id", func(t *testing.T) {
+		hasher := newHasher(firstPepper)
+
+		hash, err := hasher.Hash("Enter123!")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(hash, "$hmac-sha256$k=1$argon2id$v=19$"))
+		assert.False(t, hasher.NeedsRehash(hash))
+
+		valid, err := hasher.Verify(hash, "Enter123!")
+		assert.NoError(t, err)
+		assert.True(t, valid)
+
+		valid, err = hasher.Verify(hash, "Enter123?")
+		assert.NoError(t, err)
+		assert.False(t, valid)
+
+		// without the pepper, the inner hash is useless
+		innerHash := strings.TrimPrefix(hash, "$hmac-sha256$k=1")
+		valid, err = argon2idHasher.Verify(innerHash, "Enter123!")
+		assert.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("verifies unpeppered hashes, which need a rehash", func(t *testing.T) {
+		hasher := newHasher(firstPepper)
+
+		hash, err := argon2idHasher.Hash("Enter123!")
+		assert.NoError(t, err)
+
+		valid, err := hasher.Verify(hash, "Enter123!")
+		assert.NoError(t, err)
+		assert.True(t, valid)
+		assert.True(t, hasher.NeedsRehash(hash))
+	})
+
+	t.Run("verifies the hashes of a previous pepper, which need a rehash", func(t *testing.T) {
+		hash, err := newHasher(firstPepper).Hash("Enter123!")
+		assert.NoError(t, err)
+
+		hasher := newHasher(secondPepper, firstPepper)
+
+		valid, err := hasher.Verify(hash, "Enter123!")
+		assert.NoError(t, err)
+		assert.True(t, valid)
+		assert.True(t, hasher.NeedsRehash(hash))
+	})
+
+	t.Run("hashes of a retired pepper cannot be verified", func(t *testing.T) {
+		hash, err := newHasher(firstPepper).Hash("Enter123!")
+		assert.NoError(t, err)
+
+		valid, err := newHasher(secondPepper).Verify(hash, "Enter123!")
+		assert.Error(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("invalid peppers", func(t *testing.T) {
+		for name, opts := range map[string]handler.NewPepperedHasherOptions{
+			"missing hasher": {Pepper: firstPepper},
+			"missing id":     {Hasher: argon2idHasher, Pepper: handler.PasswordPepper{Key: firstPepper.Key}},
+			"id with a $":    {Hasher: argon2idHasher, Pepper: handler.PasswordPepper{ID: "1$", Key: firstPepper.Key}},
+			"short key":      {Hasher: argon2idHasher, Pepper: handler.PasswordPepper{ID: "1", Key: []byte("short")}},
+			"duplicate id":   {Hasher: argon2idHasher, Pepper: firstPepper, PreviousPeppers: []handler.PasswordPepper{{ID: "1", Key: secondPepper.Key}}},
+		} {
+			_, err := handler.NewPepperedHasher(opts)
+			assert.Error(t, err, name)
+		}
+	})
+}
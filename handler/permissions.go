@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// permissionsExtension declares the permissions an operation of the api spec requires, e.g.
+//
+//	x-permissions:
+//	  - users:read
+const permissionsExtension = "x-permissions"
+
+// adminRole is granted to the back-office oauth clients, by the admin scope of their client_credentials tokens
+const adminRole = "admin"
+
+// authorizedClaimsKey keeps the claims checked by the permissions middleware in the echo context
+const authorizedClaimsKey = "authorized_claims"
+
+var (
+	errPermissionRequired    = errors.New("permission required")
+	errPermissionsNotChecked = errors.New("permissions not checked, the permissions middleware is not installed")
+)
+
+// PermissionsMiddleware enforces the permissions declared by the operations of the api spec. the requests to these
+// operations need an access token whose roles grant every permission, the other operations are left to their handler.
+func (s *Server) PermissionsMiddleware(swagger *openapi3.T) (echo.MiddlewareFunc, error) {
+	routePermissions, err := operationPermissions(swagger)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// the route is matched before the middlewares added with Use are run
+			required := routePermissions[c.Request().Method+" "+c.Path()]
+			if len(required) == 0 {
+				return next(c)
+			}
+
+			claims, err := s.validateBearerToken(c)
+			if err == nil {
+				err = s.checkPermissions(c.Request().Context(), claims, required)
+			}
+			if err != nil {
+				return permissionErrorResponse(c, err)
+			}
+
+			c.Set(authorizedClaimsKey, claims)
+
+			return next(c)
+		}
+	}, nil
+}
+
+// operationPermissions maps the echo routes of the operations, e.g. "GET /admin/users/:id", to their permissions
+func operationPermissions(swagger *openapi3.T) (map[string][]string, error) {
+	// the generated code registers the routes with the echo syntax of the path parameters
+	routeReplacer := strings.NewReplacer("{", ":", "}", "")
+
+	routePermissions := make(map[string][]string)
+	for path, pathItem := range swagger.Paths {
+		for method, operation := range pathItem.Operations() {
+			value, ok := operation.Extensions[permissionsExtension]
+			if !ok {
+				continue
+			}
+
+			values, ok := value.([]interface{})
+			if !ok || len(values) == 0 {
+				return nil, fmt.Errorf("%s of %s %s must be a list of permissions", permissionsExtension, method, path)
+			}
+
+			route := method + " " + routeReplacer.Replace(path)
+			for _, value := range values {
+				permission, ok := value.(string)
+				if !ok || permission == "" {
+					return nil, fmt.Errorf("%s of %s %s must be a list of permissions", permissionsExtension, method, path)
+				}
+
+				routePermissions[route] = append(routePermissions[route], permission)
+			}
+		}
+	}
+
+	return routePermissions, nil
+}
+
+// checkPermissions makes sure the roles of the token grant every required permission. tokens issued to an oauth
+// client for a user do not carry the roles of the user.
+func (s *Server) checkPermissions(ctx context.Context, claims *JWTCustomClaims, required []string) error {
+	roles := claims.Roles
+	if claims.ClientID != "" {
+		roles = nil
+		if claims.ID == "" && hasField(claims.Scope, adminScope) {
+			roles = []string{adminRole}
+		}
+	}
+
+	granted := make(map[string]bool)
+	if len(roles) > 0 {
+		permissions, err := s.Repository.ListRolePermissions(ctx, roles)
+		if err != nil {
+			return errors.Wrap(err, "error listing role permissions")
+		}
+
+		for _, permission := range permissions {
+			granted[permission] = true
+		}
+	}
+
+	for _, permission := range required {
+		if !granted[permission] {
+			return fmt.Errorf("%w: %s", errPermissionRequired, permission)
+		}
+	}
+
+	return nil
+}
+
+// authorizedClaims returns the claims checked by the permissions middleware. the handlers of the operations
+// requiring permissions refuse the requests without them, so a server missing the middleware lets nobody in.
+func authorizedClaims(c echo.Context) (*JWTCustomClaims, error) {
+	claims, ok := c.Get(authorizedClaimsKey).(*JWTCustomClaims)
+	if !ok {
+		return nil, errPermissionsNotChecked
+	}
+
+	return claims, nil
+}
+
+func permissionErrorResponse(c echo.Context, err error) error {
+	if errors.Is(err, errPermissionRequired) {
+		return c.JSON(http.StatusForbidden, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	for _, tokenErr := range tokenErrors {
+		if errors.Is(err, tokenErr) {
+			return c.JSON(http.StatusUnauthorized, generated.ErrorResponse{
+				Message: "not authenticated: " + tokenErr.Error(),
+			})
+		}
+	}
+
+	return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+		Message: err.Error(),
+	})
+}
@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	phoneOTPTTL = 5 * time.Minute
+	// phoneOTPMaxAttempts keeps a code from being guessed, a new code has to be requested once they are used up
+	phoneOTPMaxAttempts = 5
+	// phoneOTPResendInterval limits the sms sent to a user, as every message has a cost
+	phoneOTPResendInterval = time.Minute
+
+	phoneOTPPurposeVerification = "verification"
+	phoneOTPPurposeLogin        = "login"
+)
+
+var (
+	errSMSNotConfigured = errors.New("sms is not configured")
+	errPhoneOTPNotValid = errors.New("phone one-time code not valid")
+)
+
+var (
+	// dummyPhoneOTPHash is made at the cost of the real codes, on the first check without a code
+	dummyPhoneOTPHash     []byte
+	dummyPhoneOTPHashOnce sync.Once
+)
+
+// Sends a one-time code by SMS to the phone number of the logged in user, to verify they own it
+// (POST /users/me/phone/verify)
+func (s *Server) SendPhoneVerification(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	if s.SMSSender == nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: errSMSNotConfigured.Error(),
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if user.PhoneVerifiedAt != nil {
+		return c.JSON(http.StatusConflict, generated.ErrorResponse{
+			Message: "phone number already verified",
+		})
+	}
+
+	err = s.sendPhoneOTP(ctx, user, phoneOTPPurposeVerification)
+	if err != nil {
+		if err == repository.ErrPhoneOTPResendTooSoon {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(phoneOTPResendInterval.Seconds())))
+			return c.JSON(http.StatusTooManyRequests, generated.ErrorResponse{
+				Message: "code sent too recently",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusAccepted, generated.PhoneOTPResponse{
+		ExpiresIn: int64(phoneOTPTTL.Seconds()),
+	})
+}
+
+// Verifies the phone number of the logged in user with the code sent by SMS
+// (POST /users/me/phone/verify/confirm)
+func (s *Server) ConfirmPhoneVerification(c echo.Context) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	var payload ConfirmPhoneVerificationValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	if payload.Code == "" {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "code is required",
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	err = s.usePhoneOTP(ctx, user, phoneOTPPurposeVerification, payload.Code)
+	if err == nil {
+		// the phone number may still change between the code check & the update
+		err = s.Repository.VerifyPhoneNumber(ctx, repository.VerifyPhoneNumberInput{
+			UserID:      user.ID,
+			PhoneNumber: user.PhoneNumber,
+		})
+	}
+	if err != nil {
+		if err == errPhoneOTPNotValid || err == repository.ErrPhoneNumberChanged {
+			return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+				Message: "code not valid",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, generated.UserResponse{
+		Id:            user.ID,
+		FullName:      user.FullName,
+		PhoneNumber:   user.PhoneNumber,
+		PhoneVerified: true,
+		LoginCount:    int64(user.LoginCount),
+		LastLoginAt:   user.LastLoginAt,
+	})
+}
+
+// Sends a one-time login code by SMS, if the phone number belongs to a user & is verified
+// (POST /auth/otp)
+func (s *Server) SendLoginOTP(c echo.Context) error {
+	var payload SendLoginOTPValidator
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	if payload.PhoneNumber == "" {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "phone_number is required",
+		})
+	}
+
+	if s.SMSSender == nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: errSMSNotConfigured.Error(),
+		})
+	}
+
+	ctx := c.Request().Context()
+
+	user, err := s.Repository.GetUserByPhoneNumber(ctx, payload.PhoneNumber)
+	if err != nil && err != sql.ErrNoRows {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// unknown & unverified phone numbers get the same response after the same hashing work, so this endpoint does
+	// not tell who is registered. a failed send must not change the response either, it is only logged
+	if err == nil && user.PhoneVerifiedAt != nil {
+		err = s.sendPhoneOTP(ctx, user, phoneOTPPurposeLogin)
+		if err != nil && err != repository.ErrPhoneOTPResendTooSoon {
+			c.Logger().Errorf("failed to send a login code: %v", err)
+		}
+	} else {
+		hashDummyPhoneOTP()
+	}
+
+	return c.JSON(http.StatusAccepted, generated.PhoneOTPResponse{
+		ExpiresIn: int64(phoneOTPTTL.Seconds()),
+	})
+}
+
+// sendPhoneOTP sends a new one-time code to the phone number of the user, replacing the previous code of the
+// same purpose. repository.ErrPhoneOTPResendTooSoon is returned while the previous code is too recent.
+func (s *Server) sendPhoneOTP(ctx context.Context, user repository.UserOutput, purpose string) error {
+	if s.SMSSender == nil {
+		return errSMSNotConfigured
+	}
+
+	code, err := generatePhoneOTP()
+	if err != nil {
+		return err
+	}
+
+	// the codes only have 6 digits, a slow hash keeps them from being recovered from a database dump
+	codeHash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "error hashing code")
+	}
+
+	err = s.Repository.UpsertPhoneOTP(ctx, repository.UpsertPhoneOTPInput{
+		UserID:         user.ID,
+		Purpose:        purpose,
+		PhoneNumber:    user.PhoneNumber,
+		CodeHash:       string(codeHash),
+		ExpiresAt:      time.Now().Add(phoneOTPTTL).UTC(),
+		ResendInterval: int(phoneOTPResendInterval.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Your %s code is %s. It expires in %d minutes, do not share it with anyone.",
+		strings.ReplaceAll(purpose, "_", " "), code, int(phoneOTPTTL.Minutes()))
+
+	return errors.Wrap(s.SMSSender.SendSMS(ctx, user.PhoneNumber, message), "error sending sms")
+}
+
+// compareDummyPhoneOTP spends the time of a code check when there is no code to check against
+func compareDummyPhoneOTP(code string) {
+	dummyPhoneOTPHashOnce.Do(func() {
+		dummyPhoneOTPHash, _ = bcrypt.GenerateFromPassword([]byte("000000"), bcrypt.DefaultCost)
+	})
+
+	_ = bcrypt.CompareHashAndPassword(dummyPhoneOTPHash, []byte(code))
+}
+
+// hashDummyPhoneOTP spends the time of hashing a new code when no code is sent
+func hashDummyPhoneOTP() {
+	_, _ = bcrypt.GenerateFromPassword([]byte("000000"), bcrypt.DefaultCost)
+}
+
+// usePhoneOTP checks the code against the last code sent to the user for the purpose, and marks it as used.
+// errPhoneOTPNotValid is returned for a wrong, expired or used code, or a code sent to a previous phone number.
+func (s *Server) usePhoneOTP(ctx context.Context, user repository.UserOutput, purpose, code string) error {
+	codeHash, err := s.checkPhoneOTP(ctx, user, purpose, code)
+	if err != nil {
+		return err
+	}
+
+	return s.consumePhoneOTP(ctx, user.ID, purpose, codeHash)
+}
+
+// checkPhoneOTP checks the code like usePhoneOTP, without marking it as used, and returns the hash of the code
+// checked to pass to consumePhoneOTP. the attempt is counted all the same.
+func (s *Server) checkPhoneOTP(ctx context.Context, user repository.UserOutput, purpose, code string) (string, error) {
+	// every attempt is counted before checking the code, so the code cannot be brute forced
+	existingCode, err := s.Repository.AttemptPhoneOTP(ctx, repository.AttemptPhoneOTPInput{
+		UserID:      user.ID,
+		Purpose:     purpose,
+		MaxAttempts: phoneOTPMaxAttempts,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			compareDummyPhoneOTP(code)
+			return "", errPhoneOTPNotValid
+		}
+
+		return "", err
+	}
+
+	// the code is compared first, so a code which cannot be valid anyway takes as long to refuse
+	matches := bcrypt.CompareHashAndPassword([]byte(existingCode.CodeHash), []byte(code)) == nil
+	if !matches || !existingCode.ExpiresAt.After(time.Now()) || existingCode.PhoneNumber != user.PhoneNumber {
+		return "", errPhoneOTPNotValid
+	}
+
+	return existingCode.CodeHash, nil
+}
+
+// consumePhoneOTP marks the code checked by checkPhoneOTP as used
+func (s *Server) consumePhoneOTP(ctx context.Context, userID, purpose, codeHash string) error {
+	// consuming the exact code checked fails if it was used or replaced in the meantime
+	err := s.Repository.ConsumePhoneOTP(ctx, repository.ConsumePhoneOTPInput{
+		UserID:   userID,
+		Purpose:  purpose,
+		CodeHash: codeHash,
+	})
+	if err == repository.ErrPhoneOTPNotActive {
+		return errPhoneOTPNotValid
+	}
+
+	return err
+}
+
+// generatePhoneOTP returns a random 6 digits code
+func generatePhoneOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", errors.Wrap(err, "error generating code")
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
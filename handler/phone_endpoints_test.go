@@ -0,0 +1,794 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSMSSender keeps the sent messages instead of sending them, so tests can read the codes
+type recordingSMSSender struct {
+	phoneNumbers []string
+	messages     []string
+	err          error
+}
+
+func (s *recordingSMSSender) SendSMS(ctx context.Context, phoneNumber, message string) error {
+	s.phoneNumbers = append(s.phoneNumbers, phoneNumber)
+	s.messages = append(s.messages, message)
+
+	return s.err
+}
+
+func (s *recordingSMSSender) lastCode() string {
+	if len(s.messages) == 0 {
+		return ""
+	}
+
+	return regexp.MustCompile(`\d{6}`).FindString(s.messages[len(s.messages)-1])
+}
+
+type upsertPhoneOTPInputMatcher struct {
+	userID      string
+	purpose     string
+	phoneNumber string
+}
+
+func (m upsertPhoneOTPInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.UpsertPhoneOTPInput)
+	if !ok {
+		return false
+	}
+
+	return actualInput.UserID == m.userID &&
+		actualInput.Purpose == m.purpose &&
+		actualInput.PhoneNumber == m.phoneNumber &&
+		strings.HasPrefix(actualInput.CodeHash, "$2a$") &&
+		actualInput.ExpiresAt.After(time.Now()) &&
+		actualInput.ResendInterval == 60
+}
+
+func (m upsertPhoneOTPInputMatcher) String() string {
+	return fmt.Sprintf("{UpsertPhoneOTPInput - UserID:%s, Purpose:%s, PhoneNumber:%s}", m.userID, m.purpose, m.phoneNumber)
+}
+
+func TestLogSMSSender(t *testing.T) {
+	var out strings.Builder
+	sender := handler.NewLogSMSSender(&out)
+
+	err := sender.SendSMS(context.Background(), "+62812345678", "Your login code is 123456")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), `to=+62812345678 message="Your login code is 123456"`)
+}
+
+func TestServer_SendPhoneVerification(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          dummyJWTClaims.ID,
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+	}
+	verifiedAt := time.Now()
+	verifiedUser := user
+	verifiedUser.PhoneVerifiedAt = &verifiedAt
+
+	var upserted repository.UpsertPhoneOTPInput
+	upsertMatcher := upsertPhoneOTPInputMatcher{
+		userID:      user.ID,
+		purpose:     "verification",
+		phoneNumber: user.PhoneNumber,
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+		SMSSender  *recordingSMSSender
+	}
+	tests := []struct {
+		name           string
+		fields         fields
+		token          string
+		wantStatus     int
+		wantRetryAfter string
+		wantSent       bool
+	}{
+		{
+			name: "successfully sends a verification code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						DoAndReturn(func(_ context.Context, input repository.UpsertPhoneOTPInput) error {
+							upserted = input
+							return nil
+						})
+
+					return mockRepo
+				}(),
+				SMSSender: &recordingSMSSender{},
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusAccepted,
+			wantSent:   true,
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+				SMSSender:  &recordingSMSSender{},
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "phone number already verified",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(verifiedUser, nil)
+
+					return mockRepo
+				}(),
+				SMSSender: &recordingSMSSender{},
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "code sent too recently",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(repository.ErrPhoneOTPResendTooSoon)
+
+					return mockRepo
+				}(),
+				SMSSender: &recordingSMSSender{},
+			},
+			token:          dummyJWT,
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "60",
+		},
+		{
+			name: "failed when sending the sms",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				SMSSender: &recordingSMSSender{err: assert.AnError},
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "sms is not configured",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			token:      dummyJWT,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			opts := handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			}
+			// a nil *recordingSMSSender would not be a nil interface
+			if tt.fields.SMSSender != nil {
+				opts.SMSSender = tt.fields.SMSSender
+			}
+			s := handler.NewServer(opts)
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/users/me/phone/verify").
+				WithJWSAuth(tt.token).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			assert.Equal(t, tt.wantRetryAfter, response.Recorder.Header().Get("Retry-After"))
+			if !tt.wantSent {
+				return
+			}
+
+			var body generated.PhoneOTPResponse
+			err := response.UnmarshalBodyToObject(&body)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(300), body.ExpiresIn)
+
+			// the code sent by sms is the one stored as a hash
+			assert.Equal(t, []string{user.PhoneNumber}, tt.fields.SMSSender.phoneNumbers)
+			code := tt.fields.SMSSender.lastCode()
+			assert.Len(t, code, 6)
+			assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(upserted.CodeHash), []byte(code)))
+		})
+	}
+}
+
+func TestServer_ConfirmPhoneVerification(t *testing.T) {
+	user := repository.UserOutput{
+		ID:          dummyJWTClaims.ID,
+		FullName:    "test",
+		PhoneNumber: "+62812345678",
+	}
+
+	codeHash, _ := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.MinCost)
+	attempt := repository.AttemptPhoneOTPInput{
+		UserID:      user.ID,
+		Purpose:     "verification",
+		MaxAttempts: 5,
+	}
+	activeCode := repository.PhoneOTPOutput{
+		PhoneNumber: user.PhoneNumber,
+		CodeHash:    string(codeHash),
+		ExpiresAt:   time.Now().Add(time.Minute),
+	}
+	consume := repository.ConsumePhoneOTPInput{
+		UserID:   user.ID,
+		Purpose:  "verification",
+		CodeHash: string(codeHash),
+	}
+	verify := repository.VerifyPhoneNumberInput{
+		UserID:      user.ID,
+		PhoneNumber: user.PhoneNumber,
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		code       string
+		wantStatus int
+	}{
+		{
+			name: "successfully verifies the phone number",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(activeCode, nil)
+
+					mockRepo.EXPECT().
+						ConsumePhoneOTP(gomock.Any(), consume).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						VerifyPhoneNumber(gomock.Any(), verify).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			code:       "123456",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "code is missing",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "code not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(activeCode, nil)
+
+					return mockRepo
+				}(),
+			},
+			code:       "654321",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "no code sent or attempts exhausted",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(repository.PhoneOTPOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			code:       "123456",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "code expired",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					expiredCode := activeCode
+					expiredCode.ExpiresAt = time.Now().Add(-time.Minute)
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(expiredCode, nil)
+
+					return mockRepo
+				}(),
+			},
+			code:       "123456",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "code sent to the previous phone number",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					previousNumberCode := activeCode
+					previousNumberCode.PhoneNumber = "+62899999999"
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(previousNumberCode, nil)
+
+					return mockRepo
+				}(),
+			},
+			code:       "123456",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "code used concurrently",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(activeCode, nil)
+
+					mockRepo.EXPECT().
+						ConsumePhoneOTP(gomock.Any(), consume).
+						Return(repository.ErrPhoneOTPNotActive)
+
+					return mockRepo
+				}(),
+			},
+			code:       "123456",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "phone number changed after the code check",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(activeCode, nil)
+
+					mockRepo.EXPECT().
+						ConsumePhoneOTP(gomock.Any(), consume).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						VerifyPhoneNumber(gomock.Any(), verify).
+						Return(repository.ErrPhoneNumberChanged)
+
+					return mockRepo
+				}(),
+			},
+			code:       "123456",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed when counting the attempt",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						GetUserByID(gomock.Any(), user.ID).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), attempt).
+						Return(repository.PhoneOTPOutput{}, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			code:       "123456",
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+				SMSSender:  &recordingSMSSender{},
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/users/me/phone/verify/confirm").
+				WithJWSAuth(dummyJWT).
+				WithJsonBody(map[string]interface{}{"code": tt.code}).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body generated.UserResponse
+			err := response.UnmarshalBodyToObject(&body)
+			assert.NoError(t, err)
+			assert.True(t, body.PhoneVerified)
+		})
+	}
+}
+
+func TestServer_SendLoginOTP(t *testing.T) {
+	verifiedAt := time.Now()
+	user := repository.UserOutput{
+		ID:              "abc123-def456",
+		FullName:        "test",
+		PhoneNumber:     "+62812345678",
+		PhoneVerifiedAt: &verifiedAt,
+	}
+	unverifiedUser := user
+	unverifiedUser.PhoneVerifiedAt = nil
+
+	upsertMatcher := upsertPhoneOTPInputMatcher{
+		userID:      user.ID,
+		purpose:     "login",
+		phoneNumber: user.PhoneNumber,
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name        string
+		fields      fields
+		phoneNumber string
+		sendErr     error
+		wantStatus  int
+		wantSent    bool
+		wantLogged  bool
+	}{
+		{
+			name: "successfully sends a login code",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusAccepted,
+			wantSent:    true,
+		},
+		{
+			name: "unknown phone number gets the same response",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusAccepted,
+		},
+		{
+			name: "unverified phone number gets the same response",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(unverifiedUser, nil)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusAccepted,
+		},
+		{
+			name: "code sent too recently gets the same response",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(repository.ErrPhoneOTPResendTooSoon)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusAccepted,
+		},
+		{
+			name: "failed send gets the same response",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			sendErr:     assert.AnError,
+			wantStatus:  http.StatusAccepted,
+			wantSent:    true,
+			wantLogged:  true,
+		},
+		{
+			name: "failed code storage gets the same response",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						UpsertPhoneOTP(gomock.Any(), upsertMatcher).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusAccepted,
+			wantLogged:  true,
+		},
+		{
+			name: "phone number is missing",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed when fetching the user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(repository.UserOutput{}, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			phoneNumber: user.PhoneNumber,
+			wantStatus:  http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			var logs bytes.Buffer
+			e.Logger.SetOutput(&logs)
+			sender := &recordingSMSSender{err: tt.sendErr}
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+				SMSSender:  sender,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/auth/otp").
+				WithJsonBody(map[string]interface{}{"phone_number": tt.phoneNumber}).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			assert.Equal(t, tt.wantLogged, strings.Contains(logs.String(), "failed to send a login code"))
+			if tt.wantSent {
+				assert.Equal(t, []string{user.PhoneNumber}, sender.phoneNumbers)
+				assert.Len(t, sender.lastCode(), 6)
+			} else {
+				assert.Empty(t, sender.messages)
+			}
+		})
+	}
+}
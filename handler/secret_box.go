@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// SecretBox encrypts the secrets stored in the database with AES-256-GCM, so a database dump alone does not reveal them
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a secret box from a 32 bytes key
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret box key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cipher")
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts the plaintext. the additional data (e.g. the owner id) is authenticated but not stored,
+// so a sealed secret copied to another row cannot be opened.
+func (b *SecretBox) Seal(plaintext, additionalData []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "error generating nonce")
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, plaintext, additionalData)
+
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed with the same additional data
+func (b *SecretBox) Open(sealed string, additionalData []byte) ([]byte, error) {
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding sealed secret")
+	}
+
+	nonceSize := b.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("sealed secret is too short")
+	}
+
+	plaintext, err := b.aead.Open(nil, data[:nonceSize], data[nonceSize:], additionalData)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening sealed secret")
+	}
+
+	return plaintext, nil
+}
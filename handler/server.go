@@ -1,20 +1,168 @@
 package handler
 
-import "github.com/SawitProRecruitment/UserService/repository"
+import (
+	"sync"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+)
+
+const (
+	defaultAccessTokenTTL      = 15 * time.Minute
+	defaultRefreshTokenTTL     = 30 * 24 * time.Hour
+	defaultTOTPIssuer          = "User Service"
+	defaultWebAuthnRPName      = "User Service"
+	defaultPasswordHistorySize = 5
+
+	defaultLoginAttemptWindow    = 15 * time.Minute
+	defaultLoginLockoutThreshold = 5
+	defaultLoginLockoutDuration  = 15 * time.Minute
+	defaultLoginIPThreshold      = 50
+
+	defaultAccountDeletionGracePeriod = 30 * 24 * time.Hour
+	defaultDataExportTTL              = 24 * time.Hour
+)
 
 type Server struct {
-	Repository repository.RepositoryInterface
-	JWT        JWT
+	Repository              repository.RepositoryInterface
+	JWT                     *JWT
+	AccessTokenTTL          time.Duration
+	RefreshTokenTTL         time.Duration
+	SecretBox               *SecretBox
+	TOTPIssuer              string
+	WebAuthnRPID            string
+	WebAuthnRPName          string
+	WebAuthnOrigins         []string
+	SMSSender               SMSSender
+	PasswordHistorySize     int
+	LoginAttempts           LoginAttemptStore
+	LoginAttemptWindow      time.Duration
+	LoginLockoutThreshold   int
+	LoginLockoutDuration    time.Duration
+	LoginIPThreshold        int
+	RegistrationPrivacyMode bool
+	PasswordHasher          PasswordHasher
+	BreachedPasswords       BreachedPasswordChecker
+	// AccountDeletionGracePeriod is how long the personal data of a deleted account is kept before being erased
+	AccountDeletionGracePeriod time.Duration
+	// DataExportTTL is how long the archive of a data export can be downloaded once built
+	DataExportTTL time.Duration
+
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
 }
 
 type NewServerOptions struct {
 	Repository repository.RepositoryInterface
-	JWT        JWT
+	JWT        *JWT
+	// AccessTokenTTL & RefreshTokenTTL default to 15 minutes & 30 days when left empty
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// SecretBox encrypts the totp secrets, two-factor authentication is unavailable without it
+	SecretBox *SecretBox
+	// TOTPIssuer is the name shown in authenticator apps, defaults to "User Service"
+	TOTPIssuer string
+	// WebAuthnRPID & WebAuthnOrigins default to the host & the origin of the jwt issuer, passkeys are bound to them
+	WebAuthnRPID    string
+	WebAuthnOrigins []string
+	// WebAuthnRPName is the name shown by passkey managers, defaults to "User Service"
+	WebAuthnRPName string
+	// SMSSender delivers the one-time codes, phone verification & login by SMS are unavailable without it
+	SMSSender SMSSender
+	// PasswordHistorySize is the number of recent passwords, the current one included, which cannot be set again. defaults to 5
+	PasswordHistorySize int
+	// LoginAttempts keeps the failed login counters, defaults to an in-memory store
+	LoginAttempts LoginAttemptStore
+	// LoginAttemptWindow is how long a failed login is counted, and how long a client ip stays blocked. defaults to 15 minutes
+	LoginAttemptWindow time.Duration
+	// LoginLockoutThreshold is the number of failed logins which locks an account for LoginLockoutDuration. defaults to 5 & 15 minutes
+	LoginLockoutThreshold int
+	LoginLockoutDuration  time.Duration
+	// LoginIPThreshold is the number of failed logins, of any account, which blocks a client ip. defaults to 50
+	LoginIPThreshold int
+	// RegistrationPrivacyMode answers the registration of a phone number already registered like a new one, and
+	// notifies the owner of the number by SMS instead, so registration does not tell who is registered
+	RegistrationPrivacyMode bool
+	// PasswordHasher hashes the passwords, defaults to argon2id with DefaultArgon2idParams
+	PasswordHasher PasswordHasher
+	// BreachedPasswords refuses the new passwords known from a data breach, they are not checked without it
+	BreachedPasswords BreachedPasswordChecker
+	// AccountDeletionGracePeriod is how long the personal data of a deleted account is kept before being erased.
+	// defaults to 30 days
+	AccountDeletionGracePeriod time.Duration
+	// DataExportTTL is how long the archive of a data export can be downloaded once built, defaults to 24 hours
+	DataExportTTL time.Duration
 }
 
 func NewServer(opts NewServerOptions) *Server {
-	return &Server{
-		Repository: opts.Repository,
-		JWT:        opts.JWT,
+	s := &Server{
+		Repository:              opts.Repository,
+		JWT:                     opts.JWT,
+		AccessTokenTTL:          opts.AccessTokenTTL,
+		RefreshTokenTTL:         opts.RefreshTokenTTL,
+		SecretBox:               opts.SecretBox,
+		TOTPIssuer:              opts.TOTPIssuer,
+		WebAuthnRPID:            opts.WebAuthnRPID,
+		WebAuthnRPName:          opts.WebAuthnRPName,
+		WebAuthnOrigins:         opts.WebAuthnOrigins,
+		SMSSender:               opts.SMSSender,
+		PasswordHistorySize:     opts.PasswordHistorySize,
+		LoginAttempts:           opts.LoginAttempts,
+		LoginAttemptWindow:      opts.LoginAttemptWindow,
+		LoginLockoutThreshold:   opts.LoginLockoutThreshold,
+		LoginLockoutDuration:    opts.LoginLockoutDuration,
+		LoginIPThreshold:        opts.LoginIPThreshold,
+		RegistrationPrivacyMode: opts.RegistrationPrivacyMode,
+		PasswordHasher:          opts.PasswordHasher,
+		BreachedPasswords:       opts.BreachedPasswords,
+
+		AccountDeletionGracePeriod: opts.AccountDeletionGracePeriod,
+		DataExportTTL:              opts.DataExportTTL,
+	}
+
+	if s.AccessTokenTTL <= 0 {
+		s.AccessTokenTTL = defaultAccessTokenTTL
+	}
+	if s.RefreshTokenTTL <= 0 {
+		s.RefreshTokenTTL = defaultRefreshTokenTTL
+	}
+	if s.TOTPIssuer == "" {
+		s.TOTPIssuer = defaultTOTPIssuer
 	}
+	if s.WebAuthnRPName == "" {
+		s.WebAuthnRPName = defaultWebAuthnRPName
+	}
+	if s.PasswordHistorySize <= 0 {
+		s.PasswordHistorySize = defaultPasswordHistorySize
+	}
+	if s.LoginAttemptWindow <= 0 {
+		s.LoginAttemptWindow = defaultLoginAttemptWindow
+	}
+	if s.LoginLockoutThreshold <= 0 {
+		s.LoginLockoutThreshold = defaultLoginLockoutThreshold
+	}
+	if s.LoginLockoutDuration <= 0 {
+		s.LoginLockoutDuration = defaultLoginLockoutDuration
+	}
+	if s.LoginIPThreshold <= 0 {
+		s.LoginIPThreshold = defaultLoginIPThreshold
+	}
+	if s.PasswordHasher == nil {
+		s.PasswordHasher = NewArgon2idHasher(DefaultArgon2idParams)
+	}
+	if s.AccountDeletionGracePeriod <= 0 {
+		s.AccountDeletionGracePeriod = defaultAccountDeletionGracePeriod
+	}
+	if s.DataExportTTL <= 0 {
+		s.DataExportTTL = defaultDataExportTTL
+	}
+	if s.LoginAttempts == nil {
+		// the counter of an unknown phone number is its lock, it is kept as long as the lock lasts
+		s.LoginAttempts = NewMemoryLoginAttemptStore(NewMemoryLoginAttemptStoreOptions{
+			Window:    s.LoginAttemptWindow,
+			Retention: s.LoginLockoutDuration,
+		})
+	}
+
+	return s
 }
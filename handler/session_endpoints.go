@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// the page size of the login history
+const (
+	defaultLoginHistoryLimit = 20
+	maxLoginHistoryLimit     = 100
+)
+
+// Lists the login history of the logged in user, latest first
+// (GET /users/me/logins)
+func (s *Server) ListLogins(c echo.Context, params generated.ListLoginsParams) error {
+	id, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	limit := defaultLoginHistoryLimit
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if limit < 1 || limit > maxLoginHistoryLimit {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "limit must be between 1 and 100",
+		})
+	}
+
+	// the cursor is the id of the last event of the previous page
+	var beforeID int64
+	if params.Cursor != nil {
+		beforeID, err = strconv.ParseInt(*params.Cursor, 10, 64)
+		if err != nil || beforeID < 1 {
+			return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+				Message: "cursor not valid",
+			})
+		}
+	}
+
+	// one more event than the page tells whether there is a next page
+	events, err := s.Repository.ListLoginEvents(c.Request().Context(), repository.ListLoginEventsInput{
+		UserID:   id,
+		BeforeID: beforeID,
+		Limit:    limit + 1,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	response := generated.LoginHistoryResponse{
+		Logins: []generated.LoginEvent{},
+	}
+	if len(events) > limit {
+		events = events[:limit]
+		nextCursor := strconv.FormatInt(events[limit-1].ID, 10)
+		response.NextCursor = &nextCursor
+	}
+
+	for _, event := range events {
+		response.Logins = append(response.Logins, loginEventResponse(event))
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func loginEventResponse(event repository.LoginEventOutput) generated.LoginEvent {
+	login := generated.LoginEvent{
+		Method:    event.Method,
+		IpAddress: event.IPAddress,
+		UserAgent: event.UserAgent,
+		Succeeded: event.Succeeded,
+		SessionId: event.SessionID,
+		CreatedAt: event.CreatedAt,
+	}
+	if event.FailureReason != "" {
+		failureReason := event.FailureReason
+		login.FailureReason = &failureReason
+	}
+
+	return login
+}
+
+// Lists the active sessions of the logged in user, the ones which can still be refreshed
+// (GET /users/me/sessions)
+func (s *Server) ListSessions(c echo.Context) error {
+	claims, err := s.validateLoggedInClaims(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	sessions, err := s.Repository.ListUserSessions(c.Request().Context(), claims.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	response := generated.SessionsResponse{
+		Sessions: []generated.Session{},
+	}
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, sessionResponse(session, claims.SessionID))
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// sessionResponse returns the session, flagged as current when it is the one of the request
+func sessionResponse(session repository.SessionOutput, currentSessionID string) generated.Session {
+	return generated.Session{
+		Id:         session.ID,
+		ClientId:   session.ClientID,
+		IpAddress:  session.IPAddress,
+		UserAgent:  session.UserAgent,
+		Current:    session.ID == currentSessionID,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastUsedAt,
+		ExpiresAt:  session.ExpiresAt,
+	}
+}
+
+// Logs a session of the logged in user out, revoking its access tokens & refresh tokens
+// (DELETE /users/me/sessions/{id})
+func (s *Server) RevokeSession(c echo.Context, id string) error {
+	userID, err := s.ValidateLoggedInUser(c)
+	if err != nil {
+		return notLoggedInResponse(c, err)
+	}
+
+	// sessions are identified by a uuid, anything else cannot be one of them
+	if _, err := uuid.Parse(id); err != nil {
+		return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+			Message: "session not found",
+		})
+	}
+
+	// the access tokens of the session are rejected along with its refresh tokens, as they carry its id
+	err = s.Repository.RevokeUserSession(c.Request().Context(), repository.RevokeUserSessionInput{
+		UserID:    userID,
+		SessionID: id,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, generated.ErrorResponse{
+				Message: "session not found",
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
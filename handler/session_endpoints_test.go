@@ -0,0 +1,449 @@
+package handler_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_ListLogins(t *testing.T) {
+	userID := dummyJWTClaims.ID
+	sessionID := dummyJWTClaims.SessionID
+
+	events := []repository.LoginEventOutput{
+		{ID: 42, SessionID: &sessionID, Method: "password", IPAddress: "192.0.2.1", UserAgent: "curl/8.0", Succeeded: true, CreatedAt: time.Now()},
+		{ID: 41, Method: "password", IPAddress: "192.0.2.1", UserAgent: "curl/8.0", FailureReason: "invalid_credentials", CreatedAt: time.Now().Add(-time.Minute)},
+		{ID: 40, Method: "otp", IPAddress: "198.51.100.7", Succeeded: true, CreatedAt: time.Now().Add(-time.Hour)},
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name           string
+		fields         fields
+		query          string
+		authorized     bool
+		wantStatus     int
+		wantLogins     int
+		wantNextCursor *string
+	}{
+		{
+			name: "successfully lists the latest logins",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListLoginEvents(gomock.Any(), repository.ListLoginEventsInput{UserID: userID, Limit: 21}).
+						Return(events, nil)
+
+					return mockRepo
+				}(),
+			},
+			authorized: true,
+			wantStatus: http.StatusOK,
+			wantLogins: 3,
+		},
+		{
+			name: "returns a cursor when there are more logins",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListLoginEvents(gomock.Any(), repository.ListLoginEventsInput{UserID: userID, Limit: 3}).
+						Return(events, nil)
+
+					return mockRepo
+				}(),
+			},
+			query:          "?limit=2",
+			authorized:     true,
+			wantStatus:     http.StatusOK,
+			wantLogins:     2,
+			wantNextCursor: func() *string { cursor := "41"; return &cursor }(),
+		},
+		{
+			name: "lists the logins before the cursor",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListLoginEvents(gomock.Any(), repository.ListLoginEventsInput{UserID: userID, BeforeID: 41, Limit: 3}).
+						Return(events[2:], nil)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?limit=2&cursor=41",
+			authorized: true,
+			wantStatus: http.StatusOK,
+			wantLogins: 1,
+		},
+		{
+			name: "limit out of range",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?limit=101",
+			authorized: true,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "cursor not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			query:      "?cursor=abc",
+			authorized: true,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "failed when listing the logins",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListLoginEvents(gomock.Any(), gomock.Any()).
+						Return(nil, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			authorized: true,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			req := testutil.NewRequest().Get("/users/me/logins" + tt.query).
+				WithAcceptJson()
+			if tt.authorized {
+				req = req.WithHeader("Authorization", fmt.Sprintf("Bearer %s", dummyJWT))
+			}
+
+			response := req.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var history generated.LoginHistoryResponse
+			err := response.UnmarshalBodyToObject(&history)
+			assert.NoError(t, err)
+			assert.Len(t, history.Logins, tt.wantLogins)
+			assert.Equal(t, tt.wantNextCursor, history.NextCursor)
+		})
+	}
+}
+
+func TestServer_ListSessions(t *testing.T) {
+	userID := dummyJWTClaims.ID
+	clientID := "mobile-app"
+
+	sessions := []repository.SessionOutput{
+		{ID: dummyJWTClaims.SessionID, IPAddress: "192.0.2.1", UserAgent: "curl/8.0", CreatedAt: time.Now(), LastUsedAt: time.Now(), ExpiresAt: time.Now().Add(24 * time.Hour)},
+		{ID: "9d4a7f0e-3b2c-4e1d-8f6a-5c0b9e8d7a61", ClientID: &clientID, IPAddress: "198.51.100.7", CreatedAt: time.Now().Add(-time.Hour), LastUsedAt: time.Now(), ExpiresAt: time.Now().Add(24 * time.Hour)},
+	}
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name        string
+		fields      fields
+		authorized  bool
+		wantStatus  int
+		wantCurrent []bool
+	}{
+		{
+			name: "successfully lists the sessions & flags the current one",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListUserSessions(gomock.Any(), userID).
+						Return(sessions, nil)
+
+					return mockRepo
+				}(),
+			},
+			authorized:  true,
+			wantStatus:  http.StatusOK,
+			wantCurrent: []bool{true, false},
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "failed when listing the sessions",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						ListUserSessions(gomock.Any(), userID).
+						Return(nil, assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			authorized: true,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			req := testutil.NewRequest().Get("/users/me/sessions").
+				WithAcceptJson()
+			if tt.authorized {
+				req = req.WithHeader("Authorization", fmt.Sprintf("Bearer %s", dummyJWT))
+			}
+
+			response := req.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body generated.SessionsResponse
+			err := response.UnmarshalBodyToObject(&body)
+			assert.NoError(t, err)
+
+			current := []bool{}
+			for _, session := range body.Sessions {
+				current = append(current, session.Current)
+			}
+			assert.Equal(t, tt.wantCurrent, current)
+		})
+	}
+}
+
+func TestServer_RevokeSession(t *testing.T) {
+	userID := dummyJWTClaims.ID
+	sessionID := "9d4a7f0e-3b2c-4e1d-8f6a-5c0b9e8d7a61"
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		sessionID  string
+		authorized bool
+		wantStatus int
+	}{
+		{
+			name: "successfully revokes a session",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						RevokeUserSession(gomock.Any(), repository.RevokeUserSessionInput{UserID: userID, SessionID: sessionID}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+			},
+			sessionID:  sessionID,
+			authorized: true,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "session of another user or already revoked",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						RevokeUserSession(gomock.Any(), repository.RevokeUserSessionInput{UserID: userID, SessionID: sessionID}).
+						Return(sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+			},
+			sessionID:  sessionID,
+			authorized: true,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "session id not valid",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					return mockRepo
+				}(),
+			},
+			sessionID:  "not-a-session",
+			authorized: true,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "user is not logged in",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+			},
+			sessionID:  sessionID,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "failed when revoking the session",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
+					mockRepo.EXPECT().
+						RevokeUserSession(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+			},
+			sessionID:  sessionID,
+			authorized: true,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: tt.fields.Repository,
+				JWT:        newFixtureJWT(),
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			req := testutil.NewRequest().Delete("/users/me/sessions/" + tt.sessionID)
+			if tt.authorized {
+				req = req.WithHeader("Authorization", fmt.Sprintf("Bearer %s", dummyJWT))
+			}
+
+			response := req.GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+		})
+	}
+}
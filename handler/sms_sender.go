@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log"
+)
+
+// SMSSender delivers text messages, the implementation depends on the SMS gateway in use
+type SMSSender interface {
+	SendSMS(ctx context.Context, phoneNumber, message string) error
+}
+
+// LogSMSSender writes the messages to a log instead of sending them, for development & testing
+type LogSMSSender struct {
+	logger *log.Logger
+}
+
+// NewLogSMSSender creates a sender logging every message to w, e.g. os.Stdout or a file
+func NewLogSMSSender(w io.Writer) *LogSMSSender {
+	return &LogSMSSender{
+		logger: log.New(w, "sms: ", log.LstdFlags|log.LUTC),
+	}
+}
+
+func (s *LogSMSSender) SendSMS(ctx context.Context, phoneNumber, message string) error {
+	s.logger.Printf("to=%s message=%q", phoneNumber, message)
+
+	return nil
+}
@@ -1,13 +1,13 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/SawitProRecruitment/UserService/repository"
 	"github.com/golang-jwt/jwt/v5"
@@ -36,6 +36,28 @@ func (s *Server) RegisterUser(c echo.Context) error {
 
 	ctx := c.Request().Context()
 
+	fieldErrors, err := s.breachedPasswordFieldErrors(ctx, "Password", payload.Password)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if len(fieldErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message:          "field validation failed",
+			ValidationErrors: (*[]generated.FieldError)(&fieldErrors),
+		})
+	}
+
+	// generate password. it is hashed before the phone number is looked up, so a registered number is not
+	// answered faster than a new one
+	hashedPassword, err := s.PasswordHasher.Hash(payload.Password)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
 	// check if phone number already exists in DB
 	existingUser, err := s.Repository.GetUserByPhoneNumber(ctx, payload.PhoneNumber)
 	if err != nil && err != sql.ErrNoRows {
@@ -44,19 +66,21 @@ func (s *Server) RegisterUser(c echo.Context) error {
 		})
 	}
 	if existingUser.ID != "" {
+		if s.RegistrationPrivacyMode {
+			return s.registrationAttemptResponse(c, existingUser, payload)
+		}
+
 		return c.JSON(http.StatusConflict, generated.ErrorResponse{
 			Message: "phone number already registered",
 		})
 	}
 
-	// generate password
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
 	id := uuid.NewString()
 	userInput := repository.CreateUserInput{
 		ID:             id,
 		FullName:       payload.FullName,
 		PhoneNumber:    payload.PhoneNumber,
-		HashedPassword: string(hashedPassword),
+		HashedPassword: hashedPassword,
 	}
 
 	// save
@@ -74,6 +98,35 @@ func (s *Server) RegisterUser(c echo.Context) error {
 	})
 }
 
+// registrationAttemptMessage is sent to a registered user when their phone number is registered again in privacy mode
+const registrationAttemptMessage = "Someone tried to register a new account with this phone number. " +
+	"If it was you, log in or reset your password instead."
+
+// registrationAttemptResponse answers the registration of a phone number already registered like a successful
+// registration, so the endpoint does not tell who is registered. the owner of the number is told by SMS instead.
+func (s *Server) registrationAttemptResponse(c echo.Context, existingUser repository.UserOutput, payload RegisterUserValidator) error {
+	if s.SMSSender != nil {
+		// a failed notification must not change the response either
+		_ = s.SMSSender.SendSMS(c.Request().Context(), existingUser.PhoneNumber, registrationAttemptMessage)
+	}
+
+	return c.JSON(http.StatusCreated, generated.UserResponse{
+		Id:          uuid.NewString(),
+		FullName:    payload.FullName,
+		PhoneNumber: payload.PhoneNumber,
+	})
+}
+
+// checkDummyPassword spends the time of a password check when there is no user to check against. the dummy
+// hash is made by the current hasher, so it costs as much as the up to date hashes of the users.
+func (s *Server) checkDummyPassword(password string) {
+	s.dummyPasswordHashOnce.Do(func() {
+		s.dummyPasswordHash, _ = s.PasswordHasher.Hash(uuid.NewString())
+	})
+
+	_, _ = s.PasswordHasher.Verify(s.dummyPasswordHash, password)
+}
+
 // Logs a user in to the system & return the logged in user ID & generated jwt token
 // (POST /auth)
 func (s *Server) AuthenticateUser(c echo.Context) error {
@@ -84,72 +137,299 @@ func (s *Server) AuthenticateUser(c echo.Context) error {
 		})
 	}
 
+	if (payload.Password == "") == (payload.OTP == "") {
+		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+			Message: "either password or otp is required",
+		})
+	}
+
 	ctx := c.Request().Context()
 
+	// failed logins are counted per account & per client ip, so passwords cannot be guessed at full speed
+	attempt := loginAttempt{IP: c.RealIP(), PhoneNumber: payload.PhoneNumber}
+	block, err := s.checkLoginThrottle(ctx, attempt)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if block != nil {
+		return loginBlockedResponse(c, block)
+	}
+
 	// check for user
 	existingUser, err := s.Repository.GetUserByPhoneNumber(ctx, payload.PhoneNumber)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
-				Message: "user not valid",
+			// an unknown phone number takes as long to refuse as a wrong password or code
+			if payload.OTP != "" {
+				compareDummyPhoneOTP(payload.OTP)
+			} else {
+				s.checkDummyPassword(payload.Password)
+			}
+			return s.failedLoginResponse(c, attempt, nil, "", "")
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	method := loginMethodPassword
+	if payload.OTP != "" {
+		method = loginMethodOTP
+	}
+
+	if block := accountLockBlock(existingUser); block != nil {
+		err = s.recordFailedLogin(c, existingUser.ID, method, loginFailureAccountLocked)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+				Message: err.Error(),
 			})
 		}
 
+		return loginBlockedResponse(c, block)
+	}
+
+	// check password correctness, or the one-time code sent by sms for a passwordless login
+	var valid bool
+	failureReason := loginFailureInvalidCredentials
+	if payload.OTP != "" {
+		err = s.usePhoneOTP(ctx, existingUser, phoneOTPPurposeLogin, payload.OTP)
+		valid = err == nil
+		if err == errPhoneOTPNotValid {
+			err = nil
+		}
+	} else {
+		valid, err = s.verifyPassword(ctx, existingUser, payload.Password)
+		// the hash cannot be checked anymore, the login is refused like a wrong password until the password is reset
+		if err == errPasswordPepperRetired {
+			err = nil
+			failureReason = loginFailurePasswordResetRequired
+		}
+	}
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
 			Message: err.Error(),
 		})
 	}
+	if !valid {
+		return s.failedLoginResponse(c, attempt, &existingUser, method, failureReason)
+	}
 
-	// check password correctness
-	err = bcrypt.CompareHashAndPassword([]byte(existingUser.HashedPassword), []byte(payload.Password))
+	err = s.recordLoginSuccess(ctx, attempt)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
-			Message: "user not valid",
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
 		})
 	}
 
-	// password correct: return
-	token, err := s.GenerateJWT(existingUser)
+	if existingUser.SuspendedAt != nil {
+		return s.suspendedLoginResponse(c, existingUser, method)
+	}
+
+	// credentials correct: users enrolled in two-factor authentication still need their second factor
+	enrolled, err := s.isMFAEnrolled(ctx, existingUser.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
 			Message: err.Error(),
 		})
 	}
+	if enrolled {
+		return s.mfaChallengeResponse(c, existingUser)
+	}
+
+	return s.loginResponse(c, existingUser, method)
+}
 
-	err = s.Repository.IncrementLoginCount(ctx, existingUser.ID)
+// failedLoginResponse counts a failed login, and responds with the lock of the account once it is locked.
+// the failures of a known user are added to their login history with the reason.
+func (s *Server) failedLoginResponse(c echo.Context, attempt loginAttempt, user *repository.UserOutput, method, reason string) error {
+	if user != nil {
+		err := s.recordFailedLogin(c, user.ID, method, reason)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+	}
+
+	block, err := s.recordLoginFailure(c.Request().Context(), attempt, user)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
 			Message: err.Error(),
 		})
 	}
+	if block != nil {
+		return loginBlockedResponse(c, block)
+	}
 
-	return c.JSON(http.StatusOK, generated.AuthenticateUserResponse{
-		Id:    existingUser.ID,
-		Token: token,
+	return c.JSON(http.StatusBadRequest, generated.ErrorResponse{
+		Message: "user not valid",
 	})
 }
 
-func (s *Server) GenerateJWT(user repository.UserOutput) (string, error) {
+// the methods a user logs in with, recorded in their login history
+const (
+	loginMethodPassword  = "password"
+	loginMethodOTP       = "otp"
+	loginMethodMFA       = "mfa"
+	loginMethodPasskey   = "passkey"
+	loginMethodAuthorize = "authorize"
+	// the session issued along with a new password, the old ones being revoked
+	loginMethodPasswordChange = "password_change"
+)
+
+// the reasons a login of a known user is refused, recorded in their login history
+const (
+	loginFailureInvalidCredentials = "invalid_credentials"
+	loginFailureAccountLocked      = "account_locked"
+	loginFailureInvalidMFACode     = "invalid_mfa_code"
+	loginFailureInvalidPasskey     = "invalid_passkey"
+	loginFailureAccountSuspended   = "account_suspended"
+	// the pepper of the password hash was retired, the password cannot be checked until it is reset
+	loginFailurePasswordResetRequired = "password_reset_required"
+)
+
+// loginResponse completes the login of an authenticated user, starting a new session
+func (s *Server) loginResponse(c echo.Context, user repository.UserOutput, method string) error {
+	if user.SuspendedAt != nil {
+		return s.suspendedLoginResponse(c, user, method)
+	}
+
+	ctx := c.Request().Context()
+
+	session, sessionID, err := s.startSession(ctx, user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// the login count & the last login time of the user are updated along with their history
+	err = s.Repository.CreateLoginEvent(ctx, repository.CreateLoginEventInput{
+		UserID:    user.ID,
+		SessionID: &sessionID,
+		Method:    method,
+		IPAddress: c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Succeeded: true,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, session)
+}
+
+// startSession issues the tokens of a new session of the user, and returns them along with the session id
+func (s *Server) startSession(ctx context.Context, user repository.UserOutput) (generated.AuthenticateUserResponse, string, error) {
+	// every session is identified by its refresh token family
+	sessionID := uuid.NewString()
+	token, err := s.GenerateJWT(user, sessionID)
+	if err != nil {
+		return generated.AuthenticateUserResponse{}, "", err
+	}
+
+	refreshToken, err := s.createRefreshToken(ctx, user.ID, tokenGrant{SessionID: sessionID})
+	if err != nil {
+		return generated.AuthenticateUserResponse{}, "", err
+	}
+
+	return generated.AuthenticateUserResponse{
+		Id:           user.ID,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.AccessTokenTTL.Seconds()),
+	}, sessionID, nil
+}
+
+// suspendedLoginResponse refuses the login of a suspended user. it is only checked along with the credentials, so
+// the suspension is not disclosed to whoever tries a phone number.
+func (s *Server) suspendedLoginResponse(c echo.Context, user repository.UserOutput, method string) error {
+	err := s.recordFailedLogin(c, user.ID, method, loginFailureAccountSuspended)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusForbidden, generated.ErrorResponse{
+		Message: "user suspended",
+	})
+}
+
+// recordFailedLogin adds a refused login of a known user to their login history
+func (s *Server) recordFailedLogin(c echo.Context, userID, method, reason string) error {
+	return s.Repository.CreateLoginEvent(c.Request().Context(), repository.CreateLoginEventInput{
+		UserID:        userID,
+		Method:        method,
+		IPAddress:     c.RealIP(),
+		UserAgent:     c.Request().UserAgent(),
+		FailureReason: reason,
+	})
+}
+
+func (s *Server) GenerateJWT(user repository.UserOutput, sessionID string) (string, error) {
+	return s.generateAccessToken(user, tokenGrant{SessionID: sessionID})
+}
+
+// tokenGrant describes what an access token & its refresh tokens are issued for
+type tokenGrant struct {
+	// SessionID is the refresh token family
+	SessionID string
+	// ClientID & Scope are only set for tokens issued to oauth clients
+	ClientID string
+	Scope    string
+}
+
+func (s *Server) generateAccessToken(user repository.UserOutput, grant tokenGrant) (string, error) {
 	claims := JWTCustomClaims{
-		ID:          user.ID,
-		FullName:    user.FullName,
-		PhoneNumber: user.PhoneNumber,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(72 * time.Hour)),
-		},
+		ID:               user.ID,
+		SessionID:        grant.SessionID,
+		TokenVersion:     user.TokenVersion,
+		ClientID:         grant.ClientID,
+		Scope:            grant.Scope,
+		RegisteredClaims: s.registeredClaims(user.ID),
+	}
+
+	// first party tokens carry the whole profile & the roles, tokens issued to oauth clients only what their scope allows
+	if grant.ClientID == "" {
+		claims.Roles = user.Roles
+	}
+	if grant.ClientID == "" || hasField(grant.Scope, "profile") {
+		claims.FullName = user.FullName
+	}
+	if grant.ClientID == "" || hasField(grant.Scope, "phone") {
+		claims.PhoneNumber = user.PhoneNumber
 	}
 
 	return s.JWT.CreateToken(claims)
 }
 
+func (s *Server) registeredClaims(subject string) jwt.RegisteredClaims {
+	now := time.Now()
+
+	return jwt.RegisteredClaims{
+		// the jti is the key used to revoke this single token
+		ID:        uuid.NewString(),
+		Subject:   subject,
+		Issuer:    s.JWT.Issuer(),
+		Audience:  jwt.ClaimStrings{s.JWT.Audience()},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(s.AccessTokenTTL)),
+	}
+}
+
 // Get the logged in user info
 // (GET /me)
 func (s *Server) GetLoggedInUser(c echo.Context) error {
 	id, err := s.ValidateLoggedInUser(c)
 	if err != nil {
-		return c.JSON(http.StatusForbidden, generated.ErrorResponse{
-			Message: "not logged in",
-		})
+		return notLoggedInResponse(c, err)
 	}
 
 	user, err := s.Repository.GetUserByID(c.Request().Context(), id)
@@ -160,25 +440,98 @@ func (s *Server) GetLoggedInUser(c echo.Context) error {
 	}
 
 	return c.JSON(http.StatusOK, generated.UserResponse{
-		Id:          user.ID,
-		FullName:    user.FullName,
-		PhoneNumber: user.PhoneNumber,
-		LoginCount:  int64(user.LoginCount),
+		Id:            user.ID,
+		FullName:      user.FullName,
+		PhoneNumber:   user.PhoneNumber,
+		PhoneVerified: user.PhoneVerifiedAt != nil,
+		LoginCount:    int64(user.LoginCount),
+		LastLoginAt:   user.LastLoginAt,
 	})
 }
 
 func (s *Server) ValidateLoggedInUser(c echo.Context) (string, error) {
+	claims, err := s.validateLoggedInClaims(c)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.ID, nil
+}
+
+// validateLoggedInClaims validates the bearer token of a first party login
+func (s *Server) validateLoggedInClaims(c echo.Context) (*JWTCustomClaims, error) {
+	claims, err := s.validateBearerToken(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens issued to oauth clients are for the resource servers they were granted access to, not for managing the account
+	if claims.ClientID != "" {
+		return nil, fmt.Errorf("%w: token issued to an oauth client", ErrTokenInvalidClaims)
+	}
+
+	return claims, nil
+}
+
+// validateBearerToken validates the bearer token from the request,
+// and makes sure it has not been revoked since it was issued.
+func (s *Server) validateBearerToken(c echo.Context) (*JWTCustomClaims, error) {
 	authHeader := c.Request().Header.Get("Authorization")
 	if authHeader == "" {
-		return "", errors.New("unauthorized")
+		return nil, ErrTokenMissing
 	}
 
 	tokens := strings.Split(authHeader, " ")
-	if len(tokens) != 2 {
-		return "", errors.New("unauthorized")
+	if len(tokens) != 2 || !strings.EqualFold(tokens[0], "Bearer") {
+		return nil, ErrTokenMalformed
 	}
 
-	return s.JWT.ValidateToken(tokens[1])
+	claims, err := s.JWT.ValidateToken(tokens[1])
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.Repository.IsAccessTokenRevoked(c.Request().Context(), repository.IsAccessTokenRevokedInput{
+		JTI:          claims.RegisteredClaims.ID,
+		UserID:       claims.ID,
+		TokenVersion: claims.TokenVersion,
+		SessionID:    claims.SessionID,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error checking token revocation")
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+// tokenErrors are the errors telling why a bearer token is rejected
+var tokenErrors = []error{
+	ErrTokenMissing,
+	ErrTokenMalformed,
+	ErrTokenUnverifiable,
+	ErrTokenExpired,
+	ErrTokenNotValidYet,
+	ErrTokenInvalidClaims,
+	ErrTokenRevoked,
+}
+
+// notLoggedInResponse renders the error returned when validating the logged in user.
+// token errors tell the client why it is not logged in, anything else is a server error.
+func notLoggedInResponse(c echo.Context, err error) error {
+	for _, tokenErr := range tokenErrors {
+		if errors.Is(err, tokenErr) {
+			return c.JSON(http.StatusForbidden, generated.ErrorResponse{
+				Message: "not logged in: " + tokenErr.Error(),
+			})
+		}
+	}
+
+	return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+		Message: err.Error(),
+	})
 }
 
 // Updates the logged in user info. Allows for phone number & full name update
@@ -186,9 +539,7 @@ func (s *Server) ValidateLoggedInUser(c echo.Context) (string, error) {
 func (s *Server) UpdateUser(c echo.Context) error {
 	id, err := s.ValidateLoggedInUser(c)
 	if err != nil {
-		return c.JSON(http.StatusForbidden, generated.ErrorResponse{
-			Message: "not logged in",
-		})
+		return notLoggedInResponse(c, err)
 	}
 
 	var payload UpdateUserValidator
@@ -215,45 +566,62 @@ func (s *Server) UpdateUser(c echo.Context) error {
 		})
 	}
 
+	updateInput, err := s.updateUserProfile(ctx, id, existingUser, payload.FullName, payload.PhoneNumber)
+	if err != nil {
+		if err == errPhoneNumberRegistered {
+			return c.JSON(http.StatusConflict, generated.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, generated.UserResponse{
+		Id:          existingUser.ID,
+		FullName:    updateInput.FullName,
+		PhoneNumber: updateInput.PhoneNumber,
+		// changing the phone number resets its verification
+		PhoneVerified: existingUser.PhoneVerifiedAt != nil && updateInput.PhoneNumber == existingUser.PhoneNumber,
+		LoginCount:    int64(existingUser.LoginCount),
+		LastLoginAt:   existingUser.LastLoginAt,
+	})
+}
+
+var errPhoneNumberRegistered = errors.New("phone number already registered")
+
+// updateUserProfile updates the full name & the phone number of the user, keeping the current ones when left empty
+func (s *Server) updateUserProfile(ctx context.Context, id string, existingUser repository.UserOutput, fullName, phoneNumber *string) (repository.UpdateUserInput, error) {
 	updateInput := repository.UpdateUserInput{
 		FullName:    existingUser.FullName,
 		PhoneNumber: existingUser.PhoneNumber,
 	}
 
 	// first, populate phone number if it is filled & does not exist
-	if payload.PhoneNumber != nil {
-		userWithSamePhone, err := s.Repository.GetUserByPhoneNumber(ctx, *payload.PhoneNumber)
+	if phoneNumber != nil {
+		userWithSamePhone, err := s.Repository.GetUserByPhoneNumber(ctx, *phoneNumber)
 		if err != nil && err != sql.ErrNoRows {
-			return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
-				Message: err.Error(),
-			})
+			return repository.UpdateUserInput{}, err
 		}
 
 		if userWithSamePhone.ID != "" && userWithSamePhone.ID != existingUser.ID {
-			return c.JSON(http.StatusConflict, generated.ErrorResponse{
-				Message: "phone number already registered",
-			})
+			return repository.UpdateUserInput{}, errPhoneNumberRegistered
 		}
 
-		updateInput.PhoneNumber = *payload.PhoneNumber
+		updateInput.PhoneNumber = *phoneNumber
 	}
 
 	// then populate full name only if it exists
-	if payload.FullName != nil {
-		updateInput.FullName = *payload.FullName
+	if fullName != nil {
+		updateInput.FullName = *fullName
 	}
 
-	err = s.Repository.UpdateUser(ctx, id, updateInput)
+	err := s.Repository.UpdateUser(ctx, id, updateInput)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, generated.ErrorResponse{
-			Message: err.Error(),
-		})
+		return repository.UpdateUserInput{}, err
 	}
 
-	return c.JSON(http.StatusOK, generated.UserResponse{
-		Id:          existingUser.ID,
-		FullName:    updateInput.FullName,
-		PhoneNumber: updateInput.PhoneNumber,
-		LoginCount:  int64(existingUser.LoginCount),
-	})
+	return updateInput, nil
 }
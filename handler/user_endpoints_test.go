@@ -1,10 +1,14 @@
 package handler_test
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/SawitProRecruitment/UserService/handler"
@@ -30,17 +34,116 @@ func (m createUserInputMatcher) Matches(x interface{}) bool {
 	return actualInput.ID != "" &&
 		m.expected.FullName == actualInput.FullName &&
 		m.expected.PhoneNumber == actualInput.PhoneNumber &&
-		actualInput.HashedPassword != ""
+		strings.HasPrefix(actualInput.HashedPassword, "$argon2id$")
 }
 
 func (m createUserInputMatcher) String() string {
 	return fmt.Sprintf("{CreateUserInput - ID:%s FullName:%s PhoneNumber:%s}", m.expected.ID, m.expected.FullName, m.expected.PhoneNumber)
 }
 
+type refreshTokenInputMatcher struct {
+	userID   string
+	familyID string
+}
+
+func (m refreshTokenInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.CreateRefreshTokenInput)
+	if !ok {
+		return false
+	}
+
+	// a new login generates its own family, so only check it when it is known upfront
+	return actualInput.ID != "" &&
+		m.userID == actualInput.UserID &&
+		actualInput.FamilyID != "" &&
+		(m.familyID == "" || m.familyID == actualInput.FamilyID) &&
+		len(actualInput.TokenHash) == 64 &&
+		actualInput.ExpiresAt.After(time.Now())
+}
+
+func (m refreshTokenInputMatcher) String() string {
+	return fmt.Sprintf("{CreateRefreshTokenInput - UserID:%s FamilyID:%s}", m.userID, m.familyID)
+}
+
+type lockUserInputMatcher struct {
+	userID   string
+	duration time.Duration
+}
+
+func (m lockUserInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.LockUserInput)
+	if !ok {
+		return false
+	}
+
+	lockedFor := time.Until(actualInput.LockedUntil)
+	return m.userID == actualInput.UserID &&
+		lockedFor > m.duration-time.Minute &&
+		lockedFor <= m.duration
+}
+
+func (m lockUserInputMatcher) String() string {
+	return fmt.Sprintf("{LockUserInput - UserID:%s LockedFor:%s}", m.userID, m.duration)
+}
+
+type updatePasswordHashInputMatcher struct {
+	userID       string
+	previousHash string
+	password     string
+}
+
+func (m updatePasswordHashInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.UpdatePasswordHashInput)
+	if !ok {
+		return false
+	}
+
+	hasher := handler.NewArgon2idHasher(handler.DefaultArgon2idParams)
+	valid, err := hasher.Verify(actualInput.HashedPassword, m.password)
+	return m.userID == actualInput.UserID &&
+		m.previousHash == actualInput.PreviousHash &&
+		err == nil && valid &&
+		!hasher.NeedsRehash(actualInput.HashedPassword)
+}
+
+func (m updatePasswordHashInputMatcher) String() string {
+	return fmt.Sprintf("{UpdatePasswordHashInput - UserID:%s, hash of %s}", m.userID, m.password)
+}
+
+// loginEventInputMatcher matches a login of the user from the test client, a successful one when no failure
+// reason is expected
+type loginEventInputMatcher struct {
+	userID        string
+	method        string
+	failureReason string
+}
+
+func (m loginEventInputMatcher) Matches(x interface{}) bool {
+	actualInput, ok := x.(repository.CreateLoginEventInput)
+	if !ok {
+		return false
+	}
+
+	succeeded := m.failureReason == ""
+	return m.userID == actualInput.UserID &&
+		m.method == actualInput.Method &&
+		actualInput.IPAddress == "192.0.2.1" &&
+		succeeded == actualInput.Succeeded &&
+		m.failureReason == actualInput.FailureReason &&
+		// the session is only known for the first party logins
+		(!succeeded || m.method == "authorize" || actualInput.SessionID != nil)
+}
+
+func (m loginEventInputMatcher) String() string {
+	return fmt.Sprintf("{CreateLoginEventInput - UserID:%s Method:%s FailureReason:%s}", m.userID, m.method, m.failureReason)
+}
+
 func TestServer_RegisterUser(t *testing.T) {
 	type fields struct {
-		Repository repository.RepositoryInterface
-		JWT        handler.JWT
+		Repository              repository.RepositoryInterface
+		JWT                     *handler.JWT
+		RegistrationPrivacyMode bool
+		BreachedPasswords       handler.BreachedPasswordChecker
 	}
 	type args struct {
 		payload map[string]interface{}
@@ -50,187 +153,1048 @@ func TestServer_RegisterUser(t *testing.T) {
 		fields     fields
 		args       args
 		wantStatus int
+		// wantSMS are the phone numbers notified of the registration
+		wantSMS []string
+	}{
+		{
+			name: "successfully register new user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					expected := repository.CreateUserInput{
+						FullName:    "test",
+						PhoneNumber: "+62812345678",
+					}
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), expected.PhoneNumber).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateUser(gomock.Any(), createUserInputMatcher{expected}).
+						Return(repository.CreateUserOutput{
+							ID: "test-user-id",
+						}, nil)
+
+					return mockRepo
+				}(),
+				JWT: &handler.JWT{},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"full_name":    "test",
+					"phone_number": "+62812345678",
+					"password":     "Enter123!",
+				},
+			},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name: "failed to parse payload",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					return mockRepo
+				}(),
+				JWT: &handler.JWT{},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"full_name":    123,
+					"phone_number": "+62812345678",
+					"password":     "Enter123!",
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "some fields failed validation",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					return mockRepo
+				}(),
+				JWT: &handler.JWT{},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"full_name":    "test",
+					"phone_number": "12345678",
+					"password":     "Enter123!",
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "password found in a data breach",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+					return mockRepo
+				}(),
+				JWT: &handler.JWT{},
+				BreachedPasswords: func() handler.BreachedPasswordChecker {
+					checker, _ := handler.NewPwnedPasswordsChecker(newPwnedPasswordsRanges(t, "Enter123!"))
+					return checker
+				}(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"full_name":    "test",
+					"phone_number": "+62812345678",
+					"password":     "Enter123!",
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "error phone number already registered",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					expected := repository.CreateUserInput{
+						FullName:    "test",
+						PhoneNumber: "+62812345678",
+					}
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), expected.PhoneNumber).
+						Return(repository.UserOutput{ID: "existing-user", PhoneNumber: "+62812345678"}, nil)
+
+					return mockRepo
+				}(),
+				JWT: &handler.JWT{},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"full_name":    "test",
+					"phone_number": "+62812345678",
+					"password":     "Enter123!",
+				},
+			},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "phone number already registered is answered like a new one in privacy mode",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), "+62812345678").
+						Return(repository.UserOutput{ID: "existing-user", PhoneNumber: "+62812345678"}, nil)
+
+					return mockRepo
+				}(),
+				JWT:                     &handler.JWT{},
+				RegistrationPrivacyMode: true,
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"full_name":    "test",
+					"phone_number": "+62812345678",
+					"password":     "Enter123!",
+				},
+			},
+			wantStatus: http.StatusCreated,
+			wantSMS:    []string{"+62812345678"},
+		},
+		{
+			name: "error when creating user",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					expected := repository.CreateUserInput{
+						FullName:    "test",
+						PhoneNumber: "+62812345678",
+					}
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), expected.PhoneNumber).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateUser(gomock.Any(), createUserInputMatcher{expected}).
+						Return(repository.CreateUserOutput{}, assert.AnError)
+
+					return mockRepo
+				}(),
+				JWT: &handler.JWT{},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"full_name":    "test",
+					"phone_number": "+62812345678",
+					"password":     "Enter123!",
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smsSender := &recordingSMSSender{}
+
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository:              tt.fields.Repository,
+				JWT:                     tt.fields.JWT,
+				SMSSender:               smsSender,
+				RegistrationPrivacyMode: tt.fields.RegistrationPrivacyMode,
+				BreachedPasswords:       tt.fields.BreachedPasswords,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Post("/users").
+				WithAcceptJson().
+				WithJsonBody(tt.args.payload).
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			assert.Equal(t, tt.wantSMS, smsSender.phoneNumbers)
+		})
+	}
+}
+
+func TestServer_AuthenticateUser(t *testing.T) {
+	hashedPassword, _ := handler.NewArgon2idHasher(handler.DefaultArgon2idParams).Hash("testpass!")
+	user := repository.UserOutput{
+		ID:             "abc123-def456",
+		FullName:       "test",
+		PhoneNumber:    "+62812345678",
+		HashedPassword: hashedPassword,
+	}
+
+	// users registered before argon2id have a bcrypt hash, upgraded on their next login
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("testpass!"), bcrypt.MinCost)
+	bcryptUser := user
+	bcryptUser.HashedPassword = string(bcryptHash)
+
+	otpHash, _ := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.MinCost)
+	otpAttempt := repository.AttemptPhoneOTPInput{
+		UserID:      user.ID,
+		Purpose:     "login",
+		MaxAttempts: 5,
+	}
+	activeOTP := repository.PhoneOTPOutput{
+		PhoneNumber: user.PhoneNumber,
+		CodeHash:    string(otpHash),
+		ExpiresAt:   time.Now().Add(time.Minute),
+	}
+
+	lockExpiredAt := time.Now().Add(-time.Minute)
+	lockExpiredUser := user
+	lockExpiredUser.LockedUntil = &lockExpiredAt
+
+	accountKey := "phone:" + user.PhoneNumber
+	// the address of the requests made by testutil
+	ipKey := "ip:192.0.2.1"
+	longAgo := time.Now().Add(-time.Hour)
+
+	type fields struct {
+		Repository repository.RepositoryInterface
+		JWT        *handler.JWT
+		// LoginAttempts is called when the test runs, so the failures it sets are not already old
+		LoginAttempts func() *fakeLoginAttemptStore
+	}
+	type args struct {
+		payload map[string]interface{}
+	}
+	tests := []struct {
+		name           string
+		fields         fields
+		args           args
+		wantStatus     int
+		wantRetryAfter string
+		// wantFailures are the failed logins counted after the request, when the store is set
+		wantFailures map[string]int
 	}{
 		{
-			name: "successfully register new user",
+			name: "successfully logs in",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "user with the phone number not found",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid password",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password", failureReason: "invalid_credentials"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!invalid",
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed when recording a failed login",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password", failureReason: "invalid_credentials"}).
+						Return(assert.AnError)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!invalid",
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "counts a failed login for the account & the client ip",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password", failureReason: "invalid_credentials"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						accountKey: {Failures: 1, LastFailureAt: longAgo},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!invalid",
+				},
+			},
+			wantStatus:   http.StatusBadRequest,
+			wantFailures: map[string]int{accountKey: 2, ipKey: 1},
+		},
+		{
+			name: "counts a failed login for an unknown phone number",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						accountKey: {Failures: 1, LastFailureAt: longAgo},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus:   http.StatusBadRequest,
+			wantFailures: map[string]int{accountKey: 2, ipKey: 1},
+		},
+		{
+			name: "locks an unknown phone number like an account, when the failed logins reach the threshold",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+				// there is no user to lock, the counter is kept as the lock instead
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						accountKey: {Failures: 4, LastFailureAt: longAgo},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus:     http.StatusLocked,
+			wantRetryAfter: "900",
+			wantFailures:   map[string]int{accountKey: 5, ipKey: 1},
+		},
+		{
+			name: "locked unknown phone number is refused before looking the user up",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+				JWT: newFixtureJWT(),
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						accountKey: {Failures: 5, LastFailureAt: time.Now().Add(-5 * time.Minute)},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus:     http.StatusLocked,
+			wantRetryAfter: "600",
+			wantFailures:   map[string]int{accountKey: 5},
+		},
+		{
+			name: "successful login clears the failed logins of the account",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(lockExpiredUser, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						accountKey: {Failures: 3, LastFailureAt: longAgo},
+						ipKey:      {Failures: 3, LastFailureAt: longAgo},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus:   http.StatusOK,
+			wantFailures: map[string]int{ipKey: 3},
+		},
+		{
+			name: "locks the account when the failed logins reach the threshold",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						LockUser(gomock.Any(), lockUserInputMatcher{userID: user.ID, duration: 15 * time.Minute}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password", failureReason: "invalid_credentials"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						accountKey: {Failures: 4, LastFailureAt: longAgo},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!invalid",
+				},
+			},
+			wantStatus:     http.StatusLocked,
+			wantRetryAfter: "900",
+			wantFailures:   map[string]int{ipKey: 1},
+		},
+		{
+			name: "failed when locking the account",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						LockUser(gomock.Any(), lockUserInputMatcher{userID: user.ID, duration: 15 * time.Minute}).
+						Return(assert.AnError)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password", failureReason: "invalid_credentials"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						accountKey: {Failures: 4, LastFailureAt: longAgo},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!invalid",
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "locked account is refused even with the right password",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						DoAndReturn(func(ctx context.Context, phoneNumber string) (repository.UserOutput, error) {
+							lockedUntil := time.Now().Add(10 * time.Minute)
+							lockedUser := user
+							lockedUser.LockedUntil = &lockedUntil
+							return lockedUser, nil
+						})
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password", failureReason: "account_locked"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus:     http.StatusLocked,
+			wantRetryAfter: "600",
+		},
+		{
+			name: "suspended user is refused with the right password",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					suspendedAt := time.Now().Add(-time.Hour)
+					suspendedUser := user
+					suspendedUser.SuspendedAt = &suspendedAt
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(suspendedUser, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password", failureReason: "account_suspended"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "attempt made before the progressive delay has passed",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+				JWT: newFixtureJWT(),
+				// the 3rd failed login is followed by a 2 seconds delay
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						accountKey: {Failures: 3, LastFailureAt: time.Now()},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "2",
+			wantFailures:   map[string]int{accountKey: 3},
+		},
+		{
+			name: "client ip with too many failed logins",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+				JWT: newFixtureJWT(),
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						ipKey: {Failures: 50, LastFailureAt: time.Now().Add(-5 * time.Minute)},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "600",
+			wantFailures:   map[string]int{ipKey: 50},
+		},
+		{
+			name: "client ip whose failures are older than the window",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), "+62812345678").
+						Return(repository.UserOutput{}, sql.ErrNoRows)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					// the counter is still kept, e.g. as long as the lockout duration
+					return newFakeLoginAttemptStore(map[string]handler.LoginAttempts{
+						ipKey: {Failures: 50, LastFailureAt: longAgo},
+					})
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus:   http.StatusBadRequest,
+			wantFailures: map[string]int{accountKey: 1, ipKey: 51},
+		},
+		{
+			name: "failed when reading the failed logins",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					return repository.NewMockRepositoryInterface(ctrl)
+				}(),
+				JWT: newFixtureJWT(),
+				LoginAttempts: func() *fakeLoginAttemptStore {
+					return &fakeLoginAttemptStore{
+						attempts: map[string]handler.LoginAttempts{},
+						err:      assert.AnError,
+					}
+				},
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "successfully logs in & upgrades an outdated password hash",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(bcryptUser, nil)
+
+					mockRepo.EXPECT().
+						UpdatePasswordHash(gomock.Any(), updatePasswordHashInputMatcher{
+							userID:       user.ID,
+							previousHash: bcryptUser.HashedPassword,
+							password:     "testpass!",
+						}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password"}).
+						Return(nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "failed when upgrading an outdated password hash",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
-					expected := repository.CreateUserInput{
-						FullName:    "test",
-						PhoneNumber: "+62812345678",
-					}
-
 					mockRepo.EXPECT().
-						GetUserByPhoneNumber(gomock.Any(), expected.PhoneNumber).
-						Return(repository.UserOutput{}, sql.ErrNoRows)
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(bcryptUser, nil)
 
 					mockRepo.EXPECT().
-						CreateUser(gomock.Any(), createUserInputMatcher{expected}).
-						Return(repository.CreateUserOutput{
-							ID: "test-user-id",
-						}, nil)
+						UpdatePasswordHash(gomock.Any(), gomock.Any()).
+						Return(assert.AnError)
 
 					return mockRepo
 				}(),
-				JWT: handler.JWT{},
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
-					"full_name":    "test",
 					"phone_number": "+62812345678",
-					"password":     "Enter123!",
+					"password":     "testpass!",
 				},
 			},
-			wantStatus: http.StatusCreated,
+			wantStatus: http.StatusInternalServerError,
 		},
 		{
-			name: "failed to parse payload",
+			name: "wrong password does not upgrade an outdated password hash",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(bcryptUser, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password", failureReason: "invalid_credentials"}).
+						Return(nil)
+
 					return mockRepo
 				}(),
-				JWT: handler.JWT{},
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
-					"full_name":    123,
 					"phone_number": "+62812345678",
-					"password":     "Enter123!",
+					"password":     "testpass!invalid",
 				},
 			},
 			wantStatus: http.StatusBadRequest,
 		},
 		{
-			name: "some fields failed validation",
+			name: "successfully logs in with a one-time code",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						AttemptPhoneOTP(gomock.Any(), otpAttempt).
+						Return(activeOTP, nil)
+
+					mockRepo.EXPECT().
+						ConsumePhoneOTP(gomock.Any(), repository.ConsumePhoneOTPInput{
+							UserID:   user.ID,
+							Purpose:  "login",
+							CodeHash: activeOTP.CodeHash,
+						}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "otp"}).
+						Return(nil)
+
 					return mockRepo
 				}(),
-				JWT: handler.JWT{},
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
-					"full_name":    "test",
-					"phone_number": "12345678",
-					"password":     "Enter123!",
+					"phone_number": "+62812345678",
+					"otp":          "123456",
 				},
 			},
-			wantStatus: http.StatusBadRequest,
+			wantStatus: http.StatusOK,
 		},
 		{
-			name: "error phone number already registered",
+			name: "invalid one-time code",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
-					expected := repository.CreateUserInput{
-						FullName:    "test",
-						PhoneNumber: "+62812345678",
-					}
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
 
 					mockRepo.EXPECT().
-						GetUserByPhoneNumber(gomock.Any(), expected.PhoneNumber).
-						Return(repository.UserOutput{ID: "existing-user", PhoneNumber: "+62812345678"}, nil)
+						AttemptPhoneOTP(gomock.Any(), otpAttempt).
+						Return(activeOTP, nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "otp", failureReason: "invalid_credentials"}).
+						Return(nil)
 
 					return mockRepo
 				}(),
-				JWT: handler.JWT{},
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
-					"full_name":    "test",
 					"phone_number": "+62812345678",
-					"password":     "Enter123!",
+					"otp":          "654321",
 				},
 			},
-			wantStatus: http.StatusConflict,
+			wantStatus: http.StatusBadRequest,
 		},
 		{
-			name: "error when creating user",
+			name: "failed when counting the one-time code attempt",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
-					expected := repository.CreateUserInput{
-						FullName:    "test",
-						PhoneNumber: "+62812345678",
-					}
-
 					mockRepo.EXPECT().
-						GetUserByPhoneNumber(gomock.Any(), expected.PhoneNumber).
-						Return(repository.UserOutput{}, sql.ErrNoRows)
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
 
 					mockRepo.EXPECT().
-						CreateUser(gomock.Any(), createUserInputMatcher{expected}).
-						Return(repository.CreateUserOutput{}, assert.AnError)
+						AttemptPhoneOTP(gomock.Any(), otpAttempt).
+						Return(repository.PhoneOTPOutput{}, assert.AnError)
 
 					return mockRepo
 				}(),
-				JWT: handler.JWT{},
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
-					"full_name":    "test",
 					"phone_number": "+62812345678",
-					"password":     "Enter123!",
+					"otp":          "123456",
 				},
 			},
 			wantStatus: http.StatusInternalServerError,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			e := echo.New()
-			s := handler.NewServer(handler.NewServerOptions{
-				Repository: tt.fields.Repository,
-				JWT:        tt.fields.JWT,
-			})
-
-			generated.RegisterHandlers(e, s)
+		{
+			name: "both password and one-time code",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+				JWT:        newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+					"otp":          "123456",
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "neither password nor one-time code",
+			fields: fields{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+				JWT:        newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+				},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "user enrolled in two-factor authentication gets an mfa challenge",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
-			response := testutil.NewRequest().Post("/users").
-				WithAcceptJson().
-				WithJsonBody(tt.args.payload).
-				GoWithHTTPHandler(t, e)
+					mockRepo.EXPECT().
+						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+						Return(user, nil)
 
-			assert.Equal(t, tt.wantStatus, response.Code())
-		})
-	}
-}
+					confirmedAt := time.Now()
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{UserID: user.ID, ConfirmedAt: &confirmedAt}, nil)
 
-func TestServer_AuthenticateUser(t *testing.T) {
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("testpass!"), bcrypt.DefaultCost)
-	user := repository.UserOutput{
-		ID:             "abc123-def456",
-		FullName:       "test",
-		PhoneNumber:    "+62812345678",
-		HashedPassword: string(hashedPassword),
-	}
+					mockRepo.EXPECT().
+						CreateMFAChallenge(gomock.Any(), gomock.Any()).
+						Return(nil)
 
-	type fields struct {
-		Repository repository.RepositoryInterface
-		JWT        handler.JWT
-	}
-	type args struct {
-		payload map[string]interface{}
-	}
-	tests := []struct {
-		name       string
-		fields     fields
-		args       args
-		wantStatus int
-	}{
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				payload: map[string]interface{}{
+					"phone_number": "+62812345678",
+					"password":     "testpass!",
+				},
+			},
+			wantStatus: http.StatusAccepted,
+		},
 		{
-			name: "successfully logs in",
+			name: "user with a pending enrollment logs in without a second factor",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
@@ -241,12 +1205,20 @@ func TestServer_AuthenticateUser(t *testing.T) {
 						Return(user, nil)
 
 					mockRepo.EXPECT().
-						IncrementLoginCount(gomock.Any(), user.ID).
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{UserID: user.ID}, nil)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password"}).
 						Return(nil)
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
@@ -257,7 +1229,7 @@ func TestServer_AuthenticateUser(t *testing.T) {
 			wantStatus: http.StatusOK,
 		},
 		{
-			name: "user with the phone number not found",
+			name: "failed when checking the two-factor enrollment",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
@@ -265,11 +1237,15 @@ func TestServer_AuthenticateUser(t *testing.T) {
 
 					mockRepo.EXPECT().
 						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
-						Return(repository.UserOutput{}, sql.ErrNoRows)
+						Return(user, nil)
+
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, assert.AnError)
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
@@ -277,10 +1253,10 @@ func TestServer_AuthenticateUser(t *testing.T) {
 					"password":     "testpass!",
 				},
 			},
-			wantStatus: http.StatusBadRequest,
+			wantStatus: http.StatusInternalServerError,
 		},
 		{
-			name: "invalid password",
+			name: "failed when generating jwt",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
@@ -290,20 +1266,25 @@ func TestServer_AuthenticateUser(t *testing.T) {
 						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
 						Return(user, nil)
 
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				// a JWT without any key loaded fails to sign tokens
+				JWT: &handler.JWT{},
 			},
 			args: args{
 				payload: map[string]interface{}{
 					"phone_number": "+62812345678",
-					"password":     "testpass!invalid",
+					"password":     "testpass!",
 				},
 			},
-			wantStatus: http.StatusBadRequest,
+			wantStatus: http.StatusInternalServerError,
 		},
 		{
-			name: "failed when generating jwt",
+			name: "failed when storing refresh token",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
@@ -313,9 +1294,17 @@ func TestServer_AuthenticateUser(t *testing.T) {
 						GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
 						Return(user, nil)
 
+					mockRepo.EXPECT().
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(assert.AnError)
+
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte("random_public_key"), []byte("random_private_key")),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
@@ -326,7 +1315,7 @@ func TestServer_AuthenticateUser(t *testing.T) {
 			wantStatus: http.StatusInternalServerError,
 		},
 		{
-			name: "failed when incrementing login counter",
+			name: "failed when recording the login",
 			fields: fields{
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
@@ -337,12 +1326,20 @@ func TestServer_AuthenticateUser(t *testing.T) {
 						Return(user, nil)
 
 					mockRepo.EXPECT().
-						IncrementLoginCount(gomock.Any(), user.ID).
+						GetTOTPSecret(gomock.Any(), user.ID).
+						Return(repository.TOTPSecretOutput{}, sql.ErrNoRows)
+
+					mockRepo.EXPECT().
+						CreateRefreshToken(gomock.Any(), refreshTokenInputMatcher{userID: user.ID}).
+						Return(nil)
+
+					mockRepo.EXPECT().
+						CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password"}).
 						Return(assert.AnError)
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
@@ -356,10 +1353,16 @@ func TestServer_AuthenticateUser(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			e := echo.New()
-			s := handler.NewServer(handler.NewServerOptions{
+			opts := handler.NewServerOptions{
 				Repository: tt.fields.Repository,
 				JWT:        tt.fields.JWT,
-			})
+			}
+			var loginAttempts *fakeLoginAttemptStore
+			if tt.fields.LoginAttempts != nil {
+				loginAttempts = tt.fields.LoginAttempts()
+				opts.LoginAttempts = loginAttempts
+			}
+			s := handler.NewServer(opts)
 
 			generated.RegisterHandlers(e, s)
 
@@ -369,10 +1372,77 @@ func TestServer_AuthenticateUser(t *testing.T) {
 				GoWithHTTPHandler(t, e)
 
 			assert.Equal(t, tt.wantStatus, response.Code())
+			assert.Equal(t, tt.wantRetryAfter, response.Recorder.Header().Get("Retry-After"))
+			if tt.wantFailures != nil {
+				failures := map[string]int{}
+				for key, attempts := range loginAttempts.attempts {
+					failures[key] = attempts.Failures
+				}
+				assert.Equal(t, tt.wantFailures, failures)
+			}
 		})
 	}
 }
 
+// newPepperedHasher returns an argon2id hasher which only knows the pepper with the given ID
+func newPepperedHasher(t *testing.T, pepperID string) *handler.PepperedHasher {
+	hasher, err := handler.NewPepperedHasher(handler.NewPepperedHasherOptions{
+		Hasher: handler.NewArgon2idHasher(handler.DefaultArgon2idParams),
+		Pepper: handler.PasswordPepper{ID: pepperID, Key: bytes.Repeat([]byte(pepperID), 32)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func TestServer_AuthenticateUser_PasswordPepperRetired(t *testing.T) {
+	// the hash was made with a pepper which is not configured anymore
+	retiredHash, _ := newPepperedHasher(t, "1").Hash("testpass!")
+	user := repository.UserOutput{
+		ID:             "c118a1a9-28f1-4137-9093-87487d24e5d9",
+		FullName:       "test",
+		PhoneNumber:    "+62812345678",
+		HashedPassword: retiredHash,
+	}
+
+	ctrl := gomock.NewController(t)
+	mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+	mockRepo.EXPECT().
+		GetUserByPhoneNumber(gomock.Any(), user.PhoneNumber).
+		Return(user, nil)
+
+	mockRepo.EXPECT().
+		CreateLoginEvent(gomock.Any(), loginEventInputMatcher{userID: user.ID, method: "password", failureReason: "password_reset_required"}).
+		Return(nil)
+
+	loginAttempts := newFakeLoginAttemptStore(nil)
+	e := echo.New()
+	s := handler.NewServer(handler.NewServerOptions{
+		Repository:     mockRepo,
+		JWT:            newFixtureJWT(),
+		PasswordHasher: newPepperedHasher(t, "2"),
+		LoginAttempts:  loginAttempts,
+	})
+
+	generated.RegisterHandlers(e, s)
+
+	response := testutil.NewRequest().Post("/auth").
+		WithAcceptJson().
+		WithJsonBody(map[string]interface{}{
+			"phone_number": user.PhoneNumber,
+			"password":     "testpass!",
+		}).
+		GoWithHTTPHandler(t, e)
+
+	// refused like a wrong password, & counted as a failed login
+	assert.Equal(t, http.StatusBadRequest, response.Code())
+	assert.Contains(t, response.Recorder.Body.String(), "user not valid")
+	assert.Equal(t, 1, loginAttempts.attempts["phone:"+user.PhoneNumber].Failures)
+	assert.Equal(t, 1, loginAttempts.attempts["ip:192.0.2.1"].Failures)
+}
+
 func TestServer_GetLoggedInUser(t *testing.T) {
 	user := repository.UserOutput{
 		ID:          "c118a1a9-28f1-4137-9093-87487d24e5d9",
@@ -383,7 +1453,7 @@ func TestServer_GetLoggedInUser(t *testing.T) {
 
 	type fields struct {
 		Repository repository.RepositoryInterface
-		JWT        handler.JWT
+		JWT        *handler.JWT
 	}
 	type args struct {
 		header map[string]string
@@ -401,13 +1471,17 @@ func TestServer_GetLoggedInUser(t *testing.T) {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
 					mockRepo.EXPECT().
 						GetUserByID(gomock.Any(), user.ID).
 						Return(user, nil)
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				header: map[string]string{
@@ -424,11 +1498,33 @@ func TestServer_GetLoggedInUser(t *testing.T) {
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args:       args{},
 			wantStatus: http.StatusForbidden,
 		},
+		{
+			name: "token has been revoked",
+			fields: fields{
+				Repository: func() *repository.MockRepositoryInterface {
+					ctrl := gomock.NewController(t)
+					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(true, nil)
+
+					return mockRepo
+				}(),
+				JWT: newFixtureJWT(),
+			},
+			args: args{
+				header: map[string]string{
+					"Authorization": fmt.Sprintf("Bearer %s", dummyJWT),
+				},
+			},
+			wantStatus: http.StatusForbidden,
+		},
 		{
 			name: "error fetching user data",
 			fields: fields{
@@ -436,13 +1532,17 @@ func TestServer_GetLoggedInUser(t *testing.T) {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
 					mockRepo.EXPECT().
 						GetUserByID(gomock.Any(), user.ID).
 						Return(repository.UserOutput{}, assert.AnError)
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				header: map[string]string{
@@ -486,7 +1586,7 @@ func TestServer_UpdateUser(t *testing.T) {
 
 	type fields struct {
 		Repository repository.RepositoryInterface
-		JWT        handler.JWT
+		JWT        *handler.JWT
 	}
 	type args struct {
 		header  map[string]string
@@ -505,6 +1605,10 @@ func TestServer_UpdateUser(t *testing.T) {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
 					mockRepo.EXPECT().
 						GetUserByID(gomock.Any(), user.ID).
 						Return(user, nil)
@@ -522,7 +1626,7 @@ func TestServer_UpdateUser(t *testing.T) {
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				header: map[string]string{
@@ -543,7 +1647,7 @@ func TestServer_UpdateUser(t *testing.T) {
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				payload: map[string]interface{}{
@@ -560,6 +1664,10 @@ func TestServer_UpdateUser(t *testing.T) {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
 					mockRepo.EXPECT().
 						GetUserByID(gomock.Any(), user.ID).
 						Return(user, nil)
@@ -577,7 +1685,7 @@ func TestServer_UpdateUser(t *testing.T) {
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				header: map[string]string{
@@ -596,6 +1704,10 @@ func TestServer_UpdateUser(t *testing.T) {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
 					mockRepo.EXPECT().
 						GetUserByID(gomock.Any(), user.ID).
 						Return(user, nil)
@@ -609,7 +1721,7 @@ func TestServer_UpdateUser(t *testing.T) {
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				header: map[string]string{
@@ -627,9 +1739,14 @@ func TestServer_UpdateUser(t *testing.T) {
 				Repository: func() *repository.MockRepositoryInterface {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
+
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				header: map[string]string{
@@ -648,6 +1765,10 @@ func TestServer_UpdateUser(t *testing.T) {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
 					mockRepo.EXPECT().
 						GetUserByID(gomock.Any(), user.ID).
 						Return(user, nil)
@@ -658,7 +1779,7 @@ func TestServer_UpdateUser(t *testing.T) {
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				header: map[string]string{
@@ -677,6 +1798,10 @@ func TestServer_UpdateUser(t *testing.T) {
 					ctrl := gomock.NewController(t)
 					mockRepo := repository.NewMockRepositoryInterface(ctrl)
 
+					mockRepo.EXPECT().
+						IsAccessTokenRevoked(gomock.Any(), dummyJWTRevocationCheck).
+						Return(false, nil)
+
 					mockRepo.EXPECT().
 						GetUserByID(gomock.Any(), user.ID).
 						Return(user, nil)
@@ -690,7 +1815,7 @@ func TestServer_UpdateUser(t *testing.T) {
 
 					return mockRepo
 				}(),
-				JWT: handler.NewJWT([]byte(RsaPublicKey), []byte(RsaPrivateKey)),
+				JWT: newFixtureJWT(),
 			},
 			args: args{
 				header: map[string]string{
@@ -2,8 +2,10 @@ package handler
 
 import (
 	"fmt"
+	"math"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/go-playground/validator/v10"
@@ -66,17 +68,78 @@ func (v RegisterUserValidator) Validate() FieldErrors {
 		}
 	}
 
-	// passwords are validated using separate case from regex (not from validator, since it doesn't support regex validations)
-	if v.Password != "" && !isPasswordValid(v.Password) {
-		fieldErrors = append(fieldErrors, generated.FieldError{
-			Field:      "Password",
-			Validation: "must contain at least 1 capital characters, 1 number, and 1 special (nonalpha-numeric) characters",
-		})
+	fieldErrors = append(fieldErrors, passwordFieldErrors(fieldErrors, "Password", v.Password)...)
+	if !fieldErrors.has("Password") {
+		fieldErrors = append(fieldErrors, passwordPersonalInfoFieldErrors("Password", v.Password, v.FullName, v.PhoneNumber)...)
 	}
 
 	return fieldErrors
 }
 
+// passwords are validated using separate case from regex (not from validator, since it doesn't support regex validations).
+// a password is only scored once it passes the length & the character rules, so a field gets a single error.
+func passwordFieldErrors(fieldErrors FieldErrors, field, password string) FieldErrors {
+	if password == "" || fieldErrors.has(field) {
+		return nil
+	}
+
+	if !isPasswordValid(password) {
+		return FieldErrors{{
+			Field:      field,
+			Validation: "must contain at least 1 capital characters, 1 number, and 1 special (nonalpha-numeric) characters",
+		}}
+	}
+
+	if passwordEntropy(password) < minPasswordEntropy {
+		return FieldErrors{{
+			Field:      field,
+			Validation: "is too easy to guess, use a longer password without repeated or sequential characters",
+		}}
+	}
+
+	return nil
+}
+
+// passwordPersonalInfoFieldErrors refuses a password containing the name or the phone number of its user, the
+// first things tried when guessing their password
+func passwordPersonalInfoFieldErrors(field, password, fullName, phoneNumber string) FieldErrors {
+	if password == "" {
+		return nil
+	}
+
+	lowerPassword := strings.ToLower(password)
+	for _, name := range strings.Fields(strings.ToLower(fullName)) {
+		if utf8.RuneCountInString(name) >= minPersonalInfoLength && strings.Contains(lowerPassword, name) {
+			return FieldErrors{{
+				Field:      field,
+				Validation: "must not contain your name",
+			}}
+		}
+	}
+
+	// the national number is contained in every way of writing the phone number, e.g. +62812..., 62812... & 0812...
+	nationalNumber := strings.TrimPrefix(phoneNumber, "+62")
+	if len(nationalNumber) >= minPersonalInfoLength && strings.Contains(password, nationalNumber) {
+		return FieldErrors{{
+			Field:      field,
+			Validation: "must not contain your phone number",
+		}}
+	}
+
+	return nil
+}
+
+// has reports whether the field already failed a validation
+func (fe FieldErrors) has(field string) bool {
+	for _, e := range fe {
+		if e.Field == field {
+			return true
+		}
+	}
+
+	return false
+}
+
 // helper function to check if password met the following criteria:
 // containing at least 1 digit, 1 symbol, and 1 uppercase letter.
 // this is done since regexp golang does not support lookaheads.
@@ -101,9 +164,216 @@ func isPasswordValid(password string) bool {
 	return containUppercase && containDigit && containSymbol
 }
 
+const (
+	// minPasswordEntropy is the estimated strength, in bits, a password needs
+	minPasswordEntropy = 40
+	// minPersonalInfoLength ignores the parts of a name, or the numbers, too short to tell anything, e.g. an initial
+	minPersonalInfoLength = 3
+)
+
+// passwordEntropy estimates the strength of a password in bits, from the size of the character classes it uses.
+// repeated & sequential characters (aaa, abc, 321) add nothing, guessing tools try them first.
+func passwordEntropy(password string) float64 {
+	var containLower, containUpper, containDigit, containSymbol, containOther bool
+	length, previous := 0, rune(-1)
+
+	for _, c := range password {
+		switch {
+		case c >= utf8.RuneSelf:
+			containOther = true
+		case unicode.IsLower(c):
+			containLower = true
+		case unicode.IsUpper(c):
+			containUpper = true
+		case unicode.IsNumber(c):
+			containDigit = true
+		default:
+			containSymbol = true
+		}
+
+		if diff := c - previous; previous < 0 || diff < -1 || diff > 1 {
+			length++
+		}
+		previous = c
+	}
+
+	poolSize := 0
+	if containLower {
+		poolSize += 26
+	}
+	if containUpper {
+		poolSize += 26
+	}
+	if containDigit {
+		poolSize += 10
+	}
+	if containSymbol {
+		poolSize += 33
+	}
+	if containOther {
+		poolSize += 100
+	}
+	if poolSize == 0 {
+		return 0
+	}
+
+	return float64(length) * math.Log2(float64(poolSize))
+}
+
+// AuthenticateUserValidator holds the login credentials, either the password or a one-time code sent by sms
 type AuthenticateUserValidator struct {
 	PhoneNumber string `json:"phone_number" validate:"required"`
-	Password    string `json:"password" validate:"required"`
+	Password    string `json:"password"`
+	OTP         string `json:"otp"`
+}
+
+type SendLoginOTPValidator struct {
+	PhoneNumber string `json:"phone_number"`
+}
+
+type ConfirmPhoneVerificationValidator struct {
+	Code string `json:"code"`
+}
+
+// ChangePasswordValidator holds the current password & the new one, checked by the same rules as on registration
+type ChangePasswordValidator struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=6,max=64"`
+}
+
+func (v ChangePasswordValidator) Validate() FieldErrors {
+	fieldErrors := FieldErrors{}
+
+	err := validate.Struct(v)
+	if err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+
+		for _, validationErr := range validationErrors {
+			fieldErrors = append(fieldErrors, generated.FieldError{
+				Field:      validationErr.Field(),
+				Validation: validationMessages(validationErr.Tag(), validationErr.Param()),
+			})
+		}
+	}
+
+	return append(fieldErrors, passwordFieldErrors(fieldErrors, "NewPassword", v.NewPassword)...)
+}
+
+type RequestPasswordResetValidator struct {
+	PhoneNumber string `json:"phone_number"`
+}
+
+type DeleteAccountValidator struct {
+	Password string `json:"password"`
+}
+
+type RequestDataExportValidator struct {
+	Format string `json:"format"`
+}
+
+// ConfirmPasswordResetValidator holds the code sent by sms & the new password, checked by the same rules as on registration
+type ConfirmPasswordResetValidator struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+	Code        string `json:"code" validate:"required"`
+	Password    string `json:"password" validate:"required,min=6,max=64"`
+}
+
+func (v ConfirmPasswordResetValidator) Validate() FieldErrors {
+	fieldErrors := FieldErrors{}
+
+	err := validate.Struct(v)
+	if err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+
+		for _, validationErr := range validationErrors {
+			fieldErrors = append(fieldErrors, generated.FieldError{
+				Field:      validationErr.Field(),
+				Validation: validationMessages(validationErr.Tag(), validationErr.Param()),
+			})
+		}
+	}
+
+	return append(fieldErrors, passwordFieldErrors(fieldErrors, "Password", v.Password)...)
+}
+
+type RefreshTokenValidator struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type ConfirmTOTPValidator struct {
+	Code string `json:"code"`
+}
+
+// VerifyMFAValidator holds the second factor of a login, either a totp code or a recovery code
+type VerifyMFAValidator struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// RegisterPasskeyValidator holds the credential created by navigator.credentials.create(), binary values are base64url encoded
+type RegisterPasskeyValidator struct {
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// PasskeyLoginValidator holds the assertion returned by navigator.credentials.get(), binary values are base64url encoded
+type PasskeyLoginValidator struct {
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// IntrospectTokenValidator is bound from a form encoded body, as required by RFC 7662
+type IntrospectTokenValidator struct {
+	Token         string `form:"token" validate:"required"`
+	TokenTypeHint string `form:"token_type_hint" validate:"omitempty,oneof=access_token refresh_token"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// AuthorizationRequestValidator holds the oauth authorization request, carried over from the authorize page to its form
+type AuthorizationRequestValidator struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"`
+}
+
+type SubmitAuthorizationValidator struct {
+	AuthorizationRequestValidator
+	PhoneNumber string `form:"phone_number"`
+	Password    string `form:"password"`
+	// the second step of the login of a user enrolled in two-factor authentication
+	MFAToken     string `form:"mfa_token"`
+	Code         string `form:"code"`
+	RecoveryCode string `form:"recovery_code"`
+	Decision     string `form:"decision"`
+}
+
+type OAuthTokenValidator struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
 }
 
 type UpdateUserValidator struct {
@@ -128,3 +398,27 @@ func (v UpdateUserValidator) Validate() FieldErrors {
 
 	return fieldErrors
 }
+
+type AdminUpdateUserValidator struct {
+	FullName    *string   `json:"full_name" validate:"omitempty,min=3,max=60"`
+	PhoneNumber *string   `json:"phone_number" validate:"omitempty,min=10,max=13,startswith=+62"`
+	Roles       *[]string `json:"roles" validate:"omitempty,max=10,dive,required,max=32"`
+}
+
+func (v AdminUpdateUserValidator) Validate() FieldErrors {
+	fieldErrors := FieldErrors{}
+
+	err := validate.Struct(v)
+	if err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+
+		for _, validationErr := range validationErrors {
+			fieldErrors = append(fieldErrors, generated.FieldError{
+				Field:      validationErr.Field(),
+				Validation: validationErr.Error(),
+			})
+		}
+	}
+
+	return fieldErrors
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -149,6 +150,48 @@ func TestRegisterUserValidator_Validate(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "password is too easy to guess",
+			fields: fields{
+				FullName:    "john smith",
+				PhoneNumber: "+628123456789",
+				Password:    "Aaaaaaaa1234!",
+			},
+			want: FieldErrors{
+				{
+					Field:      "Password",
+					Validation: "is too easy to guess, use a longer password without repeated or sequential characters",
+				},
+			},
+		},
+		{
+			name: "password contains the name",
+			fields: fields{
+				FullName:    "john smith",
+				PhoneNumber: "+628123456789",
+				Password:    "Smith#2024Kq",
+			},
+			want: FieldErrors{
+				{
+					Field:      "Password",
+					Validation: "must not contain your name",
+				},
+			},
+		},
+		{
+			name: "password contains the phone number",
+			fields: fields{
+				FullName:    "john smith",
+				PhoneNumber: "+628123456789",
+				Password:    "Xq08123456789!vz",
+			},
+			want: FieldErrors{
+				{
+					Field:      "Password",
+					Validation: "must not contain your phone number",
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -162,3 +205,86 @@ func TestRegisterUserValidator_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestConfirmPasswordResetValidator_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		validator ConfirmPasswordResetValidator
+		want      FieldErrors
+	}{
+		{
+			name: "successfully validates all field",
+			validator: ConfirmPasswordResetValidator{
+				PhoneNumber: "+62812345678",
+				Code:        "123456",
+				Password:    "TestingNewUser123!",
+			},
+			want: FieldErrors{},
+		},
+		{
+			name: "code is not present",
+			validator: ConfirmPasswordResetValidator{
+				PhoneNumber: "+62812345678",
+				Password:    "TestingNewUser123!",
+			},
+			want: FieldErrors{
+				{
+					Field:      "Code",
+					Validation: "field is required",
+				},
+			},
+		},
+		{
+			name: "password is less than minimum",
+			validator: ConfirmPasswordResetValidator{
+				PhoneNumber: "+62812345678",
+				Code:        "123456",
+				Password:    "Te1!",
+			},
+			want: FieldErrors{
+				{
+					Field:      "Password",
+					Validation: "length is less than minimum allowed length of 6",
+				},
+			},
+		},
+		{
+			name: "password does not met character rule",
+			validator: ConfirmPasswordResetValidator{
+				PhoneNumber: "+62812345678",
+				Code:        "123456",
+				Password:    "testingtesting",
+			},
+			want: FieldErrors{
+				{
+					Field:      "Password",
+					Validation: "must contain at least 1 capital characters, 1 number, and 1 special (nonalpha-numeric) characters",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.validator.Validate())
+		})
+	}
+}
+
+func Test_passwordEntropy(t *testing.T) {
+	tests := []struct {
+		password string
+		want     float64
+	}{
+		{password: "", want: 0},
+		{password: "aaaa", want: math.Log2(26)},
+		{password: "abcdef", want: math.Log2(26)},
+		{password: "fedcba", want: math.Log2(26)},
+		{password: "Enter123!", want: 7 * math.Log2(95)},
+		{password: "pässwörd", want: 7 * math.Log2(126)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			assert.InDelta(t, tt.want, passwordEntropy(tt.password), 0.001)
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// flags of the authenticator data, see https://www.w3.org/TR/webauthn-2/#flags
+const (
+	authenticatorFlagUserPresent            = 0x01
+	authenticatorFlagUserVerified           = 0x04
+	authenticatorFlagAttestedCredentialData = 0x40
+)
+
+// COSE algorithm identifiers of the supported credential keys
+const (
+	coseAlgorithmES256 = -7
+	coseAlgorithmEdDSA = -8
+	coseAlgorithmRS256 = -257
+)
+
+// supportedCOSEAlgorithms are offered to authenticators in order of preference
+var supportedCOSEAlgorithms = []int64{coseAlgorithmES256, coseAlgorithmEdDSA, coseAlgorithmRS256}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// parseWebAuthnClientData checks the client data of a ceremony against its type & the allowed origins,
+// and returns the challenge signed by the browser
+func parseWebAuthnClientData(data []byte, ceremonyType string, origins []string) (string, error) {
+	var clientData webAuthnClientData
+	if err := json.Unmarshal(data, &clientData); err != nil {
+		return "", errors.Wrap(err, "client data not valid")
+	}
+
+	if clientData.Type != ceremonyType {
+		return "", errors.New("client data type not valid")
+	}
+
+	for _, origin := range origins {
+		if clientData.Origin == origin {
+			return clientData.Challenge, nil
+		}
+	}
+
+	return "", errors.New("origin not allowed")
+}
+
+type authenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// CredentialID & CredentialPublicKey are only set during registration
+	CredentialID        []byte
+	CredentialPublicKey []byte
+}
+
+// parseAuthenticatorData decodes the authenticator data, see https://www.w3.org/TR/webauthn-2/#sctn-authenticator-data
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, errors.New("authenticator data too short")
+	}
+
+	authData := authenticatorData{
+		RPIDHash:  data[0:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.Flags&authenticatorFlagAttestedCredentialData == 0 {
+		return authData, nil
+	}
+
+	// attested credential data: aaguid (16 bytes), credential id length (2 bytes), credential id, COSE key
+	rest := data[37:]
+	if len(rest) < 18 {
+		return authenticatorData{}, errors.New("attested credential data too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return authenticatorData{}, errors.New("credential id too short")
+	}
+	authData.CredentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// the COSE key is followed by the extensions, its length is only known by decoding it
+	decoder := cborDecoder{data: rest}
+	if _, err := decoder.decode(0); err != nil {
+		return authenticatorData{}, errors.Wrap(err, "credential public key not valid")
+	}
+	authData.CredentialPublicKey = rest[:decoder.pos]
+
+	return authData, nil
+}
+
+// verify checks the authenticator data was produced for the relying party, with the user verified
+func (a authenticatorData) verify(rpID string) error {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(a.RPIDHash, rpIDHash[:]) {
+		return errors.New("relying party not valid")
+	}
+
+	if a.Flags&authenticatorFlagUserPresent == 0 {
+		return errors.New("user not present")
+	}
+	if a.Flags&authenticatorFlagUserVerified == 0 {
+		return errors.New("user not verified")
+	}
+
+	return nil
+}
+
+// parseAttestationObject returns the authenticator data of the attestation object. The attestation
+// statement is ignored: "none" attestation is requested, the authenticator model is not checked.
+func parseAttestationObject(data []byte) ([]byte, error) {
+	decoder := cborDecoder{data: data}
+	value, err := decoder.decode(0)
+	if err != nil {
+		return nil, errors.Wrap(err, "attestation object not valid")
+	}
+
+	attestationObject, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object not valid")
+	}
+
+	authData, ok := attestationObject["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+
+	return authData, nil
+}
+
+type coseKey struct {
+	Algorithm int64
+	PublicKey crypto.PublicKey
+}
+
+// parseCOSEKey decodes a credential public key of one of the supported algorithms, see RFC 8152
+func parseCOSEKey(data []byte) (coseKey, error) {
+	decoder := cborDecoder{data: data}
+	value, err := decoder.decode(0)
+	if err != nil {
+		return coseKey{}, errors.Wrap(err, "credential public key not valid")
+	}
+
+	params, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return coseKey{}, errors.New("credential public key not valid")
+	}
+
+	keyType, _ := params[int64(1)].(int64)
+	algorithm, _ := params[int64(3)].(int64)
+	curve, _ := params[int64(-1)].(int64)
+
+	switch {
+	case algorithm == coseAlgorithmES256 && keyType == 2 && curve == 1:
+		x, _ := params[int64(-2)].([]byte)
+		y, _ := params[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return coseKey{}, errors.New("credential public key not valid")
+		}
+
+		xInt, yInt := new(big.Int).SetBytes(x), new(big.Int).SetBytes(y)
+		if !elliptic.P256().IsOnCurve(xInt, yInt) {
+			return coseKey{}, errors.New("credential public key not on curve")
+		}
+
+		return coseKey{
+			Algorithm: algorithm,
+			PublicKey: &ecdsa.PublicKey{Curve: elliptic.P256(), X: xInt, Y: yInt},
+		}, nil
+
+	case algorithm == coseAlgorithmEdDSA && keyType == 1 && curve == 6:
+		x, _ := params[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return coseKey{}, errors.New("credential public key not valid")
+		}
+
+		return coseKey{
+			Algorithm: algorithm,
+			PublicKey: ed25519.PublicKey(x),
+		}, nil
+
+	case algorithm == coseAlgorithmRS256 && keyType == 3:
+		n, _ := params[int64(-1)].([]byte)
+		e, _ := params[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return coseKey{}, errors.New("credential public key not valid")
+		}
+
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+
+		return coseKey{
+			Algorithm: algorithm,
+			PublicKey: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent},
+		}, nil
+	}
+
+	return coseKey{}, errors.New("credential algorithm not supported")
+}
+
+// verify checks the signature of an assertion, made over the authenticator data & the hash of the client data
+func (k coseKey) verify(authData, clientData, signature []byte) error {
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	valid := false
+	switch key := k.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+
+	if !valid {
+		return errors.New("signature not valid")
+	}
+
+	return nil
+}
+
+// decodeWebAuthnBase64 decodes the base64url values sent by browsers, with or without padding
+func decodeWebAuthnBase64(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// cborMaxDepth bounds the nesting of decoded values, authenticators never go past a few levels
+const cborMaxDepth = 8
+
+// cborDecoder decodes the subset of CBOR (RFC 8949) used by authenticators: integers, byte & text strings,
+// arrays, maps & simple values, all of definite length
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor value nested too deep")
+	}
+
+	majorType, argument, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+
+	switch majorType {
+	case 0:
+		if argument > 1<<63-1 {
+			return nil, errors.New("cbor integer overflow")
+		}
+		return int64(argument), nil
+	case 1:
+		if argument > 1<<63-1 {
+			return nil, errors.New("cbor integer overflow")
+		}
+		return -1 - int64(argument), nil
+	case 2, 3:
+		if argument > uint64(len(d.data)-d.pos) {
+			return nil, errors.New("cbor string too short")
+		}
+		value := d.data[d.pos : d.pos+int(argument)]
+		d.pos += int(argument)
+		if majorType == 3 {
+			return string(value), nil
+		}
+		return value, nil
+	case 4:
+		// every item takes at least one byte, which bounds the allocation
+		if argument > uint64(len(d.data)-d.pos) {
+			return nil, errors.New("cbor array too short")
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if argument > uint64(len(d.data)-d.pos) {
+			return nil, errors.New("cbor map too short")
+		}
+		entries := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("cbor map key not supported")
+			}
+
+			value, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			entries[key] = value
+		}
+		return entries, nil
+	case 7:
+		switch argument {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+	}
+
+	return nil, errors.New("cbor value not supported")
+}
+
+// readHead reads the major type & the argument of the next value
+func (d *cborDecoder) readHead() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errors.New("cbor data too short")
+	}
+
+	initial := d.data[d.pos]
+	d.pos++
+	majorType, additional := initial>>5, initial&0x1f
+
+	if additional < 24 {
+		return majorType, uint64(additional), nil
+	}
+	if additional > 27 {
+		// indefinite lengths & reserved values are never produced by authenticators
+		return 0, 0, errors.New("cbor value not supported")
+	}
+
+	size := 1 << (additional - 24)
+	if len(d.data)-d.pos < size {
+		return 0, 0, errors.New("cbor data too short")
+	}
+
+	var argument uint64
+	for _, b := range d.data[d.pos : d.pos+size] {
+		argument = argument<<8 | uint64(b)
+	}
+	d.pos += size
+
+	return majorType, argument, nil
+}
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/labstack/echo/v4"
+)
+
+// Returns the public keys used to verify the tokens issued by the service, as a JSON Web Key Set
+// (GET /.well-known/jwks.json)
+func (s *Server) GetJSONWebKeySet(c echo.Context) error {
+	// allow verifiers to cache the key set, while still picking up rotated keys quickly
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+
+	return c.JSON(http.StatusOK, generated.JSONWebKeySet{
+		Keys: s.JWT.JSONWebKeys(),
+	})
+}
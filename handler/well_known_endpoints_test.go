@@ -0,0 +1,112 @@
+package handler_test
+
+import (
+	"crypto/elliptic"
+	"net/http"
+	"testing"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/oapi-codegen/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_GetJSONWebKeySet(t *testing.T) {
+	previousKey := generateRSAKey(t, "previous-key")
+	ecKey := generateECKey(t, "ec-key", elliptic.P256())
+	ed25519Key := generateEd25519Key(t, "ed25519-key")
+
+	tests := []struct {
+		name       string
+		jwt        *handler.JWT
+		wantStatus int
+		wantKids   []string
+		wantAlgs   []string
+	}{
+		{
+			name: "successfully returns every verification key",
+			jwt: mustNewJWT(handler.NewJWTOptions{
+				SigningKey: handler.JWTKey{
+					ID:         "fixture-key",
+					PublicKey:  []byte(RsaPublicKey),
+					PrivateKey: []byte(RsaPrivateKey),
+				},
+				VerificationKeys: []handler.JWTKey{previousKey},
+				Issuer:           fixtureIssuer,
+				Audience:         fixtureAudience,
+			}),
+			wantStatus: http.StatusOK,
+			wantKids:   []string{"fixture-key", "previous-key"},
+			wantAlgs:   []string{"RS256", "RS256"},
+		},
+		{
+			name: "successfully returns ECDSA & Ed25519 keys",
+			jwt: mustNewJWT(handler.NewJWTOptions{
+				SigningKey:       ed25519Key,
+				VerificationKeys: []handler.JWTKey{ecKey},
+				Issuer:           fixtureIssuer,
+				Audience:         fixtureAudience,
+			}),
+			wantStatus: http.StatusOK,
+			wantKids:   []string{"ec-key", "ed25519-key"},
+			wantAlgs:   []string{"ES256", "EdDSA"},
+		},
+		{
+			name:       "returns an empty key set when no key is loaded",
+			jwt:        &handler.JWT{},
+			wantStatus: http.StatusOK,
+			wantKids:   []string{},
+			wantAlgs:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			s := handler.NewServer(handler.NewServerOptions{
+				Repository: repository.NewMockRepositoryInterface(gomock.NewController(t)),
+				JWT:        tt.jwt,
+			})
+
+			generated.RegisterHandlers(e, s)
+
+			response := testutil.NewRequest().Get("/.well-known/jwks.json").
+				WithAcceptJson().
+				GoWithHTTPHandler(t, e)
+
+			assert.Equal(t, tt.wantStatus, response.Code())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body generated.JSONWebKeySet
+			assert.NoError(t, response.UnmarshalBodyToObject(&body))
+
+			kids := []string{}
+			algs := []string{}
+			for _, key := range body.Keys {
+				switch key.Alg {
+				case "RS256":
+					assert.Equal(t, "RSA", key.Kty)
+					assert.NotNil(t, key.N)
+					assert.NotNil(t, key.E)
+				case "ES256":
+					assert.Equal(t, "EC", key.Kty)
+					assert.Equal(t, "P-256", *key.Crv)
+					assert.Len(t, *key.X, 43)
+					assert.Len(t, *key.Y, 43)
+				case "EdDSA":
+					assert.Equal(t, "OKP", key.Kty)
+					assert.Equal(t, "Ed25519", *key.Crv)
+					assert.Len(t, *key.X, 43)
+				}
+				kids = append(kids, key.Kid)
+				algs = append(algs, key.Alg)
+			}
+			assert.Equal(t, tt.wantKids, kids)
+			assert.Equal(t, tt.wantAlgs, algs)
+		})
+	}
+}
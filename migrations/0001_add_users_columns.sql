@@ -0,0 +1,12 @@
+-- the columns added to the users since database.sql first created the table, so existing databases can be brought
+-- up to date. every statement is idempotent.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_verified_at TIMESTAMP(0);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP(0);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMP(0);
+-- suspended_at is set by an admin, a suspended user cannot log in until it is cleared
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP(0);
+-- deleted_at is set when the user deletes their account, they are invisible from then on. their personal data is
+-- erased after a grace period, or at once by an admin: erased_at is set then, and the row is kept as a tombstone.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(0);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP(0);
@@ -0,0 +1,19 @@
+-- the admin listing of the users pages through them by creation, and filters them by name or phone number prefix.
+-- text_pattern_ops lets LIKE 'prefix%' use the indexes whatever the collation of the database.
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS users_full_name_prefix_idx ON users (LOWER(full_name) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS users_phone_number_prefix_idx ON users (phone_number text_pattern_ops);
+
+-- the admin search of the users matches the trigrams of the names, ignoring their accents & case.
+-- unaccent is only stable, as its dictionary could change, so it is wrapped in an immutable function to be indexed.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+CREATE OR REPLACE FUNCTION search_name(name TEXT) RETURNS TEXT AS $$
+  SELECT LOWER(public.unaccent('public.unaccent'::regdictionary, name))
+$$ LANGUAGE SQL IMMUTABLE PARALLEL SAFE STRICT;
+
+CREATE INDEX IF NOT EXISTS users_full_name_trgm_idx ON users USING GIN (search_name(full_name) gin_trgm_ops);
+
+-- the deleted users waiting for their erasure
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL AND erased_at IS NULL;
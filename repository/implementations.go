@@ -2,9 +2,41 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ErrRefreshTokenNotActive is returned when rotating a refresh token that has
+// already been rotated or revoked, e.g. by a concurrent refresh request.
+var ErrRefreshTokenNotActive = errors.New("refresh token is no longer active")
+
+// errors returned by the two-factor authentication updates, which only succeed for a row in the expected state
+var (
+	ErrTOTPAlreadyConfirmed  = errors.New("totp enrollment is already confirmed")
+	ErrTOTPStepAlreadyUsed   = errors.New("totp code has already been used")
+	ErrRecoveryCodeNotValid  = errors.New("recovery code is not valid")
+	ErrMFAChallengeNotActive = errors.New("mfa challenge is no longer active")
+)
+
+// errors returned by the phone one-time code updates
+var (
+	ErrPhoneOTPResendTooSoon = errors.New("phone one-time code was sent too recently")
+	ErrPhoneOTPNotActive     = errors.New("phone one-time code is no longer active")
+	ErrPhoneNumberChanged    = errors.New("phone number has changed")
+)
+
+// ErrPasswordHashChanged is returned when replacing a password hash which was changed in the meantime
+var ErrPasswordHashChanged = errors.New("password hash has changed")
+
+// errors returned by the passkey updates
+var (
+	ErrWebAuthnCredentialExists  = errors.New("webauthn credential is already registered")
+	ErrWebAuthnSignCountNotValid = errors.New("webauthn sign count did not increase")
 )
 
 func (r *Repository) CreateUser(ctx context.Context, input CreateUserInput) (output CreateUserOutput, err error) {
@@ -34,11 +66,19 @@ func (r *Repository) GetUserByPhoneNumber(ctx context.Context, phoneNumber strin
 			full_name,
 			phone_number,
 			hashed_password,
-			login_count
+			login_count,
+			token_version,
+			phone_verified_at,
+			locked_until,
+			last_login_at,
+			suspended_at,
+			ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role) AS roles,
+			created_at
 		FROM
 			users
 		WHERE
 			phone_number = $1
+			AND deleted_at IS NULL
 		LIMIT 1
 	`
 
@@ -54,11 +94,19 @@ func (r *Repository) GetUserByID(ctx context.Context, id string) (output UserOut
 			full_name,
 			phone_number,
 			hashed_password,
-			login_count
+			login_count,
+			token_version,
+			phone_verified_at,
+			locked_until,
+			last_login_at,
+			suspended_at,
+			ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role) AS roles,
+			created_at
 		FROM
 			users
 		WHERE
 			id = $1
+			AND deleted_at IS NULL
 		LIMIT 1
 	`
 
@@ -67,13 +115,132 @@ func (r *Repository) GetUserByID(ctx context.Context, id string) (output UserOut
 	return
 }
 
+// ListUsers lists a page of the users matching the filter, ordered by creation. only the conditions of the filters
+// which are set are added, so each query can use the index on the creation or on the prefixes.
+func (r *Repository) ListUsers(ctx context.Context, input ListUsersInput) (output []UserOutput, err error) {
+	// the deleted users are never listed
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	// arg adds the value to the arguments of the query, and returns its placeholder
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	filter := input.Filter
+	if filter.FullNamePrefix != "" {
+		conditions = append(conditions, "LOWER(full_name) LIKE "+arg(likePrefix(strings.ToLower(filter.FullNamePrefix))))
+	}
+	if filter.PhoneNumberPrefix != "" {
+		conditions = append(conditions, "phone_number LIKE "+arg(likePrefix(filter.PhoneNumberPrefix)))
+	}
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, "created_at >= "+arg(*filter.CreatedFrom))
+	}
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(*filter.CreatedBefore))
+	}
+
+	switch filter.Status {
+	case "":
+	case UserStatusActive:
+		conditions = append(conditions, "suspended_at IS NULL AND (locked_until IS NULL OR locked_until <= NOW())")
+	case UserStatusLocked:
+		conditions = append(conditions, "suspended_at IS NULL AND locked_until > NOW()")
+	case UserStatusSuspended:
+		conditions = append(conditions, "suspended_at IS NOT NULL")
+	default:
+		return nil, fmt.Errorf("unknown user status %q", filter.Status)
+	}
+
+	order, comparison := "DESC", "<"
+	if input.OldestFirst {
+		order, comparison = "ASC", ">"
+	}
+	if input.After != nil {
+		// a row comparison, which the index on (created_at, id) can seek to
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) %s (%s, %s)", comparison, arg(input.After.CreatedAt), arg(input.After.ID)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			id,
+			full_name,
+			phone_number,
+			hashed_password,
+			login_count,
+			token_version,
+			phone_verified_at,
+			locked_until,
+			last_login_at,
+			suspended_at,
+			ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role) AS roles,
+			created_at
+		FROM
+			users
+		WHERE
+			%s
+		ORDER BY
+			created_at %s,
+			id %s
+		LIMIT %s
+	`, strings.Join(conditions, " AND "), order, order, arg(input.Limit))
+
+	err = r.Db.SelectContext(ctx, &output, query, args...)
+
+	return
+}
+
+// SearchUsers finds the users whose full name is similar to the query, the best matches first. the query is
+// compared with the most similar words of the names, so a partial or misspelled name still matches, and the
+// accents & the case are ignored on both sides. the <% operator keeps the matches above the word similarity
+// threshold of pg_trgm (0.6 by default), using the trigram index of the names.
+func (r *Repository) SearchUsers(ctx context.Context, input SearchUsersInput) (output []UserSearchResultOutput, err error) {
+	query := `
+		SELECT
+			id,
+			full_name,
+			phone_number,
+			hashed_password,
+			login_count,
+			token_version,
+			phone_verified_at,
+			locked_until,
+			last_login_at,
+			suspended_at,
+			ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role) AS roles,
+			created_at,
+			word_similarity(search_name($1), search_name(full_name)) AS rank
+		FROM
+			users
+		WHERE
+			search_name($1) <% search_name(full_name)
+			AND deleted_at IS NULL
+		ORDER BY
+			rank DESC,
+			created_at DESC,
+			id DESC
+		LIMIT $2
+	`
+
+	err = r.Db.SelectContext(ctx, &output, query, input.Query, input.Limit)
+
+	return
+}
+
+// likePrefix returns the LIKE pattern matching the values starting with the prefix, its wildcards matched as is
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
 func (r *Repository) UpdateUser(ctx context.Context, id string, input UpdateUserInput) (err error) {
 	query := `
 		UPDATE
 			users
 		SET
 			full_name = $1,
-			phone_number = $2
+			phone_number = $2,
+			phone_verified_at = CASE WHEN phone_number = $2 THEN phone_verified_at ELSE NULL END
 		WHERE
 			id = $3
 	`
@@ -92,14 +259,230 @@ func (r *Repository) UpdateUser(ctx context.Context, id string, input UpdateUser
 	return
 }
 
-func (r *Repository) IncrementLoginCount(ctx context.Context, id string) error {
+// CreateLoginEvent records a login in the history of the user. A successful login also increments the login
+// count & sets the last login time of the user, within the same statement.
+func (r *Repository) CreateLoginEvent(ctx context.Context, input CreateLoginEventInput) error {
 	query := `
+		WITH login_event AS (
+			INSERT INTO
+				login_events
+				(user_id, session_id, method, ip_address, user_agent, succeeded, failure_reason)
+			VALUES
+				($1, $2, $3, $4, $5, $6, $7)
+			RETURNING user_id, succeeded, created_at
+		)
 		UPDATE
 			users
 		SET
-			login_count = login_count + 1
+			login_count = login_count + 1,
+			last_login_at = login_event.created_at
+		FROM
+			login_event
+		WHERE
+			users.id = login_event.user_id
+			AND login_event.succeeded
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, input.SessionID, input.Method, input.IPAddress,
+		input.UserAgent, input.Succeeded, input.FailureReason)
+	if err != nil {
+		return err
+	}
+
+	// a failed login is only recorded in the history
+	expectedRows := int64(0)
+	if input.Succeeded {
+		expectedRows = 1
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != expectedRows {
+		return fmt.Errorf("unexpected behavior: expected update %d row but got %d", expectedRows, rowsAffected)
+	}
+
+	return nil
+}
+
+// ListLoginEvents lists the logins of the user, latest first. The pages are keyed on the event id, so new logins
+// do not shift the following pages.
+func (r *Repository) ListLoginEvents(ctx context.Context, input ListLoginEventsInput) (output []LoginEventOutput, err error) {
+	query := `
+		SELECT
+			id,
+			session_id,
+			method,
+			ip_address,
+			user_agent,
+			succeeded,
+			failure_reason,
+			created_at
+		FROM
+			login_events
+		WHERE
+			user_id = $1
+			AND (CAST($2 AS BIGINT) = 0 OR id < CAST($2 AS BIGINT))
+		ORDER BY
+			id DESC
+		LIMIT $3
+	`
+
+	err = r.Db.SelectContext(ctx, &output, query, input.UserID, input.BeforeID, input.Limit)
+
+	return
+}
+
+// ListUserSessions lists the sessions of the user which can still be refreshed, along with the login which
+// started them. Sessions started by an oauth authorization have no login event, their client is set instead.
+func (r *Repository) ListUserSessions(ctx context.Context, userID string) (output []SessionOutput, err error) {
+	query := `
+		SELECT
+			refresh_tokens.family_id AS id,
+			refresh_tokens.client_id,
+			COALESCE(login_events.ip_address, '') AS ip_address,
+			COALESCE(login_events.user_agent, '') AS user_agent,
+			(
+				SELECT MIN(created_at) FROM refresh_tokens AS family WHERE family.family_id = refresh_tokens.family_id
+			) AS created_at,
+			refresh_tokens.created_at AS last_used_at,
+			refresh_tokens.expires_at
+		FROM
+			refresh_tokens
+			LEFT JOIN login_events ON login_events.session_id = refresh_tokens.family_id AND login_events.succeeded
+		WHERE
+			refresh_tokens.user_id = $1
+			AND refresh_tokens.rotated_at IS NULL
+			AND refresh_tokens.revoked_at IS NULL
+			AND refresh_tokens.expires_at > NOW()
+		ORDER BY
+			refresh_tokens.created_at DESC
+	`
+
+	err = r.Db.SelectContext(ctx, &output, query, userID)
+
+	return
+}
+
+// ListUserConsents lists the oauth clients which can still act for the user, through a live refresh token or an
+// authorization code not exchanged yet
+func (r *Repository) ListUserConsents(ctx context.Context, userID string) (output []ConsentOutput, err error) {
+	query := `
+		SELECT
+			oauth_clients.id AS client_id,
+			oauth_clients.name AS client_name
+		FROM
+			oauth_clients
+		WHERE
+			oauth_clients.id IN (
+				SELECT
+					client_id
+				FROM
+					refresh_tokens
+				WHERE
+					user_id = $1
+					AND client_id IS NOT NULL
+					AND rotated_at IS NULL
+					AND revoked_at IS NULL
+					AND expires_at > NOW()
+				UNION
+				SELECT
+					client_id
+				FROM
+					oauth_authorization_codes
+				WHERE
+					user_id = $1
+					AND used_at IS NULL
+					AND expires_at > NOW()
+			)
+		ORDER BY
+			oauth_clients.id
+	`
+
+	err = r.Db.SelectContext(ctx, &output, query, userID)
+
+	return
+}
+
+// RevokeUserSession revokes the refresh tokens of a session of the user. sql.ErrNoRows is returned when the user
+// has no such session, or when it is already revoked.
+func (r *Repository) RevokeUserSession(ctx context.Context, input RevokeUserSessionInput) error {
+	query := `
+		UPDATE
+			refresh_tokens
+		SET
+			revoked_at = NOW()
+		WHERE
+			family_id = $1
+			AND user_id = $2
+			AND revoked_at IS NULL
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.SessionID, input.UserID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected < 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *Repository) CreateRefreshToken(ctx context.Context, input CreateRefreshTokenInput) error {
+	query := `
+		INSERT INTO
+			refresh_tokens
+			(id, user_id, family_id, token_hash, client_id, scope, expires_at)
+		VALUES
+			(:id, :user_id, :family_id, :token_hash, :client_id, :scope, :expires_at)
+	`
+
+	bindQuery, args, err := sqlx.Named(query, input)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, bindQuery), args...)
+
+	return err
+}
+
+func (r *Repository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (output RefreshTokenOutput, err error) {
+	query := `
+		SELECT
+			id,
+			user_id,
+			family_id,
+			client_id,
+			scope,
+			expires_at,
+			rotated_at,
+			revoked_at
+		FROM
+			refresh_tokens
+		WHERE
+			token_hash = $1
+		LIMIT 1
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, tokenHash)
+
+	return
+}
+
+// RotateRefreshToken marks the refresh token as used. The update only succeeds
+// for an active token, so two requests racing with the same token cannot both rotate it.
+func (r *Repository) RotateRefreshToken(ctx context.Context, id string) error {
+	query := `
+		UPDATE
+			refresh_tokens
+		SET
+			rotated_at = NOW()
 		WHERE
 			id = $1
+			AND rotated_at IS NULL
+			AND revoked_at IS NULL
 	`
 
 	res, err := r.Db.ExecContext(ctx, query, id)
@@ -107,6 +490,171 @@ func (r *Repository) IncrementLoginCount(ctx context.Context, id string) error {
 		return err
 	}
 
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrRefreshTokenNotActive
+	}
+
+	return nil
+}
+
+func (r *Repository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	query := `
+		UPDATE
+			refresh_tokens
+		SET
+			revoked_at = NOW()
+		WHERE
+			family_id = $1
+			AND revoked_at IS NULL
+	`
+
+	_, err := r.Db.ExecContext(ctx, query, familyID)
+
+	return err
+}
+
+func (r *Repository) RevokeAccessToken(ctx context.Context, input RevokeAccessTokenInput) error {
+	query := `
+		INSERT INTO
+			revoked_access_tokens
+			(jti, user_id, expires_at)
+		VALUES
+			(:jti, :user_id, :expires_at)
+		ON CONFLICT (jti) DO NOTHING
+	`
+
+	bindQuery, args, err := sqlx.Named(query, input)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, bindQuery), args...)
+
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the access token was revoked on its own (logout),
+// or was issued before the user revoked all of their sessions (token version bumped).
+// Tokens issued to oauth clients on their own behalf have no user, only the first check applies to them.
+func (r *Repository) IsAccessTokenRevoked(ctx context.Context, input IsAccessTokenRevokedInput) (revoked bool, err error) {
+	query := `
+		SELECT
+			EXISTS (
+				SELECT 1 FROM revoked_access_tokens WHERE jti = $1
+			)
+			OR (
+				CAST($2 AS TEXT) <> ''
+				AND NOT EXISTS (
+					SELECT 1 FROM users WHERE id = CAST(NULLIF($2, '') AS UUID) AND token_version = $3
+				)
+			)
+			OR (
+				CAST($4 AS TEXT) <> ''
+				AND EXISTS (
+					SELECT 1 FROM refresh_tokens
+					WHERE family_id = CAST(NULLIF($4, '') AS UUID) AND revoked_at IS NOT NULL
+				)
+			)
+	`
+
+	err = r.Db.GetContext(ctx, &revoked, query, input.JTI, input.UserID, input.TokenVersion, input.SessionID)
+
+	return
+}
+
+// DeleteExpiredRevokedAccessTokens deletes the revoked access tokens which expired, as they are rejected anyway,
+// and returns their number
+func (r *Repository) DeleteExpiredRevokedAccessTokens(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM
+			revoked_access_tokens
+		WHERE
+			expires_at <= NOW()
+	`
+
+	res, err := r.Db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// RevokeAllUserSessions revokes every refresh token of the user, and bumps the user
+// token version so every access token issued so far is rejected.
+func (r *Repository) RevokeAllUserSessions(ctx context.Context, userID string) error {
+	query := `
+		WITH revoked_refresh_tokens AS (
+			UPDATE
+				refresh_tokens
+			SET
+				revoked_at = NOW()
+			WHERE
+				user_id = $1
+				AND revoked_at IS NULL
+		)
+		UPDATE
+			users
+		SET
+			token_version = token_version + 1
+		WHERE
+			id = $1
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		err = fmt.Errorf("unexpected behavior: expected update 1 row but got %d", rowsAffected)
+		return err
+	}
+
+	return nil
+}
+
+// UpdatePassword replaces the password of the user, keeping the previous one in the password history,
+// and logs every session of the user out at once
+func (r *Repository) UpdatePassword(ctx context.Context, input UpdatePasswordInput) error {
+	query := `
+		WITH previous_password AS (
+			INSERT INTO
+				password_history
+				(user_id, hashed_password)
+			SELECT
+				id,
+				hashed_password
+			FROM
+				users
+			WHERE
+				id = $1
+		),
+		revoked_refresh_tokens AS (
+			UPDATE
+				refresh_tokens
+			SET
+				revoked_at = NOW()
+			WHERE
+				user_id = $1
+				AND revoked_at IS NULL
+		)
+		UPDATE
+			users
+		SET
+			hashed_password = $2,
+			token_version = token_version + 1
+		WHERE
+			id = $1
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, input.HashedPassword)
+	if err != nil {
+		return err
+	}
+
 	rowsAffected, _ := res.RowsAffected()
 	if rowsAffected != 1 {
 		err = fmt.Errorf("unexpected behavior: expected update 1 row but got %d", rowsAffected)
@@ -115,3 +663,1150 @@ func (r *Repository) IncrementLoginCount(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// UpdatePasswordHash replaces the hash of the current password, e.g. with a hash of a stronger algorithm.
+// unlike UpdatePassword, the password stays the same, so the sessions & the password history are kept.
+func (r *Repository) UpdatePasswordHash(ctx context.Context, input UpdatePasswordHashInput) error {
+	query := `
+		UPDATE
+			users
+		SET
+			hashed_password = $3
+		WHERE
+			id = $1
+			AND hashed_password = $2
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, input.PreviousHash, input.HashedPassword)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrPasswordHashChanged
+	}
+
+	return nil
+}
+
+// ListPasswordHistory returns the hashes of the previous passwords of the user, the most recent first
+func (r *Repository) ListPasswordHistory(ctx context.Context, input ListPasswordHistoryInput) (output []string, err error) {
+	query := `
+		SELECT
+			hashed_password
+		FROM
+			password_history
+		WHERE
+			user_id = $1
+		ORDER BY
+			created_at DESC
+		LIMIT $2
+	`
+
+	err = r.Db.SelectContext(ctx, &output, query, input.UserID, input.Limit)
+
+	return
+}
+
+func (r *Repository) GetOAuthClientByID(ctx context.Context, id string) (output OAuthClientOutput, err error) {
+	query := `
+		SELECT
+			id,
+			name,
+			hashed_secret,
+			redirect_uris,
+			scopes,
+			grant_types
+		FROM
+			oauth_clients
+		WHERE
+			id = $1
+		LIMIT 1
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, id)
+
+	return
+}
+
+func (r *Repository) CreateAuthorizationCode(ctx context.Context, input CreateAuthorizationCodeInput) error {
+	query := `
+		INSERT INTO
+			oauth_authorization_codes
+			(code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, nonce, auth_time, expires_at)
+		VALUES
+			(:code_hash, :client_id, :user_id, :redirect_uri, :scope, :code_challenge, :code_challenge_method, :nonce, :auth_time, :expires_at)
+	`
+
+	bindQuery, args, err := sqlx.Named(query, input)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, bindQuery), args...)
+
+	return err
+}
+
+// ConsumeAuthorizationCode marks the authorization code as used & returns it. The update only succeeds
+// for an unused code, so sql.ErrNoRows is returned for unknown codes & codes exchanged before.
+func (r *Repository) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (output AuthorizationCodeOutput, err error) {
+	query := `
+		UPDATE
+			oauth_authorization_codes
+		SET
+			used_at = NOW()
+		WHERE
+			code_hash = $1
+			AND used_at IS NULL
+		RETURNING
+			client_id,
+			user_id,
+			redirect_uri,
+			scope,
+			code_challenge,
+			code_challenge_method,
+			nonce,
+			auth_time,
+			expires_at
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, codeHash)
+
+	return
+}
+
+func (r *Repository) GetTOTPSecret(ctx context.Context, userID string) (output TOTPSecretOutput, err error) {
+	query := `
+		SELECT
+			user_id,
+			encrypted_secret,
+			confirmed_at,
+			last_used_step
+		FROM
+			user_totp_secrets
+		WHERE
+			user_id = $1
+		LIMIT 1
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, userID)
+
+	return
+}
+
+// UpsertTOTPSecret starts a new enrollment, replacing any pending one. A confirmed secret is never
+// replaced, ErrTOTPAlreadyConfirmed is returned instead.
+func (r *Repository) UpsertTOTPSecret(ctx context.Context, input UpsertTOTPSecretInput) error {
+	query := `
+		INSERT INTO
+			user_totp_secrets
+			(user_id, encrypted_secret)
+		VALUES
+			(:user_id, :encrypted_secret)
+		ON CONFLICT (user_id) DO UPDATE
+		SET
+			encrypted_secret = EXCLUDED.encrypted_secret,
+			last_used_step = 0,
+			created_at = NOW()
+		WHERE
+			user_totp_secrets.confirmed_at IS NULL
+	`
+
+	bindQuery, args, err := sqlx.Named(query, input)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.Db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, bindQuery), args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrTOTPAlreadyConfirmed
+	}
+
+	return nil
+}
+
+// ConfirmTOTPSecret confirms a pending enrollment, and replaces the recovery codes of the user
+// in the same statement. ErrTOTPAlreadyConfirmed is returned when there is no pending enrollment.
+func (r *Repository) ConfirmTOTPSecret(ctx context.Context, input ConfirmTOTPSecretInput) error {
+	query := `
+		WITH confirmed_secret AS (
+			UPDATE
+				user_totp_secrets
+			SET
+				confirmed_at = NOW(),
+				last_used_step = $2
+			WHERE
+				user_id = $1
+				AND confirmed_at IS NULL
+			RETURNING
+				user_id
+		), deleted_recovery_codes AS (
+			DELETE FROM
+				user_recovery_codes
+			WHERE
+				user_id IN (SELECT user_id FROM confirmed_secret)
+		)
+		INSERT INTO
+			user_recovery_codes
+			(user_id, code_hash)
+		SELECT
+			confirmed_secret.user_id, code_hash
+		FROM
+			confirmed_secret, UNNEST($3::TEXT[]) AS code_hash
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, input.Step, pq.Array(input.RecoveryCodeHashes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		return ErrTOTPAlreadyConfirmed
+	}
+
+	return nil
+}
+
+// UseTOTPStep records the time step of a code used to log in. The update only succeeds for a step
+// later than the last one used, so a code can never be replayed.
+func (r *Repository) UseTOTPStep(ctx context.Context, input UseTOTPStepInput) error {
+	query := `
+		UPDATE
+			user_totp_secrets
+		SET
+			last_used_step = $2
+		WHERE
+			user_id = $1
+			AND confirmed_at IS NOT NULL
+			AND last_used_step < $2
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, input.Step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrTOTPStepAlreadyUsed
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code as used. ErrRecoveryCodeNotValid is returned for
+// unknown codes & codes used before.
+func (r *Repository) UseRecoveryCode(ctx context.Context, input UseRecoveryCodeInput) error {
+	query := `
+		UPDATE
+			user_recovery_codes
+		SET
+			used_at = NOW()
+		WHERE
+			user_id = $1
+			AND code_hash = $2
+			AND used_at IS NULL
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, input.CodeHash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrRecoveryCodeNotValid
+	}
+
+	return nil
+}
+
+func (r *Repository) CreateMFAChallenge(ctx context.Context, input CreateMFAChallengeInput) error {
+	query := `
+		INSERT INTO
+			mfa_challenges
+			(token_hash, user_id, expires_at)
+		VALUES
+			(:token_hash, :user_id, :expires_at)
+	`
+
+	bindQuery, args, err := sqlx.Named(query, input)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, bindQuery), args...)
+
+	return err
+}
+
+// AttemptMFAChallenge counts an attempt at the challenge & returns it. The update only succeeds for
+// an unused challenge with attempts left, so sql.ErrNoRows is returned once the attempts are exhausted.
+func (r *Repository) AttemptMFAChallenge(ctx context.Context, input AttemptMFAChallengeInput) (output MFAChallengeOutput, err error) {
+	query := `
+		UPDATE
+			mfa_challenges
+		SET
+			attempts = attempts + 1
+		WHERE
+			token_hash = $1
+			AND used_at IS NULL
+			AND attempts < $2
+		RETURNING
+			user_id,
+			expires_at
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, input.TokenHash, input.MaxAttempts)
+
+	return
+}
+
+// ConsumeMFAChallenge marks the challenge as used, so it can only complete a single login
+func (r *Repository) ConsumeMFAChallenge(ctx context.Context, tokenHash string) error {
+	query := `
+		UPDATE
+			mfa_challenges
+		SET
+			used_at = NOW()
+		WHERE
+			token_hash = $1
+			AND used_at IS NULL
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrMFAChallengeNotActive
+	}
+
+	return nil
+}
+
+func (r *Repository) CreateWebAuthnChallenge(ctx context.Context, input CreateWebAuthnChallengeInput) error {
+	query := `
+		INSERT INTO
+			webauthn_challenges
+			(challenge_hash, ceremony, user_id, expires_at)
+		VALUES
+			(:challenge_hash, :ceremony, CAST(NULLIF(:user_id, '') AS UUID), :expires_at)
+	`
+
+	bindQuery, args, err := sqlx.Named(query, input)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, bindQuery), args...)
+
+	return err
+}
+
+// ConsumeWebAuthnChallenge marks the challenge as used & returns it. The update only succeeds for an
+// unused challenge of the same ceremony, so sql.ErrNoRows is returned for unknown & replayed challenges.
+func (r *Repository) ConsumeWebAuthnChallenge(ctx context.Context, input ConsumeWebAuthnChallengeInput) (output WebAuthnChallengeOutput, err error) {
+	query := `
+		UPDATE
+			webauthn_challenges
+		SET
+			used_at = NOW()
+		WHERE
+			challenge_hash = $1
+			AND ceremony = $2
+			AND used_at IS NULL
+		RETURNING
+			COALESCE(user_id::TEXT, '') AS user_id,
+			expires_at
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, input.ChallengeHash, input.Ceremony)
+
+	return
+}
+
+// CreateWebAuthnCredential stores a new passkey. ErrWebAuthnCredentialExists is returned when the
+// credential id is already registered, by this user or another one.
+func (r *Repository) CreateWebAuthnCredential(ctx context.Context, input CreateWebAuthnCredentialInput) error {
+	query := `
+		INSERT INTO
+			webauthn_credentials
+			(id, user_id, public_key, sign_count, transports)
+		VALUES
+			(:id, :user_id, :public_key, :sign_count, :transports)
+		ON CONFLICT (id) DO NOTHING
+	`
+
+	bindQuery, args, err := sqlx.Named(query, input)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.Db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, bindQuery), args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrWebAuthnCredentialExists
+	}
+
+	return nil
+}
+
+func (r *Repository) GetWebAuthnCredential(ctx context.Context, id string) (output WebAuthnCredentialOutput, err error) {
+	query := `
+		SELECT
+			id,
+			user_id,
+			public_key,
+			sign_count,
+			transports,
+			created_at
+		FROM
+			webauthn_credentials
+		WHERE
+			id = $1
+		LIMIT 1
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, id)
+
+	return
+}
+
+func (r *Repository) ListWebAuthnCredentials(ctx context.Context, userID string) (output []WebAuthnCredentialOutput, err error) {
+	query := `
+		SELECT
+			id,
+			user_id,
+			public_key,
+			sign_count,
+			transports,
+			created_at
+		FROM
+			webauthn_credentials
+		WHERE
+			user_id = $1
+		ORDER BY
+			created_at
+	`
+
+	err = r.Db.SelectContext(ctx, &output, query, userID)
+
+	return
+}
+
+// UseWebAuthnCredential records the sign count of an assertion. The update only succeeds when the count
+// increased, or when the authenticator does not implement one, so ErrWebAuthnSignCountNotValid
+// hints at a cloned authenticator.
+func (r *Repository) UseWebAuthnCredential(ctx context.Context, input UseWebAuthnCredentialInput) error {
+	query := `
+		UPDATE
+			webauthn_credentials
+		SET
+			sign_count = $2,
+			last_used_at = NOW()
+		WHERE
+			id = $1
+			AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.ID, input.SignCount)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrWebAuthnSignCountNotValid
+	}
+
+	return nil
+}
+
+// UpsertPhoneOTP stores a new one-time code, replacing the previous code of the same purpose.
+// ErrPhoneOTPResendTooSoon is returned when the previous code was sent less than ResendInterval ago.
+func (r *Repository) UpsertPhoneOTP(ctx context.Context, input UpsertPhoneOTPInput) error {
+	query := `
+		INSERT INTO
+			phone_otp_codes
+			(user_id, purpose, phone_number, code_hash, expires_at)
+		VALUES
+			(:user_id, :purpose, :phone_number, :code_hash, :expires_at)
+		ON CONFLICT (user_id, purpose) DO UPDATE
+		SET
+			phone_number = EXCLUDED.phone_number,
+			code_hash = EXCLUDED.code_hash,
+			attempts = 0,
+			expires_at = EXCLUDED.expires_at,
+			used_at = NULL,
+			created_at = NOW()
+		WHERE
+			phone_otp_codes.created_at <= NOW() - :resend_interval * INTERVAL '1 second'
+	`
+
+	bindQuery, args, err := sqlx.Named(query, input)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.Db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, bindQuery), args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrPhoneOTPResendTooSoon
+	}
+
+	return nil
+}
+
+// AttemptPhoneOTP counts an attempt at the one-time code & returns it. The update only succeeds for an
+// unused code with attempts left, so sql.ErrNoRows is returned once the attempts are exhausted.
+func (r *Repository) AttemptPhoneOTP(ctx context.Context, input AttemptPhoneOTPInput) (output PhoneOTPOutput, err error) {
+	query := `
+		UPDATE
+			phone_otp_codes
+		SET
+			attempts = attempts + 1
+		WHERE
+			user_id = $1
+			AND purpose = $2
+			AND used_at IS NULL
+			AND attempts < $3
+		RETURNING
+			phone_number,
+			code_hash,
+			expires_at
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, input.UserID, input.Purpose, input.MaxAttempts)
+
+	return
+}
+
+// ConsumePhoneOTP marks the one-time code as used, so it can only be used once
+func (r *Repository) ConsumePhoneOTP(ctx context.Context, input ConsumePhoneOTPInput) error {
+	query := `
+		UPDATE
+			phone_otp_codes
+		SET
+			used_at = NOW()
+		WHERE
+			user_id = $1
+			AND purpose = $2
+			AND code_hash = $3
+			AND used_at IS NULL
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, input.Purpose, input.CodeHash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrPhoneOTPNotActive
+	}
+
+	return nil
+}
+
+// VerifyPhoneNumber marks the phone number of the user as verified. The update only succeeds while the
+// user still has the verified phone number, ErrPhoneNumberChanged is returned otherwise.
+func (r *Repository) VerifyPhoneNumber(ctx context.Context, input VerifyPhoneNumberInput) error {
+	query := `
+		UPDATE
+			users
+		SET
+			phone_verified_at = NOW()
+		WHERE
+			id = $1
+			AND phone_number = $2
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, input.PhoneNumber)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return ErrPhoneNumberChanged
+	}
+
+	return nil
+}
+
+// LockUser keeps the user from logging in until the lock expires
+func (r *Repository) LockUser(ctx context.Context, input LockUserInput) error {
+	query := `
+		UPDATE
+			users
+		SET
+			locked_until = $2
+		WHERE
+			id = $1
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, input.LockedUntil)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return fmt.Errorf("unexpected behavior: expected update 1 row but got %d", rowsAffected)
+	}
+
+	return nil
+}
+
+// UnlockUser lifts the login lock of the user, sql.ErrNoRows is returned for an unknown user
+func (r *Repository) UnlockUser(ctx context.Context, userID string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			locked_until = NULL
+		WHERE
+			id = $1
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SuspendUser keeps the user from logging in until they are unsuspended, and logs every session of the user out.
+// sql.ErrNoRows is returned for an unknown user.
+func (r *Repository) SuspendUser(ctx context.Context, userID string) error {
+	query := `
+		WITH revoked_refresh_tokens AS (
+			UPDATE
+				refresh_tokens
+			SET
+				revoked_at = NOW()
+			WHERE
+				user_id = $1
+				AND revoked_at IS NULL
+		)
+		UPDATE
+			users
+		SET
+			suspended_at = COALESCE(suspended_at, NOW()),
+			token_version = token_version + 1
+		WHERE
+			id = $1
+			AND deleted_at IS NULL
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UnsuspendUser lets a suspended user log in again, sql.ErrNoRows is returned for an unknown user
+func (r *Repository) UnsuspendUser(ctx context.Context, userID string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			suspended_at = NULL
+		WHERE
+			id = $1
+			AND deleted_at IS NULL
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ListRoles lists the names of the roles which can be granted
+func (r *Repository) ListRoles(ctx context.Context) (output []string, err error) {
+	query := `
+		SELECT
+			name
+		FROM
+			roles
+		ORDER BY
+			name
+	`
+
+	err = r.Db.SelectContext(ctx, &output, query)
+
+	return
+}
+
+// ListRolePermissions lists the permissions granted by any of the roles
+func (r *Repository) ListRolePermissions(ctx context.Context, roles []string) (output []string, err error) {
+	query := `
+		SELECT DISTINCT
+			permission
+		FROM
+			role_permissions
+		WHERE
+			role = ANY($1)
+		ORDER BY
+			permission
+	`
+
+	err = r.Db.SelectContext(ctx, &output, query, pq.Array(roles))
+
+	return
+}
+
+// SetUserRoles replaces the roles of the user, and logs every session of the user out at once, so no access token
+// carries the previous roles anymore
+func (r *Repository) SetUserRoles(ctx context.Context, input SetUserRolesInput) error {
+	query := `
+		WITH removed_roles AS (
+			DELETE FROM
+				user_roles
+			WHERE
+				user_id = $1
+				AND NOT (role = ANY($2))
+		), added_roles AS (
+			INSERT INTO
+				user_roles
+				(user_id, role)
+			SELECT
+				$1, name
+			FROM
+				roles
+			WHERE
+				name = ANY($2)
+			ON CONFLICT DO NOTHING
+		), revoked_refresh_tokens AS (
+			UPDATE
+				refresh_tokens
+			SET
+				revoked_at = NOW()
+			WHERE
+				user_id = $1
+				AND revoked_at IS NULL
+		)
+		UPDATE
+			users
+		SET
+			token_version = token_version + 1
+		WHERE
+			id = $1
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.UserID, pq.Array(input.Roles))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return fmt.Errorf("unexpected behavior: expected update 1 row but got %d", rowsAffected)
+	}
+
+	return nil
+}
+
+// CreateAdminAuditEvent records an action of an admin on a user, for the audit
+func (r *Repository) CreateAdminAuditEvent(ctx context.Context, input CreateAdminAuditEventInput) error {
+	query := `
+		INSERT INTO
+			admin_audit_events
+			(user_id, action, detail, actor_user_id, actor_client_id)
+		VALUES
+			($1, $2, $3, $4, $5)
+	`
+
+	_, err := r.Db.ExecContext(ctx, query, input.UserID, input.Action, input.Detail, input.ActorUserID,
+		input.ActorClientID)
+
+	return err
+}
+
+// ListAdminAuditEvents lists the actions of the admins on the user, latest first
+func (r *Repository) ListAdminAuditEvents(ctx context.Context, userID string) (output []AdminAuditEventOutput, err error) {
+	query := `
+		SELECT
+			id,
+			action,
+			detail,
+			actor_user_id,
+			actor_client_id,
+			created_at
+		FROM
+			admin_audit_events
+		WHERE
+			user_id = $1
+		ORDER BY
+			id DESC
+	`
+
+	err = r.Db.SelectContext(ctx, &output, query, userID)
+
+	return
+}
+
+// SoftDeleteUser deletes the account of the user, and logs every session of the user out. the user is invisible from
+// then on, their phone number can be registered again, and their personal data is kept until it is erased.
+// sql.ErrNoRows is returned for an unknown or already deleted user.
+func (r *Repository) SoftDeleteUser(ctx context.Context, userID string) error {
+	query := `
+		WITH revoked_refresh_tokens AS (
+			UPDATE
+				refresh_tokens
+			SET
+				revoked_at = NOW()
+			WHERE
+				user_id = $1
+				AND revoked_at IS NULL
+		)
+		UPDATE
+			users
+		SET
+			deleted_at = NOW(),
+			token_version = token_version + 1,
+			updated_at = NOW()
+		WHERE
+			id = $1
+			AND deleted_at IS NULL
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// eraseUsersQuery erases the personal data of the users selected by the subquery it is formatted with. their row is
+// kept as an anonymized tombstone, which the other tables still reference. their credentials, codes, authorization
+// codes & data exports are deleted, their refresh tokens revoked, and their logins are kept for the audit, without
+// their ip address & user agent.
+const eraseUsersQuery = `
+	WITH erased_users AS (
+		UPDATE
+			users
+		SET
+			full_name = '',
+			phone_number = '',
+			hashed_password = '',
+			phone_verified_at = NULL,
+			locked_until = NULL,
+			last_login_at = NULL,
+			deleted_at = COALESCE(deleted_at, NOW()),
+			erased_at = NOW(),
+			token_version = token_version + 1,
+			updated_at = NOW()
+		WHERE
+			id IN (%s)
+		RETURNING
+			id
+	), revoked_refresh_tokens AS (
+		UPDATE
+			refresh_tokens
+		SET
+			revoked_at = NOW()
+		WHERE
+			user_id IN (SELECT id FROM erased_users)
+			AND revoked_at IS NULL
+	), deleted_password_history AS (
+		DELETE FROM password_history WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_totp_secrets AS (
+		DELETE FROM user_totp_secrets WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_recovery_codes AS (
+		DELETE FROM user_recovery_codes WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_mfa_challenges AS (
+		DELETE FROM mfa_challenges WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_webauthn_credentials AS (
+		DELETE FROM webauthn_credentials WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_webauthn_challenges AS (
+		DELETE FROM webauthn_challenges WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_phone_otp_codes AS (
+		DELETE FROM phone_otp_codes WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_user_roles AS (
+		DELETE FROM user_roles WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_data_exports AS (
+		DELETE FROM data_exports WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_authorization_codes AS (
+		DELETE FROM oauth_authorization_codes WHERE user_id IN (SELECT id FROM erased_users)
+	), anonymized_login_events AS (
+		UPDATE
+			login_events
+		SET
+			ip_address = '',
+			user_agent = ''
+		WHERE
+			user_id IN (SELECT id FROM erased_users)
+	)
+	SELECT
+		COUNT(*)
+	FROM
+		erased_users
+`
+
+// EraseUser erases the personal data of the user at once, whether they deleted their account or not.
+// sql.ErrNoRows is returned for an unknown or already erased user.
+func (r *Repository) EraseUser(ctx context.Context, userID string) error {
+	query := fmt.Sprintf(eraseUsersQuery, `
+		SELECT id FROM users WHERE id = $1 AND erased_at IS NULL
+	`)
+
+	var erased int64
+	err := r.Db.GetContext(ctx, &erased, query, userID)
+	if err != nil {
+		return err
+	}
+
+	if erased != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// EraseDeletedUsers erases the personal data of the users who deleted their account before the given time, up to
+// the limit, and returns the number of users erased. the users erased concurrently by another instance are skipped.
+func (r *Repository) EraseDeletedUsers(ctx context.Context, input EraseDeletedUsersInput) (erased int64, err error) {
+	query := fmt.Sprintf(eraseUsersQuery, `
+		SELECT
+			id
+		FROM
+			users
+		WHERE
+			deleted_at < $1
+			AND erased_at IS NULL
+		ORDER BY
+			deleted_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`)
+
+	err = r.Db.GetContext(ctx, &erased, query, input.DeletedBefore, input.Limit)
+
+	return
+}
+
+func (r *Repository) CreateDataExport(ctx context.Context, input CreateDataExportInput) error {
+	query := `
+		INSERT INTO
+			data_exports
+			(id, user_id, format)
+		VALUES
+			(:id, :user_id, :format)
+	`
+
+	bindQuery, args, err := sqlx.Named(query, input)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, bindQuery), args...)
+
+	return err
+}
+
+// GetLatestDataExport returns the latest export requested by the user which has not expired yet.
+// sql.ErrNoRows is returned when there is none.
+func (r *Repository) GetLatestDataExport(ctx context.Context, userID string) (output DataExportOutput, err error) {
+	query := `
+		SELECT
+			id,
+			user_id,
+			format,
+			completed_at,
+			expires_at,
+			created_at
+		FROM
+			data_exports
+		WHERE
+			user_id = $1
+			AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY
+			created_at DESC
+		LIMIT 1
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, userID)
+
+	return
+}
+
+// ClaimDataExport picks the oldest pending export up, along with the ones whose job is considered gone. the exports
+// picked up concurrently by another instance are skipped, and so are the ones of the deleted users.
+// sql.ErrNoRows is returned when there is nothing to export.
+func (r *Repository) ClaimDataExport(ctx context.Context, input ClaimDataExportInput) (output DataExportOutput, err error) {
+	query := `
+		UPDATE
+			data_exports
+		SET
+			started_at = NOW()
+		WHERE
+			id = (
+				SELECT
+					data_exports.id
+				FROM
+					data_exports
+					JOIN users ON users.id = data_exports.user_id
+				WHERE
+					data_exports.completed_at IS NULL
+					AND (data_exports.started_at IS NULL OR data_exports.started_at < $1)
+					AND users.deleted_at IS NULL
+				ORDER BY
+					data_exports.created_at
+				LIMIT 1
+				FOR UPDATE OF data_exports SKIP LOCKED
+			)
+		RETURNING
+			id,
+			user_id,
+			format,
+			completed_at,
+			expires_at,
+			created_at
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, input.StartedBefore)
+
+	return
+}
+
+// CompleteDataExport stores the archive of the export, which can be downloaded from then on.
+// sql.ErrNoRows is returned when the export is unknown or was completed by another job.
+func (r *Repository) CompleteDataExport(ctx context.Context, input CompleteDataExportInput) error {
+	query := `
+		UPDATE
+			data_exports
+		SET
+			archive = $2,
+			completed_at = NOW(),
+			expires_at = $3
+		WHERE
+			id = $1
+			AND completed_at IS NULL
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.ID, input.Archive, input.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetDataExportDownloadToken replaces the download token of a completed export, the previous link stops working.
+// sql.ErrNoRows is returned when the export is unknown, pending or expired.
+func (r *Repository) SetDataExportDownloadToken(ctx context.Context, input SetDataExportDownloadTokenInput) error {
+	query := `
+		UPDATE
+			data_exports
+		SET
+			download_token_hash = $2
+		WHERE
+			id = $1
+			AND completed_at IS NOT NULL
+			AND expires_at > NOW()
+	`
+
+	res, err := r.Db.ExecContext(ctx, query, input.ID, input.TokenHash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetDataExportArchive returns the archive of the export downloaded with the token. sql.ErrNoRows is returned when
+// the token is unknown, the export expired, or its user was deleted.
+func (r *Repository) GetDataExportArchive(ctx context.Context, tokenHash string) (output DataExportArchiveOutput, err error) {
+	query := `
+		SELECT
+			data_exports.format,
+			data_exports.archive,
+			data_exports.completed_at
+		FROM
+			data_exports
+			JOIN users ON users.id = data_exports.user_id
+		WHERE
+			data_exports.download_token_hash = $1
+			AND data_exports.expires_at > NOW()
+			AND users.deleted_at IS NULL
+		LIMIT 1
+	`
+
+	err = r.Db.GetContext(ctx, &output, query, tokenHash)
+
+	return
+}
+
+// DeleteExpiredDataExports deletes the exports which cannot be downloaded anymore, and returns their number
+func (r *Repository) DeleteExpiredDataExports(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM
+			data_exports
+		WHERE
+			expires_at <= NOW()
+	`
+
+	res, err := r.Db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -2,11 +2,16 @@ package repository_test
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/SawitProRecruitment/UserService/repository"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -128,8 +133,9 @@ func TestRepository_GetUserByPhoneNumber(t *testing.T) {
 			name: "successfully fetches a single user by phone number",
 			mockExec: mockExec{
 				data: repository.UserOutput{
-					ID:          "abc123-def456",
-					PhoneNumber: "+62812345567",
+					ID:           "abc123-def456",
+					PhoneNumber:  "+62812345567",
+					TokenVersion: 2,
 				},
 				err: nil,
 			},
@@ -138,8 +144,9 @@ func TestRepository_GetUserByPhoneNumber(t *testing.T) {
 				phoneNumber: "+62812345567",
 			},
 			want: repository.UserOutput{
-				ID:          "abc123-def456",
-				PhoneNumber: "+62812345567",
+				ID:           "abc123-def456",
+				PhoneNumber:  "+62812345567",
+				TokenVersion: 2,
 			},
 			wantErr: false,
 		},
@@ -170,11 +177,19 @@ func TestRepository_GetUserByPhoneNumber(t *testing.T) {
 					full_name,
 					phone_number,
 					hashed_password,
-					login_count
+					login_count,
+					token_version,
+					phone_verified_at,
+					locked_until,
+					last_login_at,
+					suspended_at,
+					ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role) AS roles,
+					created_at
 				FROM
 					users
 				WHERE
 					phone_number = $1
+					AND deleted_at IS NULL
 				LIMIT 1
 			`
 
@@ -182,9 +197,10 @@ func TestRepository_GetUserByPhoneNumber(t *testing.T) {
 			if tt.mockExec.err != nil {
 				expectExec.WillReturnError(tt.mockExec.err)
 			} else {
-				rows := sqlmock.NewRows([]string{"id", "full_name", "phone_number", "hashed_password", "login_count"})
+				rows := sqlmock.NewRows([]string{"id", "full_name", "phone_number", "hashed_password", "login_count", "token_version", "phone_verified_at", "locked_until", "last_login_at", "suspended_at", "roles", "created_at"})
 				mockRow := tt.mockExec.data
-				rows.AddRow(mockRow.ID, mockRow.FullName, mockRow.PhoneNumber, mockRow.HashedPassword, mockRow.LoginCount)
+				roles, _ := mockRow.Roles.Value()
+				rows.AddRow(mockRow.ID, mockRow.FullName, mockRow.PhoneNumber, mockRow.HashedPassword, mockRow.LoginCount, mockRow.TokenVersion, mockRow.PhoneVerifiedAt, mockRow.LockedUntil, mockRow.LastLoginAt, mockRow.SuspendedAt, roles, mockRow.CreatedAt)
 
 				expectExec.WillReturnRows(rows)
 			}
@@ -204,6 +220,8 @@ func TestRepository_GetUserByPhoneNumber(t *testing.T) {
 }
 
 func TestRepository_GetUserByID(t *testing.T) {
+	phoneVerifiedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
 	type mockExec struct {
 		data repository.UserOutput
 		err  error
@@ -223,8 +241,12 @@ func TestRepository_GetUserByID(t *testing.T) {
 			name: "successfully fetches a single user by id",
 			mockExec: mockExec{
 				data: repository.UserOutput{
-					ID:          "abc123-def456",
-					PhoneNumber: "+62812345567",
+					ID:              "abc123-def456",
+					PhoneNumber:     "+62812345567",
+					TokenVersion:    2,
+					PhoneVerifiedAt: &phoneVerifiedAt,
+					Roles:           pq.StringArray{"admin", "support"},
+					CreatedAt:       createdAt,
 				},
 				err: nil,
 			},
@@ -233,8 +255,12 @@ func TestRepository_GetUserByID(t *testing.T) {
 				id:  "abc123-def456",
 			},
 			want: repository.UserOutput{
-				ID:          "abc123-def456",
-				PhoneNumber: "+62812345567",
+				ID:              "abc123-def456",
+				PhoneNumber:     "+62812345567",
+				TokenVersion:    2,
+				PhoneVerifiedAt: &phoneVerifiedAt,
+				Roles:           pq.StringArray{"admin", "support"},
+				CreatedAt:       createdAt,
 			},
 			wantErr: false,
 		},
@@ -265,11 +291,19 @@ func TestRepository_GetUserByID(t *testing.T) {
 					full_name,
 					phone_number,
 					hashed_password,
-					login_count
+					login_count,
+					token_version,
+					phone_verified_at,
+					locked_until,
+					last_login_at,
+					suspended_at,
+					ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role) AS roles,
+					created_at
 				FROM
 					users
 				WHERE
 					id = $1
+					AND deleted_at IS NULL
 				LIMIT 1
 			`
 
@@ -277,9 +311,10 @@ func TestRepository_GetUserByID(t *testing.T) {
 			if tt.mockExec.err != nil {
 				expectExec.WillReturnError(tt.mockExec.err)
 			} else {
-				rows := sqlmock.NewRows([]string{"id", "full_name", "phone_number", "hashed_password", "login_count"})
+				rows := sqlmock.NewRows([]string{"id", "full_name", "phone_number", "hashed_password", "login_count", "token_version", "phone_verified_at", "locked_until", "last_login_at", "suspended_at", "roles", "created_at"})
 				mockRow := tt.mockExec.data
-				rows.AddRow(mockRow.ID, mockRow.FullName, mockRow.PhoneNumber, mockRow.HashedPassword, mockRow.LoginCount)
+				roles, _ := mockRow.Roles.Value()
+				rows.AddRow(mockRow.ID, mockRow.FullName, mockRow.PhoneNumber, mockRow.HashedPassword, mockRow.LoginCount, mockRow.TokenVersion, mockRow.PhoneVerifiedAt, mockRow.LockedUntil, mockRow.LastLoginAt, mockRow.SuspendedAt, roles, mockRow.CreatedAt)
 
 				expectExec.WillReturnRows(rows)
 			}
@@ -375,7 +410,8 @@ func TestRepository_UpdateUser(t *testing.T) {
 					users
 				SET
 					full_name = $1,
-					phone_number = $2
+					phone_number = $2,
+					phone_verified_at = CASE WHEN phone_number = $2 THEN phone_verified_at ELSE NULL END
 				WHERE
 					id = $3
 			`
@@ -400,14 +436,30 @@ func TestRepository_UpdateUser(t *testing.T) {
 	}
 }
 
-func TestRepository_IncrementLoginCount(t *testing.T) {
+func TestRepository_CreateLoginEvent(t *testing.T) {
+	sessionID := "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a"
 	type mockExec struct {
 		err          error
 		affectedRows int
 	}
 	type args struct {
-		ctx context.Context
-		id  string
+		ctx   context.Context
+		input repository.CreateLoginEventInput
+	}
+	succeeded := repository.CreateLoginEventInput{
+		UserID:    "abc123-def456",
+		SessionID: &sessionID,
+		Method:    "password",
+		IPAddress: "192.0.2.1",
+		UserAgent: "Mozilla/5.0",
+		Succeeded: true,
+	}
+	failed := repository.CreateLoginEventInput{
+		UserID:        "abc123-def456",
+		Method:        "password",
+		IPAddress:     "192.0.2.1",
+		UserAgent:     "Mozilla/5.0",
+		FailureReason: "invalid_password",
 	}
 	tests := []struct {
 		name     string
@@ -416,36 +468,46 @@ func TestRepository_IncrementLoginCount(t *testing.T) {
 		wantErr  bool
 	}{
 		{
-			name: "successfully increment single user login count by id",
+			name: "successfully records a successful login & increments the login count",
 			mockExec: mockExec{
-				err:          nil,
 				affectedRows: 1,
 			},
 			args: args{
-				ctx: context.Background(),
-				id:  "abc123-def456",
+				ctx:   context.Background(),
+				input: succeeded,
+			},
+			wantErr: false,
+		},
+		{
+			name: "successfully records a failed login",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: failed,
 			},
 			wantErr: false,
 		},
 		{
-			name: "error when increment user login count by id",
+			name: "error when recording the login",
 			mockExec: mockExec{
 				err: assert.AnError,
 			},
 			args: args{
-				ctx: context.Background(),
-				id:  "abc123-def456",
+				ctx:   context.Background(),
+				input: succeeded,
 			},
 			wantErr: true,
 		},
 		{
-			name: "error when increment user login count due to affected rows is 0",
+			name: "error when the login count of a successful login is not incremented",
 			mockExec: mockExec{
 				affectedRows: 0,
 			},
 			args: args{
-				ctx: context.Background(),
-				id:  "abc123-def456",
+				ctx:   context.Background(),
+				input: succeeded,
 			},
 			wantErr: true,
 		},
@@ -460,15 +522,29 @@ func TestRepository_IncrementLoginCount(t *testing.T) {
 			defer db.Close()
 
 			query := `
+				WITH login_event AS (
+					INSERT INTO
+						login_events
+						(user_id, session_id, method, ip_address, user_agent, succeeded, failure_reason)
+					VALUES
+						($1, $2, $3, $4, $5, $6, $7)
+					RETURNING user_id, succeeded, created_at
+				)
 				UPDATE
 					users
 				SET
-					login_count = login_count + 1
+					login_count = login_count + 1,
+					last_login_at = login_event.created_at
+				FROM
+					login_event
 				WHERE
-					id = $1
+					users.id = login_event.user_id
+					AND login_event.succeeded
 			`
 
-			expectExec := m.ExpectExec(query).WithArgs(tt.args.id)
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.UserID, input.SessionID, input.Method, input.IPAddress,
+				input.UserAgent, input.Succeeded, input.FailureReason)
 			if tt.mockExec.err != nil {
 				expectExec.WillReturnError(tt.mockExec.err)
 			} else {
@@ -478,11 +554,5558 @@ func TestRepository_IncrementLoginCount(t *testing.T) {
 			sqlxDB := sqlx.NewDb(db, "sqlmock")
 			r := repository.Repository{Db: sqlxDB}
 
-			err = r.IncrementLoginCount(tt.args.ctx, tt.args.id)
+			err = r.CreateLoginEvent(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_CreateRefreshToken(t *testing.T) {
+	clientID := "mobile-app"
+
+	type mockExec struct {
+		err error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.CreateRefreshTokenInput
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully inserts refresh token",
+			args: args{
+				ctx: context.Background(),
+				input: repository.CreateRefreshTokenInput{
+					ID:        "token-id",
+					UserID:    "abc123-def456",
+					FamilyID:  "family-id",
+					TokenHash: "token-hash",
+					ExpiresAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "successfully inserts refresh token issued to an oauth client",
+			args: args{
+				ctx: context.Background(),
+				input: repository.CreateRefreshTokenInput{
+					ID:        "token-id",
+					UserID:    "abc123-def456",
+					FamilyID:  "family-id",
+					TokenHash: "token-hash",
+					ClientID:  &clientID,
+					Scope:     "profile phone",
+					ExpiresAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when inserting refresh token",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.CreateRefreshTokenInput{
+					ID:        "token-id",
+					UserID:    "abc123-def456",
+					FamilyID:  "family-id",
+					TokenHash: "token-hash",
+					ExpiresAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					refresh_tokens
+					(id, user_id, family_id, token_hash, client_id, scope, expires_at)
+				VALUES
+					($1, $2, $3, $4, $5, $6, $7)
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.ID, input.UserID, input.FamilyID, input.TokenHash, input.ClientID, input.Scope, input.ExpiresAt)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.CreateRefreshToken(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_GetRefreshTokenByHash(t *testing.T) {
+	rotatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type mockExec struct {
+		data repository.RefreshTokenOutput
+		err  error
+	}
+	type args struct {
+		ctx       context.Context
+		tokenHash string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.RefreshTokenOutput
+		wantErr  bool
+	}{
+		{
+			name: "successfully fetches a refresh token by hash",
+			mockExec: mockExec{
+				data: repository.RefreshTokenOutput{
+					ID:        "token-id",
+					UserID:    "abc123-def456",
+					FamilyID:  "family-id",
+					ExpiresAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+					RotatedAt: &rotatedAt,
+				},
+			},
+			args: args{
+				ctx:       context.Background(),
+				tokenHash: "token-hash",
+			},
+			want: repository.RefreshTokenOutput{
+				ID:        "token-id",
+				UserID:    "abc123-def456",
+				FamilyID:  "family-id",
+				ExpiresAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+				RotatedAt: &rotatedAt,
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when fetching refresh token by hash",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:       context.Background(),
+				tokenHash: "token-hash",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					id,
+					user_id,
+					family_id,
+					client_id,
+					scope,
+					expires_at,
+					rotated_at,
+					revoked_at
+				FROM
+					refresh_tokens
+				WHERE
+					token_hash = $1
+				LIMIT 1
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.tokenHash)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"id", "user_id", "family_id", "client_id", "scope", "expires_at", "rotated_at", "revoked_at"})
+				mockRow := tt.mockExec.data
+				rows.AddRow(mockRow.ID, mockRow.UserID, mockRow.FamilyID, mockRow.ClientID, mockRow.Scope, mockRow.ExpiresAt, mockRow.RotatedAt, mockRow.RevokedAt)
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.GetRefreshTokenByHash(tt.args.ctx, tt.args.tokenHash)
 			if tt.wantErr {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_RotateRefreshToken(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx context.Context
+		id  string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully rotates an active refresh token",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx: context.Background(),
+				id:  "token-id",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "error when rotating refresh token",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				id:  "token-id",
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when refresh token is already rotated or revoked",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx: context.Background(),
+				id:  "token-id",
+			},
+			wantErr: repository.ErrRefreshTokenNotActive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					refresh_tokens
+				SET
+					rotated_at = NOW()
+				WHERE
+					id = $1
+					AND rotated_at IS NULL
+					AND revoked_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.id)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.RotateRefreshToken(tt.args.ctx, tt.args.id)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_RevokeRefreshTokenFamily(t *testing.T) {
+	type mockExec struct {
+		err error
+	}
+	type args struct {
+		ctx      context.Context
+		familyID string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully revokes a refresh token family",
+			args: args{
+				ctx:      context.Background(),
+				familyID: "family-id",
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when revoking a refresh token family",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:      context.Background(),
+				familyID: "family-id",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					refresh_tokens
+				SET
+					revoked_at = NOW()
+				WHERE
+					family_id = $1
+					AND revoked_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.familyID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, 2))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.RevokeRefreshTokenFamily(tt.args.ctx, tt.args.familyID)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_RevokeAccessToken(t *testing.T) {
+	type mockExec struct {
+		err error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.RevokeAccessTokenInput
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully revokes an access token",
+			args: args{
+				ctx: context.Background(),
+				input: repository.RevokeAccessTokenInput{
+					JTI:       "b5f8e2f3-3a4c-4c6e-9b1a-2f1f5d1c9e11",
+					UserID:    "abc123-def456",
+					ExpiresAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when revoking an access token",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.RevokeAccessTokenInput{
+					JTI:       "b5f8e2f3-3a4c-4c6e-9b1a-2f1f5d1c9e11",
+					UserID:    "abc123-def456",
+					ExpiresAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					revoked_access_tokens
+					(jti, user_id, expires_at)
+				VALUES
+					($1, $2, $3)
+				ON CONFLICT (jti) DO NOTHING
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.JTI, input.UserID, input.ExpiresAt)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.RevokeAccessToken(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_IsAccessTokenRevoked(t *testing.T) {
+	type mockExec struct {
+		revoked bool
+		err     error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.IsAccessTokenRevokedInput
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     bool
+		wantErr  bool
+	}{
+		{
+			name: "access token is still valid",
+			mockExec: mockExec{
+				revoked: false,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.IsAccessTokenRevokedInput{
+					JTI:          "b5f8e2f3-3a4c-4c6e-9b1a-2f1f5d1c9e11",
+					UserID:       "abc123-def456",
+					TokenVersion: 1,
+				},
+			},
+			want:    false,
+			wantErr: false,
+		},
+		{
+			name: "access token is revoked",
+			mockExec: mockExec{
+				revoked: true,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.IsAccessTokenRevokedInput{
+					JTI:          "b5f8e2f3-3a4c-4c6e-9b1a-2f1f5d1c9e11",
+					UserID:       "abc123-def456",
+					TokenVersion: 1,
+					SessionID:    "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+				},
+			},
+			want:    true,
+			wantErr: false,
+		},
+		{
+			name: "error when checking access token revocation",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.IsAccessTokenRevokedInput{
+					JTI:          "b5f8e2f3-3a4c-4c6e-9b1a-2f1f5d1c9e11",
+					UserID:       "abc123-def456",
+					TokenVersion: 1,
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					EXISTS (
+						SELECT 1 FROM revoked_access_tokens WHERE jti = $1
+					)
+					OR (
+						CAST($2 AS TEXT) <> ''
+						AND NOT EXISTS (
+							SELECT 1 FROM users WHERE id = CAST(NULLIF($2, '') AS UUID) AND token_version = $3
+						)
+					)
+					OR (
+						CAST($4 AS TEXT) <> ''
+						AND EXISTS (
+							SELECT 1 FROM refresh_tokens
+							WHERE family_id = CAST(NULLIF($4, '') AS UUID) AND revoked_at IS NOT NULL
+						)
+					)
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectQuery(query).WithArgs(input.JTI, input.UserID, input.TokenVersion, input.SessionID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnRows(sqlmock.NewRows([]string{"revoked"}).AddRow(tt.mockExec.revoked))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.IsAccessTokenRevoked(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_DeleteExpiredRevokedAccessTokens(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		want     int64
+		wantErr  bool
+	}{
+		{
+			name: "successfully deletes the expired revoked access tokens",
+			mockExec: mockExec{
+				affectedRows: 3,
+			},
+			want: 3,
+		},
+		{
+			name: "error when deleting the expired revoked access tokens",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				DELETE FROM
+					revoked_access_tokens
+				WHERE
+					expires_at <= NOW()
+			`
+
+			expectExec := m.ExpectExec(query)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.DeleteExpiredRevokedAccessTokens(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_RevokeAllUserSessions(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully revokes all sessions of the user",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when revoking all sessions of the user",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: true,
+		},
+		{
+			name: "error when revoking sessions due to affected rows is 0",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				WITH revoked_refresh_tokens AS (
+					UPDATE
+						refresh_tokens
+					SET
+						revoked_at = NOW()
+					WHERE
+						user_id = $1
+						AND revoked_at IS NULL
+				)
+				UPDATE
+					users
+				SET
+					token_version = token_version + 1
+				WHERE
+					id = $1
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.RevokeAllUserSessions(tt.args.ctx, tt.args.userID)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_UpdatePassword(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.UpdatePasswordInput
+	}
+	input := repository.UpdatePasswordInput{
+		UserID:         "abc123-def456",
+		HashedPassword: "hashed-password",
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully updates the password & revokes all sessions",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when updating the password",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: true,
+		},
+		{
+			name: "error when updating the password due to affected rows is 0",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				WITH previous_password AS (
+					INSERT INTO
+						password_history
+						(user_id, hashed_password)
+					SELECT
+						id,
+						hashed_password
+					FROM
+						users
+					WHERE
+						id = $1
+				),
+				revoked_refresh_tokens AS (
+					UPDATE
+						refresh_tokens
+					SET
+						revoked_at = NOW()
+					WHERE
+						user_id = $1
+						AND revoked_at IS NULL
+				)
+				UPDATE
+					users
+				SET
+					hashed_password = $2,
+					token_version = token_version + 1
+				WHERE
+					id = $1
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.UserID, tt.args.input.HashedPassword)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.UpdatePassword(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_UpdatePasswordHash(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.UpdatePasswordHashInput
+	}
+	input := repository.UpdatePasswordHashInput{
+		UserID:         "abc123-def456",
+		PreviousHash:   "$2a$10$previous",
+		HashedPassword: "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$a2V5",
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully replaces the password hash",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when replacing the password hash",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the password changed in the meantime",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrPasswordHashChanged,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					users
+				SET
+					hashed_password = $3
+				WHERE
+					id = $1
+					AND hashed_password = $2
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.UserID, input.PreviousHash, input.HashedPassword)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.UpdatePasswordHash(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_ListPasswordHistory(t *testing.T) {
+	type mockExec struct {
+		data []string
+		err  error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.ListPasswordHistoryInput
+	}
+	input := repository.ListPasswordHistoryInput{
+		UserID: "abc123-def456",
+		Limit:  4,
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "successfully lists the previous passwords",
+			mockExec: mockExec{
+				data: []string{"hashed-password-2", "hashed-password-1"},
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			want:    []string{"hashed-password-2", "hashed-password-1"},
+			wantErr: false,
+		},
+		{
+			name: "error when listing the previous passwords",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					hashed_password
+				FROM
+					password_history
+				WHERE
+					user_id = $1
+				ORDER BY
+					created_at DESC
+				LIMIT $2
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.input.UserID, tt.args.input.Limit)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"hashed_password"})
+				for _, hash := range tt.mockExec.data {
+					rows.AddRow(hash)
+				}
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ListPasswordHistory(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_GetOAuthClientByID(t *testing.T) {
+	type mockExec struct {
+		data repository.OAuthClientOutput
+		err  error
+	}
+	type args struct {
+		ctx context.Context
+		id  string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.OAuthClientOutput
+		wantErr  bool
+	}{
+		{
+			name: "successfully fetches an oauth client by id",
+			mockExec: mockExec{
+				data: repository.OAuthClientOutput{
+					ID:           "billing-service",
+					Name:         "Billing Service",
+					HashedSecret: "hashed-secret",
+					RedirectURIs: "https://billing.example.com/callback",
+					Scopes:       "profile phone",
+					GrantTypes:   "authorization_code client_credentials",
+				},
+			},
+			args: args{
+				ctx: context.Background(),
+				id:  "billing-service",
+			},
+			want: repository.OAuthClientOutput{
+				ID:           "billing-service",
+				Name:         "Billing Service",
+				HashedSecret: "hashed-secret",
+				RedirectURIs: "https://billing.example.com/callback",
+				Scopes:       "profile phone",
+				GrantTypes:   "authorization_code client_credentials",
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when fetching oauth client by id",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				id:  "billing-service",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					id,
+					name,
+					hashed_secret,
+					redirect_uris,
+					scopes,
+					grant_types
+				FROM
+					oauth_clients
+				WHERE
+					id = $1
+				LIMIT 1
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.id)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"id", "name", "hashed_secret", "redirect_uris", "scopes", "grant_types"})
+				mockRow := tt.mockExec.data
+				rows.AddRow(mockRow.ID, mockRow.Name, mockRow.HashedSecret, mockRow.RedirectURIs, mockRow.Scopes, mockRow.GrantTypes)
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.GetOAuthClientByID(tt.args.ctx, tt.args.id)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_CreateAuthorizationCode(t *testing.T) {
+	type mockExec struct {
+		err error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.CreateAuthorizationCodeInput
+	}
+	input := repository.CreateAuthorizationCodeInput{
+		CodeHash:            "code-hash",
+		ClientID:            "mobile-app",
+		UserID:              "abc123-def456",
+		RedirectURI:         "com.example.app:/callback",
+		Scope:               "profile",
+		CodeChallenge:       "code-challenge",
+		CodeChallengeMethod: "S256",
+		Nonce:               "nonce",
+		AuthTime:            time.Date(2023, 12, 31, 23, 55, 0, 0, time.UTC),
+		ExpiresAt:           time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully inserts authorization code",
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when inserting authorization code",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					oauth_authorization_codes
+					(code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, nonce, auth_time, expires_at)
+				VALUES
+					($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.CodeHash, input.ClientID, input.UserID, input.RedirectURI, input.Scope, input.CodeChallenge, input.CodeChallengeMethod, input.Nonce, input.AuthTime, input.ExpiresAt)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.CreateAuthorizationCode(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_ConsumeAuthorizationCode(t *testing.T) {
+	type mockExec struct {
+		data repository.AuthorizationCodeOutput
+		err  error
+	}
+	type args struct {
+		ctx      context.Context
+		codeHash string
+	}
+	code := repository.AuthorizationCodeOutput{
+		ClientID:            "mobile-app",
+		UserID:              "abc123-def456",
+		RedirectURI:         "com.example.app:/callback",
+		Scope:               "profile",
+		CodeChallenge:       "code-challenge",
+		CodeChallengeMethod: "S256",
+		Nonce:               "nonce",
+		AuthTime:            time.Date(2023, 12, 31, 23, 55, 0, 0, time.UTC),
+		ExpiresAt:           time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.AuthorizationCodeOutput
+		wantErr  error
+	}{
+		{
+			name: "successfully consumes an unused authorization code",
+			mockExec: mockExec{
+				data: code,
+			},
+			args: args{
+				ctx:      context.Background(),
+				codeHash: "code-hash",
+			},
+			want: code,
+		},
+		{
+			name: "no rows for an unknown or already used authorization code",
+			mockExec: mockExec{
+				err: sql.ErrNoRows,
+			},
+			args: args{
+				ctx:      context.Background(),
+				codeHash: "code-hash",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					oauth_authorization_codes
+				SET
+					used_at = NOW()
+				WHERE
+					code_hash = $1
+					AND used_at IS NULL
+				RETURNING
+					client_id,
+					user_id,
+					redirect_uri,
+					scope,
+					code_challenge,
+					code_challenge_method,
+					nonce,
+					auth_time,
+					expires_at
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.codeHash)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"client_id", "user_id", "redirect_uri", "scope", "code_challenge", "code_challenge_method", "nonce", "auth_time", "expires_at"})
+				mockRow := tt.mockExec.data
+				rows.AddRow(mockRow.ClientID, mockRow.UserID, mockRow.RedirectURI, mockRow.Scope, mockRow.CodeChallenge, mockRow.CodeChallengeMethod, mockRow.Nonce, mockRow.AuthTime, mockRow.ExpiresAt)
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ConsumeAuthorizationCode(tt.args.ctx, tt.args.codeHash)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_GetTOTPSecret(t *testing.T) {
+	type mockExec struct {
+		data repository.TOTPSecretOutput
+		err  error
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	confirmedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.TOTPSecretOutput
+		wantErr  error
+	}{
+		{
+			name: "successfully gets the totp secret",
+			mockExec: mockExec{
+				data: repository.TOTPSecretOutput{
+					UserID:          "abc123-def456",
+					EncryptedSecret: "encrypted-secret",
+					ConfirmedAt:     &confirmedAt,
+					LastUsedStep:    56666666,
+				},
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			want: repository.TOTPSecretOutput{
+				UserID:          "abc123-def456",
+				EncryptedSecret: "encrypted-secret",
+				ConfirmedAt:     &confirmedAt,
+				LastUsedStep:    56666666,
+			},
+		},
+		{
+			name: "no rows when the user is not enrolled",
+			mockExec: mockExec{
+				err: sql.ErrNoRows,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					user_id,
+					encrypted_secret,
+					confirmed_at,
+					last_used_step
+				FROM
+					user_totp_secrets
+				WHERE
+					user_id = $1
+				LIMIT 1
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				mockRow := tt.mockExec.data
+				rows := sqlmock.NewRows([]string{"user_id", "encrypted_secret", "confirmed_at", "last_used_step"}).
+					AddRow(mockRow.UserID, mockRow.EncryptedSecret, mockRow.ConfirmedAt, mockRow.LastUsedStep)
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.GetTOTPSecret(tt.args.ctx, tt.args.userID)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_UpsertTOTPSecret(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.UpsertTOTPSecretInput
+	}
+	input := repository.UpsertTOTPSecretInput{
+		UserID:          "abc123-def456",
+		EncryptedSecret: "encrypted-secret",
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully starts the enrollment",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when storing the secret",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the enrollment is already confirmed",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrTOTPAlreadyConfirmed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					user_totp_secrets
+					(user_id, encrypted_secret)
+				VALUES
+					($1, $2)
+				ON CONFLICT (user_id) DO UPDATE
+				SET
+					encrypted_secret = EXCLUDED.encrypted_secret,
+					last_used_step = 0,
+					created_at = NOW()
+				WHERE
+					user_totp_secrets.confirmed_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.UserID, tt.args.input.EncryptedSecret)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.UpsertTOTPSecret(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_ConfirmTOTPSecret(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.ConfirmTOTPSecretInput
+	}
+	input := repository.ConfirmTOTPSecretInput{
+		UserID:             "abc123-def456",
+		Step:               56666666,
+		RecoveryCodeHashes: []string{"code-hash-1", "code-hash-2"},
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully confirms the enrollment & stores the recovery codes",
+			mockExec: mockExec{
+				affectedRows: 2,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when confirming the enrollment",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when there is no pending enrollment",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrTOTPAlreadyConfirmed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				WITH confirmed_secret AS (
+					UPDATE
+						user_totp_secrets
+					SET
+						confirmed_at = NOW(),
+						last_used_step = $2
+					WHERE
+						user_id = $1
+						AND confirmed_at IS NULL
+					RETURNING
+						user_id
+				), deleted_recovery_codes AS (
+					DELETE FROM
+						user_recovery_codes
+					WHERE
+						user_id IN (SELECT user_id FROM confirmed_secret)
+				)
+				INSERT INTO
+					user_recovery_codes
+					(user_id, code_hash)
+				SELECT
+					confirmed_secret.user_id, code_hash
+				FROM
+					confirmed_secret, UNNEST($3::TEXT[]) AS code_hash
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.UserID, input.Step, pq.Array(input.RecoveryCodeHashes))
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.ConfirmTOTPSecret(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_UseTOTPStep(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.UseTOTPStepInput
+	}
+	input := repository.UseTOTPStepInput{
+		UserID: "abc123-def456",
+		Step:   56666667,
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully uses a new time step",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when using the time step",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the time step has already been used",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrTOTPStepAlreadyUsed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					user_totp_secrets
+				SET
+					last_used_step = $2
+				WHERE
+					user_id = $1
+					AND confirmed_at IS NOT NULL
+					AND last_used_step < $2
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.UserID, tt.args.input.Step)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.UseTOTPStep(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_UseRecoveryCode(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.UseRecoveryCodeInput
+	}
+	input := repository.UseRecoveryCodeInput{
+		UserID:   "abc123-def456",
+		CodeHash: "code-hash",
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully uses the recovery code",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when using the recovery code",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the recovery code is unknown or already used",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrRecoveryCodeNotValid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					user_recovery_codes
+				SET
+					used_at = NOW()
+				WHERE
+					user_id = $1
+					AND code_hash = $2
+					AND used_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.UserID, tt.args.input.CodeHash)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.UseRecoveryCode(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_CreateMFAChallenge(t *testing.T) {
+	type mockExec struct {
+		err error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.CreateMFAChallengeInput
+	}
+	input := repository.CreateMFAChallengeInput{
+		TokenHash: "token-hash",
+		UserID:    "abc123-def456",
+		ExpiresAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully inserts the mfa challenge",
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when inserting the mfa challenge",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					mfa_challenges
+					(token_hash, user_id, expires_at)
+				VALUES
+					($1, $2, $3)
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.TokenHash, input.UserID, input.ExpiresAt)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.CreateMFAChallenge(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_AttemptMFAChallenge(t *testing.T) {
+	type mockExec struct {
+		data repository.MFAChallengeOutput
+		err  error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.AttemptMFAChallengeInput
+	}
+	input := repository.AttemptMFAChallengeInput{
+		TokenHash:   "token-hash",
+		MaxAttempts: 5,
+	}
+	challenge := repository.MFAChallengeOutput{
+		UserID:    "abc123-def456",
+		ExpiresAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.MFAChallengeOutput
+		wantErr  error
+	}{
+		{
+			name: "successfully counts an attempt",
+			mockExec: mockExec{
+				data: challenge,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			want: challenge,
+		},
+		{
+			name: "no rows for an unknown, used or exhausted challenge",
+			mockExec: mockExec{
+				err: sql.ErrNoRows,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					mfa_challenges
+				SET
+					attempts = attempts + 1
+				WHERE
+					token_hash = $1
+					AND used_at IS NULL
+					AND attempts < $2
+				RETURNING
+					user_id,
+					expires_at
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.input.TokenHash, tt.args.input.MaxAttempts)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"user_id", "expires_at"}).
+					AddRow(tt.mockExec.data.UserID, tt.mockExec.data.ExpiresAt)
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.AttemptMFAChallenge(tt.args.ctx, tt.args.input)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_ConsumeMFAChallenge(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx       context.Context
+		tokenHash string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully consumes the mfa challenge",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:       context.Background(),
+				tokenHash: "token-hash",
+			},
+		},
+		{
+			name: "error when consuming the mfa challenge",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:       context.Background(),
+				tokenHash: "token-hash",
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the mfa challenge has already been used",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:       context.Background(),
+				tokenHash: "token-hash",
+			},
+			wantErr: repository.ErrMFAChallengeNotActive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					mfa_challenges
+				SET
+					used_at = NOW()
+				WHERE
+					token_hash = $1
+					AND used_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.tokenHash)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.ConsumeMFAChallenge(tt.args.ctx, tt.args.tokenHash)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_CreateWebAuthnChallenge(t *testing.T) {
+	type mockExec struct {
+		err error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.CreateWebAuthnChallengeInput
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully inserts a registration challenge",
+			args: args{
+				ctx: context.Background(),
+				input: repository.CreateWebAuthnChallengeInput{
+					ChallengeHash: "challenge-hash",
+					Ceremony:      "registration",
+					UserID:        "abc123-def456",
+					ExpiresAt:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "successfully inserts an authentication challenge without user",
+			args: args{
+				ctx: context.Background(),
+				input: repository.CreateWebAuthnChallengeInput{
+					ChallengeHash: "challenge-hash",
+					Ceremony:      "authentication",
+					ExpiresAt:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when inserting the challenge",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.CreateWebAuthnChallengeInput{
+					ChallengeHash: "challenge-hash",
+					Ceremony:      "authentication",
+					ExpiresAt:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					webauthn_challenges
+					(challenge_hash, ceremony, user_id, expires_at)
+				VALUES
+					($1, $2, CAST(NULLIF($3, '') AS UUID), $4)
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.ChallengeHash, input.Ceremony, input.UserID, input.ExpiresAt)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.CreateWebAuthnChallenge(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_ConsumeWebAuthnChallenge(t *testing.T) {
+	type mockExec struct {
+		data repository.WebAuthnChallengeOutput
+		err  error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.ConsumeWebAuthnChallengeInput
+	}
+	input := repository.ConsumeWebAuthnChallengeInput{
+		ChallengeHash: "challenge-hash",
+		Ceremony:      "registration",
+	}
+	challenge := repository.WebAuthnChallengeOutput{
+		UserID:    "abc123-def456",
+		ExpiresAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.WebAuthnChallengeOutput
+		wantErr  error
+	}{
+		{
+			name: "successfully consumes the challenge",
+			mockExec: mockExec{
+				data: challenge,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			want: challenge,
+		},
+		{
+			name: "no rows for an unknown or used challenge",
+			mockExec: mockExec{
+				err: sql.ErrNoRows,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					webauthn_challenges
+				SET
+					used_at = NOW()
+				WHERE
+					challenge_hash = $1
+					AND ceremony = $2
+					AND used_at IS NULL
+				RETURNING
+					COALESCE(user_id::TEXT, '') AS user_id,
+					expires_at
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.input.ChallengeHash, tt.args.input.Ceremony)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"user_id", "expires_at"}).
+					AddRow(tt.mockExec.data.UserID, tt.mockExec.data.ExpiresAt)
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ConsumeWebAuthnChallenge(tt.args.ctx, tt.args.input)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_CreateWebAuthnCredential(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.CreateWebAuthnCredentialInput
+	}
+	input := repository.CreateWebAuthnCredentialInput{
+		ID:         "credential-id",
+		UserID:     "abc123-def456",
+		PublicKey:  []byte("cose-key"),
+		SignCount:  1,
+		Transports: "internal hybrid",
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully inserts the credential",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when inserting the credential",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the credential is already registered",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrWebAuthnCredentialExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					webauthn_credentials
+					(id, user_id, public_key, sign_count, transports)
+				VALUES
+					($1, $2, $3, $4, $5)
+				ON CONFLICT (id) DO NOTHING
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.ID, input.UserID, input.PublicKey, input.SignCount, input.Transports)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.CreateWebAuthnCredential(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_GetWebAuthnCredential(t *testing.T) {
+	type mockExec struct {
+		data repository.WebAuthnCredentialOutput
+		err  error
+	}
+	type args struct {
+		ctx context.Context
+		id  string
+	}
+	credential := repository.WebAuthnCredentialOutput{
+		ID:         "credential-id",
+		UserID:     "abc123-def456",
+		PublicKey:  []byte("cose-key"),
+		SignCount:  3,
+		Transports: "internal",
+		CreatedAt:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.WebAuthnCredentialOutput
+		wantErr  error
+	}{
+		{
+			name: "successfully gets the credential",
+			mockExec: mockExec{
+				data: credential,
+			},
+			args: args{
+				ctx: context.Background(),
+				id:  "credential-id",
+			},
+			want: credential,
+		},
+		{
+			name: "no rows for an unknown credential",
+			mockExec: mockExec{
+				err: sql.ErrNoRows,
+			},
+			args: args{
+				ctx: context.Background(),
+				id:  "credential-id",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					id,
+					user_id,
+					public_key,
+					sign_count,
+					transports,
+					created_at
+				FROM
+					webauthn_credentials
+				WHERE
+					id = $1
+				LIMIT 1
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.id)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				data := tt.mockExec.data
+				rows := sqlmock.NewRows([]string{"id", "user_id", "public_key", "sign_count", "transports", "created_at"}).
+					AddRow(data.ID, data.UserID, data.PublicKey, data.SignCount, data.Transports, data.CreatedAt)
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.GetWebAuthnCredential(tt.args.ctx, tt.args.id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_ListWebAuthnCredentials(t *testing.T) {
+	type mockExec struct {
+		data []repository.WebAuthnCredentialOutput
+		err  error
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	credentials := []repository.WebAuthnCredentialOutput{
+		{
+			ID:         "credential-id",
+			UserID:     "abc123-def456",
+			PublicKey:  []byte("cose-key"),
+			SignCount:  3,
+			Transports: "internal",
+			CreatedAt:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			ID:         "another-credential-id",
+			UserID:     "abc123-def456",
+			PublicKey:  []byte("another-cose-key"),
+			SignCount:  0,
+			Transports: "usb nfc",
+			CreatedAt:  time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     []repository.WebAuthnCredentialOutput
+		wantErr  bool
+	}{
+		{
+			name: "successfully lists the credentials of the user",
+			mockExec: mockExec{
+				data: credentials,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			want: credentials,
+		},
+		{
+			name: "error when listing the credentials",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					id,
+					user_id,
+					public_key,
+					sign_count,
+					transports,
+					created_at
+				FROM
+					webauthn_credentials
+				WHERE
+					user_id = $1
+				ORDER BY
+					created_at
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"id", "user_id", "public_key", "sign_count", "transports", "created_at"})
+				for _, data := range tt.mockExec.data {
+					rows.AddRow(data.ID, data.UserID, data.PublicKey, data.SignCount, data.Transports, data.CreatedAt)
+				}
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ListWebAuthnCredentials(tt.args.ctx, tt.args.userID)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_UseWebAuthnCredential(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.UseWebAuthnCredentialInput
+	}
+	input := repository.UseWebAuthnCredentialInput{
+		ID:        "credential-id",
+		SignCount: 4,
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully records the sign count",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when recording the sign count",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the sign count did not increase",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrWebAuthnSignCountNotValid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					webauthn_credentials
+				SET
+					sign_count = $2,
+					last_used_at = NOW()
+				WHERE
+					id = $1
+					AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.ID, tt.args.input.SignCount)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.UseWebAuthnCredential(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_UpsertPhoneOTP(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.UpsertPhoneOTPInput
+	}
+	input := repository.UpsertPhoneOTPInput{
+		UserID:         "abc123-def456",
+		Purpose:        "verification",
+		PhoneNumber:    "+62812345567",
+		CodeHash:       "code-hash",
+		ExpiresAt:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		ResendInterval: 60,
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully stores the code",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when storing the code",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the previous code was sent too recently",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrPhoneOTPResendTooSoon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					phone_otp_codes
+					(user_id, purpose, phone_number, code_hash, expires_at)
+				VALUES
+					($1, $2, $3, $4, $5)
+				ON CONFLICT (user_id, purpose) DO UPDATE
+				SET
+					phone_number = EXCLUDED.phone_number,
+					code_hash = EXCLUDED.code_hash,
+					attempts = 0,
+					expires_at = EXCLUDED.expires_at,
+					used_at = NULL,
+					created_at = NOW()
+				WHERE
+					phone_otp_codes.created_at <= NOW() - $6 * INTERVAL '1 second'
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).
+				WithArgs(input.UserID, input.Purpose, input.PhoneNumber, input.CodeHash, input.ExpiresAt, input.ResendInterval)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.UpsertPhoneOTP(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_AttemptPhoneOTP(t *testing.T) {
+	type mockExec struct {
+		data repository.PhoneOTPOutput
+		err  error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.AttemptPhoneOTPInput
+	}
+	input := repository.AttemptPhoneOTPInput{
+		UserID:      "abc123-def456",
+		Purpose:     "login",
+		MaxAttempts: 5,
+	}
+	code := repository.PhoneOTPOutput{
+		PhoneNumber: "+62812345567",
+		CodeHash:    "code-hash",
+		ExpiresAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.PhoneOTPOutput
+		wantErr  error
+	}{
+		{
+			name: "successfully counts the attempt",
+			mockExec: mockExec{
+				data: code,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			want: code,
+		},
+		{
+			name: "no rows when the attempts are exhausted",
+			mockExec: mockExec{
+				err: sql.ErrNoRows,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					phone_otp_codes
+				SET
+					attempts = attempts + 1
+				WHERE
+					user_id = $1
+					AND purpose = $2
+					AND used_at IS NULL
+					AND attempts < $3
+				RETURNING
+					phone_number,
+					code_hash,
+					expires_at
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.input.UserID, tt.args.input.Purpose, tt.args.input.MaxAttempts)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"phone_number", "code_hash", "expires_at"}).
+					AddRow(tt.mockExec.data.PhoneNumber, tt.mockExec.data.CodeHash, tt.mockExec.data.ExpiresAt)
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.AttemptPhoneOTP(tt.args.ctx, tt.args.input)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_ConsumePhoneOTP(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.ConsumePhoneOTPInput
+	}
+	input := repository.ConsumePhoneOTPInput{
+		UserID:   "abc123-def456",
+		Purpose:  "login",
+		CodeHash: "code-hash",
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully consumes the code",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when consuming the code",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the code was replaced or already used",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrPhoneOTPNotActive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					phone_otp_codes
+				SET
+					used_at = NOW()
+				WHERE
+					user_id = $1
+					AND purpose = $2
+					AND code_hash = $3
+					AND used_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.UserID, tt.args.input.Purpose, tt.args.input.CodeHash)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.ConsumePhoneOTP(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_VerifyPhoneNumber(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.VerifyPhoneNumberInput
+	}
+	input := repository.VerifyPhoneNumberInput{
+		UserID:      "abc123-def456",
+		PhoneNumber: "+62812345567",
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully verifies the phone number",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when verifying the phone number",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the phone number changed in the meantime",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: repository.ErrPhoneNumberChanged,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					users
+				SET
+					phone_verified_at = NOW()
+				WHERE
+					id = $1
+					AND phone_number = $2
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.UserID, tt.args.input.PhoneNumber)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.VerifyPhoneNumber(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_LockUser(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.LockUserInput
+	}
+	input := repository.LockUserInput{
+		UserID:      "abc123-def456",
+		LockedUntil: time.Date(2024, 1, 1, 0, 15, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully locks the user",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when locking the user",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: true,
+		},
+		{
+			name: "error when no user is updated",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					users
+				SET
+					locked_until = $2
+				WHERE
+					id = $1
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.UserID, tt.args.input.LockedUntil)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.LockUser(tt.args.ctx, tt.args.input)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestRepository_UnlockUser(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully unlocks the user",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+		},
+		{
+			name: "error when unlocking the user",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the user does not exist",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					users
+				SET
+					locked_until = NULL
+				WHERE
+					id = $1
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.UnlockUser(tt.args.ctx, tt.args.userID)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_ListLoginEvents(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sessionID := "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a"
+	type mockExec struct {
+		data []repository.LoginEventOutput
+		err  error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.ListLoginEventsInput
+	}
+	events := []repository.LoginEventOutput{
+		{
+			ID:        42,
+			SessionID: &sessionID,
+			Method:    "password",
+			IPAddress: "192.0.2.1",
+			UserAgent: "Mozilla/5.0",
+			Succeeded: true,
+			CreatedAt: createdAt,
+		},
+		{
+			ID:            41,
+			Method:        "password",
+			IPAddress:     "192.0.2.1",
+			UserAgent:     "Mozilla/5.0",
+			FailureReason: "invalid_password",
+			CreatedAt:     createdAt,
+		},
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     []repository.LoginEventOutput
+		wantErr  bool
+	}{
+		{
+			name: "successfully lists the latest logins",
+			mockExec: mockExec{
+				data: events,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.ListLoginEventsInput{
+					UserID: "abc123-def456",
+					Limit:  2,
+				},
+			},
+			want:    events,
+			wantErr: false,
+		},
+		{
+			name: "successfully lists the logins before an event",
+			mockExec: mockExec{
+				data: events[1:],
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.ListLoginEventsInput{
+					UserID:   "abc123-def456",
+					BeforeID: 42,
+					Limit:    2,
+				},
+			},
+			want:    events[1:],
+			wantErr: false,
+		},
+		{
+			name: "error when listing the logins",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.ListLoginEventsInput{
+					UserID: "abc123-def456",
+					Limit:  2,
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					id,
+					session_id,
+					method,
+					ip_address,
+					user_agent,
+					succeeded,
+					failure_reason,
+					created_at
+				FROM
+					login_events
+				WHERE
+					user_id = $1
+					AND (CAST($2 AS BIGINT) = 0 OR id < CAST($2 AS BIGINT))
+				ORDER BY
+					id DESC
+				LIMIT $3
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectQuery(query).WithArgs(input.UserID, input.BeforeID, input.Limit)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"id", "session_id", "method", "ip_address", "user_agent", "succeeded", "failure_reason", "created_at"})
+				for _, event := range tt.mockExec.data {
+					rows.AddRow(event.ID, event.SessionID, event.Method, event.IPAddress, event.UserAgent, event.Succeeded, event.FailureReason, event.CreatedAt)
+				}
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ListLoginEvents(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_ListUserSessions(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clientID := "mobile-app"
+	type mockExec struct {
+		data []repository.SessionOutput
+		err  error
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	sessions := []repository.SessionOutput{
+		{
+			ID:         "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+			IPAddress:  "192.0.2.1",
+			UserAgent:  "Mozilla/5.0",
+			CreatedAt:  createdAt,
+			LastUsedAt: createdAt.Add(time.Hour),
+			ExpiresAt:  createdAt.Add(30 * 24 * time.Hour),
+		},
+		{
+			ID:         "6f1d9b2e-8c4a-4e7f-9a3b-1d5c7e9f2a4b",
+			ClientID:   &clientID,
+			CreatedAt:  createdAt,
+			LastUsedAt: createdAt,
+			ExpiresAt:  createdAt.Add(30 * 24 * time.Hour),
+		},
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     []repository.SessionOutput
+		wantErr  bool
+	}{
+		{
+			name: "successfully lists the active sessions",
+			mockExec: mockExec{
+				data: sessions,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			want:    sessions,
+			wantErr: false,
+		},
+		{
+			name: "error when listing the sessions",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					refresh_tokens.family_id AS id,
+					refresh_tokens.client_id,
+					COALESCE(login_events.ip_address, '') AS ip_address,
+					COALESCE(login_events.user_agent, '') AS user_agent,
+					(
+						SELECT MIN(created_at) FROM refresh_tokens AS family WHERE family.family_id = refresh_tokens.family_id
+					) AS created_at,
+					refresh_tokens.created_at AS last_used_at,
+					refresh_tokens.expires_at
+				FROM
+					refresh_tokens
+					LEFT JOIN login_events ON login_events.session_id = refresh_tokens.family_id AND login_events.succeeded
+				WHERE
+					refresh_tokens.user_id = $1
+					AND refresh_tokens.rotated_at IS NULL
+					AND refresh_tokens.revoked_at IS NULL
+					AND refresh_tokens.expires_at > NOW()
+				ORDER BY
+					refresh_tokens.created_at DESC
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"id", "client_id", "ip_address", "user_agent", "created_at", "last_used_at", "expires_at"})
+				for _, session := range tt.mockExec.data {
+					rows.AddRow(session.ID, session.ClientID, session.IPAddress, session.UserAgent, session.CreatedAt, session.LastUsedAt, session.ExpiresAt)
+				}
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ListUserSessions(tt.args.ctx, tt.args.userID)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_ListUserConsents(t *testing.T) {
+	type mockExec struct {
+		data []repository.ConsentOutput
+		err  error
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	consents := []repository.ConsentOutput{
+		{
+			ClientID:   "mobile-app",
+			ClientName: "Mobile App",
+		},
+		{
+			ClientID:   "partner",
+			ClientName: "Partner",
+		},
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     []repository.ConsentOutput
+		wantErr  bool
+	}{
+		{
+			name: "successfully lists the clients authorized by the user",
+			mockExec: mockExec{
+				data: consents,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			want:    consents,
+			wantErr: false,
+		},
+		{
+			name: "error when listing the clients",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					oauth_clients.id AS client_id,
+					oauth_clients.name AS client_name
+				FROM
+					oauth_clients
+				WHERE
+					oauth_clients.id IN (
+						SELECT
+							client_id
+						FROM
+							refresh_tokens
+						WHERE
+							user_id = $1
+							AND client_id IS NOT NULL
+							AND rotated_at IS NULL
+							AND revoked_at IS NULL
+							AND expires_at > NOW()
+						UNION
+						SELECT
+							client_id
+						FROM
+							oauth_authorization_codes
+						WHERE
+							user_id = $1
+							AND used_at IS NULL
+							AND expires_at > NOW()
+					)
+				ORDER BY
+					oauth_clients.id
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"client_id", "client_name"})
+				for _, consent := range tt.mockExec.data {
+					rows.AddRow(consent.ClientID, consent.ClientName)
+				}
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ListUserConsents(tt.args.ctx, tt.args.userID)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_RevokeUserSession(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.RevokeUserSessionInput
+	}
+	input := repository.RevokeUserSessionInput{
+		UserID:    "abc123-def456",
+		SessionID: "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully revokes the session",
+			mockExec: mockExec{
+				affectedRows: 2,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: nil,
+		},
+		{
+			name: "error when revoking the session",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the user has no such active session",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					refresh_tokens
+				SET
+					revoked_at = NOW()
+				WHERE
+					family_id = $1
+					AND user_id = $2
+					AND revoked_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.SessionID, tt.args.input.UserID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.RevokeUserSession(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_ListUsers(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	createdBefore := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	type mockExec struct {
+		// where & order complete the query, args are its arguments
+		where string
+		order string
+		args  []driver.Value
+		data  []repository.UserOutput
+		err   error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.ListUsersInput
+	}
+	users := []repository.UserOutput{
+		{
+			ID:          "abc123-def456",
+			FullName:    "test",
+			PhoneNumber: "+62812345567",
+			Roles:       pq.StringArray{"admin"},
+			CreatedAt:   createdAt,
+		},
+		{
+			ID:          "abc123-def455",
+			FullName:    "another test",
+			PhoneNumber: "+62812345568",
+			Roles:       pq.StringArray{},
+			CreatedAt:   createdAt,
+		},
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     []repository.UserOutput
+		wantErr  bool
+	}{
+		{
+			name: "successfully lists the users, newest first",
+			mockExec: mockExec{
+				where: "deleted_at IS NULL",
+				order: "DESC",
+				args:  []driver.Value{2},
+				data:  users,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.ListUsersInput{
+					Limit: 2,
+				},
+			},
+			want:    users,
+			wantErr: false,
+		},
+		{
+			name: "successfully lists the users matching every filter, after the cursor",
+			mockExec: mockExec{
+				where: `deleted_at IS NULL AND LOWER(full_name) LIKE $1 AND phone_number LIKE $2 AND created_at >= $3 AND created_at < $4
+					AND suspended_at IS NOT NULL AND (created_at, id) > ($5, $6)`,
+				order: "ASC",
+				args:  []driver.Value{`50\%\_off%`, "+6281%", createdAt, createdBefore, createdAt, "abc123-def454", 2},
+				data:  users,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.ListUsersInput{
+					Filter: repository.ListUsersFilter{
+						FullNamePrefix:    "50%_Off",
+						PhoneNumberPrefix: "+6281",
+						CreatedFrom:       &createdAt,
+						CreatedBefore:     &createdBefore,
+						Status:            repository.UserStatusSuspended,
+					},
+					OldestFirst: true,
+					After:       &repository.UserCursor{CreatedAt: createdAt, ID: "abc123-def454"},
+					Limit:       2,
+				},
+			},
+			want:    users,
+			wantErr: false,
+		},
+		{
+			name: "successfully lists the active users, after the cursor",
+			mockExec: mockExec{
+				where: `deleted_at IS NULL AND suspended_at IS NULL AND (locked_until IS NULL OR locked_until <= NOW())
+					AND (created_at, id) < ($1, $2)`,
+				order: "DESC",
+				args:  []driver.Value{createdAt, "abc123-def457", 2},
+				data:  users,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.ListUsersInput{
+					Filter: repository.ListUsersFilter{
+						Status: repository.UserStatusActive,
+					},
+					After: &repository.UserCursor{CreatedAt: createdAt, ID: "abc123-def457"},
+					Limit: 2,
+				},
+			},
+			want:    users,
+			wantErr: false,
+		},
+		{
+			name: "successfully lists the locked users",
+			mockExec: mockExec{
+				where: "deleted_at IS NULL AND suspended_at IS NULL AND locked_until > NOW()",
+				order: "DESC",
+				args:  []driver.Value{2},
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.ListUsersInput{
+					Filter: repository.ListUsersFilter{
+						Status: repository.UserStatusLocked,
+					},
+					Limit: 2,
+				},
+			},
+			want:    nil,
+			wantErr: false,
+		},
+		{
+			name: "error when listing the users",
+			mockExec: mockExec{
+				where: "deleted_at IS NULL",
+				order: "DESC",
+				args:  []driver.Value{2},
+				err:   assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.ListUsersInput{
+					Limit: 2,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error when the status is unknown",
+			args: args{
+				ctx: context.Background(),
+				input: repository.ListUsersInput{
+					Filter: repository.ListUsersFilter{
+						Status: "deleted",
+					},
+					Limit: 2,
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			if tt.mockExec.order != "" {
+				query := fmt.Sprintf(`
+					SELECT
+						id,
+						full_name,
+						phone_number,
+						hashed_password,
+						login_count,
+						token_version,
+						phone_verified_at,
+						locked_until,
+						last_login_at,
+						suspended_at,
+						ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role) AS roles,
+						created_at
+					FROM
+						users
+					WHERE
+						%s
+					ORDER BY
+						created_at %s,
+						id %s
+					LIMIT $%d
+				`, tt.mockExec.where, tt.mockExec.order, tt.mockExec.order, len(tt.mockExec.args))
+
+				expectExec := m.ExpectQuery(query).WithArgs(tt.mockExec.args...)
+				if tt.mockExec.err != nil {
+					expectExec.WillReturnError(tt.mockExec.err)
+				} else {
+					rows := sqlmock.NewRows([]string{"id", "full_name", "phone_number", "hashed_password", "login_count", "token_version", "phone_verified_at", "locked_until", "last_login_at", "suspended_at", "roles", "created_at"})
+					for _, user := range tt.mockExec.data {
+						roles, _ := user.Roles.Value()
+						rows.AddRow(user.ID, user.FullName, user.PhoneNumber, user.HashedPassword, user.LoginCount, user.TokenVersion, user.PhoneVerifiedAt, user.LockedUntil, user.LastLoginAt, user.SuspendedAt, roles, user.CreatedAt)
+					}
+
+					expectExec.WillReturnRows(rows)
+				}
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ListUsers(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRepository_SearchUsers(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	type mockExec struct {
+		data []repository.UserSearchResultOutput
+		err  error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.SearchUsersInput
+	}
+	results := []repository.UserSearchResultOutput{
+		{
+			UserOutput: repository.UserOutput{
+				ID:          "abc123-def456",
+				FullName:    "José Ramírez",
+				PhoneNumber: "+62812345567",
+				Roles:       pq.StringArray{},
+				CreatedAt:   createdAt,
+			},
+			Rank: 1,
+		},
+		{
+			UserOutput: repository.UserOutput{
+				ID:          "abc123-def455",
+				FullName:    "Jose Ramos",
+				PhoneNumber: "+62812345568",
+				Roles:       pq.StringArray{"support"},
+				CreatedAt:   createdAt,
+			},
+			Rank: 0.6666667,
+		},
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     []repository.UserSearchResultOutput
+		wantErr  bool
+	}{
+		{
+			name: "successfully searches the users",
+			mockExec: mockExec{
+				data: results,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.SearchUsersInput{
+					Query: "jose rami",
+					Limit: 20,
+				},
+			},
+			want:    results,
+			wantErr: false,
+		},
+		{
+			name: "error when searching the users",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.SearchUsersInput{
+					Query: "jose rami",
+					Limit: 20,
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					id,
+					full_name,
+					phone_number,
+					hashed_password,
+					login_count,
+					token_version,
+					phone_verified_at,
+					locked_until,
+					last_login_at,
+					suspended_at,
+					ARRAY(SELECT role FROM user_roles WHERE user_roles.user_id = users.id ORDER BY role) AS roles,
+					created_at,
+					word_similarity(search_name($1), search_name(full_name)) AS rank
+				FROM
+					users
+				WHERE
+					search_name($1) <% search_name(full_name)
+					AND deleted_at IS NULL
+				ORDER BY
+					rank DESC,
+					created_at DESC,
+					id DESC
+				LIMIT $2
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.input.Query, tt.args.input.Limit)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"id", "full_name", "phone_number", "hashed_password", "login_count", "token_version", "phone_verified_at", "locked_until", "last_login_at", "suspended_at", "roles", "created_at", "rank"})
+				for _, result := range tt.mockExec.data {
+					roles, _ := result.Roles.Value()
+					rows.AddRow(result.ID, result.FullName, result.PhoneNumber, result.HashedPassword, result.LoginCount, result.TokenVersion, result.PhoneVerifiedAt, result.LockedUntil, result.LastLoginAt, result.SuspendedAt, roles, result.CreatedAt, result.Rank)
+				}
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.SearchUsers(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_SuspendUser(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully suspends the user",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+		},
+		{
+			name: "error when suspending the user",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the user does not exist",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				WITH revoked_refresh_tokens AS (
+					UPDATE
+						refresh_tokens
+					SET
+						revoked_at = NOW()
+					WHERE
+						user_id = $1
+						AND revoked_at IS NULL
+				)
+				UPDATE
+					users
+				SET
+					suspended_at = COALESCE(suspended_at, NOW()),
+					token_version = token_version + 1
+				WHERE
+					id = $1
+					AND deleted_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.SuspendUser(tt.args.ctx, tt.args.userID)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_UnsuspendUser(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully unsuspends the user",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+		},
+		{
+			name: "error when unsuspending the user",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the user does not exist",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					users
+				SET
+					suspended_at = NULL
+				WHERE
+					id = $1
+					AND deleted_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.UnsuspendUser(tt.args.ctx, tt.args.userID)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_ListRoles(t *testing.T) {
+	type mockExec struct {
+		data []string
+		err  error
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "successfully lists the roles",
+			mockExec: mockExec{
+				data: []string{"admin", "support"},
+			},
+			want:    []string{"admin", "support"},
+			wantErr: false,
+		},
+		{
+			name: "error when listing the roles",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					name
+				FROM
+					roles
+				ORDER BY
+					name
+			`
+
+			expectExec := m.ExpectQuery(query)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"name"})
+				for _, role := range tt.mockExec.data {
+					rows.AddRow(role)
+				}
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ListRoles(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_ListRolePermissions(t *testing.T) {
+	type mockExec struct {
+		data []string
+		err  error
+	}
+	type args struct {
+		ctx   context.Context
+		roles []string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "successfully lists the permissions of the roles",
+			mockExec: mockExec{
+				data: []string{"users:read", "users:unlock"},
+			},
+			args: args{
+				ctx:   context.Background(),
+				roles: []string{"support"},
+			},
+			want:    []string{"users:read", "users:unlock"},
+			wantErr: false,
+		},
+		{
+			name: "error when listing the permissions of the roles",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				roles: []string{"support"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT DISTINCT
+					permission
+				FROM
+					role_permissions
+				WHERE
+					role = ANY($1)
+				ORDER BY
+					permission
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(pq.Array(tt.args.roles))
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"permission"})
+				for _, permission := range tt.mockExec.data {
+					rows.AddRow(permission)
+				}
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ListRolePermissions(tt.args.ctx, tt.args.roles)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_SetUserRoles(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.SetUserRolesInput
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully sets the roles of the user",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.SetUserRolesInput{
+					UserID: "abc123-def456",
+					Roles:  []string{"admin", "support"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when setting the roles of the user",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.SetUserRolesInput{
+					UserID: "abc123-def456",
+					Roles:  []string{},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error when the user does not exist",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.SetUserRolesInput{
+					UserID: "abc123-def456",
+					Roles:  []string{"admin"},
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				WITH removed_roles AS (
+					DELETE FROM
+						user_roles
+					WHERE
+						user_id = $1
+						AND NOT (role = ANY($2))
+				), added_roles AS (
+					INSERT INTO
+						user_roles
+						(user_id, role)
+					SELECT
+						$1, name
+					FROM
+						roles
+					WHERE
+						name = ANY($2)
+					ON CONFLICT DO NOTHING
+				), revoked_refresh_tokens AS (
+					UPDATE
+						refresh_tokens
+					SET
+						revoked_at = NOW()
+					WHERE
+						user_id = $1
+						AND revoked_at IS NULL
+				)
+				UPDATE
+					users
+				SET
+					token_version = token_version + 1
+				WHERE
+					id = $1
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.UserID, pq.Array(input.Roles))
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.SetUserRoles(tt.args.ctx, tt.args.input)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestRepository_CreateAdminAuditEvent(t *testing.T) {
+	actorClientID := "back-office"
+	type mockExec struct {
+		err error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.CreateAdminAuditEventInput
+	}
+	input := repository.CreateAdminAuditEventInput{
+		UserID:        "abc123-def456",
+		Action:        "set_roles",
+		Detail:        "admin support",
+		ActorClientID: &actorClientID,
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully records the action",
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when recording the action",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					admin_audit_events
+					(user_id, action, detail, actor_user_id, actor_client_id)
+				VALUES
+					($1, $2, $3, $4, $5)
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.UserID, input.Action, input.Detail, input.ActorUserID,
+				input.ActorClientID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.CreateAdminAuditEvent(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_ListAdminAuditEvents(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	actorUserID := "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a"
+	actorClientID := "back-office"
+	type mockExec struct {
+		data []repository.AdminAuditEventOutput
+		err  error
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	events := []repository.AdminAuditEventOutput{
+		{
+			ID:          2,
+			Action:      "unlock",
+			ActorUserID: &actorUserID,
+			CreatedAt:   createdAt,
+		},
+		{
+			ID:            1,
+			Action:        "set_roles",
+			Detail:        "support",
+			ActorClientID: &actorClientID,
+			CreatedAt:     createdAt,
+		},
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     []repository.AdminAuditEventOutput
+		wantErr  bool
+	}{
+		{
+			name: "successfully lists the actions on the user",
+			mockExec: mockExec{
+				data: events,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			want:    events,
+			wantErr: false,
+		},
+		{
+			name: "error when listing the actions",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					id,
+					action,
+					detail,
+					actor_user_id,
+					actor_client_id,
+					created_at
+				FROM
+					admin_audit_events
+				WHERE
+					user_id = $1
+				ORDER BY
+					id DESC
+			`
+
+			expectExec := m.ExpectQuery(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"id", "action", "detail", "actor_user_id", "actor_client_id", "created_at"})
+				for _, event := range tt.mockExec.data {
+					rows.AddRow(event.ID, event.Action, event.Detail, event.ActorUserID, event.ActorClientID, event.CreatedAt)
+				}
+
+				expectExec.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ListAdminAuditEvents(tt.args.ctx, tt.args.userID)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_SoftDeleteUser(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully deletes the user",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+		},
+		{
+			name: "error when deleting the user",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the user does not exist or is already deleted",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				WITH revoked_refresh_tokens AS (
+					UPDATE
+						refresh_tokens
+					SET
+						revoked_at = NOW()
+					WHERE
+						user_id = $1
+						AND revoked_at IS NULL
+				)
+				UPDATE
+					users
+				SET
+					deleted_at = NOW(),
+					token_version = token_version + 1,
+					updated_at = NOW()
+				WHERE
+					id = $1
+					AND deleted_at IS NULL
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.SoftDeleteUser(tt.args.ctx, tt.args.userID)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+// eraseUsersQuery is the query erasing the users selected by the subquery it is formatted with
+const eraseUsersQuery = `
+	WITH erased_users AS (
+		UPDATE
+			users
+		SET
+			full_name = '',
+			phone_number = '',
+			hashed_password = '',
+			phone_verified_at = NULL,
+			locked_until = NULL,
+			last_login_at = NULL,
+			deleted_at = COALESCE(deleted_at, NOW()),
+			erased_at = NOW(),
+			token_version = token_version + 1,
+			updated_at = NOW()
+		WHERE
+			id IN (%s)
+		RETURNING
+			id
+	), revoked_refresh_tokens AS (
+		UPDATE
+			refresh_tokens
+		SET
+			revoked_at = NOW()
+		WHERE
+			user_id IN (SELECT id FROM erased_users)
+			AND revoked_at IS NULL
+	), deleted_password_history AS (
+		DELETE FROM password_history WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_totp_secrets AS (
+		DELETE FROM user_totp_secrets WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_recovery_codes AS (
+		DELETE FROM user_recovery_codes WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_mfa_challenges AS (
+		DELETE FROM mfa_challenges WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_webauthn_credentials AS (
+		DELETE FROM webauthn_credentials WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_webauthn_challenges AS (
+		DELETE FROM webauthn_challenges WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_phone_otp_codes AS (
+		DELETE FROM phone_otp_codes WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_user_roles AS (
+		DELETE FROM user_roles WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_data_exports AS (
+		DELETE FROM data_exports WHERE user_id IN (SELECT id FROM erased_users)
+	), deleted_authorization_codes AS (
+		DELETE FROM oauth_authorization_codes WHERE user_id IN (SELECT id FROM erased_users)
+	), anonymized_login_events AS (
+		UPDATE
+			login_events
+		SET
+			ip_address = '',
+			user_agent = ''
+		WHERE
+			user_id IN (SELECT id FROM erased_users)
+	)
+	SELECT
+		COUNT(*)
+	FROM
+		erased_users
+`
+
+func TestRepository_EraseUser(t *testing.T) {
+	type mockExec struct {
+		erased int64
+		err    error
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully erases the user",
+			mockExec: mockExec{
+				erased: 1,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+		},
+		{
+			name: "error when erasing the user",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the user does not exist or is already erased",
+			mockExec: mockExec{
+				erased: 0,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := fmt.Sprintf(eraseUsersQuery, `
+				SELECT id FROM users WHERE id = $1 AND erased_at IS NULL
+			`)
+
+			expectQuery := m.ExpectQuery(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectQuery.WillReturnError(tt.mockExec.err)
+			} else {
+				expectQuery.WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.mockExec.erased))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.EraseUser(tt.args.ctx, tt.args.userID)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_EraseDeletedUsers(t *testing.T) {
+	deletedBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	type mockExec struct {
+		erased int64
+		err    error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.EraseDeletedUsersInput
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     int64
+		wantErr  bool
+	}{
+		{
+			name: "successfully erases the deleted users",
+			mockExec: mockExec{
+				erased: 3,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.EraseDeletedUsersInput{
+					DeletedBefore: deletedBefore,
+					Limit:         100,
+				},
+			},
+			want:    3,
+			wantErr: false,
+		},
+		{
+			name: "error when erasing the deleted users",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx: context.Background(),
+				input: repository.EraseDeletedUsersInput{
+					DeletedBefore: deletedBefore,
+					Limit:         100,
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := fmt.Sprintf(eraseUsersQuery, `
+				SELECT
+					id
+				FROM
+					users
+				WHERE
+					deleted_at < $1
+					AND erased_at IS NULL
+				ORDER BY
+					deleted_at
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			`)
+
+			expectQuery := m.ExpectQuery(query).WithArgs(tt.args.input.DeletedBefore, tt.args.input.Limit)
+			if tt.mockExec.err != nil {
+				expectQuery.WillReturnError(tt.mockExec.err)
+			} else {
+				expectQuery.WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.mockExec.erased))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.EraseDeletedUsers(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_CreateDataExport(t *testing.T) {
+	type mockExec struct {
+		err error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.CreateDataExportInput
+	}
+	input := repository.CreateDataExportInput{
+		ID:     "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+		UserID: "abc123-def456",
+		Format: "zip",
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  bool
+	}{
+		{
+			name: "successfully inserts the data export",
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: false,
+		},
+		{
+			name: "error when inserting the data export",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				INSERT INTO
+					data_exports
+					(id, user_id, format)
+				VALUES
+					($1, $2, $3)
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.ID, input.UserID, input.Format)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.CreateDataExport(tt.args.ctx, tt.args.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// dataExportColumns are the columns of repository.DataExportOutput, in the order they are selected
+var dataExportColumns = []string{"id", "user_id", "format", "completed_at", "expires_at", "created_at"}
+
+func dataExportRow(export repository.DataExportOutput) []driver.Value {
+	return []driver.Value{export.ID, export.UserID, export.Format, export.CompletedAt, export.ExpiresAt, export.CreatedAt}
+}
+
+func TestRepository_GetLatestDataExport(t *testing.T) {
+	completedAt := time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC)
+	expiresAt := time.Date(2024, 1, 2, 0, 5, 0, 0, time.UTC)
+	export := repository.DataExportOutput{
+		ID:          "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+		UserID:      "abc123-def456",
+		Format:      "json",
+		CompletedAt: &completedAt,
+		ExpiresAt:   &expiresAt,
+		CreatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	type mockExec struct {
+		data repository.DataExportOutput
+		err  error
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.DataExportOutput
+		wantErr  error
+	}{
+		{
+			name: "successfully gets the latest data export",
+			mockExec: mockExec{
+				data: export,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			want: export,
+		},
+		{
+			name: "no rows when the user has no data export left",
+			mockExec: mockExec{
+				err: sql.ErrNoRows,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "abc123-def456",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					id,
+					user_id,
+					format,
+					completed_at,
+					expires_at,
+					created_at
+				FROM
+					data_exports
+				WHERE
+					user_id = $1
+					AND (expires_at IS NULL OR expires_at > NOW())
+				ORDER BY
+					created_at DESC
+				LIMIT 1
+			`
+
+			expectQuery := m.ExpectQuery(query).WithArgs(tt.args.userID)
+			if tt.mockExec.err != nil {
+				expectQuery.WillReturnError(tt.mockExec.err)
+			} else {
+				expectQuery.WillReturnRows(sqlmock.NewRows(dataExportColumns).AddRow(dataExportRow(tt.mockExec.data)...))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.GetLatestDataExport(tt.args.ctx, tt.args.userID)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_ClaimDataExport(t *testing.T) {
+	export := repository.DataExportOutput{
+		ID:        "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+		UserID:    "abc123-def456",
+		Format:    "zip",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	input := repository.ClaimDataExportInput{
+		StartedBefore: time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC),
+	}
+
+	type mockExec struct {
+		data repository.DataExportOutput
+		err  error
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.ClaimDataExportInput
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.DataExportOutput
+		wantErr  error
+	}{
+		{
+			name: "successfully picks the pending data export up",
+			mockExec: mockExec{
+				data: export,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			want: export,
+		},
+		{
+			name: "no rows when there is nothing to export",
+			mockExec: mockExec{
+				err: sql.ErrNoRows,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					data_exports
+				SET
+					started_at = NOW()
+				WHERE
+					id = (
+						SELECT
+							data_exports.id
+						FROM
+							data_exports
+							JOIN users ON users.id = data_exports.user_id
+						WHERE
+							data_exports.completed_at IS NULL
+							AND (data_exports.started_at IS NULL OR data_exports.started_at < $1)
+							AND users.deleted_at IS NULL
+						ORDER BY
+							data_exports.created_at
+						LIMIT 1
+						FOR UPDATE OF data_exports SKIP LOCKED
+					)
+				RETURNING
+					id,
+					user_id,
+					format,
+					completed_at,
+					expires_at,
+					created_at
+			`
+
+			expectQuery := m.ExpectQuery(query).WithArgs(tt.args.input.StartedBefore)
+			if tt.mockExec.err != nil {
+				expectQuery.WillReturnError(tt.mockExec.err)
+			} else {
+				expectQuery.WillReturnRows(sqlmock.NewRows(dataExportColumns).AddRow(dataExportRow(tt.mockExec.data)...))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.ClaimDataExport(tt.args.ctx, tt.args.input)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_CompleteDataExport(t *testing.T) {
+	input := repository.CompleteDataExportInput{
+		ID:        "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+		Archive:   []byte(`{"user":{}}`),
+		ExpiresAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.CompleteDataExportInput
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully completes the data export",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when completing the data export",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the data export was completed by another job",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					data_exports
+				SET
+					archive = $2,
+					completed_at = NOW(),
+					expires_at = $3
+				WHERE
+					id = $1
+					AND completed_at IS NULL
+			`
+
+			input := tt.args.input
+			expectExec := m.ExpectExec(query).WithArgs(input.ID, input.Archive, input.ExpiresAt)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.CompleteDataExport(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_SetDataExportDownloadToken(t *testing.T) {
+	input := repository.SetDataExportDownloadTokenInput{
+		ID:        "0b6b1c4e-5d2a-4f0e-8a57-3c9d1f6e2b7a",
+		TokenHash: "token-hash",
+	}
+
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	type args struct {
+		ctx   context.Context
+		input repository.SetDataExportDownloadTokenInput
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		wantErr  error
+	}{
+		{
+			name: "successfully sets the download token",
+			mockExec: mockExec{
+				affectedRows: 1,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+		},
+		{
+			name: "error when setting the download token",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: assert.AnError,
+		},
+		{
+			name: "error when the data export is pending or expired",
+			mockExec: mockExec{
+				affectedRows: 0,
+			},
+			args: args{
+				ctx:   context.Background(),
+				input: input,
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				UPDATE
+					data_exports
+				SET
+					download_token_hash = $2
+				WHERE
+					id = $1
+					AND completed_at IS NOT NULL
+					AND expires_at > NOW()
+			`
+
+			expectExec := m.ExpectExec(query).WithArgs(tt.args.input.ID, tt.args.input.TokenHash)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			err = r.SetDataExportDownloadToken(tt.args.ctx, tt.args.input)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepository_GetDataExportArchive(t *testing.T) {
+	archive := repository.DataExportArchiveOutput{
+		Format:      "json",
+		Archive:     []byte(`{"user":{}}`),
+		CompletedAt: time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC),
+	}
+
+	type mockExec struct {
+		data repository.DataExportArchiveOutput
+		err  error
+	}
+	type args struct {
+		ctx       context.Context
+		tokenHash string
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		args     args
+		want     repository.DataExportArchiveOutput
+		wantErr  error
+	}{
+		{
+			name: "successfully gets the archive",
+			mockExec: mockExec{
+				data: archive,
+			},
+			args: args{
+				ctx:       context.Background(),
+				tokenHash: "token-hash",
+			},
+			want: archive,
+		},
+		{
+			name: "no rows for an unknown token or an expired data export",
+			mockExec: mockExec{
+				err: sql.ErrNoRows,
+			},
+			args: args{
+				ctx:       context.Background(),
+				tokenHash: "token-hash",
+			},
+			wantErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				SELECT
+					data_exports.format,
+					data_exports.archive,
+					data_exports.completed_at
+				FROM
+					data_exports
+					JOIN users ON users.id = data_exports.user_id
+				WHERE
+					data_exports.download_token_hash = $1
+					AND data_exports.expires_at > NOW()
+					AND users.deleted_at IS NULL
+				LIMIT 1
+			`
+
+			expectQuery := m.ExpectQuery(query).WithArgs(tt.args.tokenHash)
+			if tt.mockExec.err != nil {
+				expectQuery.WillReturnError(tt.mockExec.err)
+			} else {
+				rows := sqlmock.NewRows([]string{"format", "archive", "completed_at"}).
+					AddRow(tt.mockExec.data.Format, tt.mockExec.data.Archive, tt.mockExec.data.CompletedAt)
+
+				expectQuery.WillReturnRows(rows)
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.GetDataExportArchive(tt.args.ctx, tt.args.tokenHash)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRepository_DeleteExpiredDataExports(t *testing.T) {
+	type mockExec struct {
+		err          error
+		affectedRows int
+	}
+	tests := []struct {
+		name     string
+		mockExec mockExec
+		want     int64
+		wantErr  bool
+	}{
+		{
+			name: "successfully deletes the expired data exports",
+			mockExec: mockExec{
+				affectedRows: 2,
+			},
+			want: 2,
+		},
+		{
+			name: "error when deleting the expired data exports",
+			mockExec: mockExec{
+				err: assert.AnError,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			defer db.Close()
+
+			query := `
+				DELETE FROM
+					data_exports
+				WHERE
+					expires_at <= NOW()
+			`
+
+			expectExec := m.ExpectExec(query)
+			if tt.mockExec.err != nil {
+				expectExec.WillReturnError(tt.mockExec.err)
+			} else {
+				expectExec.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockExec.affectedRows)))
+			}
+
+			sqlxDB := sqlx.NewDb(db, "sqlmock")
+			r := repository.Repository{Db: sqlxDB}
+
+			got, err := r.DeleteExpiredDataExports(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
 			}
 		})
 	}
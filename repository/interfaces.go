@@ -11,5 +11,62 @@ type RepositoryInterface interface {
 	GetUserByPhoneNumber(context.Context, string) (UserOutput, error)
 	GetUserByID(context.Context, string) (UserOutput, error)
 	UpdateUser(context.Context, string, UpdateUserInput) error
-	IncrementLoginCount(context.Context, string) error
+	ListUsers(context.Context, ListUsersInput) ([]UserOutput, error)
+	SearchUsers(context.Context, SearchUsersInput) ([]UserSearchResultOutput, error)
+	SuspendUser(context.Context, string) error
+	UnsuspendUser(context.Context, string) error
+	ListRoles(context.Context) ([]string, error)
+	ListRolePermissions(context.Context, []string) ([]string, error)
+	SetUserRoles(context.Context, SetUserRolesInput) error
+	CreateAdminAuditEvent(context.Context, CreateAdminAuditEventInput) error
+	ListAdminAuditEvents(context.Context, string) ([]AdminAuditEventOutput, error)
+	SoftDeleteUser(context.Context, string) error
+	EraseUser(context.Context, string) error
+	EraseDeletedUsers(context.Context, EraseDeletedUsersInput) (int64, error)
+	CreateDataExport(context.Context, CreateDataExportInput) error
+	GetLatestDataExport(context.Context, string) (DataExportOutput, error)
+	ClaimDataExport(context.Context, ClaimDataExportInput) (DataExportOutput, error)
+	CompleteDataExport(context.Context, CompleteDataExportInput) error
+	SetDataExportDownloadToken(context.Context, SetDataExportDownloadTokenInput) error
+	GetDataExportArchive(context.Context, string) (DataExportArchiveOutput, error)
+	DeleteExpiredDataExports(context.Context) (int64, error)
+	UpdatePassword(context.Context, UpdatePasswordInput) error
+	UpdatePasswordHash(context.Context, UpdatePasswordHashInput) error
+	ListPasswordHistory(context.Context, ListPasswordHistoryInput) ([]string, error)
+	CreateLoginEvent(context.Context, CreateLoginEventInput) error
+	ListLoginEvents(context.Context, ListLoginEventsInput) ([]LoginEventOutput, error)
+	LockUser(context.Context, LockUserInput) error
+	UnlockUser(context.Context, string) error
+	CreateRefreshToken(context.Context, CreateRefreshTokenInput) error
+	GetRefreshTokenByHash(context.Context, string) (RefreshTokenOutput, error)
+	RotateRefreshToken(context.Context, string) error
+	RevokeRefreshTokenFamily(context.Context, string) error
+	RevokeAccessToken(context.Context, RevokeAccessTokenInput) error
+	IsAccessTokenRevoked(context.Context, IsAccessTokenRevokedInput) (bool, error)
+	DeleteExpiredRevokedAccessTokens(context.Context) (int64, error)
+	RevokeAllUserSessions(context.Context, string) error
+	ListUserSessions(context.Context, string) ([]SessionOutput, error)
+	ListUserConsents(context.Context, string) ([]ConsentOutput, error)
+	RevokeUserSession(context.Context, RevokeUserSessionInput) error
+	GetOAuthClientByID(context.Context, string) (OAuthClientOutput, error)
+	CreateAuthorizationCode(context.Context, CreateAuthorizationCodeInput) error
+	ConsumeAuthorizationCode(context.Context, string) (AuthorizationCodeOutput, error)
+	GetTOTPSecret(context.Context, string) (TOTPSecretOutput, error)
+	UpsertTOTPSecret(context.Context, UpsertTOTPSecretInput) error
+	ConfirmTOTPSecret(context.Context, ConfirmTOTPSecretInput) error
+	UseTOTPStep(context.Context, UseTOTPStepInput) error
+	UseRecoveryCode(context.Context, UseRecoveryCodeInput) error
+	CreateMFAChallenge(context.Context, CreateMFAChallengeInput) error
+	AttemptMFAChallenge(context.Context, AttemptMFAChallengeInput) (MFAChallengeOutput, error)
+	ConsumeMFAChallenge(context.Context, string) error
+	CreateWebAuthnChallenge(context.Context, CreateWebAuthnChallengeInput) error
+	ConsumeWebAuthnChallenge(context.Context, ConsumeWebAuthnChallengeInput) (WebAuthnChallengeOutput, error)
+	CreateWebAuthnCredential(context.Context, CreateWebAuthnCredentialInput) error
+	GetWebAuthnCredential(context.Context, string) (WebAuthnCredentialOutput, error)
+	ListWebAuthnCredentials(context.Context, string) ([]WebAuthnCredentialOutput, error)
+	UseWebAuthnCredential(context.Context, UseWebAuthnCredentialInput) error
+	UpsertPhoneOTP(context.Context, UpsertPhoneOTPInput) error
+	AttemptPhoneOTP(context.Context, AttemptPhoneOTPInput) (PhoneOTPOutput, error)
+	ConsumePhoneOTP(context.Context, ConsumePhoneOTPInput) error
+	VerifyPhoneNumber(context.Context, VerifyPhoneNumberInput) error
 }
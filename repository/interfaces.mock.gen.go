@@ -34,6 +34,221 @@ func (m *MockRepositoryInterface) EXPECT() *MockRepositoryInterfaceMockRecorder
 	return m.recorder
 }
 
+// AttemptMFAChallenge mocks base method.
+func (m *MockRepositoryInterface) AttemptMFAChallenge(arg0 context.Context, arg1 AttemptMFAChallengeInput) (MFAChallengeOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AttemptMFAChallenge", arg0, arg1)
+	ret0, _ := ret[0].(MFAChallengeOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AttemptMFAChallenge indicates an expected call of AttemptMFAChallenge.
+func (mr *MockRepositoryInterfaceMockRecorder) AttemptMFAChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AttemptMFAChallenge", reflect.TypeOf((*MockRepositoryInterface)(nil).AttemptMFAChallenge), arg0, arg1)
+}
+
+// AttemptPhoneOTP mocks base method.
+func (m *MockRepositoryInterface) AttemptPhoneOTP(arg0 context.Context, arg1 AttemptPhoneOTPInput) (PhoneOTPOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AttemptPhoneOTP", arg0, arg1)
+	ret0, _ := ret[0].(PhoneOTPOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AttemptPhoneOTP indicates an expected call of AttemptPhoneOTP.
+func (mr *MockRepositoryInterfaceMockRecorder) AttemptPhoneOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AttemptPhoneOTP", reflect.TypeOf((*MockRepositoryInterface)(nil).AttemptPhoneOTP), arg0, arg1)
+}
+
+// ClaimDataExport mocks base method.
+func (m *MockRepositoryInterface) ClaimDataExport(arg0 context.Context, arg1 ClaimDataExportInput) (DataExportOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimDataExport", arg0, arg1)
+	ret0, _ := ret[0].(DataExportOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimDataExport indicates an expected call of ClaimDataExport.
+func (mr *MockRepositoryInterfaceMockRecorder) ClaimDataExport(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimDataExport", reflect.TypeOf((*MockRepositoryInterface)(nil).ClaimDataExport), arg0, arg1)
+}
+
+// CompleteDataExport mocks base method.
+func (m *MockRepositoryInterface) CompleteDataExport(arg0 context.Context, arg1 CompleteDataExportInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteDataExport", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteDataExport indicates an expected call of CompleteDataExport.
+func (mr *MockRepositoryInterfaceMockRecorder) CompleteDataExport(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteDataExport", reflect.TypeOf((*MockRepositoryInterface)(nil).CompleteDataExport), arg0, arg1)
+}
+
+// ConfirmTOTPSecret mocks base method.
+func (m *MockRepositoryInterface) ConfirmTOTPSecret(arg0 context.Context, arg1 ConfirmTOTPSecretInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConfirmTOTPSecret", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ConfirmTOTPSecret indicates an expected call of ConfirmTOTPSecret.
+func (mr *MockRepositoryInterfaceMockRecorder) ConfirmTOTPSecret(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfirmTOTPSecret", reflect.TypeOf((*MockRepositoryInterface)(nil).ConfirmTOTPSecret), arg0, arg1)
+}
+
+// ConsumeAuthorizationCode mocks base method.
+func (m *MockRepositoryInterface) ConsumeAuthorizationCode(arg0 context.Context, arg1 string) (AuthorizationCodeOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeAuthorizationCode", arg0, arg1)
+	ret0, _ := ret[0].(AuthorizationCodeOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeAuthorizationCode indicates an expected call of ConsumeAuthorizationCode.
+func (mr *MockRepositoryInterfaceMockRecorder) ConsumeAuthorizationCode(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeAuthorizationCode", reflect.TypeOf((*MockRepositoryInterface)(nil).ConsumeAuthorizationCode), arg0, arg1)
+}
+
+// ConsumeMFAChallenge mocks base method.
+func (m *MockRepositoryInterface) ConsumeMFAChallenge(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeMFAChallenge", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ConsumeMFAChallenge indicates an expected call of ConsumeMFAChallenge.
+func (mr *MockRepositoryInterfaceMockRecorder) ConsumeMFAChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeMFAChallenge", reflect.TypeOf((*MockRepositoryInterface)(nil).ConsumeMFAChallenge), arg0, arg1)
+}
+
+// ConsumePhoneOTP mocks base method.
+func (m *MockRepositoryInterface) ConsumePhoneOTP(arg0 context.Context, arg1 ConsumePhoneOTPInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumePhoneOTP", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ConsumePhoneOTP indicates an expected call of ConsumePhoneOTP.
+func (mr *MockRepositoryInterfaceMockRecorder) ConsumePhoneOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumePhoneOTP", reflect.TypeOf((*MockRepositoryInterface)(nil).ConsumePhoneOTP), arg0, arg1)
+}
+
+// ConsumeWebAuthnChallenge mocks base method.
+func (m *MockRepositoryInterface) ConsumeWebAuthnChallenge(arg0 context.Context, arg1 ConsumeWebAuthnChallengeInput) (WebAuthnChallengeOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeWebAuthnChallenge", arg0, arg1)
+	ret0, _ := ret[0].(WebAuthnChallengeOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeWebAuthnChallenge indicates an expected call of ConsumeWebAuthnChallenge.
+func (mr *MockRepositoryInterfaceMockRecorder) ConsumeWebAuthnChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeWebAuthnChallenge", reflect.TypeOf((*MockRepositoryInterface)(nil).ConsumeWebAuthnChallenge), arg0, arg1)
+}
+
+// CreateAdminAuditEvent mocks base method.
+func (m *MockRepositoryInterface) CreateAdminAuditEvent(arg0 context.Context, arg1 CreateAdminAuditEventInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAdminAuditEvent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAdminAuditEvent indicates an expected call of CreateAdminAuditEvent.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateAdminAuditEvent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAdminAuditEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateAdminAuditEvent), arg0, arg1)
+}
+
+// CreateAuthorizationCode mocks base method.
+func (m *MockRepositoryInterface) CreateAuthorizationCode(arg0 context.Context, arg1 CreateAuthorizationCodeInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAuthorizationCode", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAuthorizationCode indicates an expected call of CreateAuthorizationCode.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateAuthorizationCode(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAuthorizationCode", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateAuthorizationCode), arg0, arg1)
+}
+
+// CreateDataExport mocks base method.
+func (m *MockRepositoryInterface) CreateDataExport(arg0 context.Context, arg1 CreateDataExportInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateDataExport", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateDataExport indicates an expected call of CreateDataExport.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateDataExport(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDataExport", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateDataExport), arg0, arg1)
+}
+
+// CreateLoginEvent mocks base method.
+func (m *MockRepositoryInterface) CreateLoginEvent(arg0 context.Context, arg1 CreateLoginEventInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateLoginEvent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateLoginEvent indicates an expected call of CreateLoginEvent.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateLoginEvent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLoginEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateLoginEvent), arg0, arg1)
+}
+
+// CreateMFAChallenge mocks base method.
+func (m *MockRepositoryInterface) CreateMFAChallenge(arg0 context.Context, arg1 CreateMFAChallengeInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateMFAChallenge", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateMFAChallenge indicates an expected call of CreateMFAChallenge.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateMFAChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMFAChallenge", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateMFAChallenge), arg0, arg1)
+}
+
+// CreateRefreshToken mocks base method.
+func (m *MockRepositoryInterface) CreateRefreshToken(arg0 context.Context, arg1 CreateRefreshTokenInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRefreshToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateRefreshToken indicates an expected call of CreateRefreshToken.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateRefreshToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRefreshToken", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateRefreshToken), arg0, arg1)
+}
+
 // CreateUser mocks base method.
 func (m *MockRepositoryInterface) CreateUser(arg0 context.Context, arg1 CreateUserInput) (CreateUserOutput, error) {
 	m.ctrl.T.Helper()
@@ -49,6 +264,168 @@ func (mr *MockRepositoryInterfaceMockRecorder) CreateUser(arg0, arg1 interface{}
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateUser), arg0, arg1)
 }
 
+// CreateWebAuthnChallenge mocks base method.
+func (m *MockRepositoryInterface) CreateWebAuthnChallenge(arg0 context.Context, arg1 CreateWebAuthnChallengeInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateWebAuthnChallenge", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateWebAuthnChallenge indicates an expected call of CreateWebAuthnChallenge.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateWebAuthnChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWebAuthnChallenge", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateWebAuthnChallenge), arg0, arg1)
+}
+
+// CreateWebAuthnCredential mocks base method.
+func (m *MockRepositoryInterface) CreateWebAuthnCredential(arg0 context.Context, arg1 CreateWebAuthnCredentialInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateWebAuthnCredential", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateWebAuthnCredential indicates an expected call of CreateWebAuthnCredential.
+func (mr *MockRepositoryInterfaceMockRecorder) CreateWebAuthnCredential(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWebAuthnCredential", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateWebAuthnCredential), arg0, arg1)
+}
+
+// DeleteExpiredDataExports mocks base method.
+func (m *MockRepositoryInterface) DeleteExpiredDataExports(arg0 context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredDataExports", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredDataExports indicates an expected call of DeleteExpiredDataExports.
+func (mr *MockRepositoryInterfaceMockRecorder) DeleteExpiredDataExports(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredDataExports", reflect.TypeOf((*MockRepositoryInterface)(nil).DeleteExpiredDataExports), arg0)
+}
+
+// DeleteExpiredRevokedAccessTokens mocks base method.
+func (m *MockRepositoryInterface) DeleteExpiredRevokedAccessTokens(arg0 context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredRevokedAccessTokens", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredRevokedAccessTokens indicates an expected call of DeleteExpiredRevokedAccessTokens.
+func (mr *MockRepositoryInterfaceMockRecorder) DeleteExpiredRevokedAccessTokens(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredRevokedAccessTokens", reflect.TypeOf((*MockRepositoryInterface)(nil).DeleteExpiredRevokedAccessTokens), arg0)
+}
+
+// EraseDeletedUsers mocks base method.
+func (m *MockRepositoryInterface) EraseDeletedUsers(arg0 context.Context, arg1 EraseDeletedUsersInput) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EraseDeletedUsers", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EraseDeletedUsers indicates an expected call of EraseDeletedUsers.
+func (mr *MockRepositoryInterfaceMockRecorder) EraseDeletedUsers(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EraseDeletedUsers", reflect.TypeOf((*MockRepositoryInterface)(nil).EraseDeletedUsers), arg0, arg1)
+}
+
+// EraseUser mocks base method.
+func (m *MockRepositoryInterface) EraseUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EraseUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EraseUser indicates an expected call of EraseUser.
+func (mr *MockRepositoryInterfaceMockRecorder) EraseUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EraseUser", reflect.TypeOf((*MockRepositoryInterface)(nil).EraseUser), arg0, arg1)
+}
+
+// GetDataExportArchive mocks base method.
+func (m *MockRepositoryInterface) GetDataExportArchive(arg0 context.Context, arg1 string) (DataExportArchiveOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDataExportArchive", arg0, arg1)
+	ret0, _ := ret[0].(DataExportArchiveOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDataExportArchive indicates an expected call of GetDataExportArchive.
+func (mr *MockRepositoryInterfaceMockRecorder) GetDataExportArchive(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDataExportArchive", reflect.TypeOf((*MockRepositoryInterface)(nil).GetDataExportArchive), arg0, arg1)
+}
+
+// GetLatestDataExport mocks base method.
+func (m *MockRepositoryInterface) GetLatestDataExport(arg0 context.Context, arg1 string) (DataExportOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLatestDataExport", arg0, arg1)
+	ret0, _ := ret[0].(DataExportOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLatestDataExport indicates an expected call of GetLatestDataExport.
+func (mr *MockRepositoryInterfaceMockRecorder) GetLatestDataExport(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLatestDataExport", reflect.TypeOf((*MockRepositoryInterface)(nil).GetLatestDataExport), arg0, arg1)
+}
+
+// GetOAuthClientByID mocks base method.
+func (m *MockRepositoryInterface) GetOAuthClientByID(arg0 context.Context, arg1 string) (OAuthClientOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOAuthClientByID", arg0, arg1)
+	ret0, _ := ret[0].(OAuthClientOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOAuthClientByID indicates an expected call of GetOAuthClientByID.
+func (mr *MockRepositoryInterfaceMockRecorder) GetOAuthClientByID(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOAuthClientByID", reflect.TypeOf((*MockRepositoryInterface)(nil).GetOAuthClientByID), arg0, arg1)
+}
+
+// GetRefreshTokenByHash mocks base method.
+func (m *MockRepositoryInterface) GetRefreshTokenByHash(arg0 context.Context, arg1 string) (RefreshTokenOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRefreshTokenByHash", arg0, arg1)
+	ret0, _ := ret[0].(RefreshTokenOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRefreshTokenByHash indicates an expected call of GetRefreshTokenByHash.
+func (mr *MockRepositoryInterfaceMockRecorder) GetRefreshTokenByHash(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRefreshTokenByHash", reflect.TypeOf((*MockRepositoryInterface)(nil).GetRefreshTokenByHash), arg0, arg1)
+}
+
+// GetTOTPSecret mocks base method.
+func (m *MockRepositoryInterface) GetTOTPSecret(arg0 context.Context, arg1 string) (TOTPSecretOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTOTPSecret", arg0, arg1)
+	ret0, _ := ret[0].(TOTPSecretOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTOTPSecret indicates an expected call of GetTOTPSecret.
+func (mr *MockRepositoryInterfaceMockRecorder) GetTOTPSecret(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTOTPSecret", reflect.TypeOf((*MockRepositoryInterface)(nil).GetTOTPSecret), arg0, arg1)
+}
+
 // GetUserByID mocks base method.
 func (m *MockRepositoryInterface) GetUserByID(arg0 context.Context, arg1 string) (UserOutput, error) {
 	m.ctrl.T.Helper()
@@ -79,18 +456,380 @@ func (mr *MockRepositoryInterfaceMockRecorder) GetUserByPhoneNumber(arg0, arg1 i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByPhoneNumber", reflect.TypeOf((*MockRepositoryInterface)(nil).GetUserByPhoneNumber), arg0, arg1)
 }
 
-// IncrementLoginCount mocks base method.
-func (m *MockRepositoryInterface) IncrementLoginCount(arg0 context.Context, arg1 string) error {
+// GetWebAuthnCredential mocks base method.
+func (m *MockRepositoryInterface) GetWebAuthnCredential(arg0 context.Context, arg1 string) (WebAuthnCredentialOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebAuthnCredential", arg0, arg1)
+	ret0, _ := ret[0].(WebAuthnCredentialOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebAuthnCredential indicates an expected call of GetWebAuthnCredential.
+func (mr *MockRepositoryInterfaceMockRecorder) GetWebAuthnCredential(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebAuthnCredential", reflect.TypeOf((*MockRepositoryInterface)(nil).GetWebAuthnCredential), arg0, arg1)
+}
+
+// IsAccessTokenRevoked mocks base method.
+func (m *MockRepositoryInterface) IsAccessTokenRevoked(arg0 context.Context, arg1 IsAccessTokenRevokedInput) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsAccessTokenRevoked", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsAccessTokenRevoked indicates an expected call of IsAccessTokenRevoked.
+func (mr *MockRepositoryInterfaceMockRecorder) IsAccessTokenRevoked(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsAccessTokenRevoked", reflect.TypeOf((*MockRepositoryInterface)(nil).IsAccessTokenRevoked), arg0, arg1)
+}
+
+// ListAdminAuditEvents mocks base method.
+func (m *MockRepositoryInterface) ListAdminAuditEvents(arg0 context.Context, arg1 string) ([]AdminAuditEventOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAdminAuditEvents", arg0, arg1)
+	ret0, _ := ret[0].([]AdminAuditEventOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAdminAuditEvents indicates an expected call of ListAdminAuditEvents.
+func (mr *MockRepositoryInterfaceMockRecorder) ListAdminAuditEvents(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAdminAuditEvents", reflect.TypeOf((*MockRepositoryInterface)(nil).ListAdminAuditEvents), arg0, arg1)
+}
+
+// ListLoginEvents mocks base method.
+func (m *MockRepositoryInterface) ListLoginEvents(arg0 context.Context, arg1 ListLoginEventsInput) ([]LoginEventOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListLoginEvents", arg0, arg1)
+	ret0, _ := ret[0].([]LoginEventOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListLoginEvents indicates an expected call of ListLoginEvents.
+func (mr *MockRepositoryInterfaceMockRecorder) ListLoginEvents(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLoginEvents", reflect.TypeOf((*MockRepositoryInterface)(nil).ListLoginEvents), arg0, arg1)
+}
+
+// ListPasswordHistory mocks base method.
+func (m *MockRepositoryInterface) ListPasswordHistory(arg0 context.Context, arg1 ListPasswordHistoryInput) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPasswordHistory", arg0, arg1)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPasswordHistory indicates an expected call of ListPasswordHistory.
+func (mr *MockRepositoryInterfaceMockRecorder) ListPasswordHistory(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPasswordHistory", reflect.TypeOf((*MockRepositoryInterface)(nil).ListPasswordHistory), arg0, arg1)
+}
+
+// ListRolePermissions mocks base method.
+func (m *MockRepositoryInterface) ListRolePermissions(arg0 context.Context, arg1 []string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRolePermissions", arg0, arg1)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRolePermissions indicates an expected call of ListRolePermissions.
+func (mr *MockRepositoryInterfaceMockRecorder) ListRolePermissions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRolePermissions", reflect.TypeOf((*MockRepositoryInterface)(nil).ListRolePermissions), arg0, arg1)
+}
+
+// ListRoles mocks base method.
+func (m *MockRepositoryInterface) ListRoles(arg0 context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRoles", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRoles indicates an expected call of ListRoles.
+func (mr *MockRepositoryInterfaceMockRecorder) ListRoles(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRoles", reflect.TypeOf((*MockRepositoryInterface)(nil).ListRoles), arg0)
+}
+
+// ListUserConsents mocks base method.
+func (m *MockRepositoryInterface) ListUserConsents(arg0 context.Context, arg1 string) ([]ConsentOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserConsents", arg0, arg1)
+	ret0, _ := ret[0].([]ConsentOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUserConsents indicates an expected call of ListUserConsents.
+func (mr *MockRepositoryInterfaceMockRecorder) ListUserConsents(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserConsents", reflect.TypeOf((*MockRepositoryInterface)(nil).ListUserConsents), arg0, arg1)
+}
+
+// ListUserSessions mocks base method.
+func (m *MockRepositoryInterface) ListUserSessions(arg0 context.Context, arg1 string) ([]SessionOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserSessions", arg0, arg1)
+	ret0, _ := ret[0].([]SessionOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUserSessions indicates an expected call of ListUserSessions.
+func (mr *MockRepositoryInterfaceMockRecorder) ListUserSessions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserSessions", reflect.TypeOf((*MockRepositoryInterface)(nil).ListUserSessions), arg0, arg1)
+}
+
+// ListUsers mocks base method.
+func (m *MockRepositoryInterface) ListUsers(arg0 context.Context, arg1 ListUsersInput) ([]UserOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUsers", arg0, arg1)
+	ret0, _ := ret[0].([]UserOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUsers indicates an expected call of ListUsers.
+func (mr *MockRepositoryInterfaceMockRecorder) ListUsers(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsers", reflect.TypeOf((*MockRepositoryInterface)(nil).ListUsers), arg0, arg1)
+}
+
+// ListWebAuthnCredentials mocks base method.
+func (m *MockRepositoryInterface) ListWebAuthnCredentials(arg0 context.Context, arg1 string) ([]WebAuthnCredentialOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListWebAuthnCredentials", arg0, arg1)
+	ret0, _ := ret[0].([]WebAuthnCredentialOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListWebAuthnCredentials indicates an expected call of ListWebAuthnCredentials.
+func (mr *MockRepositoryInterfaceMockRecorder) ListWebAuthnCredentials(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListWebAuthnCredentials", reflect.TypeOf((*MockRepositoryInterface)(nil).ListWebAuthnCredentials), arg0, arg1)
+}
+
+// LockUser mocks base method.
+func (m *MockRepositoryInterface) LockUser(arg0 context.Context, arg1 LockUserInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LockUser indicates an expected call of LockUser.
+func (mr *MockRepositoryInterfaceMockRecorder) LockUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockUser", reflect.TypeOf((*MockRepositoryInterface)(nil).LockUser), arg0, arg1)
+}
+
+// RevokeAccessToken mocks base method.
+func (m *MockRepositoryInterface) RevokeAccessToken(arg0 context.Context, arg1 RevokeAccessTokenInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAccessToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAccessToken indicates an expected call of RevokeAccessToken.
+func (mr *MockRepositoryInterfaceMockRecorder) RevokeAccessToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAccessToken", reflect.TypeOf((*MockRepositoryInterface)(nil).RevokeAccessToken), arg0, arg1)
+}
+
+// RevokeAllUserSessions mocks base method.
+func (m *MockRepositoryInterface) RevokeAllUserSessions(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAllUserSessions", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAllUserSessions indicates an expected call of RevokeAllUserSessions.
+func (mr *MockRepositoryInterfaceMockRecorder) RevokeAllUserSessions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAllUserSessions", reflect.TypeOf((*MockRepositoryInterface)(nil).RevokeAllUserSessions), arg0, arg1)
+}
+
+// RevokeRefreshTokenFamily mocks base method.
+func (m *MockRepositoryInterface) RevokeRefreshTokenFamily(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRefreshTokenFamily", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeRefreshTokenFamily indicates an expected call of RevokeRefreshTokenFamily.
+func (mr *MockRepositoryInterfaceMockRecorder) RevokeRefreshTokenFamily(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRefreshTokenFamily", reflect.TypeOf((*MockRepositoryInterface)(nil).RevokeRefreshTokenFamily), arg0, arg1)
+}
+
+// RevokeUserSession mocks base method.
+func (m *MockRepositoryInterface) RevokeUserSession(arg0 context.Context, arg1 RevokeUserSessionInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserSession", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeUserSession indicates an expected call of RevokeUserSession.
+func (mr *MockRepositoryInterfaceMockRecorder) RevokeUserSession(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserSession", reflect.TypeOf((*MockRepositoryInterface)(nil).RevokeUserSession), arg0, arg1)
+}
+
+// RotateRefreshToken mocks base method.
+func (m *MockRepositoryInterface) RotateRefreshToken(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockRepositoryInterfaceMockRecorder) RotateRefreshToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockRepositoryInterface)(nil).RotateRefreshToken), arg0, arg1)
+}
+
+// SearchUsers mocks base method.
+func (m *MockRepositoryInterface) SearchUsers(arg0 context.Context, arg1 SearchUsersInput) ([]UserSearchResultOutput, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchUsers", arg0, arg1)
+	ret0, _ := ret[0].([]UserSearchResultOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchUsers indicates an expected call of SearchUsers.
+func (mr *MockRepositoryInterfaceMockRecorder) SearchUsers(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchUsers", reflect.TypeOf((*MockRepositoryInterface)(nil).SearchUsers), arg0, arg1)
+}
+
+// SetDataExportDownloadToken mocks base method.
+func (m *MockRepositoryInterface) SetDataExportDownloadToken(arg0 context.Context, arg1 SetDataExportDownloadTokenInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetDataExportDownloadToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetDataExportDownloadToken indicates an expected call of SetDataExportDownloadToken.
+func (mr *MockRepositoryInterfaceMockRecorder) SetDataExportDownloadToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDataExportDownloadToken", reflect.TypeOf((*MockRepositoryInterface)(nil).SetDataExportDownloadToken), arg0, arg1)
+}
+
+// SetUserRoles mocks base method.
+func (m *MockRepositoryInterface) SetUserRoles(arg0 context.Context, arg1 SetUserRolesInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUserRoles", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUserRoles indicates an expected call of SetUserRoles.
+func (mr *MockRepositoryInterfaceMockRecorder) SetUserRoles(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserRoles", reflect.TypeOf((*MockRepositoryInterface)(nil).SetUserRoles), arg0, arg1)
+}
+
+// SoftDeleteUser mocks base method.
+func (m *MockRepositoryInterface) SoftDeleteUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SoftDeleteUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SoftDeleteUser indicates an expected call of SoftDeleteUser.
+func (mr *MockRepositoryInterfaceMockRecorder) SoftDeleteUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SoftDeleteUser", reflect.TypeOf((*MockRepositoryInterface)(nil).SoftDeleteUser), arg0, arg1)
+}
+
+// SuspendUser mocks base method.
+func (m *MockRepositoryInterface) SuspendUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SuspendUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SuspendUser indicates an expected call of SuspendUser.
+func (mr *MockRepositoryInterfaceMockRecorder) SuspendUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SuspendUser", reflect.TypeOf((*MockRepositoryInterface)(nil).SuspendUser), arg0, arg1)
+}
+
+// UnlockUser mocks base method.
+func (m *MockRepositoryInterface) UnlockUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnlockUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnlockUser indicates an expected call of UnlockUser.
+func (mr *MockRepositoryInterfaceMockRecorder) UnlockUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnlockUser", reflect.TypeOf((*MockRepositoryInterface)(nil).UnlockUser), arg0, arg1)
+}
+
+// UnsuspendUser mocks base method.
+func (m *MockRepositoryInterface) UnsuspendUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnsuspendUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnsuspendUser indicates an expected call of UnsuspendUser.
+func (mr *MockRepositoryInterfaceMockRecorder) UnsuspendUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnsuspendUser", reflect.TypeOf((*MockRepositoryInterface)(nil).UnsuspendUser), arg0, arg1)
+}
+
+// UpdatePassword mocks base method.
+func (m *MockRepositoryInterface) UpdatePassword(arg0 context.Context, arg1 UpdatePasswordInput) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "IncrementLoginCount", arg0, arg1)
+	ret := m.ctrl.Call(m, "UpdatePassword", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// IncrementLoginCount indicates an expected call of IncrementLoginCount.
-func (mr *MockRepositoryInterfaceMockRecorder) IncrementLoginCount(arg0, arg1 interface{}) *gomock.Call {
+// UpdatePassword indicates an expected call of UpdatePassword.
+func (mr *MockRepositoryInterfaceMockRecorder) UpdatePassword(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementLoginCount", reflect.TypeOf((*MockRepositoryInterface)(nil).IncrementLoginCount), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePassword", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdatePassword), arg0, arg1)
+}
+
+// UpdatePasswordHash mocks base method.
+func (m *MockRepositoryInterface) UpdatePasswordHash(arg0 context.Context, arg1 UpdatePasswordHashInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePasswordHash", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePasswordHash indicates an expected call of UpdatePasswordHash.
+func (mr *MockRepositoryInterfaceMockRecorder) UpdatePasswordHash(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePasswordHash", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdatePasswordHash), arg0, arg1)
 }
 
 // UpdateUser mocks base method.
@@ -106,3 +845,87 @@ func (mr *MockRepositoryInterfaceMockRecorder) UpdateUser(arg0, arg1, arg2 inter
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdateUser), arg0, arg1, arg2)
 }
+
+// UpsertPhoneOTP mocks base method.
+func (m *MockRepositoryInterface) UpsertPhoneOTP(arg0 context.Context, arg1 UpsertPhoneOTPInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertPhoneOTP", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertPhoneOTP indicates an expected call of UpsertPhoneOTP.
+func (mr *MockRepositoryInterfaceMockRecorder) UpsertPhoneOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertPhoneOTP", reflect.TypeOf((*MockRepositoryInterface)(nil).UpsertPhoneOTP), arg0, arg1)
+}
+
+// UpsertTOTPSecret mocks base method.
+func (m *MockRepositoryInterface) UpsertTOTPSecret(arg0 context.Context, arg1 UpsertTOTPSecretInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertTOTPSecret", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertTOTPSecret indicates an expected call of UpsertTOTPSecret.
+func (mr *MockRepositoryInterfaceMockRecorder) UpsertTOTPSecret(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertTOTPSecret", reflect.TypeOf((*MockRepositoryInterface)(nil).UpsertTOTPSecret), arg0, arg1)
+}
+
+// UseRecoveryCode mocks base method.
+func (m *MockRepositoryInterface) UseRecoveryCode(arg0 context.Context, arg1 UseRecoveryCodeInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode.
+func (mr *MockRepositoryInterfaceMockRecorder) UseRecoveryCode(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockRepositoryInterface)(nil).UseRecoveryCode), arg0, arg1)
+}
+
+// UseTOTPStep mocks base method.
+func (m *MockRepositoryInterface) UseTOTPStep(arg0 context.Context, arg1 UseTOTPStepInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseTOTPStep", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseTOTPStep indicates an expected call of UseTOTPStep.
+func (mr *MockRepositoryInterfaceMockRecorder) UseTOTPStep(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseTOTPStep", reflect.TypeOf((*MockRepositoryInterface)(nil).UseTOTPStep), arg0, arg1)
+}
+
+// UseWebAuthnCredential mocks base method.
+func (m *MockRepositoryInterface) UseWebAuthnCredential(arg0 context.Context, arg1 UseWebAuthnCredentialInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseWebAuthnCredential", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseWebAuthnCredential indicates an expected call of UseWebAuthnCredential.
+func (mr *MockRepositoryInterfaceMockRecorder) UseWebAuthnCredential(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseWebAuthnCredential", reflect.TypeOf((*MockRepositoryInterface)(nil).UseWebAuthnCredential), arg0, arg1)
+}
+
+// VerifyPhoneNumber mocks base method.
+func (m *MockRepositoryInterface) VerifyPhoneNumber(arg0 context.Context, arg1 VerifyPhoneNumberInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyPhoneNumber", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VerifyPhoneNumber indicates an expected call of VerifyPhoneNumber.
+func (mr *MockRepositoryInterfaceMockRecorder) VerifyPhoneNumber(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyPhoneNumber", reflect.TypeOf((*MockRepositoryInterface)(nil).VerifyPhoneNumber), arg0, arg1)
+}
@@ -1,6 +1,12 @@
 // This file contains types that are used in the repository layer.
 package repository
 
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
 type CreateUserInput struct {
 	ID             string `db:"id"`
 	FullName       string `db:"full_name"`
@@ -14,6 +20,94 @@ type UserOutput struct {
 	PhoneNumber    string `db:"phone_number"`
 	LoginCount     int    `db:"login_count"`
 	HashedPassword string `db:"hashed_password"`
+	TokenVersion   int    `db:"token_version"`
+	// PhoneVerifiedAt is nil until the user proves they own the phone number, and reset when it changes
+	PhoneVerifiedAt *time.Time `db:"phone_verified_at"`
+	// LockedUntil is set when the user is locked out after too many failed logins
+	LockedUntil *time.Time `db:"locked_until"`
+	// LastLoginAt is the time of the last successful login, nil until the first one
+	LastLoginAt *time.Time `db:"last_login_at"`
+	// SuspendedAt is set while the user is suspended by an admin
+	SuspendedAt *time.Time `db:"suspended_at"`
+	// Roles are the names of the roles granted to the user, ordered by name
+	Roles     pq.StringArray `db:"roles"`
+	CreatedAt time.Time      `db:"created_at"`
+}
+
+// UserStatus is the state of the account of a user, from the admin point of view
+type UserStatus string
+
+const (
+	// UserStatusActive users can log in
+	UserStatusActive UserStatus = "active"
+	// UserStatusLocked users are locked after too many failed logins, until their lock expires
+	UserStatusLocked UserStatus = "locked"
+	// UserStatusSuspended users are suspended by an admin, a suspension takes precedence over a lock
+	UserStatusSuspended UserStatus = "suspended"
+)
+
+// ListUsersFilter narrows the users listed, the fields left to their zero value are not filtered on
+type ListUsersFilter struct {
+	// FullNamePrefix matches the full names starting with it, whatever their case
+	FullNamePrefix    string
+	PhoneNumberPrefix string
+	// CreatedFrom is inclusive, CreatedBefore is exclusive
+	CreatedFrom   *time.Time
+	CreatedBefore *time.Time
+	Status        UserStatus
+}
+
+// UserCursor is the position of a user in the listing, the users are ordered by their creation then their id
+type UserCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+type ListUsersInput struct {
+	Filter ListUsersFilter
+	// OldestFirst lists the users by ascending creation, they are listed newest first otherwise
+	OldestFirst bool
+	// After lists the users following this one in the order, or from the first user when nil
+	After *UserCursor
+	Limit int
+}
+
+type SearchUsersInput struct {
+	Query string
+	Limit int
+}
+
+// UserSearchResultOutput is a user found by a search, ranked by the similarity of their name to the query,
+// from 0 to 1
+type UserSearchResultOutput struct {
+	UserOutput
+	Rank float64 `db:"rank"`
+}
+
+type SetUserRolesInput struct {
+	UserID string
+	Roles  []string
+}
+
+type CreateAdminAuditEventInput struct {
+	UserID string
+	// Action is what the admin did to the user: "suspend", "unsuspend", "unlock", "set_roles" or "update_profile"
+	Action string
+	// Detail completes the action, e.g. the roles set or the fields updated, as a space separated list
+	Detail string
+	// ActorUserID is the admin who acted, unless it was a back-office client with the client_credentials grant,
+	// which is then ActorClientID
+	ActorUserID   *string
+	ActorClientID *string
+}
+
+type AdminAuditEventOutput struct {
+	ID            int64     `db:"id"`
+	Action        string    `db:"action"`
+	Detail        string    `db:"detail"`
+	ActorUserID   *string   `db:"actor_user_id"`
+	ActorClientID *string   `db:"actor_client_id"`
+	CreatedAt     time.Time `db:"created_at"`
 }
 
 type UpdateUserInput struct {
@@ -21,6 +115,329 @@ type UpdateUserInput struct {
 	PhoneNumber string `db:"phone_number"`
 }
 
+type UpdatePasswordInput struct {
+	UserID         string
+	HashedPassword string
+}
+
+type UpdatePasswordHashInput struct {
+	UserID string
+	// PreviousHash is the hash being replaced, the update fails if the password changed in the meantime
+	PreviousHash   string
+	HashedPassword string
+}
+
+type ListPasswordHistoryInput struct {
+	UserID string
+	Limit  int
+}
+
 type CreateUserOutput struct {
 	ID string `db:"id"`
 }
+
+type CreateRefreshTokenInput struct {
+	ID        string    `db:"id"`
+	UserID    string    `db:"user_id"`
+	FamilyID  string    `db:"family_id"`
+	TokenHash string    `db:"token_hash"`
+	ClientID  *string   `db:"client_id"`
+	Scope     string    `db:"scope"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type RefreshTokenOutput struct {
+	ID        string     `db:"id"`
+	UserID    string     `db:"user_id"`
+	FamilyID  string     `db:"family_id"`
+	ClientID  *string    `db:"client_id"`
+	Scope     string     `db:"scope"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	RotatedAt *time.Time `db:"rotated_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+type RevokeAccessTokenInput struct {
+	JTI       string    `db:"jti"`
+	UserID    string    `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type IsAccessTokenRevokedInput struct {
+	JTI          string
+	UserID       string
+	TokenVersion int
+	// SessionID is the refresh token family of the token, the token is revoked along with the family
+	SessionID string
+}
+
+type OAuthClientOutput struct {
+	ID   string `db:"id"`
+	Name string `db:"name"`
+	// HashedSecret is empty for public clients
+	HashedSecret string `db:"hashed_secret"`
+	RedirectURIs string `db:"redirect_uris"`
+	Scopes       string `db:"scopes"`
+	GrantTypes   string `db:"grant_types"`
+}
+
+type CreateAuthorizationCodeInput struct {
+	CodeHash            string `db:"code_hash"`
+	ClientID            string `db:"client_id"`
+	UserID              string `db:"user_id"`
+	RedirectURI         string `db:"redirect_uri"`
+	Scope               string `db:"scope"`
+	CodeChallenge       string `db:"code_challenge"`
+	CodeChallengeMethod string `db:"code_challenge_method"`
+	// Nonce & AuthTime are written to the id token issued for the code
+	Nonce     string    `db:"nonce"`
+	AuthTime  time.Time `db:"auth_time"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type AuthorizationCodeOutput struct {
+	ClientID            string `db:"client_id"`
+	UserID              string `db:"user_id"`
+	RedirectURI         string `db:"redirect_uri"`
+	Scope               string `db:"scope"`
+	CodeChallenge       string `db:"code_challenge"`
+	CodeChallengeMethod string `db:"code_challenge_method"`
+	// Nonce & AuthTime are written to the id token issued for the code
+	Nonce     string    `db:"nonce"`
+	AuthTime  time.Time `db:"auth_time"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type TOTPSecretOutput struct {
+	UserID string `db:"user_id"`
+	// EncryptedSecret is sealed by the handler, the repository never sees the raw secret
+	EncryptedSecret string `db:"encrypted_secret"`
+	// ConfirmedAt is nil while the enrollment is pending
+	ConfirmedAt  *time.Time `db:"confirmed_at"`
+	LastUsedStep int64      `db:"last_used_step"`
+}
+
+type UpsertTOTPSecretInput struct {
+	UserID          string `db:"user_id"`
+	EncryptedSecret string `db:"encrypted_secret"`
+}
+
+type ConfirmTOTPSecretInput struct {
+	UserID string
+	// Step is the time step of the code confirming the enrollment, it cannot be used again
+	Step int64
+	// RecoveryCodeHashes replace every recovery code of the user
+	RecoveryCodeHashes []string
+}
+
+type UseTOTPStepInput struct {
+	UserID string
+	Step   int64
+}
+
+type UseRecoveryCodeInput struct {
+	UserID   string
+	CodeHash string
+}
+
+type CreateMFAChallengeInput struct {
+	TokenHash string    `db:"token_hash"`
+	UserID    string    `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type AttemptMFAChallengeInput struct {
+	TokenHash   string
+	MaxAttempts int
+}
+
+type MFAChallengeOutput struct {
+	UserID    string    `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type CreateWebAuthnChallengeInput struct {
+	ChallengeHash string `db:"challenge_hash"`
+	// Ceremony is either "registration" or "authentication"
+	Ceremony string `db:"ceremony"`
+	// UserID is empty for authentication, the user is only known from the credential used
+	UserID    string    `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type ConsumeWebAuthnChallengeInput struct {
+	ChallengeHash string
+	Ceremony      string
+}
+
+type WebAuthnChallengeOutput struct {
+	UserID    string    `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type CreateWebAuthnCredentialInput struct {
+	// ID is the base64url encoded credential id chosen by the authenticator
+	ID     string `db:"id"`
+	UserID string `db:"user_id"`
+	// PublicKey is the COSE encoded public key of the credential
+	PublicKey []byte `db:"public_key"`
+	SignCount int64  `db:"sign_count"`
+	// Transports is a space separated list, e.g. "internal hybrid"
+	Transports string `db:"transports"`
+}
+
+type WebAuthnCredentialOutput struct {
+	ID         string    `db:"id"`
+	UserID     string    `db:"user_id"`
+	PublicKey  []byte    `db:"public_key"`
+	SignCount  int64     `db:"sign_count"`
+	Transports string    `db:"transports"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+type UseWebAuthnCredentialInput struct {
+	ID        string
+	SignCount int64
+}
+
+type UpsertPhoneOTPInput struct {
+	UserID string `db:"user_id"`
+	// Purpose is either "verification" or "login", each purpose has its own code
+	Purpose     string    `db:"purpose"`
+	PhoneNumber string    `db:"phone_number"`
+	CodeHash    string    `db:"code_hash"`
+	ExpiresAt   time.Time `db:"expires_at"`
+	// ResendInterval is the minimum number of seconds between two codes of the same purpose
+	ResendInterval int `db:"resend_interval"`
+}
+
+type AttemptPhoneOTPInput struct {
+	UserID      string
+	Purpose     string
+	MaxAttempts int
+}
+
+type PhoneOTPOutput struct {
+	// PhoneNumber is the phone number the code was sent to
+	PhoneNumber string    `db:"phone_number"`
+	CodeHash    string    `db:"code_hash"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
+
+type ConsumePhoneOTPInput struct {
+	UserID   string
+	Purpose  string
+	CodeHash string
+}
+
+type VerifyPhoneNumberInput struct {
+	UserID      string
+	PhoneNumber string
+}
+
+type LockUserInput struct {
+	UserID      string
+	LockedUntil time.Time
+}
+
+type CreateLoginEventInput struct {
+	UserID string
+	// SessionID is the refresh token family started by a successful login
+	SessionID *string
+	// Method is how the user logged in: "password", "otp", "mfa", "passkey", "authorize" or "password_change"
+	Method    string
+	IPAddress string
+	UserAgent string
+	Succeeded bool
+	// FailureReason tells why a failed login was refused, e.g. "invalid_password" or "account_locked"
+	FailureReason string
+}
+
+type ListLoginEventsInput struct {
+	UserID string
+	// BeforeID lists the events older than the event with this id, or the latest events when 0
+	BeforeID int64
+	Limit    int
+}
+
+type LoginEventOutput struct {
+	ID            int64     `db:"id"`
+	SessionID     *string   `db:"session_id"`
+	Method        string    `db:"method"`
+	IPAddress     string    `db:"ip_address"`
+	UserAgent     string    `db:"user_agent"`
+	Succeeded     bool      `db:"succeeded"`
+	FailureReason string    `db:"failure_reason"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// ConsentOutput is an oauth client the user authorized, which still holds a live refresh token or an unused
+// authorization code of the user
+type ConsentOutput struct {
+	ClientID   string `db:"client_id"`
+	ClientName string `db:"client_name"`
+}
+
+type SessionOutput struct {
+	// ID is the refresh token family of the session
+	ID       string  `db:"id"`
+	ClientID *string `db:"client_id"`
+	// IPAddress & UserAgent are the ones of the login which started the session, empty for oauth sessions
+	IPAddress string    `db:"ip_address"`
+	UserAgent string    `db:"user_agent"`
+	CreatedAt time.Time `db:"created_at"`
+	// LastUsedAt is when the session was last refreshed
+	LastUsedAt time.Time `db:"last_used_at"`
+	ExpiresAt  time.Time `db:"expires_at"`
+}
+
+type RevokeUserSessionInput struct {
+	UserID    string
+	SessionID string
+}
+
+type EraseDeletedUsersInput struct {
+	// DeletedBefore is the end of the grace period of the deleted users, the users deleted later are kept
+	DeletedBefore time.Time
+	Limit         int
+}
+
+type CreateDataExportInput struct {
+	ID     string `db:"id"`
+	UserID string `db:"user_id"`
+	// Format is either "json" or "zip"
+	Format string `db:"format"`
+}
+
+type DataExportOutput struct {
+	ID     string `db:"id"`
+	UserID string `db:"user_id"`
+	Format string `db:"format"`
+	// CompletedAt is nil until the archive is built, it can be downloaded from then on until ExpiresAt
+	CompletedAt *time.Time `db:"completed_at"`
+	ExpiresAt   *time.Time `db:"expires_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+}
+
+type ClaimDataExportInput struct {
+	// StartedBefore takes over the exports picked up before this time and still not completed, their job is
+	// considered gone
+	StartedBefore time.Time
+}
+
+type CompleteDataExportInput struct {
+	ID        string
+	Archive   []byte
+	ExpiresAt time.Time
+}
+
+type SetDataExportDownloadTokenInput struct {
+	ID        string
+	TokenHash string
+}
+
+type DataExportArchiveOutput struct {
+	Format      string    `db:"format"`
+	Archive     []byte    `db:"archive"`
+	CompletedAt time.Time `db:"completed_at"`
+}